@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/prometheus/common/promslog"
@@ -24,6 +25,8 @@ import (
 
 	dto "github.com/prometheus/client_model/go"
 
+	"github.com/prometheus/pushgateway/audit"
+	"github.com/prometheus/pushgateway/cluster"
 	"github.com/prometheus/pushgateway/handler"
 	"github.com/prometheus/pushgateway/histogram"
 	"github.com/prometheus/pushgateway/storage"
@@ -39,14 +42,16 @@ const (
 type errorType string
 
 const (
-	errorNone        errorType = ""
-	errorTimeout     errorType = "timeout"
-	errorCanceled    errorType = "canceled"
-	errorExec        errorType = "execution"
-	errorBadData     errorType = "bad_data"
-	errorInternal    errorType = "internal"
-	errorUnavailable errorType = "unavailable"
-	errorNotFound    errorType = "not_found"
+	errorNone            errorType = ""
+	errorTimeout         errorType = "timeout"
+	errorCanceled        errorType = "canceled"
+	errorExec            errorType = "execution"
+	errorBadData         errorType = "bad_data"
+	errorInternal        errorType = "internal"
+	errorUnavailable     errorType = "unavailable"
+	errorNotFound        errorType = "not_found"
+	errorForbidden       errorType = "forbidden"
+	errorTooManyRequests errorType = "too_many_requests"
 )
 
 type apiError struct {
@@ -80,6 +85,16 @@ type API struct {
 	Flags       map[string]string
 	StartTime   time.Time
 	BuildInfo   map[string]string
+	// Cluster is the ring of cluster members, or nil without clustering.
+	Cluster *cluster.Ring
+	// Authorizer, if not nil, has to authorize a deletion of groups by
+	// selectors to change each of the matching groups.
+	Authorizer handler.Authorizer
+	// AuditLog, if not nil, records deletions of groups by selectors.
+	AuditLog *audit.Log
+
+	closing   chan struct{} // Closed by CloseWatches.
+	closeOnce sync.Once
 }
 
 // New returns a new API. The log.Logger can be nil, in which case no logging is performed.
@@ -99,26 +114,49 @@ func New(
 		MetricStore: ms,
 		Flags:       flags,
 		BuildInfo:   buildInfo,
+		closing:     make(chan struct{}),
 	}
 }
 
+// wrap instruments the provided handler and sets the CORS headers.
+func wrap(handlerName string, f http.HandlerFunc) http.HandlerFunc {
+	return handler.InstrumentWithCounter(
+		handlerName,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			setCORS(w)
+			f(w, r)
+		}),
+	)
+}
+
 // Register registers the API handlers under their correct routes
 // in the given router.
 func (api *API) Register(r *route.Router) {
-	wrap := func(handlerName string, f http.HandlerFunc) http.HandlerFunc {
-		return handler.InstrumentWithCounter(
-			handlerName,
-			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				setCORS(w)
-				f(w, r)
-			}),
-		)
-	}
-
 	r.Options("/*path", wrap("api/v1/options", func(w http.ResponseWriter, r *http.Request) {}))
 
 	r.Get("/status", wrap("api/v1/status", api.status))
 	r.Get("/metrics", wrap("api/v1/metrics", api.metrics))
+	r.Get("/groups", wrap("api/v1/groups", api.listGroups))
+	r.Get("/groups/:key", wrap("api/v1/groups/key", api.group))
+	r.Get("/groups/:key/history", wrap("api/v1/groups/key/history", api.groupHistory))
+	r.Get("/groups/:key/history/diff", wrap("api/v1/groups/key/history/diff", api.groupHistoryDiff))
+	r.Get("/watch", wrap("api/v1/watch", api.watch))
+	r.Get("/cluster", wrap("api/v1/cluster", api.cluster))
+	for _, suffix := range []string{"", handler.Base64Suffix} {
+		jobBase64Encoded := suffix == handler.Base64Suffix
+		validate := wrap("api/v1/validate", api.validate(jobBase64Encoded))
+		r.Post("/validate/job"+suffix+"/:job/*labels", validate)
+		r.Post("/validate/job"+suffix+"/:job", validate)
+		r.Put("/validate/job"+suffix+"/:job/*labels", validate)
+		r.Put("/validate/job"+suffix+"/:job", validate)
+	}
+}
+
+// RegisterAdmin registers the handlers of the admin API that are part of this
+// API (like the deletion of groups by selectors) in the given router. They must
+// only be registered if the admin API is enabled.
+func (api *API) RegisterAdmin(r *route.Router) {
+	r.Del("/groups", wrap("api/v1/groups", api.deleteGroups))
 }
 
 type metrics struct {
@@ -132,25 +170,35 @@ func (api *API) metrics(w http.ResponseWriter, r *http.Request) {
 	familyMaps := api.MetricStore.GetMetricFamiliesMap()
 	res := []any{}
 	for _, v := range familyMaps {
-		metricResponse := map[string]any{}
-		metricResponse["labels"] = v.Labels
-		metricResponse["last_push_successful"] = v.LastPushSuccess()
-		for name, metricValues := range v.Metrics {
-			metricFamily := metricValues.GetMetricFamily()
-			uniqueMetrics := metrics{
-				Type:      metricFamily.GetType().String(),
-				Help:      metricFamily.GetHelp(),
-				Timestamp: metricValues.Timestamp,
-				Metrics:   makeEncodableMetrics(metricFamily.GetMetric(), metricFamily.GetType()),
-			}
-			metricResponse[name] = uniqueMetrics
-		}
-		res = append(res, metricResponse)
+		res = append(res, makeGroupResponse(v))
 	}
 
 	api.respond(w, res)
 }
 
+// makeGroupResponse returns the JSON representation of the provided group with
+// all its metric families as used by the metrics endpoint.
+func makeGroupResponse(v storage.MetricGroup) map[string]any {
+	metricResponse := map[string]any{}
+	metricResponse["labels"] = v.Labels
+	metricResponse["last_push_successful"] = v.LastPushSuccess()
+	for name, metricValues := range v.Metrics {
+		metricResponse[name] = makeMetrics(metricValues)
+	}
+	return metricResponse
+}
+
+// makeMetrics returns the JSON representation of the provided metric family.
+func makeMetrics(tmf storage.TimestampedMetricFamily) metrics {
+	metricFamily := tmf.GetMetricFamily()
+	return metrics{
+		Type:      metricFamily.GetType().String(),
+		Help:      metricFamily.GetHelp(),
+		Timestamp: tmf.Timestamp,
+		Metrics:   makeEncodableMetrics(metricFamily.GetMetric(), metricFamily.GetType()),
+	}
+}
+
 func (api *API) status(w http.ResponseWriter, r *http.Request) {
 	res := map[string]any{}
 	res["flags"] = api.Flags
@@ -194,8 +242,16 @@ func (api *API) respondError(w http.ResponseWriter, apiErr apiError, data any) {
 	switch apiErr.typ {
 	case errorBadData:
 		w.WriteHeader(http.StatusBadRequest)
+	case errorNotFound:
+		w.WriteHeader(http.StatusNotFound)
 	case errorInternal:
 		w.WriteHeader(http.StatusInternalServerError)
+	case errorUnavailable:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case errorForbidden:
+		w.WriteHeader(http.StatusForbidden)
+	case errorTooManyRequests:
+		w.WriteHeader(http.StatusTooManyRequests)
 	default:
 		panic(fmt.Sprintf("unknown error type %q", apiErr.Error()))
 	}
@@ -26,6 +26,7 @@ import (
 
 	dto "github.com/prometheus/client_model/go"
 
+	"github.com/prometheus/pushgateway/cluster"
 	"github.com/prometheus/pushgateway/storage"
 	"github.com/prometheus/pushgateway/testutil"
 )
@@ -444,3 +445,44 @@ func TestMetricsAPI(t *testing.T) {
 		t.Errorf("Wanted response %q, got %q.", expected, got)
 	}
 }
+
+func TestClusterAPI(t *testing.T) {
+	dms := storage.NewDiskMetricStore("", 100*time.Millisecond, nil, logger)
+	testAPI := New(logger, dms, testFlags, testBuildInfo)
+
+	req, err := http.NewRequest("GET", "http://example.org/", &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	testAPI.cluster(w, req)
+	if expected, got := `{"status":"success","data":{"enabled":false,"members":[]}}`, w.Body.String(); expected != got {
+		t.Errorf("Wanted response %q, got %q.", expected, got)
+	}
+
+	testAPI.Cluster, err = cluster.NewRing([]string{"http://pg-b:9091", "http://pg-a:9091/"}, "http://pg-a:9091")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	testAPI.cluster(w, req)
+
+	var res struct {
+		Data clusterStatus `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("unexpected error unmarshaling response: %v", err)
+	}
+	if !res.Data.Enabled || res.Data.Self != "http://pg-a:9091" {
+		t.Errorf("Wanted clustering enabled with self http://pg-a:9091, got %+v.", res.Data)
+	}
+	if len(res.Data.Members) != 2 ||
+		res.Data.Members[0].URL != "http://pg-a:9091" || !res.Data.Members[0].Self ||
+		res.Data.Members[1].URL != "http://pg-b:9091" || res.Data.Members[1].Self {
+		t.Fatalf("Wanted members http://pg-a:9091 (self) and http://pg-b:9091, got %+v.", res.Data.Members)
+	}
+	if sum := res.Data.Members[0].Ownership + res.Data.Members[1].Ownership; sum < 0.999 || sum > 1.001 {
+		t.Errorf("Wanted ownership to add up to 1, got %v.", sum)
+	}
+}
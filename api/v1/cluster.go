@@ -0,0 +1,51 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"net/http"
+)
+
+// clusterMember is the JSON representation of a member of the cluster ring.
+// Ownership is the share of metric groups the member is expected to own.
+type clusterMember struct {
+	URL       string  `json:"url"`
+	Self      bool    `json:"self"`
+	Ownership float64 `json:"ownership"`
+}
+
+type clusterStatus struct {
+	Enabled bool            `json:"enabled"`
+	Self    string          `json:"self,omitempty"`
+	Members []clusterMember `json:"members"`
+}
+
+// cluster shows the members of the cluster ring. Without clustering, Enabled is
+// false, and there are no members.
+func (api *API) cluster(w http.ResponseWriter, _ *http.Request) {
+	res := clusterStatus{Members: []clusterMember{}}
+	if api.Cluster != nil {
+		res.Enabled = true
+		res.Self = api.Cluster.Self()
+		ownership := api.Cluster.Ownership()
+		for _, m := range api.Cluster.Members() {
+			res.Members = append(res.Members, clusterMember{
+				URL:       m,
+				Self:      m == res.Self,
+				Ownership: ownership[m],
+			})
+		}
+	}
+	api.respond(w, res)
+}
@@ -0,0 +1,365 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"cmp"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/route"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/prometheus/pushgateway/audit"
+	"github.com/prometheus/pushgateway/handler"
+	"github.com/prometheus/pushgateway/storage"
+)
+
+// Values of the sort parameter of the groups endpoint.
+const (
+	sortByKey      = "key"
+	sortByLastPush = "last_push"
+)
+
+// groupSummary is the JSON representation of a metric group in the group
+// listing. Key identifies the group in the URL path of the group endpoint.
+type groupSummary struct {
+	Key                 string            `json:"key"`
+	Labels              map[string]string `json:"labels"`
+	LastPushTime        *time.Time        `json:"last_push_time,omitempty"`
+	LastPushFailureTime *time.Time        `json:"last_push_failure_time,omitempty"`
+	LastPushSuccessful  bool              `json:"last_push_successful"`
+	MetricFamilies      int               `json:"metric_families"`
+}
+
+type groupList struct {
+	Groups   []groupSummary `json:"groups"`
+	Continue string         `json:"continue,omitempty"`
+}
+
+// groupPosition is the position of a group in the sorted group listing. It is
+// also what a continue cursor encodes.
+type groupPosition struct {
+	lastPush int64 // Unix nanoseconds. Only used when sorting by last push.
+	key      string
+}
+
+// listGroups lists the metric groups without their metric families, optionally
+// filtered by match[] selectors, sorted by grouping key or last push time, and
+// split into pages of the size given by the limit parameter. If a page is not
+// the last one, the response contains a cursor to be passed as the continue
+// parameter (along with otherwise unchanged parameters) to get the next page.
+func (api *API) listGroups(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		api.respondError(w, apiError{typ: errorBadData, err: err}, nil)
+		return
+	}
+	var (
+		selectors [][]*labels.Matcher
+		limit     int
+		cursor    *groupPosition
+		err       error
+	)
+	if matchParams := r.Form["match[]"]; len(matchParams) > 0 {
+		if selectors, err = parseSelectors(matchParams); err != nil {
+			api.respondError(w, apiError{typ: errorBadData, err: err}, nil)
+			return
+		}
+	}
+	if s := r.Form.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			api.respondError(w, apiError{typ: errorBadData, err: fmt.Errorf("invalid limit parameter %q, must be a positive integer", s)}, nil)
+			return
+		}
+	}
+	sortBy := r.Form.Get("sort")
+	switch sortBy {
+	case "":
+		sortBy = sortByKey
+	case sortByKey, sortByLastPush:
+	default:
+		api.respondError(w, apiError{typ: errorBadData, err: fmt.Errorf("invalid sort parameter %q, must be %q or %q", sortBy, sortByKey, sortByLastPush)}, nil)
+		return
+	}
+	descending := false
+	switch order := r.Form.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		descending = true
+	default:
+		api.respondError(w, apiError{typ: errorBadData, err: fmt.Errorf("invalid order parameter %q, must be \"asc\" or \"desc\"", order)}, nil)
+		return
+	}
+	if s := r.Form.Get("continue"); s != "" {
+		if cursor, err = decodeCursor(s); err != nil {
+			api.respondError(w, apiError{typ: errorBadData, err: fmt.Errorf("invalid continue parameter %q: %w", s, err)}, nil)
+			return
+		}
+	}
+
+	type entry struct {
+		pos   groupPosition
+		group storage.GroupSummary
+	}
+	compare := func(a, b groupPosition) int {
+		c := 0
+		if sortBy == sortByLastPush {
+			c = cmp.Compare(a.lastPush, b.lastPush)
+		}
+		if c == 0 {
+			c = strings.Compare(a.key, b.key)
+		}
+		if descending {
+			return -c
+		}
+		return c
+	}
+
+	var entries []entry
+	for _, group := range api.MetricStore.GetGroupSummaries() {
+		if selectors != nil && !group.Matches(selectors) {
+			continue
+		}
+		pos := groupPosition{key: group.Key}
+		if sortBy == sortByLastPush {
+			if t := group.LastPushTime; !t.IsZero() {
+				pos.lastPush = t.UnixNano()
+			}
+		}
+		entries = append(entries, entry{pos: pos, group: group})
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return compare(a.pos, b.pos) })
+	if cursor != nil {
+		start, _ := slices.BinarySearchFunc(entries, *cursor, func(e entry, c groupPosition) int {
+			if compare(e.pos, c) <= 0 {
+				return -1
+			}
+			return 1
+		})
+		entries = entries[start:]
+	}
+
+	res := groupList{Groups: []groupSummary{}}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+		res.Continue = encodeCursor(entries[limit-1].pos)
+	}
+	for _, e := range entries {
+		summary := groupSummary{
+			Key:                encodeGroupKey(e.pos.key),
+			Labels:             e.group.Labels,
+			LastPushSuccessful: e.group.LastPushSuccess,
+			MetricFamilies:     e.group.PushedMetricFamilies,
+		}
+		if t := e.group.LastPushTime; !t.IsZero() {
+			summary.LastPushTime = &t
+		}
+		if t := e.group.LastPushFailureTime; !t.IsZero() {
+			summary.LastPushFailureTime = &t
+		}
+		res.Groups = append(res.Groups, summary)
+	}
+	api.respond(w, res)
+}
+
+// group returns the metric group identified by the key in the URL path (as
+// listed by the groups endpoint) with all its metric families.
+func (api *API) group(w http.ResponseWriter, r *http.Request) {
+	group, ok := api.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+	api.respond(w, makeGroupResponse(group))
+}
+
+// groupFromPath returns the metric group identified by the key in the URL path.
+// If the key is invalid or the group doesn't exist, an error is responded, and
+// false is returned.
+func (api *API) groupFromPath(w http.ResponseWriter, r *http.Request) (storage.MetricGroup, bool) {
+	encodedKey := route.Param(r.Context(), "key")
+	key, err := decodeGroupKey(encodedKey)
+	if err != nil {
+		api.respondError(w, apiError{typ: errorBadData, err: fmt.Errorf("invalid group key %q: %w", encodedKey, err)}, nil)
+		return storage.MetricGroup{}, false
+	}
+	group, ok := api.MetricStore.GetMetricGroup(key)
+	if !ok {
+		api.respondError(w, apiError{typ: errorNotFound, err: fmt.Errorf("group %q not found", encodedKey)}, nil)
+		return storage.MetricGroup{}, false
+	}
+	return group, true
+}
+
+// deleteGroups deletes all metric groups whose grouping labels match at least
+// one of the selectors provided as match[] parameters (in the usual Prometheus
+// selector syntax). With the parameter dry_run=true, the matching groups are
+// only returned but not deleted. With clustering, deleting groups by selectors
+// is rejected, as the matching groups might be owned by other members.
+func (api *API) deleteGroups(w http.ResponseWriter, r *http.Request) {
+	if api.Cluster != nil {
+		api.respondError(w, apiError{typ: errorBadData, err: errors.New("deleting groups by selectors is not supported with clustering")}, nil)
+		return
+	}
+	if err := api.MetricStore.Ready(); errors.Is(err, storage.ErrRestoring) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(handler.RestoreRetryAfter.Seconds())))
+		api.respondError(w, apiError{typ: errorUnavailable, err: err}, nil)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		api.respondError(w, apiError{typ: errorBadData, err: err}, nil)
+		return
+	}
+	dryRun := false
+	if s := r.Form.Get("dry_run"); s != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(s); err != nil {
+			api.respondError(w, apiError{typ: errorBadData, err: fmt.Errorf("invalid dry_run parameter %q: %w", s, err)}, nil)
+			return
+		}
+	}
+	matchParams := r.Form["match[]"]
+	// Dry runs don't change anything and are therefore not audited.
+	entry := &audit.Entry{}
+	if !dryRun {
+		var finish func()
+		entry, w, finish = handler.StartAudit(api.AuditLog, audit.OperationDelete, w, r)
+		defer finish()
+		entry.Match = matchParams
+	}
+	if len(matchParams) == 0 {
+		api.respondError(w, apiError{typ: errorBadData, err: errors.New("no match[] parameter provided")}, nil)
+		return
+	}
+	selectors, err := parseSelectors(matchParams)
+	if err != nil {
+		api.respondError(w, apiError{typ: errorBadData, err: err}, nil)
+		return
+	}
+	for i, selector := range selectors {
+		if !matchesNonEmpty(selector) {
+			api.respondError(w, apiError{
+				typ: errorBadData,
+				err: fmt.Errorf("match[] parameter %q must contain at least one matcher that doesn't match the empty string", matchParams[i]),
+			}, nil)
+			return
+		}
+	}
+
+	var deleted []map[string]string
+	if api.Authorizer == nil {
+		if deleted, err = api.MetricStore.DeleteGroups(selectors, dryRun); err != nil {
+			api.respondDeleteError(w, err)
+			return
+		}
+	} else {
+		// Only delete the matching groups if each of them may be
+		// changed. Groups that only start to match in the meantime are
+		// not deleted.
+		matched, err := api.MetricStore.DeleteGroups(selectors, true)
+		if err != nil {
+			api.respondDeleteError(w, err)
+			return
+		}
+		identity, err := handler.AuthorizeGroups(api.Authorizer, nil, r, matched)
+		if identity != "" {
+			entry.Identity = identity
+		}
+		if err != nil {
+			api.respondError(w, apiError{typ: errorForbidden, err: err}, nil)
+			return
+		}
+		deleted = matched
+		if !dryRun {
+			keys := make([]string, len(matched))
+			for i, labels := range matched {
+				keys[i] = storage.GroupingKeyFor(labels)
+			}
+			if deleted, err = api.MetricStore.DeleteGroupsByKey(keys); err != nil {
+				api.respondDeleteError(w, err)
+				return
+			}
+		}
+	}
+	if !dryRun {
+		entry.Groups = len(deleted)
+		api.logger.Debug("deleted metric groups", "match", matchParams, "count", len(deleted))
+	}
+	api.respond(w, deleted)
+}
+
+// respondDeleteError responds with the provided error returned by the
+// MetricStore when deleting groups. If the write queue is full, the client is
+// asked to retry later.
+func (api *API) respondDeleteError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrWriteQueueFull) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(handler.WriteQueueRetryAfter.Seconds())))
+		api.respondError(w, apiError{typ: errorTooManyRequests, err: err}, nil)
+		return
+	}
+	api.respondError(w, apiError{typ: errorInternal, err: err}, nil)
+}
+
+// parseSelectors parses the provided match[] parameters.
+func parseSelectors(matchParams []string) ([][]*labels.Matcher, error) {
+	return parser.NewParser(parser.Options{}).ParseMetricSelectors(matchParams)
+}
+
+// matchesNonEmpty returns whether the selector contains at least one matcher
+// that doesn't match the empty string, so that it cannot match all groups.
+func matchesNonEmpty(selector []*labels.Matcher) bool {
+	for _, m := range selector {
+		if !m.Matches("") {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeGroupKey encodes a grouping key of the MetricStore for use in the URL
+// path.
+func encodeGroupKey(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeGroupKey(s string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	return string(b), err
+}
+
+func encodeCursor(pos groupPosition) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(pos.lastPush, 10) + ":" + pos.key))
+}
+
+func decodeCursor(s string) (*groupPosition, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	lastPush, key, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return nil, errors.New("malformed cursor")
+	}
+	pos := &groupPosition{key: key}
+	if pos.lastPush, err = strconv.ParseInt(lastPush, 10, 64); err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	return pos, nil
+}
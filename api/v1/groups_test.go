@@ -0,0 +1,498 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/route"
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/prometheus/pushgateway/audit"
+	"github.com/prometheus/pushgateway/authz"
+	"github.com/prometheus/pushgateway/cluster"
+	"github.com/prometheus/pushgateway/storage"
+	"github.com/prometheus/pushgateway/testutil"
+)
+
+// pushGroups pushes mf1 to the groups with the provided grouping labels, each
+// with a push timestamp one second after the previous one.
+func pushGroups(t *testing.T, dms *storage.DiskMetricStore, ts time.Time, groupings ...map[string]string) {
+	t.Helper()
+	for i, grouping := range groupings {
+		errCh := make(chan error, 1)
+		dms.SubmitWriteRequest(storage.WriteRequest{
+			Labels:         grouping,
+			Timestamp:      ts.Add(time.Duration(i) * time.Second),
+			MetricFamilies: testutil.MetricFamiliesMap(mf1),
+			Done:           errCh,
+		})
+		for err := range errCh {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+}
+
+func TestListGroupsAPI(t *testing.T) {
+	dms := storage.NewDiskMetricStore("", 100*time.Millisecond, nil, logger)
+	testAPI := New(logger, dms, testFlags, testBuildInfo)
+
+	ts := time.Unix(1700000000, 0)
+	// Pushed in this order, i.e. the last push times are ascending.
+	pushGroups(t, dms, ts,
+		map[string]string{"job": "c"},
+		map[string]string{"job": "a", "instance": "i1"},
+		map[string]string{"job": "b"},
+		map[string]string{"job": "a", "instance": "i2"},
+	)
+
+	list := func(query string) (int, groupList) {
+		t.Helper()
+		req, err := http.NewRequest("GET", "http://example.org/api/v1/groups?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		testAPI.listGroups(w, req)
+		var resp struct {
+			Data groupList `json:"data"`
+		}
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("unexpected error unmarshaling response: %v", err)
+			}
+		}
+		return w.Code, resp.Data
+	}
+	jobsAndInstances := func(gl groupList) []string {
+		result := []string{}
+		for _, g := range gl.Groups {
+			result = append(result, g.Labels["job"]+g.Labels["instance"])
+		}
+		return result
+	}
+
+	code, gl := list("")
+	if expected, got := http.StatusOK, code; expected != got {
+		t.Fatalf("Wanted status code %v, got %v.", expected, got)
+	}
+	if expected, got := []string{"ai1", "ai2", "b", "c"}, jobsAndInstances(gl); !reflect.DeepEqual(expected, got) {
+		t.Errorf("Wanted groups %v, got %v.", expected, got)
+	}
+	if gl.Continue != "" {
+		t.Errorf("Unexpected continue cursor %q.", gl.Continue)
+	}
+	g := gl.Groups[2]
+	if expected, got := 1, g.MetricFamilies; expected != got {
+		t.Errorf("Wanted %d metric families, got %d.", expected, got)
+	}
+	if g.LastPushTime == nil || !g.LastPushTime.Equal(ts.Add(2*time.Second)) {
+		t.Errorf("Wanted last push time %v, got %v.", ts.Add(2*time.Second), g.LastPushTime)
+	}
+	if g.LastPushFailureTime != nil {
+		t.Errorf("Unexpected last push failure time %v.", g.LastPushFailureTime)
+	}
+	if !g.LastPushSuccessful {
+		t.Error("Wanted last push to be successful.")
+	}
+
+	code, gl = list("match[]=" + url.QueryEscape(`{job="a"}`) + "&match[]=" + url.QueryEscape(`{job="c"}`))
+	if expected, got := []string{"ai1", "ai2", "c"}, jobsAndInstances(gl); code != http.StatusOK || !reflect.DeepEqual(expected, got) {
+		t.Errorf("Wanted groups %v, got %v (status code %v).", expected, got, code)
+	}
+
+	// Pagination, sorted by last push time in descending order.
+	var pages [][]string
+	query := "sort=last_push&order=desc&limit=3"
+	for {
+		code, gl = list(query)
+		if code != http.StatusOK {
+			t.Fatalf("Wanted status code %v, got %v.", http.StatusOK, code)
+		}
+		pages = append(pages, jobsAndInstances(gl))
+		if gl.Continue == "" {
+			break
+		}
+		query = "sort=last_push&order=desc&limit=3&continue=" + url.QueryEscape(gl.Continue)
+	}
+	if expected, got := [][]string{{"ai2", "b", "ai1"}, {"c"}}, pages; !reflect.DeepEqual(expected, got) {
+		t.Errorf("Wanted pages %v, got %v.", expected, got)
+	}
+
+	for _, query := range []string{
+		"limit=0",
+		"limit=x",
+		"sort=size",
+		"order=up",
+		"continue=%21",
+		"match[]=" + url.QueryEscape(`{job=`),
+	} {
+		if code, _ := list(query); code != http.StatusBadRequest {
+			t.Errorf("Wanted status code %v for query %q, got %v.", http.StatusBadRequest, query, code)
+		}
+	}
+}
+
+func TestGroupAPI(t *testing.T) {
+	dms := storage.NewDiskMetricStore("", 100*time.Millisecond, nil, logger)
+	testAPI := New(logger, dms, testFlags, testBuildInfo)
+	pushGroups(t, dms, time.Unix(1700000000, 0), grouping1)
+
+	get := func(key string) *httptest.ResponseRecorder {
+		t.Helper()
+		req, err := http.NewRequest("GET", "http://example.org/api/v1/groups/"+key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		testAPI.group(w, req.WithContext(route.WithParam(req.Context(), "key", key)))
+		return w
+	}
+
+	var key string
+	for k := range dms.GetMetricFamiliesMap() {
+		key = encodeGroupKey(k)
+	}
+	w := get(key)
+	if expected, got := http.StatusOK, w.Code; expected != got {
+		t.Fatalf("Wanted status code %v, got %v.", expected, got)
+	}
+	var resp struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unexpected error unmarshaling response: %v", err)
+	}
+	for _, name := range []string{"labels", "last_push_successful", "mf1", "push_time_seconds", "push_failure_time_seconds"} {
+		if _, ok := resp.Data[name]; !ok {
+			t.Errorf("Wanted %q in response %s.", name, w.Body.String())
+		}
+	}
+
+	if expected, got := http.StatusNotFound, get(encodeGroupKey("job\xffunknown")).Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+	if expected, got := http.StatusBadRequest, get("!").Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+}
+
+func TestDeleteGroupsAPI(t *testing.T) {
+	dms := storage.NewDiskMetricStore("", 100*time.Millisecond, nil, logger)
+	testAPI := New(logger, dms, testFlags, testBuildInfo)
+
+	for _, grouping := range []map[string]string{
+		{"job": "nightly-etl", "env": "staging-1"},
+		{"job": "nightly-etl", "env": "prod"},
+		{"job": "other", "env": "staging-2"},
+	} {
+		errCh := make(chan error, 1)
+		dms.SubmitWriteRequest(storage.WriteRequest{
+			Labels:         grouping,
+			Timestamp:      time.Now(),
+			MetricFamilies: testutil.MetricFamiliesMap(mf1),
+			Done:           errCh,
+		})
+		for err := range errCh {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	scenarios := []struct {
+		query          string
+		expectedCode   int
+		expectedBody   string
+		expectedGroups int
+	}{
+		{
+			query:          "",
+			expectedCode:   http.StatusBadRequest,
+			expectedBody:   `{"status":"error","errorType":"bad_data","error":"no match[] parameter provided"}`,
+			expectedGroups: 3,
+		},
+		{
+			query:          "match[]=" + url.QueryEscape(`{job=~".*"}`),
+			expectedCode:   http.StatusBadRequest,
+			expectedBody:   `{"status":"error","errorType":"bad_data","error":"match[] parameter \"{job=~\\\".*\\\"}\" must contain at least one matcher that doesn't match the empty string"}`,
+			expectedGroups: 3,
+		},
+		{
+			query:          "match[]=" + url.QueryEscape(`{job="nightly-etl"`),
+			expectedCode:   http.StatusBadRequest,
+			expectedGroups: 3,
+		},
+		{
+			query:          "dry_run=maybe&match[]=" + url.QueryEscape(`{job="nightly-etl"}`),
+			expectedCode:   http.StatusBadRequest,
+			expectedGroups: 3,
+		},
+		{
+			query:          "dry_run=true&match[]=" + url.QueryEscape(`{job="nightly-etl",env=~"staging.*"}`),
+			expectedCode:   http.StatusOK,
+			expectedBody:   `{"status":"success","data":[{"env":"staging-1","job":"nightly-etl"}]}`,
+			expectedGroups: 3,
+		},
+		{
+			query:          "match[]=" + url.QueryEscape(`{job="nightly-etl",env=~"staging.*"}`) + "&match[]=" + url.QueryEscape(`{env="staging-2"}`),
+			expectedCode:   http.StatusOK,
+			expectedBody:   `{"status":"success","data":[{"env":"staging-1","job":"nightly-etl"},{"env":"staging-2","job":"other"}]}`,
+			expectedGroups: 1,
+		},
+		{
+			query:          "match[]=" + url.QueryEscape(`{env="staging-2"}`),
+			expectedCode:   http.StatusOK,
+			expectedBody:   `{"status":"success","data":[]}`,
+			expectedGroups: 1,
+		},
+	}
+	for i, s := range scenarios {
+		req, err := http.NewRequest("DELETE", "http://example.org/api/v1/groups?"+s.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		testAPI.deleteGroups(w, req)
+		if expected, got := s.expectedCode, w.Code; expected != got {
+			t.Errorf("%d. Wanted status code %v, got %v.", i, expected, got)
+		}
+		if s.expectedBody != "" {
+			if expected, got := s.expectedBody, w.Body.String(); expected != got {
+				t.Errorf("%d. Wanted response %s, got %s.", i, expected, got)
+			}
+		}
+		if expected, got := s.expectedGroups, len(dms.GetMetricFamiliesMap()); expected != got {
+			t.Errorf("%d. Wanted %d remaining groups, got %d.", i, expected, got)
+		}
+	}
+}
+
+func TestDeleteGroupsRequiresAdmin(t *testing.T) {
+	dms := storage.NewDiskMetricStore("", 100*time.Millisecond, nil, logger)
+	defer dms.Shutdown()
+	testAPI := New(logger, dms, testFlags, testBuildInfo)
+
+	for _, admin := range []bool{false, true} {
+		r := route.New()
+		testAPI.Register(r)
+		if admin {
+			testAPI.RegisterAdmin(r)
+		}
+		req := httptest.NewRequest("DELETE", "http://example.org/groups?match[]="+url.QueryEscape(`{job="a"}`), nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		expected := http.StatusMethodNotAllowed
+		if admin {
+			expected = http.StatusOK
+		}
+		if got := w.Code; expected != got {
+			t.Errorf("admin %t: Wanted status code %v, got %v.", admin, expected, got)
+		}
+	}
+}
+
+func TestDeleteGroupsCluster(t *testing.T) {
+	dms := storage.NewDiskMetricStore("", 100*time.Millisecond, nil, logger)
+	defer dms.Shutdown()
+	testAPI := New(logger, dms, testFlags, testBuildInfo)
+	var err error
+	testAPI.Cluster, err = cluster.NewRing([]string{"http://pg-a:9091", "http://pg-b:9091"}, "http://pg-a:9091")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushGroups(t, dms, time.Now(), map[string]string{"job": "a"})
+
+	req := httptest.NewRequest("DELETE", "http://example.org/groups?match[]="+url.QueryEscape(`{job="a"}`), nil)
+	w := httptest.NewRecorder()
+	testAPI.deleteGroups(w, req)
+	if expected, got := http.StatusBadRequest, w.Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+	if expected, got := `{"status":"error","errorType":"bad_data","error":"deleting groups by selectors is not supported with clustering"}`, w.Body.String(); expected != got {
+		t.Errorf("Wanted body %q, got %q.", expected, got)
+	}
+	if expected, got := 1, len(dms.GetMetricFamiliesMap()); expected != got {
+		t.Errorf("Wanted %d groups, got %d.", expected, got)
+	}
+}
+
+func TestDeleteGroupsAuthorization(t *testing.T) {
+	dms := storage.NewDiskMetricStore("", 100*time.Millisecond, nil, logger)
+	defer dms.Shutdown()
+	testAPI := New(logger, dms, testFlags, testBuildInfo)
+	cfg, err := authz.Load(`
+rules:
+  - bearer_token: token-a
+    match: ['{job=~"teamA-.*"}']
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testAPI.Authorizer = cfg
+	auditFile := filepath.Join(t.TempDir(), "audit.log")
+	if testAPI.AuditLog, err = audit.New(auditFile, 0, 0, logger); err != nil {
+		t.Fatal(err)
+	}
+	pushGroups(t, dms, time.Now(),
+		map[string]string{"job": "teamA-x", "env": "prod"},
+		map[string]string{"job": "teamA-y", "env": "prod"},
+		map[string]string{"job": "teamB-x", "env": "staging"},
+	)
+
+	scenarios := []struct {
+		selector       string
+		expectedCode   int
+		expectedBody   string
+		expectedGroups int
+	}{
+		{
+			selector:       `{job=~"team.*"}`,
+			expectedCode:   http.StatusForbidden,
+			expectedBody:   `{"status":"error","errorType":"forbidden","error":"credentials not allowed to change the group"}`,
+			expectedGroups: 3,
+		},
+		{
+			selector:       `{env="prod"}`,
+			expectedCode:   http.StatusOK,
+			expectedBody:   `{"status":"success","data":[{"env":"prod","job":"teamA-x"},{"env":"prod","job":"teamA-y"}]}`,
+			expectedGroups: 1,
+		},
+	}
+	for i, s := range scenarios {
+		req := httptest.NewRequest("DELETE", "http://example.org/api/v1/groups?match[]="+url.QueryEscape(s.selector), nil)
+		req.Header.Set("Authorization", "Bearer token-a")
+		w := httptest.NewRecorder()
+		testAPI.deleteGroups(w, req)
+		if expected, got := s.expectedCode, w.Code; expected != got {
+			t.Errorf("%d. Wanted status code %v, got %v.", i, expected, got)
+		}
+		if expected, got := s.expectedBody, w.Body.String(); expected != got {
+			t.Errorf("%d. Wanted response %s, got %s.", i, expected, got)
+		}
+		if expected, got := s.expectedGroups, len(dms.GetGroupSummaries()); expected != got {
+			t.Errorf("%d. Wanted %d remaining groups, got %d.", i, expected, got)
+		}
+	}
+
+	// Both deletions are audited, but not the dry run.
+	req := httptest.NewRequest("DELETE", "http://example.org/api/v1/groups?dry_run=true&match[]="+url.QueryEscape(`{env="staging"}`), nil)
+	testAPI.deleteGroups(httptest.NewRecorder(), req)
+	if err := testAPI.AuditLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if expected, got := 2, len(lines); expected != got {
+		t.Fatalf("Wanted %d audit entries, got %d: %q.", expected, got, lines)
+	}
+	for i, expected := range []audit.Entry{
+		{Operation: audit.OperationDelete, Method: "DELETE", Match: []string{`{job=~"team.*"}`}, Status: http.StatusForbidden, Result: audit.ResultRejected},
+		{Operation: audit.OperationDelete, Method: "DELETE", Match: []string{`{env="prod"}`}, Groups: 2, Status: http.StatusOK, Result: audit.ResultSuccess},
+	} {
+		var got audit.Entry
+		if err := json.Unmarshal([]byte(lines[i]), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(got.Identity, "bearer_token:") {
+			t.Errorf("%d. Wanted bearer token identity, got %q.", i, got.Identity)
+		}
+		got.Time, got.RemoteAddr, got.Identity, got.Error = time.Time{}, "", "", ""
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("%d. Wanted audit entry %+v, got %+v.", i, expected, got)
+		}
+	}
+}
+
+// queueFullStore is a DiskMetricStore whose write queue is full after the
+// provided number of deletions.
+type queueFullStore struct {
+	*storage.DiskMetricStore
+	fullAfter int
+}
+
+func (s *queueFullStore) DeleteGroups(selectors [][]*labels.Matcher, dryRun bool) ([]map[string]string, error) {
+	if s.fullAfter == 0 {
+		return nil, storage.ErrWriteQueueFull
+	}
+	s.fullAfter--
+	return s.DiskMetricStore.DeleteGroups(selectors, dryRun)
+}
+
+func (s *queueFullStore) DeleteGroupsByKey(keys []string) ([]map[string]string, error) {
+	if s.fullAfter == 0 {
+		return nil, storage.ErrWriteQueueFull
+	}
+	s.fullAfter--
+	return s.DiskMetricStore.DeleteGroupsByKey(keys)
+}
+
+func TestDeleteGroupsQueueFull(t *testing.T) {
+	dms := storage.NewDiskMetricStore("", 100*time.Millisecond, nil, logger)
+	defer dms.Shutdown()
+	pushGroups(t, dms, time.Now(), map[string]string{"job": "teamA-x"})
+	cfg, err := authz.Load(`
+rules:
+  - bearer_token: token-a
+    match: ['{job=~"teamA-.*"}']
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scenarios := []struct {
+		authorizer *authz.Config
+		fullAfter  int
+	}{
+		{fullAfter: 0},
+		{authorizer: cfg, fullAfter: 0}, // Finding the matching groups fails.
+		{authorizer: cfg, fullAfter: 1}, // Deleting the matching groups fails.
+	}
+	for i, s := range scenarios {
+		ms := &queueFullStore{DiskMetricStore: dms, fullAfter: s.fullAfter}
+		testAPI := New(logger, ms, testFlags, testBuildInfo)
+		if s.authorizer != nil {
+			testAPI.Authorizer = s.authorizer
+		}
+		req, err := http.NewRequest("DELETE", "http://example.org/api/v1/groups?match[]="+url.QueryEscape(`{job="teamA-x"}`), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer token-a")
+		w := httptest.NewRecorder()
+		testAPI.deleteGroups(w, req)
+		if expected, got := http.StatusTooManyRequests, w.Code; expected != got {
+			t.Errorf("%d. Wanted status code %v, got %v.", i, expected, got)
+		}
+		if expected, got := "1", w.Header().Get("Retry-After"); expected != got {
+			t.Errorf("%d. Wanted Retry-After %q, got %q.", i, expected, got)
+		}
+		if expected, got := `{"status":"error","errorType":"too_many_requests","error":"write queue is full"}`, w.Body.String(); expected != got {
+			t.Errorf("%d. Wanted response %s, got %s.", i, expected, got)
+		}
+		if expected, got := 1, len(dms.GetMetricFamiliesMap()); expected != got {
+			t.Errorf("%d. Wanted %d remaining groups, got %d.", i, expected, got)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus/pushgateway/storage"
+)
+
+// Values of the change field in a history diff.
+const (
+	changeAdded   = "added"
+	changeRemoved = "removed"
+	changeChanged = "changed"
+)
+
+type groupVersion struct {
+	Version        uint64             `json:"version"`
+	Timestamp      time.Time          `json:"timestamp"`
+	MetricFamilies map[string]metrics `json:"metric_families"`
+}
+
+type groupHistory struct {
+	Labels   map[string]string `json:"labels"`
+	Versions []groupVersion    `json:"versions"`
+}
+
+type versionRef struct {
+	Version   uint64    `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// historyDiff is the JSON representation of the differences between two
+// versions of a group. Only changed metric families and series are listed.
+type historyDiff struct {
+	From           versionRef   `json:"from"`
+	To             versionRef   `json:"to"`
+	MetricFamilies []familyDiff `json:"metric_families"`
+}
+
+// familyDiff lists the changed series of a metric family. FromType and ToType
+// are only set if the metric family exists in the respective version.
+type familyDiff struct {
+	Name     string       `json:"name"`
+	Change   string       `json:"change"`
+	FromType string       `json:"from_type,omitempty"`
+	ToType   string       `json:"to_type,omitempty"`
+	Series   []seriesDiff `json:"series"`
+}
+
+// seriesDiff shows a changed series, without its labels in From and To.
+type seriesDiff struct {
+	Labels map[string]string `json:"labels"`
+	Change string            `json:"change"`
+	From   encodableMetric   `json:"from,omitempty"`
+	To     encodableMetric   `json:"to,omitempty"`
+}
+
+// groupHistory returns the versions of the metric group identified by the key
+// in the URL path, oldest first.
+func (api *API) groupHistory(w http.ResponseWriter, r *http.Request) {
+	group, ok := api.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+	res := groupHistory{Labels: group.Labels, Versions: []groupVersion{}}
+	for _, v := range group.History {
+		gv := groupVersion{
+			Version:        v.Version,
+			Timestamp:      v.Timestamp,
+			MetricFamilies: make(map[string]metrics, len(v.Metrics)),
+		}
+		for name, tmf := range v.Metrics {
+			gv.MetricFamilies[name] = makeMetrics(tmf)
+		}
+		res.Versions = append(res.Versions, gv)
+	}
+	api.respond(w, res)
+}
+
+// groupHistoryDiff returns the differences between two versions of the metric
+// group identified by the key in the URL path. The versions are given by the
+// from and to parameters. If to is missing, the latest version is used. If
+// from is missing, the version before to is used. The push_time_seconds and
+// push_failure_time_seconds metric families are ignored.
+func (api *API) groupHistoryDiff(w http.ResponseWriter, r *http.Request) {
+	group, ok := api.groupFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		api.respondError(w, apiError{typ: errorBadData, err: err}, nil)
+		return
+	}
+	if len(group.History) == 0 {
+		api.respondError(w, apiError{typ: errorNotFound, err: errors.New("group has no history")}, nil)
+		return
+	}
+
+	toIdx := len(group.History) - 1
+	if s := r.Form.Get("to"); s != "" {
+		i, err := findVersion(group.History, s)
+		if err != nil {
+			api.respondError(w, *err, nil)
+			return
+		}
+		toIdx = i
+	}
+	fromIdx := toIdx - 1
+	if s := r.Form.Get("from"); s != "" {
+		i, err := findVersion(group.History, s)
+		if err != nil {
+			api.respondError(w, *err, nil)
+			return
+		}
+		fromIdx = i
+	} else if fromIdx < 0 {
+		api.respondError(w, apiError{
+			typ: errorNotFound,
+			err: fmt.Errorf("no version before version %d in history", group.History[toIdx].Version),
+		}, nil)
+		return
+	}
+
+	from, to := group.History[fromIdx], group.History[toIdx]
+	api.respond(w, historyDiff{
+		From:           versionRef{Version: from.Version, Timestamp: from.Timestamp},
+		To:             versionRef{Version: to.Version, Timestamp: to.Timestamp},
+		MetricFamilies: diffMetricFamilies(from.Metrics, to.Metrics),
+	})
+}
+
+// findVersion returns the index of the version given by the provided parameter
+// value in the history.
+func findVersion(history []storage.GroupVersion, s string) (int, *apiError) {
+	version, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, &apiError{typ: errorBadData, err: fmt.Errorf("invalid version %q: %w", s, err)}
+	}
+	i := slices.IndexFunc(history, func(v storage.GroupVersion) bool { return v.Version == version })
+	if i < 0 {
+		return 0, &apiError{typ: errorNotFound, err: fmt.Errorf("version %d not in history", version)}
+	}
+	return i, nil
+}
+
+// diffMetricFamilies returns the differences between the provided metric
+// families, sorted by name.
+func diffMetricFamilies(from, to storage.NameToTimestampedMetricFamilyMap) []familyDiff {
+	names := slices.Collect(maps.Keys(from))
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	result := []familyDiff{}
+	for _, name := range names {
+		if storage.IsPushTimestamp(name) {
+			continue
+		}
+		fromMF, toMF := from[name].GetMetricFamily(), to[name].GetMetricFamily()
+		fd := familyDiff{Name: name, Change: changeChanged}
+		switch {
+		case fromMF == nil:
+			fd.Change = changeAdded
+		case toMF == nil:
+			fd.Change = changeRemoved
+		}
+		if fromMF != nil {
+			fd.FromType = fromMF.GetType().String()
+		}
+		if toMF != nil {
+			fd.ToType = toMF.GetType().String()
+		}
+		fd.Series = diffSeries(fromMF, toMF)
+		if fd.Change == changeChanged && fd.FromType == fd.ToType && len(fd.Series) == 0 {
+			continue
+		}
+		result = append(result, fd)
+	}
+	return result
+}
+
+// diffSeries returns the changed series between the provided metric families,
+// either of which can be nil, sorted by labels.
+func diffSeries(from, to *dto.MetricFamily) []seriesDiff {
+	type series struct {
+		labels map[string]string
+		from   encodableMetric
+		to     encodableMetric
+	}
+	byLabels := map[string]*series{}
+	add := func(mf *dto.MetricFamily, set func(*series, encodableMetric)) {
+		for _, em := range makeEncodableMetrics(mf.GetMetric(), mf.GetType()) {
+			lbls := em["labels"].(map[string]string)
+			delete(em, "labels")
+			key := seriesKey(lbls)
+			s, ok := byLabels[key]
+			if !ok {
+				s = &series{labels: lbls}
+				byLabels[key] = s
+			}
+			set(s, em)
+		}
+	}
+	add(from, func(s *series, em encodableMetric) { s.from = em })
+	add(to, func(s *series, em encodableMetric) { s.to = em })
+
+	result := []seriesDiff{}
+	for _, key := range slices.Sorted(maps.Keys(byLabels)) {
+		s := byLabels[key]
+		sd := seriesDiff{Labels: s.labels, From: s.from, To: s.to, Change: changeChanged}
+		switch {
+		case s.from == nil:
+			sd.Change = changeAdded
+		case s.to == nil:
+			sd.Change = changeRemoved
+		case reflect.DeepEqual(s.from, s.to):
+			continue
+		}
+		result = append(result, sd)
+	}
+	return result
+}
+
+// seriesKey returns a string identifying the provided label set that sorts like
+// the sorted label pairs.
+func seriesKey(lbls map[string]string) string {
+	var sb strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(lbls)) {
+		sb.WriteString(name)
+		sb.WriteByte(0)
+		sb.WriteString(lbls[name])
+		sb.WriteByte(0)
+	}
+	return sb.String()
+}
@@ -0,0 +1,169 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/route"
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus/pushgateway/storage"
+)
+
+// temperatures returns a gauge metric family with one series per provided
+// room and value.
+func temperatures(roomValues ...any) *dto.MetricFamily {
+	mf := &dto.MetricFamily{
+		Name: proto.String("temperature"),
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+	for i := 0; i < len(roomValues); i += 2 {
+		mf.Metric = append(mf.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{{Name: proto.String("room"), Value: proto.String(roomValues[i].(string))}},
+			Gauge: &dto.Gauge{Value: proto.Float64(roomValues[i+1].(float64))},
+		})
+	}
+	return mf
+}
+
+func TestGroupHistoryAPI(t *testing.T) {
+	dms := storage.NewDiskMetricStore("", 100*time.Millisecond, nil, logger, storage.WithHistory(5, 0))
+	defer dms.Shutdown()
+	testAPI := New(logger, dms, testFlags, testBuildInfo)
+
+	grouping := map[string]string{"job": "batch"}
+	ts := time.Unix(1700000000, 0)
+	for i, wr := range []storage.WriteRequest{
+		{MetricFamilies: map[string]*dto.MetricFamily{"temperature": temperatures("a", 1.0, "b", 2.0)}},
+		{MetricFamilies: map[string]*dto.MetricFamily{
+			"temperature": temperatures("a", 1.0, "b", 3.0, "c", 4.0),
+			"runs_total": {
+				Name:   proto.String("runs_total"),
+				Type:   dto.MetricType_COUNTER.Enum(),
+				Metric: []*dto.Metric{{Counter: &dto.Counter{Value: proto.Float64(1)}}},
+			},
+		}},
+		{MetricFamilies: map[string]*dto.MetricFamily{}, Replace: true},
+	} {
+		errCh := make(chan error, 1)
+		wr.Labels = grouping
+		wr.Timestamp = ts.Add(time.Duration(i) * time.Second)
+		wr.Done = errCh
+		dms.SubmitWriteRequest(wr)
+		for err := range errCh {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+	key := encodeGroupKey("job\xffbatch")
+
+	get := func(h http.HandlerFunc, query string) *httptest.ResponseRecorder {
+		t.Helper()
+		req, err := http.NewRequest("GET", "http://example.org/api/v1/groups/"+key+"/history?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		h(w, req.WithContext(route.WithParam(req.Context(), "key", key)))
+		return w
+	}
+
+	w := get(testAPI.groupHistory, "")
+	if expected, got := http.StatusOK, w.Code; expected != got {
+		t.Fatalf("Wanted status code %v, got %v.", expected, got)
+	}
+	var resp struct {
+		Data groupHistory `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unexpected error unmarshaling response: %v", err)
+	}
+	if expected, got := 3, len(resp.Data.Versions); expected != got {
+		t.Fatalf("Wanted %d versions, got %d.", expected, got)
+	}
+	for i, v := range resp.Data.Versions {
+		if expected, got := uint64(i+1), v.Version; expected != got {
+			t.Errorf("Wanted version %d, got %d.", expected, got)
+		}
+		if _, ok := v.MetricFamilies["push_time_seconds"]; !ok {
+			t.Errorf("Wanted push_time_seconds in version %d.", v.Version)
+		}
+	}
+	if got := resp.Data.Versions[1].MetricFamilies["temperature"].Metrics[1]["value"]; got != "3" {
+		t.Errorf("Wanted value 3 of room b in version 2, got %v.", got)
+	}
+
+	scenarios := []struct {
+		query        string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			query:        "from=1&to=2",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"status":"success","data":{"from":{"version":1,"timestamp":"` + ts.Format(time.RFC3339) + `"},"to":{"version":2,"timestamp":"` + ts.Add(time.Second).Format(time.RFC3339) + `"},"metric_families":[` +
+				`{"name":"runs_total","change":"added","to_type":"COUNTER","series":[{"labels":{"instance":"","job":"batch"},"change":"added","to":{"value":"1"}}]},` +
+				`{"name":"temperature","change":"changed","from_type":"GAUGE","to_type":"GAUGE","series":[` +
+				`{"labels":{"instance":"","job":"batch","room":"b"},"change":"changed","from":{"value":"2"},"to":{"value":"3"}},` +
+				`{"labels":{"instance":"","job":"batch","room":"c"},"change":"added","to":{"value":"4"}}]}]}}`,
+		},
+		{
+			// Latest version against the one before.
+			query:        "",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"status":"success","data":{"from":{"version":2,"timestamp":"` + ts.Add(time.Second).Format(time.RFC3339) + `"},"to":{"version":3,"timestamp":"` + ts.Add(2*time.Second).Format(time.RFC3339) + `"},"metric_families":[` +
+				`{"name":"runs_total","change":"removed","from_type":"COUNTER","series":[{"labels":{"instance":"","job":"batch"},"change":"removed","from":{"value":"1"}}]},` +
+				`{"name":"temperature","change":"removed","from_type":"GAUGE","series":[` +
+				`{"labels":{"instance":"","job":"batch","room":"a"},"change":"removed","from":{"value":"1"}},` +
+				`{"labels":{"instance":"","job":"batch","room":"b"},"change":"removed","from":{"value":"3"}},` +
+				`{"labels":{"instance":"","job":"batch","room":"c"},"change":"removed","from":{"value":"4"}}]}]}}`,
+		},
+		{
+			query:        "from=2&to=2",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"status":"success","data":{"from":{"version":2,"timestamp":"` + ts.Add(time.Second).Format(time.RFC3339) + `"},"to":{"version":2,"timestamp":"` + ts.Add(time.Second).Format(time.RFC3339) + `"},"metric_families":[]}}`,
+		},
+		{
+			query:        "to=1",
+			expectedCode: http.StatusNotFound,
+			expectedBody: `{"status":"error","errorType":"not_found","error":"no version before version 1 in history"}`,
+		},
+		{
+			query:        "from=9",
+			expectedCode: http.StatusNotFound,
+			expectedBody: `{"status":"error","errorType":"not_found","error":"version 9 not in history"}`,
+		},
+		{
+			query:        "from=latest",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, s := range scenarios {
+		w := get(testAPI.groupHistoryDiff, s.query)
+		if expected, got := s.expectedCode, w.Code; expected != got {
+			t.Errorf("%q: Wanted status code %v, got %v.", s.query, expected, got)
+		}
+		if s.expectedBody != "" {
+			if expected, got := s.expectedBody, w.Body.String(); expected != got {
+				t.Errorf("%q: Wanted response %s, got %s.", s.query, expected, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/pushgateway/handler"
+	"github.com/prometheus/pushgateway/storage"
+)
+
+// problemParse is the kind of problem of a push body that cannot be parsed,
+// in addition to the kinds of storage.Problem.
+const problemParse storage.ProblemKind = "parse_error"
+
+type validationProblem struct {
+	Kind         storage.ProblemKind `json:"kind"`
+	MetricFamily string              `json:"metric_family,omitempty"`
+	Labels       map[string]string   `json:"labels,omitempty"`
+	Message      string              `json:"message"`
+}
+
+type validation struct {
+	Labels   map[string]string   `json:"labels"`
+	Valid    bool                `json:"valid"`
+	Problems []validationProblem `json:"problems"`
+}
+
+// validate returns a handler that checks the pushed metrics in the body of a
+// request with the same URL path and body as a push (see handler.Push) against
+// the current state of the MetricStore, without changing it. A PUT request is
+// validated like a PUT push, and a POST request like a POST push. All problems
+// found are returned, see storage.MetricStore.Validate.
+func (api *API) validate(jobBase64Encoded bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := api.MetricStore.Ready(); errors.Is(err, storage.ErrRestoring) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(handler.RestoreRetryAfter.Seconds())))
+			api.respondError(w, apiError{typ: errorUnavailable, err: err}, nil)
+			return
+		}
+		labels, increment, err := handler.ParsePushPath(r, jobBase64Encoded)
+		if err != nil {
+			api.respondError(w, apiError{typ: errorBadData, err: err}, nil)
+			return
+		}
+
+		res := validation{Labels: labels, Problems: []validationProblem{}}
+		metricFamilies, err := handler.ParseMetricFamilies(r)
+		if err != nil {
+			res.Problems = append(res.Problems, validationProblem{Kind: problemParse, Message: err.Error()})
+		} else {
+			for _, p := range api.MetricStore.Validate(storage.WriteRequest{
+				Labels:         labels,
+				Timestamp:      time.Now(),
+				MetricFamilies: metricFamilies,
+				Replace:        r.Method == http.MethodPut,
+				Increment:      increment,
+			}) {
+				res.Problems = append(res.Problems, validationProblem{
+					Kind:         p.Kind,
+					MetricFamily: p.MetricFamily,
+					Labels:       p.Labels,
+					Message:      p.Message,
+				})
+			}
+		}
+		res.Valid = len(res.Problems) == 0
+		api.respond(w, res)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/route"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus/pushgateway/storage"
+)
+
+func TestValidateAPI(t *testing.T) {
+	dms := storage.NewDiskMetricStore("", 100*time.Millisecond, nil, logger)
+	defer dms.Shutdown()
+	testAPI := New(logger, dms, testFlags, testBuildInfo)
+
+	errCh := make(chan error, 1)
+	dms.SubmitWriteRequest(storage.WriteRequest{
+		Labels:         map[string]string{"job": "batch"},
+		Timestamp:      time.Now(),
+		MetricFamilies: map[string]*dto.MetricFamily{"temperature": temperatures("a", 1.0)},
+		Done:           errCh,
+	})
+	for err := range errCh {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	scenarios := []struct {
+		name         string
+		method       string
+		params       map[string]string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "valid",
+			method:       "POST",
+			params:       map[string]string{"job": "other"},
+			body:         "# TYPE temperature gauge\ntemperature{room=\"b\"} 1\n",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"status":"success","data":{"labels":{"job":"other"},"valid":true,"problems":[]}}`,
+		},
+		{
+			name:         "all problems",
+			method:       "POST",
+			params:       map[string]string{"job": "other", "labels": "/instance/x"},
+			body:         "# TYPE temperature counter\ntemperature 1\nruns 1 1000\nruns{x=\"y\"} 2\nruns{x=\"y\"} 3\n",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"status":"success","data":{"labels":{"instance":"x","job":"other"},"valid":false,"problems":[` +
+				`{"kind":"timestamp","metric_family":"runs","labels":{"instance":"x","job":"other"},"message":"pushed metrics must not have timestamps: collected metric \"runs\" { label:{name:\"instance\" value:\"x\"} label:{name:\"job\" value:\"other\"} untyped:{value:1} timestamp_ms:1000}"},` +
+				`{"kind":"duplicate_series","metric_family":"runs","labels":{"instance":"x","job":"other","x":"y"},"message":"collected metric \"runs\" { label:{name:\"instance\" value:\"x\"} label:{name:\"job\" value:\"other\"} label:{name:\"x\" value:\"y\"} untyped:{value:3}} was collected before with the same name and label values"},` +
+				`{"kind":"type_conflict","metric_family":"temperature","labels":{"instance":"x","job":"other"},"message":"collected metric \"temperature\" { label:{name:\"instance\" value:\"x\"} label:{name:\"job\" value:\"other\"} counter:{value:1}} is not a GAUGE"}]}}`,
+		},
+		{
+			// Replacing the only group with the type makes it valid.
+			name:         "replace",
+			method:       "PUT",
+			params:       map[string]string{"job": "batch"},
+			body:         "# TYPE temperature counter\ntemperature 1\n",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"status":"success","data":{"labels":{"job":"batch"},"valid":true,"problems":[]}}`,
+		},
+		{
+			name:         "unparsable",
+			method:       "POST",
+			params:       map[string]string{"job": "other"},
+			body:         "not a metric\n",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"status":"success","data":{"labels":{"job":"other"},"valid":false,"problems":[{"kind":"parse_error","message":"text format parsing error in line 1: expected float as value, got \"a\""}]}}`,
+		},
+		{
+			name:         "malformed path",
+			method:       "POST",
+			params:       map[string]string{"job": "other", "labels": "/instance"},
+			expectedCode: http.StatusBadRequest,
+			expectedBody: `{"status":"error","errorType":"bad_data","error":"odd number of components in label string \"/instance\""}`,
+		},
+	}
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			req, err := http.NewRequest(s.method, "http://example.org/api/v1/validate/job/x", strings.NewReader(s.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := req.Context()
+			for k, v := range s.params {
+				ctx = route.WithParam(ctx, k, v)
+			}
+			w := httptest.NewRecorder()
+			testAPI.validate(false)(w, req.WithContext(ctx))
+			if expected, got := s.expectedCode, w.Code; expected != got {
+				t.Errorf("Wanted status code %v, got %v.", expected, got)
+			}
+			// The text format of protobuf messages in error messages
+			// randomly contains double spaces.
+			if expected, got := s.expectedBody, strings.ReplaceAll(w.Body.String(), "  ", " "); expected != got {
+				t.Errorf("Wanted response %s, got %s.", expected, got)
+			}
+		})
+	}
+
+	// Nothing has been stored.
+	if groups := dms.GetMetricFamiliesMap(); len(groups) != 1 {
+		t.Errorf("Wanted 1 group, got %d.", len(groups))
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/prometheus/pushgateway/storage"
+)
+
+const (
+	// watchBufferSize is the number of events buffered for each watch
+	// stream. If a client doesn't keep up, the stream is closed once the
+	// buffer is full.
+	watchBufferSize = 1024
+	// watchHeartbeatInterval is the interval at which a comment is sent on
+	// an idle watch stream to keep intermediaries from closing it.
+	watchHeartbeatInterval = 30 * time.Second
+)
+
+// watchEvent is the JSON representation of a storage.Event.
+type watchEvent struct {
+	Labels    map[string]string `json:"labels"`
+	Operation storage.Operation `json:"operation"`
+	Success   bool              `json:"success"`
+	Error     string            `json:"error,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// watch streams the changes to the metric groups as Server-Sent Events, each
+// with a watchEvent as JSON data. The groups can be filtered by match[]
+// selectors. The stream ends when the client disconnects, when the client
+// doesn't keep up with the events, or when CloseWatches is called.
+func (api *API) watch(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		api.respondError(w, apiError{typ: errorBadData, err: err}, nil)
+		return
+	}
+	var selectors [][]*labels.Matcher
+	if matchParams := r.Form["match[]"]; len(matchParams) > 0 {
+		var err error
+		if selectors, err = parseSelectors(matchParams); err != nil {
+			api.respondError(w, apiError{typ: errorBadData, err: err}, nil)
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		api.respondError(w, apiError{typ: errorInternal, err: errors.New("streaming not supported")}, nil)
+		return
+	}
+
+	events, cancel := api.MetricStore.Watch(selectors, watchBufferSize)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(watchHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			we := watchEvent{
+				Labels:    e.Labels,
+				Operation: e.Operation,
+				Success:   e.Err == nil,
+				Timestamp: e.Timestamp,
+			}
+			if e.Err != nil {
+				we.Error = e.Err.Error()
+			}
+			var b []byte
+			if b, err = json.Marshal(we); err != nil {
+				api.logger.Error("error marshaling JSON", "err", err)
+				return
+			}
+			_, err = fmt.Fprintf(w, "data: %s\n\n", b)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		case <-api.closing:
+			return
+		}
+		if err != nil {
+			api.logger.Debug("failed to write event to connection", "err", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// CloseWatches ends all current and future watch streams so that the HTTP
+// server can shut down gracefully.
+func (api *API) CloseWatches() {
+	api.closeOnce.Do(func() { close(api.closing) })
+}
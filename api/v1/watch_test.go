@@ -0,0 +1,124 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus/pushgateway/storage"
+	"github.com/prometheus/pushgateway/testutil"
+)
+
+func TestWatchAPI(t *testing.T) {
+	dms := storage.NewDiskMetricStore("", 100*time.Millisecond, nil, logger)
+	testAPI := New(logger, dms, testFlags, testBuildInfo)
+	server := httptest.NewServer(http.HandlerFunc(testAPI.watch))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?match[]=" + url.QueryEscape(`{job="watched"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if expected, got := http.StatusOK, resp.StatusCode; expected != got {
+		t.Fatalf("Wanted status code %v, got %v.", expected, got)
+	}
+	if expected, got := "text/event-stream", resp.Header.Get("Content-Type"); expected != got {
+		t.Errorf("Wanted content type %q, got %q.", expected, got)
+	}
+
+	ts := time.Unix(1700000000, 0)
+	mf1ts := proto.Clone(mf1).(*dto.MetricFamily)
+	mf1ts.Metric[0].TimestampMs = proto.Int64(1700000000000)
+	for _, wr := range []storage.WriteRequest{
+		{Labels: map[string]string{"job": "ignored"}, Timestamp: ts, MetricFamilies: testutil.MetricFamiliesMap(mf1)},
+		{Labels: map[string]string{"job": "watched"}, Timestamp: ts, MetricFamilies: testutil.MetricFamiliesMap(mf1), Replace: true},
+		{Labels: map[string]string{"job": "watched"}, Timestamp: ts, MetricFamilies: testutil.MetricFamiliesMap(mf1ts)},
+	} {
+		errCh := make(chan error, 1)
+		wr.Done = errCh
+		dms.SubmitWriteRequest(wr)
+		for range errCh {
+		}
+	}
+
+	var events []watchEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for len(events) < 2 && scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var e watchEvent
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			t.Fatalf("unexpected error unmarshaling event %q: %v", data, err)
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := 2, len(events); expected != got {
+		t.Fatalf("Wanted %d events, got %d.", expected, got)
+	}
+	if !events[0].Timestamp.Equal(ts) {
+		t.Errorf("Wanted timestamp %v, got %v.", ts, events[0].Timestamp)
+	}
+	events[0].Timestamp = time.Time{}
+	if expected, got := (watchEvent{
+		Labels:    map[string]string{"job": "watched"},
+		Operation: storage.OperationReplace,
+		Success:   true,
+	}), events[0]; !reflect.DeepEqual(expected, got) {
+		t.Errorf("Wanted event %v, got %v.", expected, got)
+	}
+	if got := events[1]; got.Operation != storage.OperationPush || got.Success || got.Error == "" {
+		t.Errorf("Wanted failed push event, got %v.", got)
+	}
+
+	// Closing the watches ends the stream.
+	testAPI.CloseWatches()
+	for scanner.Scan() {
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchAPIBadSelector(t *testing.T) {
+	dms := storage.NewDiskMetricStore("", 100*time.Millisecond, nil, logger)
+	testAPI := New(logger, dms, testFlags, testBuildInfo)
+
+	req, err := http.NewRequest("GET", "http://example.org/api/v1/watch?match[]="+url.QueryEscape(`{job=`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	testAPI.watch(w, req)
+	if expected, got := http.StatusBadRequest, w.Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+}
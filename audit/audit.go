@@ -0,0 +1,199 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package audit writes a log of the changes requested via the push API, one
+// JSON object per line, to a file that is rotated by size.
+package audit
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Values of the Operation field of an Entry.
+const (
+	OperationPush   = "push"
+	OperationDelete = "delete"
+	OperationWipe   = "wipe"
+)
+
+// Values of the Result field of an Entry.
+const (
+	// ResultSuccess is the result of requests answered with a 2xx status
+	// code. Note that unchecked pushes and deletions are answered with
+	// http.StatusAccepted.
+	ResultSuccess = "success"
+	// ResultRejected is the result of requests answered with an error.
+	ResultRejected = "rejected"
+	// ResultCanceled is the result of requests canceled by the client
+	// before they were answered.
+	ResultCanceled = "canceled"
+)
+
+// queueCapacity is the number of entries that can be waiting to be written.
+// Entries recorded while the queue is full are dropped.
+const queueCapacity = 4096
+
+// dropWarningInterval is the minimum interval between warnings about entries
+// dropped because the queue was full.
+const dropWarningInterval = 10 * time.Second
+
+var droppedEntries = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "pushgateway_audit_dropped_entries_total",
+		Help: "Total audit log entries dropped because the queue was full (reason=queue_full) or writing failed (reason=write_error).",
+	},
+	[]string{"reason"},
+)
+
+func init() {
+	droppedEntries.WithLabelValues("queue_full")
+	droppedEntries.WithLabelValues("write_error")
+}
+
+// Entry is a line of the audit log.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Method    string    `json:"method"`
+	// RemoteAddr is the network address of the client. ForwardedFor is the
+	// X-Forwarded-For header, if set by a proxy in between (including
+	// another member of the cluster).
+	RemoteAddr   string `json:"remote_addr"`
+	ForwardedFor string `json:"forwarded_for,omitempty"`
+	// Identity names the credentials the request was authorized with (see
+	// authz.Rule.Identity) or the subject of its verified TLS client
+	// certificate.
+	Identity string `json:"identity,omitempty"`
+	// Labels are the grouping labels of the changed group. They are empty
+	// for a wipe.
+	Labels map[string]string `json:"labels,omitempty"`
+	// MetricFamilies are the names of the pushed metric families, sorted,
+	// and Series is the number of pushed series.
+	MetricFamilies []string `json:"metric_families,omitempty"`
+	Series         int      `json:"series"`
+	// Groups is the number of groups deleted by a wipe or a deletion by
+	// selectors, and Match are the selectors of the latter.
+	Groups int      `json:"groups,omitempty"`
+	Match  []string `json:"match,omitempty"`
+	// Bytes is the size of the request body read, after decompression.
+	Bytes  int64  `json:"bytes"`
+	Status int    `json:"status,omitempty"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Log writes entries to a rotating file in its own goroutine so that recording
+// an entry never blocks. A nil *Log discards all entries.
+type Log struct {
+	file    *rotatingFile
+	entries chan Entry
+	logger  *slog.Logger
+
+	// dropped counts the entries dropped because the queue was full since
+	// the last warning, logged at lastDropWarning (in Unix nanoseconds).
+	dropped         atomic.Int64
+	lastDropWarning atomic.Int64
+
+	closeOnce sync.Once
+	done      chan struct{} // Closed by Close.
+	stopped   chan struct{} // Closed once all entries are written.
+}
+
+// New returns a Log writing to the named file, which is created if needed and
+// appended to otherwise. Once the file would exceed maxSize bytes, it is
+// renamed with the suffix ".1" (after renaming older files from ".1" to ".2"
+// and so on) and a new file is started, keeping at most maxFiles rotated files.
+// A maxSize of zero disables rotation.
+func New(filename string, maxSize int64, maxFiles int, logger *slog.Logger) (*Log, error) {
+	file, err := openRotatingFile(filename, maxSize, maxFiles)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{
+		file:    file,
+		entries: make(chan Entry, queueCapacity),
+		logger:  logger,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go l.loop()
+	return l, nil
+}
+
+// Record queues the provided entry for writing. It never blocks. If the queue
+// is full, the entry is dropped, which is counted and logged as a warning (at
+// most once per dropWarningInterval, with the number of entries dropped since
+// the last warning).
+func (l *Log) Record(e Entry) {
+	if l == nil {
+		return
+	}
+	select {
+	case l.entries <- e:
+	default:
+		droppedEntries.WithLabelValues("queue_full").Inc()
+		l.dropped.Add(1)
+		now, last := time.Now().UnixNano(), l.lastDropWarning.Load()
+		if now-last >= int64(dropWarningInterval) && l.lastDropWarning.CompareAndSwap(last, now) {
+			l.logger.Warn("audit log queue full, dropping entries", "dropped", l.dropped.Swap(0))
+		}
+	}
+}
+
+// Close writes all queued entries and closes the file. Entries recorded
+// afterwards are never written.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.closeOnce.Do(func() { close(l.done) })
+	<-l.stopped
+	return l.file.Close()
+}
+
+func (l *Log) loop() {
+	defer close(l.stopped)
+	for {
+		select {
+		case e := <-l.entries:
+			l.write(e)
+		case <-l.done:
+			for {
+				select {
+				case e := <-l.entries:
+					l.write(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (l *Log) write(e Entry) {
+	line, err := json.Marshal(e)
+	if err == nil {
+		_, err = l.file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		droppedEntries.WithLabelValues("write_error").Inc()
+		l.logger.Error("failed to write audit log entry", "err", err)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+)
+
+var logger = promslog.NewNopLogger()
+
+func TestLog(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	// An existing file is appended to.
+	if err := os.WriteFile(filename, []byte(`{"operation":"wipe","groups":2}`+"\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	l, err := New(filename, 0, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Record(Entry{
+		Time:           time.Unix(1700000000, 0).UTC(),
+		Operation:      OperationPush,
+		Method:         "PUT",
+		RemoteAddr:     "192.0.2.1:1234",
+		Identity:       "basic_auth:team-a",
+		Labels:         map[string]string{"job": "batch"},
+		MetricFamilies: []string{"a", "b"},
+		Series:         3,
+		Bytes:          42,
+		Status:         200,
+		Result:         ResultSuccess,
+	})
+	l.Record(Entry{Operation: OperationDelete, Status: 403, Result: ResultRejected, Error: "forbidden"})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Recording after Close neither blocks nor panics.
+	l.Record(Entry{})
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"operation":"wipe","groups":2}
+{"time":"2023-11-14T22:13:20Z","operation":"push","method":"PUT","remote_addr":"192.0.2.1:1234","identity":"basic_auth:team-a","labels":{"job":"batch"},"metric_families":["a","b"],"series":3,"bytes":42,"status":200,"result":"success"}
+{"time":"0001-01-01T00:00:00Z","operation":"delete","method":"","remote_addr":"","series":0,"bytes":0,"status":403,"result":"rejected","error":"forbidden"}
+`
+	if got := string(content); got != expected {
+		t.Errorf("Expected content\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	rf, err := openRotatingFile(filename, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n", "a very long line\n", "ffff\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{
+		filename:        "ffff\n",
+		filename + ".1": "a very long line\n",
+		filename + ".2": "eeee\n",
+	} {
+		content, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(content); got != expected {
+			t.Errorf("Expected %q in %s, got %q.", expected, name, got)
+		}
+	}
+	if _, err := os.Stat(filename + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected no third rotated file, got error %v.", err)
+	}
+}
+
+func TestRecordNeverBlocks(t *testing.T) {
+	// A Log whose loop isn't running, as if it was stuck writing.
+	var logs bytes.Buffer
+	l := &Log{entries: make(chan Entry, 1), logger: slog.New(slog.NewTextHandler(&logs, nil))}
+	before := testutil.ToFloat64(droppedEntries.WithLabelValues("queue_full"))
+
+	done := make(chan struct{})
+	go func() {
+		l.Record(Entry{})
+		l.Record(Entry{})
+		l.Record(Entry{})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Record blocked.")
+	}
+	if expected, got := before+2, testutil.ToFloat64(droppedEntries.WithLabelValues("queue_full")); expected != got {
+		t.Errorf("Expected %v dropped entries, got %v.", expected, got)
+	}
+	// Only the first drop is logged, the next one is left for a later warning.
+	if expected, got := 1, strings.Count(logs.String(), "audit log queue full"); expected != got {
+		t.Errorf("Expected %d warning(s), got %d in %q.", expected, got, logs.String())
+	}
+	if expected, got := int64(1), l.dropped.Load(); expected != got {
+		t.Errorf("Expected %d drop(s) since the warning, got %d.", expected, got)
+	}
+
+	// A nil Log discards entries.
+	var nilLog *Log
+	nilLog.Record(Entry{})
+	if err := nilLog.Close(); err != nil {
+		t.Error(err)
+	}
+}
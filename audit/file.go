@@ -0,0 +1,105 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// rotatingFile is an io.WriteCloser appending to a file that is rotated by size
+// as described for New. It is not safe for concurrent use.
+type rotatingFile struct {
+	name     string
+	maxSize  int64
+	maxFiles int
+
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(name string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	rf := &rotatingFile{name: name, maxSize: maxSize, maxFiles: maxFiles}
+	if err := rf.open(os.O_APPEND); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open(flag int) error {
+	f, err := os.OpenFile(rf.name, os.O_WRONLY|os.O_CREATE|flag, 0o640)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, fi.Size()
+	return nil
+}
+
+// Write writes p to the file, rotating it first if p would make it exceed the
+// maximum size. A p larger than the maximum size is written to an empty file.
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	if rf.f == nil {
+		// A previous rotation failed to open the new file.
+		if err := rf.open(os.O_APPEND); err != nil {
+			return 0, err
+		}
+	}
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, fmt.Errorf("rotating %s: %w", rf.name, err)
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	err := rf.f.Close()
+	rf.f = nil
+	if err != nil {
+		return err
+	}
+	if rf.maxFiles <= 0 {
+		return rf.open(os.O_TRUNC)
+	}
+	for i := rf.maxFiles - 1; i > 0; i-- {
+		err := os.Rename(rf.rotatedName(i), rf.rotatedName(i+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(rf.name, rf.rotatedName(1)); err != nil {
+		return err
+	}
+	return rf.open(os.O_TRUNC)
+}
+
+func (rf *rotatingFile) rotatedName(i int) string {
+	return fmt.Sprintf("%s.%d", rf.name, i)
+}
+
+func (rf *rotatingFile) Close() error {
+	if rf.f == nil {
+		return nil
+	}
+	return rf.f.Close()
+}
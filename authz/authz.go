@@ -0,0 +1,233 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package authz implements the authorization of changes to metric groups. It
+// maps the credentials of a request (bearer tokens, basic-auth users, or the
+// subjects of verified TLS client certificates) to selectors of the grouping
+// labels of the groups the request may change.
+package authz
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"go.yaml.in/yaml/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrUnauthenticated is returned by Config.Authorize if the request
+	// carries no credentials of any rule.
+	ErrUnauthenticated = errors.New("no valid credentials provided")
+	// ErrForbidden is returned by Config.Authorize if the credentials of
+	// the request are valid but don't allow changing the group.
+	ErrForbidden = errors.New("credentials not allowed to change the group")
+)
+
+// Config is the authorization configuration. A request is authorized to change
+// a metric group if it carries the credentials of at least one rule whose
+// selectors match the grouping labels of the group.
+type Config struct {
+	Rules []*Rule `yaml:"rules"`
+}
+
+// Rule allows the holder of its credentials to change the metric groups
+// matching at least one of its selectors. Exactly one kind of credentials has
+// to be set.
+type Rule struct {
+	// BearerToken is the token expected in an "Authorization: Bearer"
+	// request header.
+	BearerToken config.Secret `yaml:"bearer_token,omitempty"`
+	// BasicAuth are the expected basic-auth credentials.
+	BasicAuth *BasicAuth `yaml:"basic_auth,omitempty"`
+	// ClientCertSubject is the expected subject of a verified TLS client
+	// certificate in the format of pkix.Name.String, e.g.
+	// "CN=team-a,O=Example".
+	ClientCertSubject string `yaml:"client_cert_subject,omitempty"`
+	// Match are selectors in the usual Prometheus syntax, applied to the
+	// grouping labels. A grouping label that is not part of the grouping
+	// key matches like a label with an empty value.
+	Match []string `yaml:"match"`
+
+	selectors [][]*labels.Matcher
+	identity  string
+}
+
+// BasicAuth are basic-auth credentials. The password is stored as a bcrypt
+// hash, like in the web configuration file.
+type BasicAuth struct {
+	Username     string        `yaml:"username"`
+	PasswordHash config.Secret `yaml:"password_hash"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (r *Rule) UnmarshalYAML(unmarshal func(any) error) error {
+	type plain Rule
+	if err := unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+	credentials := 0
+	if r.BearerToken != "" {
+		// Never reveal the token itself.
+		sum := sha256.Sum256([]byte(r.BearerToken))
+		r.identity = "bearer_token:" + hex.EncodeToString(sum[:4])
+		credentials++
+	}
+	if r.BasicAuth != nil {
+		if r.BasicAuth.Username == "" || r.BasicAuth.PasswordHash == "" {
+			return errors.New("basic_auth requires username and password_hash")
+		}
+		r.identity = "basic_auth:" + r.BasicAuth.Username
+		credentials++
+	}
+	if r.ClientCertSubject != "" {
+		r.identity = "client_cert:" + r.ClientCertSubject
+		credentials++
+	}
+	if credentials != 1 {
+		return errors.New("exactly one of bearer_token, basic_auth, and client_cert_subject must be set")
+	}
+	if len(r.Match) == 0 {
+		return errors.New("at least one match selector is required")
+	}
+	var err error
+	if r.selectors, err = parser.NewParser(parser.Options{}).ParseMetricSelectors(r.Match); err != nil {
+		return fmt.Errorf("invalid match selector: %w", err)
+	}
+	return nil
+}
+
+// Load parses the YAML input s into a Config.
+func Load(s string) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict([]byte(s), cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadFile parses the provided YAML file into a Config.
+func LoadFile(filename string) (*Config, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Load(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("parsing YAML file %s: %w", filename, err)
+	}
+	return cfg, nil
+}
+
+// Authorize returns nil if the provided request is authorized to change the
+// metric group with the provided grouping labels. Otherwise, it returns
+// ErrUnauthenticated or ErrForbidden. Unless the error is ErrUnauthenticated,
+// the returned identity names the credentials of the request (see Identity).
+func (c *Config) Authorize(r *http.Request, groupingLabels map[string]string) (identity string, err error) {
+	for _, rule := range c.Rules {
+		if !rule.authenticates(r) {
+			continue
+		}
+		if identity == "" {
+			identity = rule.identity
+		}
+		if rule.allows(groupingLabels) {
+			return rule.identity, nil
+		}
+	}
+	if identity != "" {
+		return identity, ErrForbidden
+	}
+	return "", ErrUnauthenticated
+}
+
+// Identity returns the identity of the holder of the rule's credentials, to be
+// used in logs: "basic_auth:" followed by the username, "client_cert:"
+// followed by the certificate subject, or "bearer_token:" followed by the
+// first 8 hex digits of the SHA-256 hash of the token.
+func (r *Rule) Identity() string {
+	return r.identity
+}
+
+// authenticates returns whether the request carries the credentials of the
+// rule.
+func (r *Rule) authenticates(req *http.Request) bool {
+	switch {
+	case r.BearerToken != "":
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		return ok && subtle.ConstantTimeCompare([]byte(token), []byte(r.BearerToken)) == 1
+	case r.BasicAuth != nil:
+		username, password, ok := req.BasicAuth()
+		return ok && username == r.BasicAuth.Username &&
+			bcrypt.CompareHashAndPassword([]byte(r.BasicAuth.PasswordHash), []byte(password)) == nil
+	default:
+		// Only verified certificates count. Unverified ones are
+		// presented if the web configuration requests, but doesn't
+		// require, client certificates.
+		if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+			return false
+		}
+		return req.TLS.VerifiedChains[0][0].Subject.String() == r.ClientCertSubject
+	}
+}
+
+// allows returns whether the rule's selectors match the provided grouping
+// labels.
+func (r *Rule) allows(groupingLabels map[string]string) bool {
+	for _, selector := range r.selectors {
+		matches := true
+		for _, m := range selector {
+			if !m.Matches(groupingLabels[m.Name]) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// Dynamic authorizes requests with a Config that can be replaced at any time.
+// The zero value is ready to use and authorizes every request until a Config
+// is set.
+type Dynamic struct {
+	cfg atomic.Pointer[Config]
+}
+
+// SetConfig replaces the Config. With a nil Config, every request is
+// authorized.
+func (d *Dynamic) SetConfig(cfg *Config) {
+	d.cfg.Store(cfg)
+}
+
+// Authorize authorizes the request with the current Config, see
+// Config.Authorize.
+func (d *Dynamic) Authorize(r *http.Request, groupingLabels map[string]string) (string, error) {
+	cfg := d.cfg.Load()
+	if cfg == nil {
+		return "", nil
+	}
+	return cfg.Authorize(r, groupingLabels)
+}
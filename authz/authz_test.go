@@ -0,0 +1,250 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authz
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoad(t *testing.T) {
+	scenarios := []struct {
+		name        string
+		config      string
+		expectedErr string
+	}{
+		{
+			name: "valid",
+			config: `
+rules:
+  - bearer_token: token-a
+    match: ['{job=~"teamA-.*"}']
+  - basic_auth:
+      username: team-b
+      password_hash: $2y$10$abcdefghijklmnopqrstuv
+    match: ['{job="teamB"}', '{job="shared",team="b"}']
+  - client_cert_subject: CN=team-c
+    match: ['{job=~"teamC-.*"}']
+`,
+		},
+		{
+			name: "no credentials",
+			config: `
+rules:
+  - match: ['{job="a"}']
+`,
+			expectedErr: "exactly one of",
+		},
+		{
+			name: "two credentials",
+			config: `
+rules:
+  - bearer_token: token
+    client_cert_subject: CN=a
+    match: ['{job="a"}']
+`,
+			expectedErr: "exactly one of",
+		},
+		{
+			name: "incomplete basic auth",
+			config: `
+rules:
+  - basic_auth:
+      username: a
+    match: ['{job="a"}']
+`,
+			expectedErr: "requires username and password_hash",
+		},
+		{
+			name: "no match",
+			config: `
+rules:
+  - bearer_token: token
+`,
+			expectedErr: "at least one match selector",
+		},
+		{
+			name: "invalid match",
+			config: `
+rules:
+  - bearer_token: token
+    match: ['{job=']
+`,
+			expectedErr: "invalid match selector",
+		},
+		{
+			name: "unknown field",
+			config: `
+rules:
+  - bearer_token: token
+    match: ['{job="a"}']
+    allow: all
+`,
+			expectedErr: "field allow not found",
+		},
+	}
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			_, err := Load(s.config)
+			if s.expectedErr == "" {
+				if err != nil {
+					t.Fatal("Unexpected error:", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), s.expectedErr) {
+				t.Errorf("Expected error containing %q, got %v.", s.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("password-b"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(`
+rules:
+  - bearer_token: token-a
+    match: ['{job=~"teamA-.*"}']
+  - basic_auth:
+      username: team-b
+      password_hash: ` + string(hash) + `
+    match: ['{job="teamB"}', '{job="shared",team="b"}']
+  - client_cert_subject: CN=team-c,O=Example
+    match: ['{job=~"teamC-.*",instance=""}']
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	basicAuth := func(username, password string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(username, password) }
+	}
+	clientCert := func(subject pkix.Name, verified bool) func(*http.Request) {
+		return func(r *http.Request) {
+			cert := &x509.Certificate{Subject: subject}
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+			if verified {
+				r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+			}
+		}
+	}
+	teamC := pkix.Name{CommonName: "team-c", Organization: []string{"Example"}}
+
+	scenarios := []struct {
+		name        string
+		credentials func(*http.Request)
+		labels      map[string]string
+		expectedErr error
+		expectedID  string
+	}{
+		{
+			name:        "bearer token allowed",
+			credentials: bearer("token-a"),
+			labels:      map[string]string{"job": "teamA-batch", "instance": "x"},
+			expectedID:  "bearer_token:a70bf50e",
+		},
+		{
+			name:        "bearer token forbidden",
+			credentials: bearer("token-a"),
+			labels:      map[string]string{"job": "teamB"},
+			expectedErr: ErrForbidden,
+			expectedID:  "bearer_token:a70bf50e",
+		},
+		{
+			name:        "wrong bearer token",
+			credentials: bearer("token-b"),
+			labels:      map[string]string{"job": "teamA-batch"},
+			expectedErr: ErrUnauthenticated,
+		},
+		{
+			name:        "no credentials",
+			credentials: func(*http.Request) {},
+			labels:      map[string]string{"job": "teamA-batch"},
+			expectedErr: ErrUnauthenticated,
+		},
+		{
+			name:        "basic auth allowed by second selector",
+			credentials: basicAuth("team-b", "password-b"),
+			labels:      map[string]string{"job": "shared", "team": "b"},
+			expectedID:  "basic_auth:team-b",
+		},
+		{
+			name:        "basic auth forbidden",
+			credentials: basicAuth("team-b", "password-b"),
+			labels:      map[string]string{"job": "shared"},
+			expectedErr: ErrForbidden,
+			expectedID:  "basic_auth:team-b",
+		},
+		{
+			name:        "wrong basic auth password",
+			credentials: basicAuth("team-b", "password-a"),
+			labels:      map[string]string{"job": "teamB"},
+			expectedErr: ErrUnauthenticated,
+		},
+		{
+			name:        "client certificate allowed",
+			credentials: clientCert(teamC, true),
+			labels:      map[string]string{"job": "teamC-etl"},
+			expectedID:  "client_cert:CN=team-c,O=Example",
+		},
+		{
+			name:        "client certificate forbidden by empty instance matcher",
+			credentials: clientCert(teamC, true),
+			labels:      map[string]string{"job": "teamC-etl", "instance": "x"},
+			expectedErr: ErrForbidden,
+			expectedID:  "client_cert:CN=team-c,O=Example",
+		},
+		{
+			name:        "unverified client certificate",
+			credentials: clientCert(teamC, false),
+			labels:      map[string]string{"job": "teamC-etl"},
+			expectedErr: ErrUnauthenticated,
+		},
+		{
+			name:        "other client certificate",
+			credentials: clientCert(pkix.Name{CommonName: "team-c"}, true),
+			labels:      map[string]string{"job": "teamC-etl"},
+			expectedErr: ErrUnauthenticated,
+		},
+	}
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			req, err := http.NewRequest("PUT", "http://example.org/metrics/job/x", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.credentials(req)
+			id, err := cfg.Authorize(req, s.labels)
+			if !errors.Is(err, s.expectedErr) {
+				t.Errorf("Expected error %v, got %v.", s.expectedErr, err)
+			}
+			if id != s.expectedID {
+				t.Errorf("Expected identity %q, got %q.", s.expectedID, id)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cluster distributes metric groups over a static set of Pushgateways.
+// Each group is owned by exactly one member, determined by a consistent-hash
+// ring over its grouping key.
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// virtualNodes is the number of points each member has on the ring. More points
+// distribute the groups more evenly between the members.
+const virtualNodes = 128
+
+// Ring is a consistent-hash ring over the grouping keys of metric groups. As
+// long as all members are configured with the same member URLs, they agree on
+// the owner of each group. Adding or removing a member only moves the groups
+// owned by that member. A Ring is immutable and safe for concurrent use.
+type Ring struct {
+	self    string
+	members []string // Sorted.
+	points  []point  // Sorted by hash.
+}
+
+type point struct {
+	hash   uint64
+	member int // Index in members.
+}
+
+// NewRing returns a Ring with the provided members, identified by the URLs
+// under which they can reach each other. self is the URL of the member the Ring
+// is created for and has to be one of members.
+func NewRing(members []string, self string) (*Ring, error) {
+	if len(members) == 0 {
+		return nil, errors.New("no cluster members")
+	}
+	r := &Ring{}
+	for _, m := range members {
+		normalized, err := normalizeURL(m)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(r.members, normalized) {
+			return nil, fmt.Errorf("duplicate cluster member %q", m)
+		}
+		r.members = append(r.members, normalized)
+	}
+	sort.Strings(r.members)
+	if self == "" {
+		return nil, errors.New("own URL not set")
+	}
+	normalized, err := normalizeURL(self)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(r.members, normalized) {
+		return nil, fmt.Errorf("own URL %q is not one of the cluster members", self)
+	}
+	r.self = normalized
+
+	r.points = make([]point, 0, len(r.members)*virtualNodes)
+	for i, m := range r.members {
+		for v := range virtualNodes {
+			r.points = append(r.points, point{
+				hash:   xxhash.Sum64String(m + "#" + strconv.Itoa(v)),
+				member: i,
+			})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i].hash < r.points[j].hash })
+	return r, nil
+}
+
+// normalizeURL checks that the provided member URL is an absolute HTTP(S) URL
+// and removes a trailing slash.
+func normalizeURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid cluster member URL %q: %w", s, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid cluster member URL %q: must be an absolute HTTP or HTTPS URL", s)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u.String(), nil
+}
+
+// Owner returns the URL of the member owning the metric group with the provided
+// grouping key.
+func (r *Ring) Owner(groupingKey string) string {
+	h := xxhash.Sum64String(groupingKey)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.members[r.points[i].member]
+}
+
+// Owns returns whether the member the Ring was created for owns the metric
+// group with the provided grouping key.
+func (r *Ring) Owns(groupingKey string) bool {
+	return r.Owner(groupingKey) == r.self
+}
+
+// Self returns the URL of the member the Ring was created for.
+func (r *Ring) Self() string {
+	return r.self
+}
+
+// Members returns the sorted URLs of all members.
+func (r *Ring) Members() []string {
+	return slices.Clone(r.members)
+}
+
+// Ownership returns the share of the hash space owned by each member, i.e. the
+// expected share of the metric groups owned by it.
+func (r *Ring) Ownership() map[string]float64 {
+	shares := make(map[string]float64, len(r.members))
+	for _, m := range r.members {
+		shares[m] = 0
+	}
+	// Each point owns the hashes between its predecessor (exclusively)
+	// and itself (inclusively). The first point also owns the hashes
+	// after the last point.
+	prev := r.points[len(r.points)-1].hash
+	for _, p := range r.points {
+		shares[r.members[p.member]] += float64(p.hash-prev) / math.MaxUint64
+		prev = p.hash
+	}
+	return shares
+}
@@ -0,0 +1,109 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+var members = []string{"http://pg-0:9091", "http://pg-1:9091/", "https://pg-2:9091"}
+
+func TestNewRing(t *testing.T) {
+	r, err := NewRing(members, "http://pg-1:9091")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := "http://pg-1:9091", r.Self(); expected != got {
+		t.Errorf("Expected self %q, got %q.", expected, got)
+	}
+	if expected, got := []string{"http://pg-0:9091", "http://pg-1:9091", "https://pg-2:9091"}, r.Members(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected members %v, got %v.", expected, got)
+	}
+
+	for _, s := range []struct {
+		members []string
+		self    string
+	}{
+		{nil, "http://pg-0:9091"},
+		{members, ""},
+		{members, "http://pg-3:9091"},
+		{[]string{"http://pg-0:9091", "http://pg-0:9091/"}, "http://pg-0:9091"},
+		{[]string{"pg-0:9091"}, "pg-0:9091"},
+		{[]string{"ftp://pg-0"}, "ftp://pg-0"},
+	} {
+		if _, err := NewRing(s.members, s.self); err == nil {
+			t.Errorf("Expected error for members %v and self %q.", s.members, s.self)
+		}
+	}
+}
+
+func TestOwner(t *testing.T) {
+	rings := map[string]*Ring{}
+	for _, self := range members {
+		// The order of the members doesn't matter.
+		reversed := []string{members[2], members[1], members[0]}
+		r, err := NewRing(reversed, self)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rings[r.Self()] = r
+	}
+
+	const keys = 30000
+	owned := map[string]int{}
+	for i := range keys {
+		key := fmt.Sprintf("job\xffjob-%d", i)
+		owner := ""
+		for self, r := range rings {
+			if owner == "" {
+				owner = r.Owner(key)
+			} else if got := r.Owner(key); got != owner {
+				t.Fatalf("Members disagree about owner of %q: %q vs. %q.", key, owner, got)
+			}
+			if r.Owns(key) != (self == owner) {
+				t.Errorf("Member %q wrongly claims ownership of %q owned by %q.", self, key, owner)
+			}
+		}
+		owned[owner]++
+	}
+	ownership := rings["http://pg-0:9091"].Ownership()
+	sum := 0.0
+	for m, share := range ownership {
+		sum += share
+		if got := float64(owned[m]) / keys; math.Abs(got-share) > 0.03 {
+			t.Errorf("Expected member %q to own a share of about %.3f, got %.3f.", m, share, got)
+		}
+		if share < 0.2 || share > 0.5 {
+			t.Errorf("Expected member %q to own a share of about a third, got %.3f.", m, share)
+		}
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		t.Errorf("Expected shares to add up to 1, got %v.", sum)
+	}
+
+	// Removing a member only moves the groups it owned.
+	smaller, err := NewRing(members[:2], members[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range keys {
+		key := fmt.Sprintf("job\xffjob-%d", i)
+		if before := rings["http://pg-0:9091"].Owner(key); before != "https://pg-2:9091" && before != smaller.Owner(key) {
+			t.Errorf("Owner of %q changed from %q to %q.", key, before, smaller.Owner(key))
+		}
+	}
+}
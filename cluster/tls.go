@@ -0,0 +1,42 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/common/config"
+	"go.yaml.in/yaml/v2"
+)
+
+// LoadTLSConfig reads the TLS settings used to connect to other members from
+// the provided YAML file, in the format of the tls_config of Prometheus.
+// Relative file paths in it are resolved relative to the directory of the
+// file. The client certificate configured there is how the other members
+// authenticate requests forwarded by this member.
+func LoadTLSConfig(filename string) (*tls.Config, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config.TLSConfig{}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", filename, err)
+	}
+	cfg.SetDirectory(filepath.Dir(filename))
+	return config.NewTLSConfig(cfg)
+}
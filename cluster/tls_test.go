@@ -0,0 +1,55 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cluster
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "tls.yml")
+	write := func(content string) {
+		if err := os.WriteFile(filename, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("server_name: pg-0\ninsecure_skip_verify: true\n")
+	cfg, err := LoadTLSConfig(filename)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if expected, got := "pg-0", cfg.ServerName; expected != got {
+		t.Errorf("Expected server name %q, got %q.", expected, got)
+	}
+	if !cfg.InsecureSkipVerify {
+		t.Error("Expected InsecureSkipVerify to be set.")
+	}
+
+	// Unknown fields and missing files referenced by the config are errors.
+	write("unknown: true\n")
+	if _, err := LoadTLSConfig(filename); err == nil {
+		t.Error("Expected error for unknown field.")
+	}
+	write("ca_file: missing.pem\n")
+	if _, err := LoadTLSConfig(filename); err == nil {
+		t.Error("Expected error for missing CA file.")
+	}
+	if _, err := LoadTLSConfig(filepath.Join(dir, "missing.yml")); err == nil {
+		t.Error("Expected error for missing file.")
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config implements the configuration file of the Pushgateway, which
+// contains the settings that can be changed at runtime by reloading it.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
+	"go.yaml.in/yaml/v2"
+
+	"github.com/prometheus/pushgateway/authz"
+	"github.com/prometheus/pushgateway/storage"
+)
+
+// Config is the configuration of the Pushgateway. Most of its settings can
+// also be set by a command line flag, which provides the default for the
+// setting in the configuration file.
+type Config struct {
+	Push          PushConfig        `yaml:"push"`
+	Persistence   PersistenceConfig `yaml:"persistence"`
+	Authorization *authz.Config     `yaml:"authorization,omitempty"`
+}
+
+// PushConfig configures how pushes are processed.
+type PushConfig struct {
+	DefaultTTL           model.Duration `yaml:"default_ttl"`
+	IncrementAllowGauges bool           `yaml:"increment_allow_gauges"`
+	Limits               storage.Limits `yaml:"limits"`
+	// RelabelConfigs are applied to the grouping labels of each push (and
+	// deletion), MetricRelabelConfigs to each pushed series, see
+	// storage.WithRelabeling.
+	RelabelConfigs       []*relabel.Config `yaml:"relabel_configs,omitempty"`
+	MetricRelabelConfigs []*relabel.Config `yaml:"metric_relabel_configs,omitempty"`
+	History              HistoryConfig     `yaml:"history"`
+	// NameValidationScheme is used to validate the label names written by
+	// the relabeling rules. It is not part of the configuration file but
+	// set according to the --push.enable-utf8-names flag. If unset, the
+	// legacy validation is used.
+	NameValidationScheme model.ValidationScheme `yaml:"-"`
+}
+
+// HistoryConfig configures the history of past versions kept for each metric
+// group, see storage.WithHistory.
+type HistoryConfig struct {
+	Size   int            `yaml:"size"`
+	MaxAge model.Duration `yaml:"max_age"`
+}
+
+// PersistenceConfig configures the persistence of the metrics. (The
+// persistence file cannot be changed at runtime and is therefore only
+// configured by a command line flag.)
+type PersistenceConfig struct {
+	Interval model.Duration `yaml:"interval"`
+}
+
+// Load parses the YAML input s into a Config. Settings not contained in s
+// retain their values from defaults. The Authorization is replaced as a whole
+// if s contains it.
+func Load(s string, defaults Config) (*Config, error) {
+	cfg := defaults
+	cfg.Authorization = nil
+	if err := yaml.UnmarshalStrict([]byte(s), &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Authorization == nil {
+		cfg.Authorization = defaults.Authorization
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// LoadFile parses the provided YAML file into a Config, see Load.
+func LoadFile(filename string, defaults Config) (*Config, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Load(string(content), defaults)
+	if err != nil {
+		return nil, fmt.Errorf("parsing YAML file %s: %w", filename, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	if c.Push.DefaultTTL < 0 {
+		return errors.New("push.default_ttl must not be negative")
+	}
+	if c.Push.History.Size < 0 {
+		return errors.New("push.history.size must not be negative")
+	}
+	if c.Push.History.MaxAge < 0 {
+		return errors.New("push.history.max_age must not be negative")
+	}
+	if c.Persistence.Interval < 0 {
+		return errors.New("persistence.interval must not be negative")
+	}
+	scheme := c.Push.NameValidationScheme
+	if scheme == model.UnsetValidation {
+		scheme = model.LegacyValidation
+	}
+	for _, rc := range c.Push.RelabelConfigs {
+		if err := rc.Validate(scheme); err != nil {
+			return fmt.Errorf("invalid push.relabel_configs: %w", err)
+		}
+	}
+	for _, rc := range c.Push.MetricRelabelConfigs {
+		if err := rc.Validate(scheme); err != nil {
+			return fmt.Errorf("invalid push.metric_relabel_configs: %w", err)
+		}
+	}
+	l := c.Push.Limits
+	for name, v := range map[storage.Limit]int{
+		storage.LimitSeriesPerPush:         l.SeriesPerPush,
+		storage.LimitSeriesPerGroup:        l.SeriesPerGroup,
+		storage.LimitSeriesPerMetricFamily: l.SeriesPerMetricFamily,
+		storage.LimitSeriesTotal:           l.SeriesTotal,
+		storage.LimitLabelsPerSeries:       l.LabelsPerSeries,
+		storage.LimitLabelValueLength:      l.LabelValueLength,
+	} {
+		if v < 0 {
+			return fmt.Errorf("push.limits.%s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// StorageOptions returns the storage.Options that apply the Config to a
+// storage.DiskMetricStore.
+func (c *Config) StorageOptions() []storage.Option {
+	return []storage.Option{
+		storage.WithDefaultTTL(time.Duration(c.Push.DefaultTTL)),
+		storage.WithGaugeIncrements(c.Push.IncrementAllowGauges),
+		storage.WithHistory(c.Push.History.Size, time.Duration(c.Push.History.MaxAge)),
+		storage.WithLimits(c.Push.Limits),
+		storage.WithPersistenceInterval(time.Duration(c.Persistence.Interval)),
+		storage.WithRelabeling(c.Push.RelabelConfigs, c.Push.MetricRelabelConfigs),
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/prometheus/pushgateway/authz"
+	"github.com/prometheus/pushgateway/storage"
+)
+
+func TestLoad(t *testing.T) {
+	defaultAuthorization := &authz.Config{}
+	defaults := Config{
+		Push: PushConfig{
+			DefaultTTL: model.Duration(time.Hour),
+			Limits:     storage.Limits{SeriesTotal: 1000},
+		},
+		Persistence:   PersistenceConfig{Interval: model.Duration(5 * time.Minute)},
+		Authorization: defaultAuthorization,
+	}
+
+	scenarios := []struct {
+		name        string
+		config      string
+		expected    Config
+		expectedErr string
+	}{
+		{
+			name:     "empty",
+			config:   "",
+			expected: defaults,
+		},
+		{
+			name: "partial override",
+			config: `
+push:
+  increment_allow_gauges: true
+  limits:
+    series_per_push: 10
+  history:
+    size: 5
+persistence:
+  interval: 1m
+`,
+			expected: Config{
+				Push: PushConfig{
+					DefaultTTL:           model.Duration(time.Hour),
+					IncrementAllowGauges: true,
+					Limits:               storage.Limits{SeriesPerPush: 10, SeriesTotal: 1000},
+					History:              HistoryConfig{Size: 5},
+				},
+				Persistence:   PersistenceConfig{Interval: model.Duration(time.Minute)},
+				Authorization: defaultAuthorization,
+			},
+		},
+		{
+			name: "negative limit",
+			config: `
+push:
+  limits:
+    series_per_group: -1
+`,
+			expectedErr: "push.limits.series_per_group must not be negative",
+		},
+		{
+			name: "negative history size",
+			config: `
+push:
+  history:
+    size: -1
+`,
+			expectedErr: "push.history.size must not be negative",
+		},
+		{
+			name: "invalid relabel config",
+			config: `
+push:
+  metric_relabel_configs:
+    - action: hashmod
+      source_labels: [instance]
+      target_label: shard
+`,
+			expectedErr: "invalid push.metric_relabel_configs",
+		},
+		{
+			name: "relabel target label invalid without UTF-8",
+			config: `
+push:
+  relabel_configs:
+    - target_label: team.name
+      replacement: a
+`,
+			expectedErr: "invalid push.relabel_configs",
+		},
+		{
+			name: "invalid authorization",
+			config: `
+authorization:
+  rules:
+    - match: ['{job="a"}']
+`,
+			expectedErr: "exactly one of",
+		},
+		{
+			name: "unknown field",
+			config: `
+push:
+  ttl: 1h
+`,
+			expectedErr: "field ttl not found",
+		},
+	}
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			cfg, err := Load(s.config, defaults)
+			if s.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), s.expectedErr) {
+					t.Errorf("Expected error containing %q, got %v.", s.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			if !reflect.DeepEqual(cfg.Push, s.expected.Push) || cfg.Persistence != s.expected.Persistence || cfg.Authorization != s.expected.Authorization {
+				t.Errorf("Expected %+v, got %+v.", s.expected, *cfg)
+			}
+		})
+	}
+
+	cfg, err := Load(`
+authorization:
+  rules:
+    - bearer_token: token
+      match: ['{job="a"}']
+`, defaults)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if cfg.Authorization == defaultAuthorization || len(cfg.Authorization.Rules) != 1 {
+		t.Errorf("Expected authorization to be replaced, got %+v.", cfg.Authorization)
+	}
+	if len(defaultAuthorization.Rules) != 0 {
+		t.Error("Expected default authorization to be unchanged.")
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/pushgateway/audit"
+)
+
+// maxAuditErrorLength limits the length of the error message of an audit entry.
+const maxAuditErrorLength = 512
+
+type identityKey struct{}
+
+// withIdentity returns a shallow copy of r carrying the provided identity of
+// its authorized credentials.
+func withIdentity(r *http.Request, identity string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, identity))
+}
+
+// requestIdentity returns the identity set by Authorize or, without one, the
+// subject of the verified TLS client certificate of the request, if any.
+func requestIdentity(r *http.Request) string {
+	if identity, _ := r.Context().Value(identityKey{}).(string); identity != "" {
+		return identity
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		return "client_cert:" + r.TLS.VerifiedChains[0][0].Subject.String()
+	}
+	return ""
+}
+
+// StartAudit returns an audit entry for the provided request, prefilled from
+// the request, to be completed by the caller. The returned ResponseWriter
+// wrapping w has to be used for the response. The returned function records
+// the entry in auditLog together with the outcome of the response. With a nil
+// auditLog, w is returned as is and nothing is recorded.
+func StartAudit(auditLog *audit.Log, operation string, w http.ResponseWriter, r *http.Request) (*audit.Entry, http.ResponseWriter, func()) {
+	entry := &audit.Entry{}
+	if auditLog == nil {
+		return entry, w, func() {}
+	}
+	*entry = audit.Entry{
+		Time:         time.Now(),
+		Operation:    operation,
+		Method:       r.Method,
+		RemoteAddr:   r.RemoteAddr,
+		ForwardedFor: r.Header.Get("X-Forwarded-For"),
+		Identity:     requestIdentity(r),
+	}
+	if r.Body != nil {
+		r.Body = &countingReader{ReadCloser: r.Body, n: &entry.Bytes}
+	}
+	aw := &auditResponseWriter{ResponseWriter: w}
+	return entry, aw, func() {
+		switch {
+		case aw.status == 0 && r.Context().Err() != nil:
+			entry.Result = audit.ResultCanceled
+		case aw.status == 0:
+			entry.Status, entry.Result = http.StatusOK, audit.ResultSuccess
+		case aw.status < 400:
+			entry.Status, entry.Result = aw.status, audit.ResultSuccess
+		default:
+			entry.Status, entry.Result = aw.status, audit.ResultRejected
+			entry.Error = strings.TrimSpace(aw.body.String())
+		}
+		auditLog.Record(*entry)
+	}
+}
+
+// auditOperation returns the audit operation of a request to Push or Delete.
+func auditOperation(r *http.Request) string {
+	if r.Method == http.MethodDelete {
+		return audit.OperationDelete
+	}
+	return audit.OperationPush
+}
+
+// auditResponseWriter remembers the status code and the beginning of the body
+// of an error response.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   strings.Builder
+}
+
+func (w *auditResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= 400 {
+		if n := maxAuditErrorLength - w.body.Len(); n > 0 {
+			w.body.Write(b[:min(n, len(b))])
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// countingReader counts the bytes read from the wrapped io.ReadCloser.
+type countingReader struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	*r.n += int64(n)
+	return n, err
+}
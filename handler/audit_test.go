@@ -0,0 +1,211 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/pushgateway/audit"
+	"github.com/prometheus/pushgateway/authz"
+	"github.com/prometheus/pushgateway/storage"
+)
+
+func TestAudit(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.New(filename, 0, 0, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := authz.Load(`
+rules:
+  - bearer_token: token-a
+    match: ['{job=~"teamA-.*"}']
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mms := MockMetricStore{
+		metricGroups: storage.GroupingKeyToMetricGroup{
+			"a": {Labels: map[string]string{"job": "a"}},
+			"b": {Labels: map[string]string{"job": "b"}},
+		},
+	}
+	mmsWithErr := MockMetricStore{err: errors.New("inconsistent help string")}
+	body := "some_metric 1\nsome_metric{x=\"y\"} 2\nanother_metric 3\n"
+
+	scenarios := []struct {
+		name     string
+		handler  func(http.ResponseWriter, *http.Request)
+		method   string
+		token    string
+		params   map[string]string
+		body     string
+		expected audit.Entry
+	}{
+		{
+			name:    "authorized push",
+			handler: Authorize(cfg, &mms, false, Push(&mms, true, true, false, auditLog, logger), auditLog, logger),
+			method:  "PUT",
+			token:   "token-a",
+			params:  map[string]string{"job": "teamA-batch", "labels": "/instance/x"},
+			body:    body,
+			expected: audit.Entry{
+				Operation:      audit.OperationPush,
+				Identity:       "bearer_token:a70bf50e",
+				Labels:         map[string]string{"job": "teamA-batch", "instance": "x"},
+				MetricFamilies: []string{"another_metric", "some_metric"},
+				Series:         3,
+				Bytes:          int64(len(body)),
+				Status:         http.StatusOK,
+				Result:         audit.ResultSuccess,
+			},
+		},
+		{
+			name:    "forbidden push",
+			handler: Authorize(cfg, &mms, false, Push(&mms, false, true, false, auditLog, logger), auditLog, logger),
+			method:  "POST",
+			token:   "token-a",
+			params:  map[string]string{"job": "teamB-batch"},
+			body:    body,
+			expected: audit.Entry{
+				Operation: audit.OperationPush,
+				Identity:  "bearer_token:a70bf50e",
+				Labels:    map[string]string{"job": "teamB-batch"},
+				Status:    http.StatusForbidden,
+				Result:    audit.ResultRejected,
+				Error:     authz.ErrForbidden.Error(),
+			},
+		},
+		{
+			name:    "unauthenticated delete",
+			handler: Authorize(cfg, &mms, false, Delete(&mms, false, auditLog, logger), auditLog, logger),
+			method:  "DELETE",
+			params:  map[string]string{"job": "teamA-batch"},
+			expected: audit.Entry{
+				Operation: audit.OperationDelete,
+				Labels:    map[string]string{"job": "teamA-batch"},
+				Status:    http.StatusForbidden,
+				Result:    audit.ResultRejected,
+				Error:     authz.ErrUnauthenticated.Error(),
+			},
+		},
+		{
+			name:    "unparsable push",
+			handler: Push(&mms, false, true, false, auditLog, logger),
+			method:  "POST",
+			params:  map[string]string{"job": "batch"},
+			body:    "not a metric\n",
+			expected: audit.Entry{
+				Operation: audit.OperationPush,
+				Labels:    map[string]string{"job": "batch"},
+				Bytes:     13,
+				Status:    http.StatusBadRequest,
+				Result:    audit.ResultRejected,
+				Error:     `text format parsing error in line 1: expected float as value, got "a"`,
+			},
+		},
+		{
+			name:    "inconsistent push",
+			handler: Push(&mmsWithErr, false, true, false, auditLog, logger),
+			method:  "POST",
+			params:  map[string]string{"job": "batch"},
+			body:    "some_metric 1\n",
+			expected: audit.Entry{
+				Operation:      audit.OperationPush,
+				Labels:         map[string]string{"job": "batch"},
+				MetricFamilies: []string{"some_metric"},
+				Series:         1,
+				Bytes:          14,
+				Status:         http.StatusBadRequest,
+				Result:         audit.ResultRejected,
+				Error:          "pushed metrics are invalid or inconsistent with existing metrics: inconsistent help string",
+			},
+		},
+		{
+			name:    "delete",
+			handler: Delete(&mms, false, auditLog, logger),
+			method:  "DELETE",
+			params:  map[string]string{"job": "batch"},
+			expected: audit.Entry{
+				Operation: audit.OperationDelete,
+				Labels:    map[string]string{"job": "batch"},
+				Status:    http.StatusAccepted,
+				Result:    audit.ResultSuccess,
+			},
+		},
+		{
+			name:    "wipe",
+			handler: WipeMetricStore(&mms, auditLog, logger).ServeHTTP,
+			method:  "PUT",
+			expected: audit.Entry{
+				Operation: audit.OperationWipe,
+				Groups:    2,
+				Status:    http.StatusAccepted,
+				Result:    audit.ResultSuccess,
+			},
+		},
+	}
+	for _, s := range scenarios {
+		req, err := http.NewRequest(s.method, "http://example.org/", strings.NewReader(s.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = "192.0.2.1:1234"
+		if s.token != "" {
+			req.Header.Set("Authorization", "Bearer "+s.token)
+		}
+		s.handler(httptest.NewRecorder(), req.WithContext(ctxWithParams(s.params, req)))
+	}
+	if err := auditLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for _, s := range scenarios {
+		if !scanner.Scan() {
+			t.Fatalf("%s: Wanted audit entry, got none.", s.name)
+		}
+		var got audit.Entry
+		if err := json.Unmarshal(scanner.Bytes(), &got); err != nil {
+			t.Fatalf("%s: Invalid audit entry %q: %v", s.name, scanner.Text(), err)
+		}
+		if time.Since(got.Time) > time.Minute {
+			t.Errorf("%s: Wanted current time in audit entry, got %v.", s.name, got.Time)
+		}
+		got.Time = time.Time{}
+		s.expected.Method = s.method
+		s.expected.RemoteAddr = "192.0.2.1:1234"
+		if !reflect.DeepEqual(s.expected, got) {
+			t.Errorf("%s: Wanted audit entry %+v, got %+v.", s.name, s.expected, got)
+		}
+	}
+	if scanner.Scan() {
+		t.Errorf("Unexpected audit entry %q.", scanner.Text())
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+
+	"github.com/prometheus/common/route"
+
+	"github.com/prometheus/pushgateway/audit"
+	"github.com/prometheus/pushgateway/authz"
+)
+
+// Authorizer decides if a request may change the metric group with the provided
+// grouping labels. It is implemented by authz.Config and authz.Dynamic.
+type Authorizer interface {
+	// Authorize returns nil if the request is authorized. Otherwise, it
+	// returns an error, which should be authz.ErrUnauthenticated if the
+	// request carries no valid credentials. The returned identity names
+	// the valid credentials of the request, if any.
+	Authorize(r *http.Request, groupingLabels map[string]string) (identity string, err error)
+}
+
+// GroupingRelabeler returns the grouping labels of the metric group that a push
+// or deletion with the provided grouping labels applies to after relabeling, or
+// false if it is dropped. It is implemented by storage.MetricStore.
+type GroupingRelabeler interface {
+	RelabelGroupingLabels(groupingLabels map[string]string) (map[string]string, bool)
+}
+
+// Authorize returns a handler that passes a request on to next only if a
+// authorizes it to change the metric group identified by the request URL path
+// (as used by Push and Delete), both as requested and after relabeling by gr
+// (see AuthorizeGroups). Otherwise, the request is rejected with
+// http.StatusForbidden and recorded in auditLog, which may be nil. Requests
+// with a malformed URL path are passed on so that next can reject them as
+// usual. Deletions with relabel=false apply to the stored group as requested
+// and are therefore not relabeled.
+func Authorize(
+	a Authorizer,
+	gr GroupingRelabeler,
+	jobBase64Encoded bool,
+	next func(http.ResponseWriter, *http.Request),
+	auditLog *audit.Log,
+	logger *slog.Logger,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		labels, ok := groupingLabelsFromPath(r, jobBase64Encoded)
+		if !ok {
+			next(w, r)
+			return
+		}
+
+		if relabel, err := relabelParam(r); err == nil && !relabel {
+			gr = nil
+		}
+		identity, err := AuthorizeGroups(a, gr, r, []map[string]string{labels})
+		if err != nil {
+			entry, w, finish := StartAudit(auditLog, auditOperation(r), w, r)
+			if identity != "" {
+				entry.Identity = identity
+			}
+			entry.Labels = labels
+			http.Error(w, err.Error(), http.StatusForbidden)
+			finish()
+			logger.Debug("request not authorized", "method", r.Method, "source", r.RemoteAddr, "labels", labels, "err", err.Error())
+			return
+		}
+		if identity != "" {
+			r = withIdentity(r, identity)
+		}
+		next(w, r)
+	}
+}
+
+// AuthorizeGroups returns the identity of the provided request as returned by
+// a and nil if a authorizes the request to change each of the metric groups
+// with the provided grouping labels. Otherwise, the rejection is counted, and
+// the error for the first group not authorized is returned. A nil a authorizes
+// every request.
+//
+// If gr is not nil, the grouping labels are those of a push or deletion that
+// is still to be relabeled. Then the request also has to be authorized to
+// change the group each of them is moved to by relabeling, so that relabeling
+// cannot move a request into a group its credentials don't allow.
+func AuthorizeGroups(a Authorizer, gr GroupingRelabeler, r *http.Request, groupingLabels []map[string]string) (identity string, err error) {
+	if a == nil {
+		return "", nil
+	}
+	for _, labels := range groupingLabels {
+		candidates := []map[string]string{labels}
+		if gr != nil {
+			if relabeled, keep := gr.RelabelGroupingLabels(labels); keep && !maps.Equal(relabeled, labels) {
+				candidates = append(candidates, relabeled)
+			}
+		}
+		for _, labels := range candidates {
+			if identity, err = a.Authorize(r, labels); err != nil {
+				reason := "forbidden"
+				if errors.Is(err, authz.ErrUnauthenticated) {
+					reason = "unauthenticated"
+				}
+				httpAuthzRejections.WithLabelValues(r.Method, reason).Inc()
+				return identity, err
+			}
+		}
+	}
+	return identity, nil
+}
+
+// groupingLabelsFromPath returns the grouping labels of the metric group
+// identified by the request URL path as used by Push and Delete, or false if
+// the URL path is malformed.
+func groupingLabelsFromPath(r *http.Request, jobBase64Encoded bool) (map[string]string, bool) {
+	labels, _, err := ParsePushPath(r, jobBase64Encoded)
+	return labels, err == nil
+}
+
+// ParsePushPath returns the grouping labels of the metric group identified by
+// the request URL path as used by Push and Delete, i.e. by the route parameters
+// "job" and "labels", and whether the path ends with IncrementSuffix.
+func ParsePushPath(r *http.Request, jobBase64Encoded bool) (map[string]string, bool, error) {
+	job := route.Param(r.Context(), "job")
+	if jobBase64Encoded {
+		var err error
+		if job, err = decodeBase64(job); err != nil {
+			return nil, false, fmt.Errorf("invalid base64 encoding in job name %q: %v", job, err)
+		}
+	}
+	labelsString, increment := trimIncrementSuffix(route.Param(r.Context(), "labels"))
+	labels, err := splitLabels(labelsString)
+	if err != nil {
+		return nil, false, err
+	}
+	if job == "" {
+		return nil, false, errors.New("job name is required")
+	}
+	labels["job"] = job
+	return labels, increment, nil
+}
@@ -0,0 +1,252 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+
+	"github.com/prometheus/pushgateway/authz"
+	"github.com/prometheus/pushgateway/storage"
+)
+
+func TestAuthorize(t *testing.T) {
+	cfg, err := authz.Load(`
+rules:
+  - bearer_token: token-a
+    match: ['{job=~"teamA-.*"}']
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scenarios := []struct {
+		name             string
+		method           string
+		token            string
+		params           map[string]string
+		jobBase64Encoded bool
+		expectedCode     int
+		expectedReason   string // Of the rejection counter, if rejected.
+	}{
+		{
+			name:         "push allowed",
+			method:       "PUT",
+			token:        "token-a",
+			params:       map[string]string{"job": "teamA-batch", "labels": "/instance/x"},
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:             "increment with base64 job allowed",
+			method:           "POST",
+			token:            "token-a",
+			params:           map[string]string{"job": "dGVhbUEtYmF0Y2g", "labels": "/instance/x/increment"},
+			jobBase64Encoded: true,
+			expectedCode:     http.StatusOK,
+		},
+		{
+			name:           "push forbidden",
+			method:         "PUT",
+			token:          "token-a",
+			params:         map[string]string{"job": "teamB-batch"},
+			expectedCode:   http.StatusForbidden,
+			expectedReason: "forbidden",
+		},
+		{
+			name:           "delete unauthenticated",
+			method:         "DELETE",
+			params:         map[string]string{"job": "teamA-batch"},
+			expectedCode:   http.StatusForbidden,
+			expectedReason: "unauthenticated",
+		},
+		{
+			name:         "malformed path passed on",
+			method:       "PUT",
+			params:       map[string]string{"job": "teamA-batch", "labels": "/instance"},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			mms := MockMetricStore{}
+			var next func(http.ResponseWriter, *http.Request)
+			if s.method == "DELETE" {
+				next = Delete(&mms, s.jobBase64Encoded, nil, logger)
+			} else {
+				next = Push(&mms, s.method == "PUT", true, s.jobBase64Encoded, nil, logger)
+			}
+			handler := Authorize(cfg, &mms, s.jobBase64Encoded, next, nil, logger)
+
+			req, err := http.NewRequest(s.method, "http://example.org/", &bytes.Buffer{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.token != "" {
+				req.Header.Set("Authorization", "Bearer "+s.token)
+			}
+			var rejectionsBefore float64
+			if s.expectedReason != "" {
+				rejectionsBefore = testutil.ToFloat64(httpAuthzRejections.WithLabelValues(s.method, s.expectedReason))
+			}
+
+			w := httptest.NewRecorder()
+			handler(w, req.WithContext(ctxWithParams(s.params, req)))
+			if expected, got := s.expectedCode, w.Code; expected != got {
+				t.Errorf("Wanted status code %v, got %v.", expected, got)
+			}
+			if s.expectedCode == http.StatusForbidden {
+				if len(mms.writeRequests) != 0 {
+					t.Errorf("Unexpected write requests %v.", mms.writeRequests)
+				}
+				if expected, got := rejectionsBefore+1, testutil.ToFloat64(httpAuthzRejections.WithLabelValues(s.method, s.expectedReason)); expected != got {
+					t.Errorf("Wanted %v rejections, got %v.", expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthorizeRelabeled(t *testing.T) {
+	cfg, err := authz.Load(`
+rules:
+  - bearer_token: token-a
+    match: ['{job=~"teamA-.*"}']
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Moves pushes with a legacy_job label into the group of that job.
+	relabelConfigs := []*relabel.Config{{
+		SourceLabels:         model.LabelNames{"legacy_job"},
+		Regex:                relabel.MustNewRegexp("(.+)"),
+		TargetLabel:          "job",
+		Replacement:          "$1",
+		Action:               relabel.Replace,
+		NameValidationScheme: model.UTF8Validation,
+	}}
+	ms := storage.NewDiskMetricStore("", 100*time.Millisecond, nil, logger, storage.WithRelabeling(relabelConfigs, nil))
+	defer ms.Shutdown()
+
+	scenarios := []struct {
+		name         string
+		method       string
+		query        string
+		labels       string
+		expectedCode int
+	}{
+		{name: "push not relabeled", method: "PUT", labels: "/instance/x", expectedCode: http.StatusOK},
+		{name: "push relabeled into allowed group", method: "PUT", labels: "/legacy_job/teamA-y", expectedCode: http.StatusOK},
+		{name: "push relabeled into forbidden group", method: "PUT", labels: "/legacy_job/teamB-y", expectedCode: http.StatusForbidden},
+		{name: "push with relabel parameter into forbidden group", method: "PUT", query: "?relabel=false", labels: "/legacy_job/teamB-y", expectedCode: http.StatusForbidden},
+		{name: "delete relabeled into forbidden group", method: "DELETE", labels: "/legacy_job/teamB-y", expectedCode: http.StatusForbidden},
+		{name: "delete of stored group", method: "DELETE", query: "?relabel=false", labels: "/legacy_job/teamB-y", expectedCode: http.StatusAccepted},
+	}
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			var next func(http.ResponseWriter, *http.Request)
+			if s.method == "DELETE" {
+				next = Delete(ms, false, nil, logger)
+			} else {
+				next = Push(ms, true, true, false, nil, logger)
+			}
+			req := httptest.NewRequest(s.method, "http://example.org/metrics/job/teamA-x"+s.labels+s.query, strings.NewReader("some_metric 1\n"))
+			req.Header.Set("Authorization", "Bearer token-a")
+			w := httptest.NewRecorder()
+			Authorize(cfg, ms, false, next, nil, logger)(w, req.WithContext(ctxWithParams(map[string]string{"job": "teamA-x", "labels": s.labels}, req)))
+			if expected, got := s.expectedCode, w.Code; expected != got {
+				t.Errorf("Wanted status code %v, got %v: %s", expected, got, w.Body.String())
+			}
+		})
+	}
+	for key, group := range ms.GetMetricFamiliesMap() {
+		if job := group.Labels["job"]; !strings.HasPrefix(job, "teamA-") {
+			t.Errorf("Unexpected group %s with job %q.", key, job)
+		}
+	}
+}
+
+func TestAuthorizeRemoteWriteAndOTLP(t *testing.T) {
+	cfg, err := authz.Load(`
+rules:
+  - bearer_token: token-a
+    match: ['{job=~"teamA-.*"}']
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteWriteSeries := func(job string) prompb.TimeSeries {
+		return prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "some_metric"}, {Name: "job", Value: job}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+		}
+	}
+	otlpResource := func(job string) string {
+		return `{
+			"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"` + job + `"}}]},
+			"scopeMetrics":[{"metrics":[{"name":"some_metric","gauge":{"dataPoints":[{"asInt":"1"}]}}]}]
+		}`
+	}
+
+	for _, jobs := range [][]string{{"teamA-x", "teamA-y"}, {"teamA-x", "teamB-y"}} {
+		expectedCode := http.StatusForbidden
+		if jobs[1] == "teamA-y" {
+			expectedCode = http.StatusNoContent
+		}
+
+		mms := MockMetricStore{}
+		req := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{remoteWriteSeries(jobs[0]), remoteWriteSeries(jobs[1])}}
+		b, err := req.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpReq := httptest.NewRequest("POST", "http://example.org/api/v1/write", bytes.NewReader(snappy.Encode(nil, b)))
+		httpReq.Header.Set("Content-Type", "application/x-protobuf")
+		httpReq.Header.Set("Authorization", "Bearer token-a")
+		w := httptest.NewRecorder()
+		RemoteWrite(&mms, true, []string{"job"}, cfg, logger)(w, httpReq)
+		if got := w.Code; expectedCode != got {
+			t.Errorf("remote write to %v: Wanted status code %v, got %v.", jobs, expectedCode, got)
+		}
+		if expectedCode == http.StatusForbidden && len(mms.writeRequests) != 0 {
+			t.Errorf("remote write to %v: Unexpected write requests %v.", jobs, mms.writeRequests)
+		}
+
+		if expectedCode == http.StatusNoContent {
+			expectedCode = http.StatusOK
+		}
+		mms = MockMetricStore{}
+		body := `{"resourceMetrics":[` + otlpResource(jobs[0]) + `,` + otlpResource(jobs[1]) + `]}`
+		httpReq = httptest.NewRequest("POST", "http://example.org/otlp/v1/metrics", strings.NewReader(body))
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("Authorization", "Bearer token-a")
+		w = httptest.NewRecorder()
+		OTLP(&mms, true, cfg, logger)(w, httpReq)
+		if got := w.Code; expectedCode != got {
+			t.Errorf("OTLP to %v: Wanted status code %v, got %v: %s", jobs, expectedCode, got, w.Body.String())
+		}
+		if expectedCode == http.StatusForbidden && len(mms.writeRequests) != 0 {
+			t.Errorf("OTLP to %v: Unexpected write requests %v.", jobs, mms.writeRequests)
+		}
+	}
+}
@@ -17,20 +17,31 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/prometheus/common/route"
 
+	"github.com/prometheus/pushgateway/audit"
 	"github.com/prometheus/pushgateway/storage"
 )
 
-// Delete returns a handler that accepts delete requests.
+// Delete returns a handler that accepts delete requests. They are answered with
+// http.StatusAccepted once the deletion has been processed. Each request is
+// recorded in auditLog, which may be nil.
+//
+// With the query parameter "relabel=false", the grouping labels in the URL path
+// are taken as those of a stored group (e.g. as shown on the status page) and
+// are not relabeled again (see storage.MetricStore.DeleteGroupsByKey).
 //
 // The returned handler is already instrumented for Prometheus.
-func Delete(ms storage.MetricStore, jobBase64Encoded bool, logger *slog.Logger) func(http.ResponseWriter, *http.Request) {
+func Delete(ms storage.MetricStore, jobBase64Encoded bool, auditLog *audit.Log, logger *slog.Logger) func(http.ResponseWriter, *http.Request) {
 	instrumentedHandler := InstrumentWithCounter(
 		"delete",
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			entry, w, finish := StartAudit(auditLog, audit.OperationDelete, w, r)
+			defer finish()
+
 			job := route.Param(r.Context(), "job")
 			if jobBase64Encoded {
 				var err error
@@ -53,10 +64,36 @@ func Delete(ms storage.MetricStore, jobBase64Encoded bool, logger *slog.Logger)
 				return
 			}
 			labels["job"] = job
-			ms.SubmitWriteRequest(storage.WriteRequest{
+			entry.Labels = labels
+			relabel, err := relabelParam(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				logger.Debug("invalid relabel parameter", "err", err.Error())
+				return
+			}
+			if !relabel {
+				if _, err := ms.DeleteGroupsByKey([]string{storage.GroupingKeyFor(labels)}); err != nil {
+					rejectWriteRequest(w, r, err, logger)
+					return
+				}
+				w.WriteHeader(http.StatusAccepted)
+				return
+			}
+			// Only respond once the deletion has been processed and
+			// logged to the write-ahead log.
+			done := make(chan error, 1)
+			if !submitWriteRequest(w, r, ms, storage.WriteRequest{
 				Labels:    labels,
 				Timestamp: time.Now(),
-			})
+				Done:      done,
+			}, logger) {
+				return
+			}
+			for err := range done {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				logger.Error("failed to delete metric group", "labels", labels, "err", err.Error())
+				return
+			}
 			w.WriteHeader(http.StatusAccepted)
 		}),
 	)
@@ -65,3 +102,18 @@ func Delete(ms storage.MetricStore, jobBase64Encoded bool, logger *slog.Logger)
 		instrumentedHandler.ServeHTTP(w, r)
 	}
 }
+
+// relabelParam returns the value of the "relabel" query parameter of the
+// provided request, which defaults to true. Only deletions can skip
+// relabeling, so the parameter is ignored for other requests.
+func relabelParam(r *http.Request) (bool, error) {
+	s := r.URL.Query().Get("relabel")
+	if s == "" || r.Method != http.MethodDelete {
+		return true, nil
+	}
+	relabel, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, fmt.Errorf("invalid relabel parameter %q: %w", s, err)
+	}
+	return relabel, nil
+}
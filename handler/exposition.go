@@ -0,0 +1,315 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ExpositionOptions controls how the metrics are exposed to scrapers.
+type ExpositionOptions struct {
+	// EnableOpenMetrics offers the OpenMetrics text format during content
+	// negotiation. Apart from the protobuf format (which is only
+	// negotiated by scrapers that support native histograms), it is the
+	// only format that transmits exemplars and UNIT metadata.
+	EnableOpenMetrics bool
+	// EnableOpenMetricsCreatedSamples adds _created series for the created
+	// timestamps of counters, summaries, and histograms to the OpenMetrics
+	// text format.
+	EnableOpenMetricsCreatedSamples bool
+}
+
+// Expose returns an http.Handler which exposes the metrics gathered by g in the
+// format negotiated with the scraper. The escaping of metric and label names
+// not conforming to the legacy naming rules is also negotiated, falling back to
+// model.NameEscapingScheme if the scraper doesn't request a specific escaping
+// scheme.
+//
+// Scrapes are not instrumented with the counter of InstrumentWithCounter.
+func Expose(g prometheus.Gatherer, opts ExpositionOptions, logger *slog.Logger) http.Handler {
+	return promhttp.HandlerFor(g, promhttp.HandlerOpts{
+		ErrorLog:                            slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		EnableOpenMetrics:                   opts.EnableOpenMetrics,
+		EnableOpenMetricsTextCreatedSamples: opts.EnableOpenMetricsCreatedSamples,
+	})
+}
+
+// CachingGatherer is implemented by metric stores (like
+// storage.DiskMetricStore) whose gathered metrics only change when their
+// generation changes.
+type CachingGatherer interface {
+	GetMetricFamilies() []*dto.MetricFamily
+	Generation() uint64
+}
+
+// ExposeCached returns an http.Handler like Expose for the metrics gathered by
+// g together with the metrics of cg. As long as the generation of cg doesn't
+// change, the metrics of cg are encoded (and compressed) only once per
+// negotiated format and compression. The metrics gathered by g are still
+// encoded for each scrape and appended to the cached part (as an additional
+// gzip member if compressed). Therefore, the metrics of cg are exposed before
+// those of g rather than all in sorted order.
+//
+// If the metrics of cg are inconsistent on their own, or if any of their names
+// might interact with a name of the metrics gathered by g (the same name or
+// names colliding via histogram or summary suffixes), the scrape is handled
+// without cache, exactly like by Expose for the combination of g and cg (but
+// keeping the units of the metric families, see unitGatherers).
+func ExposeCached(g prometheus.Gatherer, cg CachingGatherer, opts ExpositionOptions, logger *slog.Logger) http.Handler {
+	return &cachedExposition{
+		g:    g,
+		cg:   cg,
+		opts: opts,
+		fallback: Expose(unitGatherers{
+			g,
+			prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+				return cg.GetMetricFamilies(), nil
+			}),
+		}, opts, logger),
+		logger: logger,
+	}
+}
+
+type cachedExposition struct {
+	g        prometheus.Gatherer
+	cg       CachingGatherer
+	opts     ExpositionOptions
+	fallback http.Handler
+	logger   *slog.Logger
+
+	mtx        sync.Mutex // Protects the fields below.
+	valid      bool       // Whether the fields below reflect generation.
+	generation uint64
+	err        error               // Of gathering cg on its own.
+	names      map[string]struct{} // Of the metric families of cg.
+	mfs        []*dto.MetricFamily // Of cg, sorted and validated.
+	bodies     map[cachedBodyKey][]byte
+}
+
+type cachedBodyKey struct {
+	format expfmt.Format
+	gzip   bool
+}
+
+func (ce *cachedExposition) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	mfs, err := ce.g.Gather()
+	if err != nil {
+		ce.fallback.ServeHTTP(w, req)
+		return
+	}
+	var format expfmt.Format
+	if ce.opts.EnableOpenMetrics {
+		format = expfmt.NegotiateIncludingOpenMetrics(req.Header)
+	} else {
+		format = expfmt.Negotiate(req.Header)
+	}
+	compress := negotiateGzip(req)
+	body, ok := ce.cachedBody(format, compress, mfs)
+	if !ok {
+		ce.fallback.ServeHTTP(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", string(format))
+	if compress {
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	if _, err := w.Write(body); err != nil {
+		ce.logger.Error("error writing cached metrics", "err", err)
+		return
+	}
+	var out io.Writer = w
+	if compress {
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+	if err := ce.encode(out, format, mfs, true); err != nil {
+		ce.logger.Error("error encoding and sending metric family", "err", err)
+	}
+}
+
+// cachedBody returns the encoded metrics of cg in the provided format, gzipped
+// if compress is true, without the final "# EOF" line of OpenMetrics. False is
+// returned if the cache must not be used because the metrics of cg are
+// inconsistent or interact with the provided MetricFamilies.
+func (ce *cachedExposition) cachedBody(format expfmt.Format, compress bool, others []*dto.MetricFamily) ([]byte, bool) {
+	generation := ce.cg.Generation()
+	ce.mtx.Lock()
+	defer ce.mtx.Unlock()
+
+	if !ce.valid || ce.generation != generation {
+		// If the generation changes while gathering, the cache is
+		// only rebuilt once more upon the next scrape.
+		ce.mfs, ce.err = unitGatherers{
+			prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+				return ce.cg.GetMetricFamilies(), nil
+			}),
+		}.Gather()
+		ce.names = make(map[string]struct{}, len(ce.mfs))
+		for _, mf := range ce.mfs {
+			ce.names[mf.GetName()] = struct{}{}
+		}
+		ce.bodies = map[cachedBodyKey][]byte{}
+		ce.generation = generation
+		ce.valid = true
+	}
+	if ce.err != nil {
+		return nil, false
+	}
+	for _, mf := range others {
+		if ce.interacts(mf.GetName()) {
+			return nil, false
+		}
+	}
+
+	key := cachedBodyKey{format: format, gzip: compress}
+	if body, ok := ce.bodies[key]; ok {
+		return body, true
+	}
+	buf := &bytes.Buffer{}
+	var out io.Writer = buf
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(buf)
+		out = gz
+	}
+	if err := ce.encode(out, format, ce.mfs, false); err != nil {
+		ce.logger.Error("error encoding metric family", "err", err)
+		return nil, false
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, false
+		}
+	}
+	ce.bodies[key] = buf.Bytes()
+	return buf.Bytes(), true
+}
+
+// unitGatherers is like prometheus.Gatherers but keeps the units of the
+// gathered MetricFamilies, which prometheus.Gatherers drops while merging. If
+// MetricFamilies of the same name have different units, the first one wins.
+type unitGatherers []prometheus.Gatherer
+
+// Gather implements prometheus.Gatherer.
+func (gs unitGatherers) Gather() ([]*dto.MetricFamily, error) {
+	units := map[string]string{}
+	wrapped := make(prometheus.Gatherers, len(gs))
+	for i, g := range gs {
+		// prometheus.Gatherers calls the Gatherers one after the other,
+		// so units needs no locking.
+		wrapped[i] = prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			mfs, err := g.Gather()
+			for _, mf := range mfs {
+				if _, ok := units[mf.GetName()]; !ok && mf.Unit != nil {
+					units[mf.GetName()] = mf.GetUnit()
+				}
+			}
+			return mfs, err
+		})
+	}
+	mfs, err := wrapped.Gather()
+	for _, mf := range mfs {
+		// The merged MetricFamilies are new objects, so setting the unit
+		// doesn't modify those of the Gatherers.
+		if unit, ok := units[mf.GetName()]; ok {
+			mf.Unit = proto.String(unit)
+		}
+	}
+	return mfs, err
+}
+
+// interacts returns whether the provided name of a metric family not from cg
+// is the same as the name of a metric family from cg, or whether they could
+// collide because of the suffixes of histograms and summaries.
+func (ce *cachedExposition) interacts(name string) bool {
+	if _, ok := ce.names[name]; ok {
+		return true
+	}
+	for _, suffix := range []string{"_count", "_sum", "_bucket"} {
+		if _, ok := ce.names[name+suffix]; ok {
+			return true
+		}
+		if base, ok := strings.CutSuffix(name, suffix); ok {
+			if _, ok := ce.names[base]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// encode encodes the provided MetricFamilies in the provided format to w. If
+// finalize is true, the encoder is closed afterwards (which adds the final
+// "# EOF" line of OpenMetrics).
+func (ce *cachedExposition) encode(w io.Writer, format expfmt.Format, mfs []*dto.MetricFamily, finalize bool) error {
+	var enc expfmt.Encoder
+	if ce.opts.EnableOpenMetricsCreatedSamples {
+		enc = expfmt.NewEncoder(w, format, expfmt.WithCreatedLines())
+	} else {
+		enc = expfmt.NewEncoder(w, format)
+	}
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok && finalize {
+		return closer.Close()
+	}
+	return nil
+}
+
+// negotiateGzip returns whether the response to the provided request is
+// compressed with gzip, negotiated in the same way as promhttp does it: The
+// offered encodings are identity and gzip (in that order), and the first one
+// with the highest quality in the Accept-Encoding header wins.
+func negotiateGzip(req *http.Request) bool {
+	best, bestQ := "identity", -1.0
+	for _, offer := range []string{"identity", "gzip"} {
+		for _, field := range req.Header.Values("Accept-Encoding") {
+			for spec := range strings.SplitSeq(field, ",") {
+				value, params, _ := strings.Cut(spec, ";")
+				value = strings.ToLower(strings.TrimSpace(value))
+				q := 1.0
+				if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+					parsed, err := strconv.ParseFloat(v, 64)
+					if err != nil {
+						continue
+					}
+					q = parsed
+				}
+				if q > bestQ && (value == "*" || value == offer) {
+					best, bestQ = offer, q
+				}
+			}
+		}
+	}
+	return best == "gzip" && bestQ != 0
+}
@@ -0,0 +1,496 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/encoding/protodelim"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus/pushgateway/storage"
+)
+
+// pushAndScrape pushes the provided MetricFamilies in the delimited protobuf
+// format to a DiskMetricStore and returns the response to a scrape of the
+// ExposeCached handler with the provided Accept header, combined with the
+// DefaultGatherer like the /metrics endpoint.
+func pushAndScrape(t *testing.T, opts ExpositionOptions, accept string, mfs ...*dto.MetricFamily) *http.Response {
+	t.Helper()
+	ms := storage.NewDiskMetricStore("", 100*time.Millisecond, prometheus.NewRegistry(), logger)
+	defer ms.Shutdown()
+
+	buf := &bytes.Buffer{}
+	for _, mf := range mfs {
+		if _, err := protodelim.MarshalTo(buf, mf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest("POST", "http://example.org/", buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", string(expfmt.NewFormat(expfmt.TypeProtoDelim)))
+	w := httptest.NewRecorder()
+	Push(ms, false, true, false, nil, logger)(w, req.WithContext(ctxWithParams(map[string]string{"job": "testjob"}, req)))
+	if expected, got := http.StatusOK, w.Code; expected != got {
+		t.Fatalf("Wanted status code %v, got %v: %s", expected, got, w.Body.String())
+	}
+
+	req, err = http.NewRequest("GET", "http://example.org/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", accept)
+	w = httptest.NewRecorder()
+	ExposeCached(prometheus.DefaultGatherer, ms, opts, logger).ServeHTTP(w, req)
+	if expected, got := http.StatusOK, w.Code; expected != got {
+		t.Fatalf("Wanted status code %v, got %v: %s", expected, got, w.Body.String())
+	}
+	return w.Result()
+}
+
+// responseFormat returns the Format of a scrape response including its
+// escaping scheme (which expfmt.ResponseFormat drops).
+func responseFormat(resp *http.Response) expfmt.Format {
+	return expfmt.Format(resp.Header.Get("Content-Type"))
+}
+
+// decodeMetricFamilies decodes the body of a scrape response in the format of
+// its Content-Type.
+func decodeMetricFamilies(t *testing.T, resp *http.Response) map[string]*dto.MetricFamily {
+	t.Helper()
+	dec := expfmt.NewDecoder(resp.Body, responseFormat(resp))
+	result := map[string]*dto.MetricFamily{}
+	for {
+		mf := &dto.MetricFamily{}
+		if err := dec.Decode(mf); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+		result[mf.GetName()] = mf
+	}
+	return result
+}
+
+func TestExposeRoundTrip(t *testing.T) {
+	exemplar := &dto.Exemplar{
+		Label:     []*dto.LabelPair{{Name: proto.String("trace_id"), Value: proto.String("abc")}},
+		Value:     proto.Float64(0.3),
+		Timestamp: timestamppb.New(time.Unix(1700000000, 0)),
+	}
+	nativeHistogram := &dto.MetricFamily{
+		Name: proto.String("request_duration_seconds"),
+		Help: proto.String("Duration of requests."),
+		Type: dto.MetricType_HISTOGRAM.Enum(),
+		Metric: []*dto.Metric{{
+			Histogram: &dto.Histogram{
+				SampleCount:   proto.Uint64(3),
+				SampleSum:     proto.Float64(1.5),
+				Schema:        proto.Int32(3),
+				ZeroThreshold: proto.Float64(1e-128),
+				ZeroCount:     proto.Uint64(1),
+				PositiveSpan:  []*dto.BucketSpan{{Offset: proto.Int32(-2), Length: proto.Uint32(2)}},
+				PositiveDelta: []int64{1, 0},
+				Exemplars:     []*dto.Exemplar{exemplar},
+			},
+		}},
+	}
+	counter := &dto.MetricFamily{
+		Name: proto.String("requests_total"),
+		Help: proto.String("Number of requests."),
+		Type: dto.MetricType_COUNTER.Enum(),
+		Unit: proto.String("requests"),
+		Metric: []*dto.Metric{{
+			Counter: &dto.Counter{Value: proto.Float64(7), Exemplar: exemplar},
+		}},
+	}
+
+	// Protobuf transmits everything.
+	resp := pushAndScrape(t, ExpositionOptions{}, string(expfmt.NewFormat(expfmt.TypeProtoDelim)), nativeHistogram, counter)
+	mfs := decodeMetricFamilies(t, resp)
+	h := mfs["request_duration_seconds"].GetMetric()[0].GetHistogram()
+	if expected, got := int32(3), h.GetSchema(); expected != got {
+		t.Errorf("Wanted schema %d, got %d.", expected, got)
+	}
+	if expected, got := []int64{1, 0}, h.GetPositiveDelta(); len(got) != 2 || got[0] != expected[0] || got[1] != expected[1] {
+		t.Errorf("Wanted positive deltas %v, got %v.", expected, got)
+	}
+	if len(h.GetExemplars()) != 1 || !proto.Equal(exemplar, h.GetExemplars()[0]) {
+		t.Errorf("Wanted native histogram exemplar %v, got %v.", exemplar, h.GetExemplars())
+	}
+	if got := mfs["requests_total"].GetMetric()[0].GetCounter().GetExemplar(); !proto.Equal(exemplar, got) {
+		t.Errorf("Wanted counter exemplar %v, got %v.", exemplar, got)
+	}
+
+	// OpenMetrics transmits the counter exemplar and the unit, but only the
+	// count and sum of the native histogram, if it is negotiable at all.
+	openMetrics := "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5"
+	resp = pushAndScrape(t, ExpositionOptions{}, openMetrics, nativeHistogram, counter)
+	if got := responseFormat(resp).FormatType(); got != expfmt.TypeTextPlain {
+		t.Errorf("Wanted text format with OpenMetrics disabled, got %v.", got)
+	}
+	resp = pushAndScrape(t, ExpositionOptions{EnableOpenMetrics: true}, openMetrics, nativeHistogram, counter)
+	if got := responseFormat(resp).FormatType(); got != expfmt.TypeOpenMetrics {
+		t.Fatalf("Wanted OpenMetrics format, got %v.", got)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# UNIT requests requests",
+		`requests_total{instance="",job="testjob"} 7.0 # {trace_id="abc"} 0.3 1.7e+09`,
+		`request_duration_seconds_count{instance="",job="testjob"} 3`,
+		`request_duration_seconds_sum{instance="",job="testjob"} 1.5`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("Wanted line %q in OpenMetrics exposition:\n%s", line, body)
+		}
+	}
+	if strings.Contains(string(body), "_created") {
+		t.Errorf("Unexpected created samples in OpenMetrics exposition:\n%s", body)
+	}
+
+	// The text format transmits neither exemplars nor native buckets.
+	resp = pushAndScrape(t, ExpositionOptions{EnableOpenMetrics: true}, "text/plain;version=0.0.4", nativeHistogram, counter)
+	mfs = decodeMetricFamilies(t, resp)
+	if got := mfs["requests_total"].GetMetric()[0].GetCounter().GetExemplar(); got != nil {
+		t.Errorf("Unexpected exemplar %v in text format.", got)
+	}
+	if got := mfs["request_duration_seconds"].GetMetric()[0].GetHistogram().GetSampleCount(); got != 3 {
+		t.Errorf("Wanted sample count 3 in text format, got %d.", got)
+	}
+}
+
+func TestExposeCreatedSamples(t *testing.T) {
+	counter := &dto.MetricFamily{
+		Name: proto.String("requests_total"),
+		Type: dto.MetricType_COUNTER.Enum(),
+		Metric: []*dto.Metric{{
+			Counter: &dto.Counter{
+				Value:            proto.Float64(7),
+				CreatedTimestamp: timestamppb.New(time.Unix(1700000000, 0)),
+			},
+		}},
+	}
+	opts := ExpositionOptions{EnableOpenMetrics: true, EnableOpenMetricsCreatedSamples: true}
+	resp := pushAndScrape(t, opts, "application/openmetrics-text;version=1.0.0", counter)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line := `requests_created{instance="",job="testjob"} 1.7e+09`; !strings.Contains(string(body), line+"\n") {
+		t.Errorf("Wanted line %q in OpenMetrics exposition:\n%s", line, body)
+	}
+}
+
+func TestExposeNameEscaping(t *testing.T) {
+	defer func(validation model.ValidationScheme, escaping model.EscapingScheme) {
+		ValidationScheme, model.NameEscapingScheme = validation, escaping
+	}(ValidationScheme, model.NameEscapingScheme)
+	ValidationScheme = model.UTF8Validation
+
+	gauge := &dto.MetricFamily{
+		Name:   proto.String("request.rate"),
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(2)}}},
+	}
+
+	scenarios := []struct {
+		accept       string
+		escaping     model.EscapingScheme
+		expectedName string
+	}{
+		{
+			accept:       "text/plain;version=1.0.0;escaping=allow-utf-8",
+			escaping:     model.UnderscoreEscaping,
+			expectedName: "request.rate",
+		},
+		{
+			accept:       "text/plain;version=0.0.4",
+			escaping:     model.UnderscoreEscaping,
+			expectedName: "request_rate",
+		},
+		{
+			accept:       "text/plain;version=0.0.4",
+			escaping:     model.DotsEscaping,
+			expectedName: "request_dot_rate",
+		},
+		{
+			accept:       "text/plain;version=0.0.4;escaping=values",
+			escaping:     model.DotsEscaping,
+			expectedName: "U__request_2e_rate",
+		},
+	}
+	for i, s := range scenarios {
+		model.NameEscapingScheme = s.escaping
+		mfs := decodeMetricFamilies(t, pushAndScrape(t, ExpositionOptions{}, s.accept, gauge))
+		if _, ok := mfs[s.expectedName]; !ok {
+			t.Errorf("%d. Wanted metric %q, got %v.", i, s.expectedName, mfs)
+		}
+	}
+}
+
+// scrapeHandler scrapes the provided handler with the provided Accept and
+// Accept-Encoding headers and returns the decoded MetricFamilies and the
+// uncompressed body.
+func scrapeHandler(t testing.TB, h http.Handler, accept, acceptEncoding string) (map[string]*dto.MetricFamily, []byte) {
+	t.Helper()
+	req, err := http.NewRequest("GET", "http://example.org/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	resp := w.Result()
+	if expected, got := http.StatusOK, resp.StatusCode; expected != got {
+		t.Fatalf("Wanted status code %v, got %v: %s", expected, got, w.Body.String())
+	}
+	var body io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = gz
+	}
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+	if t, ok := t.(*testing.T); ok {
+		return decodeMetricFamilies(t, resp), raw
+	}
+	return nil, raw
+}
+
+func TestExposeCached(t *testing.T) {
+	ms := storage.NewDiskMetricStore("", 100*time.Millisecond, prometheus.NewRegistry(), logger)
+	defer ms.Shutdown()
+	push := func(job string, mf *dto.MetricFamily) {
+		errCh := make(chan error, 1)
+		ms.SubmitWriteRequest(storage.WriteRequest{
+			Labels:         map[string]string{"job": job},
+			Timestamp:      time.Now(),
+			MetricFamilies: map[string]*dto.MetricFamily{mf.GetName(): mf},
+			Done:           errCh,
+		})
+		for err := range errCh {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+	gaugeFamily := func(name string) *dto.MetricFamily {
+		return &dto.MetricFamily{
+			Name:   proto.String(name),
+			Type:   dto.MetricType_GAUGE.Enum(),
+			Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(1)}}},
+		}
+	}
+
+	reg := prometheus.NewRegistry()
+	own := prometheus.NewGauge(prometheus.GaugeOpts{Name: "own_metric", Help: "Own metric."})
+	reg.MustRegister(own)
+	opts := ExpositionOptions{EnableOpenMetrics: true}
+	cached := ExposeCached(reg, ms, opts, logger)
+	uncached := Expose(prometheus.Gatherers{
+		reg,
+		prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return ms.GetMetricFamilies(), nil }),
+	}, opts, logger)
+
+	push("a", gaugeFamily("pushed"))
+	formats := []string{
+		"text/plain;version=0.0.4",
+		"application/openmetrics-text;version=1.0.0",
+		string(expfmt.NewFormat(expfmt.TypeProtoDelim)),
+	}
+	check := func(step string) {
+		t.Helper()
+		for _, accept := range formats {
+			for _, acceptEncoding := range []string{"", "gzip"} {
+				expected, _ := scrapeHandler(t, uncached, accept, acceptEncoding)
+				got, body := scrapeHandler(t, cached, accept, acceptEncoding)
+				if len(expected) != len(got) {
+					t.Errorf("%s, %s, %q: Wanted %d metric families, got %d.", step, accept, acceptEncoding, len(expected), len(got))
+				}
+				for name, mf := range expected {
+					if !proto.Equal(mf, got[name]) {
+						t.Errorf("%s, %s, %q: Wanted %v, got %v.", step, accept, acceptEncoding, mf, got[name])
+					}
+				}
+				if strings.HasPrefix(accept, "application/openmetrics-text") && strings.Count(string(body), "# EOF\n") != 1 {
+					t.Errorf("%s: Wanted exactly one # EOF line:\n%s", step, body)
+				}
+			}
+		}
+	}
+
+	own.Set(1)
+	check("first scrape")
+	// The cached part comes first (rather than in sorted order).
+	if _, body := scrapeHandler(t, cached, formats[0], ""); bytes.Index(body, []byte("# TYPE pushed")) > bytes.Index(body, []byte("# TYPE own_metric")) {
+		t.Errorf("Wanted cached metrics first:\n%s", body)
+	}
+	// Changes of the metrics not from the store show up despite the cache.
+	own.Set(2)
+	check("own metric changed")
+	got, _ := scrapeHandler(t, cached, formats[0], "gzip")
+	if expected, got := 2.0, got["own_metric"].GetMetric()[0].GetGauge().GetValue(); expected != got {
+		t.Errorf("Wanted own metric %v, got %v.", expected, got)
+	}
+	// Changes of the store invalidate the cache.
+	push("b", gaugeFamily("pushed"))
+	check("second push")
+	got, _ = scrapeHandler(t, cached, formats[0], "")
+	if expected, got := 2, len(got["pushed"].GetMetric()); expected != got {
+		t.Errorf("Wanted %d pushed metrics, got %d.", expected, got)
+	}
+	// Metric families of the same name are merged without cache.
+	ownMF := gaugeFamily("own_metric")
+	ownMF.Help = proto.String("Own metric.")
+	push("c", ownMF)
+	check("push of own metric")
+	got, _ = scrapeHandler(t, cached, formats[0], "")
+	if expected, got := 2, len(got["own_metric"].GetMetric()); expected != got {
+		t.Errorf("Wanted %d own metrics, got %d.", expected, got)
+	}
+}
+
+func TestExposeCachedUnits(t *testing.T) {
+	ms := storage.NewDiskMetricStore("", 100*time.Millisecond, prometheus.NewRegistry(), logger)
+	defer ms.Shutdown()
+	// The metric families of both groups are merged by the store.
+	for _, job := range []string{"a", "b"} {
+		errCh := make(chan error, 1)
+		ms.SubmitWriteRequest(storage.WriteRequest{
+			Labels:    map[string]string{"job": job},
+			Timestamp: time.Now(),
+			MetricFamilies: map[string]*dto.MetricFamily{"latency_seconds": {
+				Name:   proto.String("latency_seconds"),
+				Help:   proto.String("Latency."),
+				Type:   dto.MetricType_GAUGE.Enum(),
+				Unit:   proto.String("seconds"),
+				Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(1)}}},
+			}},
+			Done: errCh,
+		})
+		for err := range errCh {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	// Without a metric family of the same name in the Gatherer, the cache
+	// is used, otherwise the metric families are merged without cache.
+	for _, merged := range []bool{false, true} {
+		reg := prometheus.NewRegistry()
+		if merged {
+			g := prometheus.NewGauge(prometheus.GaugeOpts{Name: "latency_seconds", Help: "Latency."})
+			g.Set(2)
+			reg.MustRegister(g)
+		}
+		h := ExposeCached(reg, ms, ExpositionOptions{EnableOpenMetrics: true}, logger)
+		got, body := scrapeHandler(t, h, "application/openmetrics-text;version=1.0.0", "")
+		if !bytes.Contains(body, []byte("# UNIT latency_seconds seconds\n")) {
+			t.Errorf("merged %t: Wanted UNIT line:\n%s", merged, body)
+		}
+		mf := got["latency_seconds"]
+		expected := 2
+		if merged {
+			expected = 3
+		}
+		if got := len(mf.GetMetric()); expected != got {
+			t.Errorf("merged %t: Wanted %d metrics, got %d.", merged, expected, got)
+		}
+	}
+}
+
+func TestNegotiateGzip(t *testing.T) {
+	scenarios := map[string]bool{
+		"":                     false,
+		"gzip":                 true,
+		"gzip, deflate, br":    true,
+		"identity, gzip":       false,
+		"identity;q=0.5, gzip": true,
+		"gzip;q=0":             false,
+		"*":                    false,
+		"br":                   false,
+	}
+	for header, expected := range scenarios {
+		req := httptest.NewRequest("GET", "http://example.org/metrics", nil)
+		req.Header.Set("Accept-Encoding", header)
+		if got := negotiateGzip(req); expected != got {
+			t.Errorf("Accept-Encoding %q: Wanted gzip %t, got %t.", header, expected, got)
+		}
+	}
+}
+
+func BenchmarkExpose(b *testing.B) {
+	ms := storage.NewDiskMetricStore("", time.Hour, prometheus.NewRegistry(), logger)
+	defer ms.Shutdown()
+	for i := range 1000 {
+		mf := &dto.MetricFamily{Name: proto.String("mf"), Type: dto.MetricType_GAUGE.Enum()}
+		for j := range 10 {
+			mf.Metric = append(mf.Metric, &dto.Metric{
+				Label: []*dto.LabelPair{{Name: proto.String("series"), Value: proto.String(strconv.Itoa(j))}},
+				Gauge: &dto.Gauge{Value: proto.Float64(float64(j))},
+			})
+		}
+		errCh := make(chan error, 1)
+		ms.SubmitWriteRequest(storage.WriteRequest{
+			Labels:         map[string]string{"job": "job", "instance": strconv.Itoa(i)},
+			Timestamp:      time.Now(),
+			MetricFamilies: map[string]*dto.MetricFamily{"mf": mf},
+			Done:           errCh,
+		})
+		for err := range errCh {
+			b.Fatal(err)
+		}
+	}
+	handlers := map[string]http.Handler{
+		"uncached": Expose(prometheus.Gatherers{
+			prometheus.DefaultGatherer,
+			prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return ms.GetMetricFamilies(), nil }),
+		}, ExpositionOptions{}, logger),
+		"cached": ExposeCached(prometheus.DefaultGatherer, ms, ExpositionOptions{}, logger),
+	}
+	for _, name := range []string{"uncached", "cached"} {
+		for _, acceptEncoding := range []string{"identity", "gzip"} {
+			b.Run(name+"/"+acceptEncoding, func(b *testing.B) {
+				for b.Loop() {
+					scrapeHandler(b, handlers[name], "text/plain;version=0.0.4", acceptEncoding)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/prometheus/pushgateway/cluster"
+	"github.com/prometheus/pushgateway/storage"
+)
+
+// The headers set on requests forwarded to another cluster member.
+// ForwardedHeader is set to the URL of the forwarding member,
+// ForwardedIdentityHeader to the identity of the client as used for auditing
+// (if any). Both are only trusted from other members, see AcceptForwarded.
+const (
+	ForwardedHeader         = "X-Pushgateway-Forwarded-By"
+	ForwardedIdentityHeader = "X-Pushgateway-Forwarded-Identity"
+)
+
+// GroupingKeyer returns the grouping key of the metric group that a push or
+// deletion with the provided grouping labels applies to, or false if it is
+// dropped. It is implemented by storage.DiskMetricStore, taking relabeling into
+// account.
+type GroupingKeyer interface {
+	GroupingKey(groupingLabels map[string]string) (string, bool)
+}
+
+// Forward returns a handler that passes a request on to next if the metric
+// group identified by the request URL path (as used by Push and Delete) is
+// owned by this member of the ring. Otherwise, the request is proxied to the
+// owner, and its response is returned. If the owner cannot be reached, the
+// request is answered with http.StatusBadGateway. Requests with a malformed
+// URL path and requests dropped by relabeling are passed on to next.
+//
+// Forward is meant to run after Authorize so that requests are authorized by
+// the receiving member. The identity of the client is passed on to the owner in
+// the ForwardedIdentityHeader. Requests forwarded by other members have to be
+// kept from reaching Forward again, see AcceptForwarded.
+//
+// The request URL path is appended to the URL of the owner. Usually, the member
+// URLs therefore have no path, and all members use the same route prefix.
+//
+// The request body must have been decoded already. Its Content-Encoding header
+// is therefore removed upon forwarding. The provided transport is used to
+// connect to the owner. If nil, http.DefaultTransport is used.
+func Forward(
+	ring *cluster.Ring,
+	gk GroupingKeyer,
+	jobBase64Encoded bool,
+	transport http.RoundTripper,
+	next func(http.ResponseWriter, *http.Request),
+	logger *slog.Logger,
+) func(http.ResponseWriter, *http.Request) {
+	proxies := map[string]*httputil.ReverseProxy{}
+	for _, member := range ring.Members() {
+		if member == ring.Self() {
+			continue
+		}
+		target, err := url.Parse(member)
+		if err != nil {
+			panic(fmt.Sprintf("invalid URL of cluster member %q: %v", member, err))
+		}
+		proxies[member] = &httputil.ReverseProxy{
+			Transport: transport,
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(target)
+				pr.SetXForwarded()
+				pr.Out.Header.Set(ForwardedHeader, ring.Self())
+				if identity := requestIdentity(pr.In); identity != "" {
+					pr.Out.Header.Set(ForwardedIdentityHeader, identity)
+				} else {
+					pr.Out.Header.Del(ForwardedIdentityHeader)
+				}
+				if pr.Out.Header.Get("Content-Encoding") != "" {
+					pr.Out.Header.Del("Content-Encoding")
+					pr.Out.ContentLength = -1
+				}
+			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				clusterForwardFailures.WithLabelValues(member).Inc()
+				logger.Warn("could not forward request to owner", "method", r.Method, "owner", member, "err", err)
+				http.Error(w, fmt.Sprintf("could not forward request to owner %s: %v", member, err), http.StatusBadGateway)
+			},
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		labels, ok := groupingLabelsFromPath(r, jobBase64Encoded)
+		if !ok {
+			next(w, r)
+			return
+		}
+		var key string
+		if relabel, err := relabelParam(r); err == nil && !relabel {
+			// The grouping labels are those of a stored group.
+			key = storage.GroupingKeyFor(labels)
+		} else if key, ok = gk.GroupingKey(labels); !ok {
+			next(w, r)
+			return
+		}
+		owner := ring.Owner(key)
+		proxy, ok := proxies[owner]
+		if !ok {
+			next(w, r)
+			return
+		}
+		clusterForwards.WithLabelValues(owner).Inc()
+		logger.Debug("forwarding request to owner", "method", r.Method, "labels", labels, "owner", owner)
+		proxy.ServeHTTP(w, r)
+	}
+}
+
+// AcceptForwarded returns a handler that passes requests forwarded by another
+// member of the ring (see Forward) to local, with the identity of the client as
+// passed on by the forwarding member, and all other requests to next. Usually,
+// next authorizes and forwards requests before passing them on to local, which
+// the owner therefore doesn't repeat for forwarded requests.
+//
+// A request only counts as forwarded by another member if it carries the
+// ForwardedHeader and a verified TLS client certificate valid for the host of
+// the URL of another member. Otherwise, the ForwardedHeader and the
+// ForwardedIdentityHeader are removed before passing the request on to next,
+// so that clients cannot bypass authorization or forwarding by setting them.
+func AcceptForwarded(
+	ring *cluster.Ring,
+	local, next func(http.ResponseWriter, *http.Request),
+	logger *slog.Logger,
+) func(http.ResponseWriter, *http.Request) {
+	var peerHosts []string
+	for _, member := range ring.Members() {
+		if member == ring.Self() {
+			continue
+		}
+		target, err := url.Parse(member)
+		if err != nil {
+			panic(fmt.Sprintf("invalid URL of cluster member %q: %v", member, err))
+		}
+		peerHosts = append(peerHosts, target.Hostname())
+	}
+	fromPeer := func(r *http.Request) bool {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			return false
+		}
+		cert := r.TLS.VerifiedChains[0][0]
+		for _, host := range peerHosts {
+			if cert.VerifyHostname(host) == nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		forwardedBy := r.Header.Get(ForwardedHeader)
+		if forwardedBy == "" {
+			r.Header.Del(ForwardedIdentityHeader)
+			next(w, r)
+			return
+		}
+		if !fromPeer(r) {
+			logger.Debug("ignoring forwarding headers of request not sent by a cluster member", "method", r.Method, "forwarded_by", forwardedBy)
+			r.Header.Del(ForwardedHeader)
+			r.Header.Del(ForwardedIdentityHeader)
+			next(w, r)
+			return
+		}
+		if identity := r.Header.Get(ForwardedIdentityHeader); identity != "" {
+			r = withIdentity(r, identity)
+		}
+		local(w, r)
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/prometheus/pushgateway/cluster"
+)
+
+// jobKeyer uses the job label as grouping key and drops the job "dropped".
+type jobKeyer struct{}
+
+func (jobKeyer) GroupingKey(groupingLabels map[string]string) (string, bool) {
+	job := groupingLabels["job"]
+	return job, job != "dropped"
+}
+
+func TestForward(t *testing.T) {
+	var (
+		upstreamPath, upstreamBody, upstreamEncoding, upstreamForwardedBy, upstreamIdentity string
+	)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.Path
+		b, _ := io.ReadAll(r.Body)
+		upstreamBody = string(b)
+		upstreamEncoding = r.Header.Get("Content-Encoding")
+		upstreamForwardedBy = r.Header.Get(ForwardedHeader)
+		upstreamIdentity = r.Header.Get(ForwardedIdentityHeader)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer upstream.Close()
+
+	const self = "http://self.example.org:9091"
+	ring, err := cluster.NewRing([]string{self, upstream.URL}, self)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownedJob := func(owner string) string {
+		for i := 0; ; i++ {
+			if job := fmt.Sprint("job-", i); ring.Owner(job) == owner {
+				return job
+			}
+		}
+	}
+	localJob, remoteJob := ownedJob(self), ownedJob(upstream.URL)
+
+	nextCalled := false
+	handler := Forward(ring, jobKeyer{}, false, nil, func(w http.ResponseWriter, _ *http.Request) {
+		nextCalled = true
+		w.WriteHeader(http.StatusOK)
+	}, logger)
+
+	scenarios := []struct {
+		name      string
+		job       string
+		identity  string
+		forwarded bool
+	}{
+		{name: "owned by self", job: localJob},
+		{name: "owned by other member", job: remoteJob, forwarded: true},
+		{name: "owned by other member with identity", job: remoteJob, identity: "token:a", forwarded: true},
+		{name: "dropped by relabeling", job: "dropped"},
+		{name: "no job", job: ""},
+	}
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			nextCalled, upstreamPath = false, ""
+			path := "/metrics/job/" + s.job + "/instance/inst"
+			req, err := http.NewRequest("POST", "http://self.example.org:9091"+path, bytes.NewBufferString("some_metric 3.14\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The body has been decoded already.
+			req.Header.Set("Content-Encoding", "gzip")
+			// Identities are only passed on as set by Authorize.
+			req.Header.Set(ForwardedIdentityHeader, "spoofed")
+			if s.identity != "" {
+				req = withIdentity(req, s.identity)
+			}
+			before := testutil.ToFloat64(clusterForwards.WithLabelValues(upstream.URL))
+			w := httptest.NewRecorder()
+			handler(w, req.WithContext(ctxWithParams(map[string]string{"job": s.job, "labels": "/instance/inst"}, req)))
+
+			if nextCalled == s.forwarded {
+				t.Errorf("Wanted request forwarded %t, got next called %t.", s.forwarded, nextCalled)
+			}
+			forwards := testutil.ToFloat64(clusterForwards.WithLabelValues(upstream.URL)) - before
+			if !s.forwarded {
+				if expected, got := http.StatusOK, w.Code; expected != got {
+					t.Errorf("Wanted status code %v, got %v.", expected, got)
+				}
+				if forwards != 0 {
+					t.Errorf("Wanted no forwards, got %v.", forwards)
+				}
+				return
+			}
+			if expected, got := http.StatusAccepted, w.Code; expected != got {
+				t.Errorf("Wanted status code %v, got %v.", expected, got)
+			}
+			if forwards != 1 {
+				t.Errorf("Wanted 1 forward, got %v.", forwards)
+			}
+			if upstreamPath != path {
+				t.Errorf("Wanted forwarded path %q, got %q.", path, upstreamPath)
+			}
+			if expected := "some_metric 3.14\n"; upstreamBody != expected {
+				t.Errorf("Wanted forwarded body %q, got %q.", expected, upstreamBody)
+			}
+			if upstreamEncoding != "" {
+				t.Errorf("Wanted no Content-Encoding, got %q.", upstreamEncoding)
+			}
+			if upstreamForwardedBy != self {
+				t.Errorf("Wanted %s header %q, got %q.", ForwardedHeader, self, upstreamForwardedBy)
+			}
+			if upstreamIdentity != s.identity {
+				t.Errorf("Wanted %s header %q, got %q.", ForwardedIdentityHeader, s.identity, upstreamIdentity)
+			}
+		})
+	}
+
+	// The owner is unreachable.
+	upstream.Close()
+	req, err := http.NewRequest("DELETE", "http://self.example.org:9091/metrics/job/"+remoteJob, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := testutil.ToFloat64(clusterForwardFailures.WithLabelValues(upstream.URL))
+	w := httptest.NewRecorder()
+	handler(w, req.WithContext(ctxWithParams(map[string]string{"job": remoteJob}, req)))
+	if expected, got := http.StatusBadGateway, w.Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+	if got := testutil.ToFloat64(clusterForwardFailures.WithLabelValues(upstream.URL)) - before; got != 1 {
+		t.Errorf("Wanted 1 forward failure, got %v.", got)
+	}
+}
+
+func TestAcceptForwarded(t *testing.T) {
+	ring, err := cluster.NewRing([]string{"https://pg-a.example.org:9091", "https://pg-b.example.org:9091"}, "https://pg-a.example.org:9091")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		calledHandler, gotIdentity string
+		gotHeaders                 http.Header
+	)
+	record := func(name string) func(http.ResponseWriter, *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			calledHandler, gotIdentity, gotHeaders = name, requestIdentity(r), r.Header
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+	handler := AcceptForwarded(ring, record("local"), record("next"), logger)
+
+	scenarios := []struct {
+		name            string
+		forwardedBy     string
+		certHost        string
+		expectedHandler string
+	}{
+		{name: "not forwarded", expectedHandler: "next"},
+		{name: "forwarded without client certificate", forwardedBy: "https://pg-b.example.org:9091", expectedHandler: "next"},
+		{name: "forwarded with certificate of other host", forwardedBy: "https://pg-b.example.org:9091", certHost: "client.example.org", expectedHandler: "next"},
+		{name: "forwarded with certificate of self", forwardedBy: "https://pg-a.example.org:9091", certHost: "pg-a.example.org", expectedHandler: "next"},
+		{name: "forwarded by other member", forwardedBy: "https://pg-b.example.org:9091", certHost: "pg-b.example.org", expectedHandler: "local"},
+	}
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "https://pg-a.example.org:9091/metrics/job/a", nil)
+			req.Header.Set(ForwardedIdentityHeader, "token:a")
+			if s.forwardedBy != "" {
+				req.Header.Set(ForwardedHeader, s.forwardedBy)
+			}
+			if s.certHost != "" {
+				cert := &x509.Certificate{Subject: pkix.Name{CommonName: s.certHost}, DNSNames: []string{s.certHost}}
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+			}
+			handler(httptest.NewRecorder(), req)
+
+			if calledHandler != s.expectedHandler {
+				t.Fatalf("Wanted %s handler called, got %s.", s.expectedHandler, calledHandler)
+			}
+			if s.expectedHandler == "local" {
+				if expected := "token:a"; gotIdentity != expected {
+					t.Errorf("Wanted identity %q, got %q.", expected, gotIdentity)
+				}
+				return
+			}
+			// Headers not sent by another member are removed.
+			for _, h := range []string{ForwardedHeader, ForwardedIdentityHeader} {
+				if got := gotHeaders.Get(h); got != "" {
+					t.Errorf("Wanted no %s header, got %q.", h, got)
+				}
+			}
+			if gotIdentity == "token:a" {
+				t.Errorf("Wanted identity not taken from header, got %q.", gotIdentity)
+			}
+		})
+	}
+}
@@ -20,12 +20,17 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/promslog"
 	"github.com/prometheus/common/route"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
 	"google.golang.org/protobuf/encoding/protodelim"
 	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/proto"
@@ -45,7 +50,11 @@ type MockMetricStore struct {
 	lastWriteRequest storage.WriteRequest
 	metricGroups     storage.GroupingKeyToMetricGroup
 	writeRequests    []storage.WriteRequest
-	err              error // If non-nil, will be sent to Done channel in request.
+	deletedKeys      []string           // Passed to DeleteGroupsByKey.
+	err              error              // If non-nil, will be sent to Done channel in request.
+	readyErr         error              // Returned by Ready.
+	submitErr        error              // If non-nil, returned by TrySubmitWriteRequest and DeleteGroupsByKey.
+	problems         []*storage.Problem // Returned by Validate.
 }
 
 func (m *MockMetricStore) SubmitWriteRequest(req storage.WriteRequest) {
@@ -59,6 +68,17 @@ func (m *MockMetricStore) SubmitWriteRequest(req storage.WriteRequest) {
 	}
 }
 
+func (m *MockMetricStore) TrySubmitWriteRequest(ctx context.Context, req storage.WriteRequest) error {
+	if m.submitErr != nil {
+		return m.submitErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.SubmitWriteRequest(req)
+	return nil
+}
+
 func (m *MockMetricStore) GetMetricFamilies() []*dto.MetricFamily {
 	panic("not implemented")
 }
@@ -67,6 +87,51 @@ func (m *MockMetricStore) GetMetricFamiliesMap() storage.GroupingKeyToMetricGrou
 	return m.metricGroups
 }
 
+func (m *MockMetricStore) GetGroupSummaries() []storage.GroupSummary {
+	var summaries []storage.GroupSummary
+	for k, g := range m.metricGroups {
+		summaries = append(summaries, g.Summary(k))
+	}
+	return summaries
+}
+
+func (m *MockMetricStore) GetMetricGroup(key string) (storage.MetricGroup, bool) {
+	g, ok := m.metricGroups[key]
+	return g, ok
+}
+
+func (m *MockMetricStore) DeleteGroups([][]*labels.Matcher, bool) ([]map[string]string, error) {
+	panic("not implemented")
+}
+
+func (m *MockMetricStore) DeleteGroupsByKey(keys []string) ([]map[string]string, error) {
+	if m.submitErr != nil {
+		return nil, m.submitErr
+	}
+	var deleted []map[string]string
+	for _, key := range keys {
+		if g, ok := m.metricGroups[key]; ok {
+			deleted = append(deleted, g.Labels)
+			delete(m.metricGroups, key)
+			m.deletedKeys = append(m.deletedKeys, key)
+		}
+	}
+	return deleted, nil
+}
+
+func (m *MockMetricStore) RelabelGroupingLabels(groupingLabels map[string]string) (map[string]string, bool) {
+	return groupingLabels, true
+}
+
+func (m *MockMetricStore) Validate(req storage.WriteRequest) []*storage.Problem {
+	m.lastWriteRequest = req
+	return m.problems
+}
+
+func (m *MockMetricStore) Watch([][]*labels.Matcher, int) (<-chan storage.Event, func()) {
+	panic("not implemented")
+}
+
 func (m *MockMetricStore) Shutdown() error {
 	return nil
 }
@@ -76,7 +141,7 @@ func (m *MockMetricStore) Healthy() error {
 }
 
 func (m *MockMetricStore) Ready() error {
-	return nil
+	return m.readyErr
 }
 
 func ctxWithParams(params map[string]string, mainReq *http.Request) context.Context {
@@ -111,13 +176,57 @@ func TestHealthyReady(t *testing.T) {
 	}
 }
 
+func TestRestoring(t *testing.T) {
+	mms := MockMetricStore{readyErr: fmt.Errorf("%w: 50%% done", storage.ErrRestoring)}
+	req, err := http.NewRequest("POST", "http://example.org/", &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	Healthy(&mms).ServeHTTP(w, req)
+	if expected, got := http.StatusOK, w.Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+
+	w = httptest.NewRecorder()
+	Ready(&mms).ServeHTTP(w, req)
+	if expected, got := http.StatusServiceUnavailable, w.Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+	if expected, got := "50% done", w.Body.String(); !strings.Contains(got, expected) {
+		t.Errorf("Wanted body containing %q, got %q.", expected, got)
+	}
+
+	nextCalled := false
+	h := RejectDuringRestore(&mms, func(http.ResponseWriter, *http.Request) { nextCalled = true })
+	w = httptest.NewRecorder()
+	h(w, req)
+	if expected, got := http.StatusServiceUnavailable, w.Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+	if expected, got := "5", w.Header().Get("Retry-After"); expected != got {
+		t.Errorf("Wanted Retry-After %q, got %q.", expected, got)
+	}
+	if nextCalled {
+		t.Error("Request passed on during restore.")
+	}
+
+	mms.readyErr = nil
+	w = httptest.NewRecorder()
+	h(w, req)
+	if !nextCalled {
+		t.Error("Request not passed on after restore.")
+	}
+}
+
 func TestPush(t *testing.T) {
 	mms := MockMetricStore{}
 	mmsWithErr := MockMetricStore{err: errors.New("testerror")}
 	// false, true, false → no replace, check consistency, no base64 encoding.
-	handler := Push(&mms, false, true, false, logger)
-	handlerWithErr := Push(&mmsWithErr, false, true, false, logger)
-	handlerBase64 := Push(&mms, false, true, true, logger)
+	handler := Push(&mms, false, true, false, nil, logger)
+	handlerWithErr := Push(&mmsWithErr, false, true, false, nil, logger)
+	handlerBase64 := Push(&mms, false, true, true, nil, logger)
 	req, err := http.NewRequest("POST", "http://example.org/", &bytes.Buffer{})
 	if err != nil {
 		t.Fatal(err)
@@ -441,8 +550,8 @@ func TestPushUTF8(t *testing.T) {
 	ValidationScheme = model.UTF8Validation
 	EscapingScheme = model.ValueEncodingEscaping
 	mms := MockMetricStore{}
-	handler := Push(&mms, false, true, false, logger)
-	handlerBase64 := Push(&mms, false, true, true, logger)
+	handler := Push(&mms, false, true, false, nil, logger)
+	handlerBase64 := Push(&mms, false, true, true, nil, logger)
 
 	// With job name, instance name, UTF-8 escaped label name in params, UTF-8 metric name and text content.
 	mms.lastWriteRequest = storage.WriteRequest{}
@@ -626,11 +735,508 @@ func TestPushUTF8(t *testing.T) {
 	EscapingScheme = model.NoEscaping
 }
 
+func TestPushTTL(t *testing.T) {
+	mms := MockMetricStore{}
+	handler := Push(&mms, false, true, false, nil, logger)
+	params := map[string]string{
+		"job": "testjob",
+	}
+
+	// Without TTL header.
+	req, err := http.NewRequest("POST", "http://example.org/", bytes.NewBufferString("some_metric 3.14\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler(w, req.WithContext(ctxWithParams(params, req)))
+	if expected, got := http.StatusOK, w.Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+	if expected, got := time.Duration(0), mms.lastWriteRequest.TTL; expected != got {
+		t.Errorf("Wanted TTL %v, got %v.", expected, got)
+	}
+
+	// With valid TTL header.
+	mms.lastWriteRequest = storage.WriteRequest{}
+	req, err = http.NewRequest("POST", "http://example.org/", bytes.NewBufferString("some_metric 3.14\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(TTLHeader, "1h30m")
+	w = httptest.NewRecorder()
+	handler(w, req.WithContext(ctxWithParams(params, req)))
+	if expected, got := http.StatusOK, w.Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+	if expected, got := 90*time.Minute, mms.lastWriteRequest.TTL; expected != got {
+		t.Errorf("Wanted TTL %v, got %v.", expected, got)
+	}
+
+	// With invalid TTL headers.
+	for _, ttl := range []string{"blub", "0s", "-1m"} {
+		mms.lastWriteRequest = storage.WriteRequest{}
+		req, err = http.NewRequest("POST", "http://example.org/", bytes.NewBufferString("some_metric 3.14\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(TTLHeader, ttl)
+		w = httptest.NewRecorder()
+		handler(w, req.WithContext(ctxWithParams(params, req)))
+		if expected, got := http.StatusBadRequest, w.Code; expected != got {
+			t.Errorf("Wanted status code %v for TTL %q, got %v.", expected, ttl, got)
+		}
+		if !mms.lastWriteRequest.Timestamp.IsZero() {
+			t.Errorf("Write request timestamp unexpectedly set: %#v", mms.lastWriteRequest)
+		}
+	}
+}
+
+func TestPushQueueFull(t *testing.T) {
+	mms := MockMetricStore{submitErr: storage.ErrWriteQueueFull}
+	params := map[string]string{
+		"job": "testjob",
+	}
+
+	for _, check := range []bool{false, true} {
+		handler := Push(&mms, false, check, false, nil, logger)
+		req, err := http.NewRequest("POST", "http://example.org/", bytes.NewBufferString("some_metric 3.14\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req.WithContext(ctxWithParams(params, req)))
+		if expected, got := http.StatusTooManyRequests, w.Code; expected != got {
+			t.Errorf("Wanted status code %v with check %t, got %v.", expected, check, got)
+		}
+		if expected, got := "1", w.Header().Get("Retry-After"); expected != got {
+			t.Errorf("Wanted Retry-After %q with check %t, got %q.", expected, check, got)
+		}
+		if len(mms.writeRequests) != 0 {
+			t.Errorf("Unexpected write requests with check %t: %v", check, mms.writeRequests)
+		}
+	}
+
+	// Nothing is submitted once the client has gone away.
+	mms.submitErr = nil
+	handler := Push(&mms, false, true, false, nil, logger)
+	req, err := http.NewRequest("POST", "http://example.org/", bytes.NewBufferString("some_metric 3.14\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(ctxWithParams(params, req))
+	cancel()
+	handler(httptest.NewRecorder(), req.WithContext(ctx))
+	if len(mms.writeRequests) != 0 {
+		t.Errorf("Unexpected write requests after cancellation: %v", mms.writeRequests)
+	}
+
+	mms.submitErr = storage.ErrWriteQueueFull
+	req, err = http.NewRequest("DELETE", "http://example.org/", &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	Delete(&mms, false, nil, logger)(w, req.WithContext(ctxWithParams(params, req)))
+	if expected, got := http.StatusTooManyRequests, w.Code; expected != got {
+		t.Errorf("Wanted status code %v for delete, got %v.", expected, got)
+	}
+
+	// Deletions of stored groups and wiping are rejected in the same way.
+	mms.metricGroups = storage.GroupingKeyToMetricGroup{
+		storage.GroupingKeyFor(params): {Labels: params},
+	}
+	req, err = http.NewRequest("DELETE", "http://example.org/?relabel=false", &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	Delete(&mms, false, nil, logger)(w, req.WithContext(ctxWithParams(params, req)))
+	if expected, got := http.StatusTooManyRequests, w.Code; expected != got {
+		t.Errorf("Wanted status code %v for delete without relabeling, got %v.", expected, got)
+	}
+	if expected, got := "1", w.Header().Get("Retry-After"); expected != got {
+		t.Errorf("Wanted Retry-After %q for delete without relabeling, got %q.", expected, got)
+	}
+	req, err = http.NewRequest("PUT", "http://example.org/api/v1/admin/wipe", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	WipeMetricStore(&mms, nil, logger).ServeHTTP(w, req)
+	if expected, got := http.StatusTooManyRequests, w.Code; expected != got {
+		t.Errorf("Wanted status code %v for wipe, got %v.", expected, got)
+	}
+	if expected, got := "1", w.Header().Get("Retry-After"); expected != got {
+		t.Errorf("Wanted Retry-After %q for wipe, got %q.", expected, got)
+	}
+	if len(mms.deletedKeys) != 0 {
+		t.Errorf("Unexpected deleted groups: %v", mms.deletedKeys)
+	}
+
+	// Remote write and OTLP are rejected in the same way.
+	w = postRemoteWrite(t, RemoteWrite(&mms, true, []string{"job"}, nil, logger), "application/x-protobuf", &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "some_metric"}, {Name: "job", Value: "testjob"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+		}},
+	})
+	if expected, got := http.StatusTooManyRequests, w.Code; expected != got {
+		t.Errorf("Wanted status code %v for remote write, got %v.", expected, got)
+	}
+	if expected, got := "1", w.Header().Get("Retry-After"); expected != got {
+		t.Errorf("Wanted Retry-After %q for remote write, got %q.", expected, got)
+	}
+	body := `{"resourceMetrics":[{
+		"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"testjob"}}]},
+		"scopeMetrics":[{"metrics":[{"name":"some_metric","gauge":{"dataPoints":[{"asInt":"1"}]}}]}]
+	}]}`
+	w = postOTLP(t, OTLP(&mms, true, nil, logger), "application/json", []byte(body))
+	if expected, got := http.StatusTooManyRequests, w.Code; expected != got {
+		t.Errorf("Wanted status code %v for OTLP, got %v.", expected, got)
+	}
+	if expected, got := "1", w.Header().Get("Retry-After"); expected != got {
+		t.Errorf("Wanted Retry-After %q for OTLP, got %q.", expected, got)
+	}
+	if len(mms.writeRequests) != 0 {
+		t.Errorf("Unexpected write requests: %v", mms.writeRequests)
+	}
+}
+
+func TestPushUnchecked(t *testing.T) {
+	mms := MockMetricStore{}
+	params := map[string]string{
+		"job": "testjob",
+	}
+
+	// Unchecked pushes are only answered once the store has processed them.
+	handler := Push(&mms, false, false, false, nil, logger)
+	req, err := http.NewRequest("POST", "http://example.org/", bytes.NewBufferString("some_metric 3.14\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler(w, req.WithContext(ctxWithParams(params, req)))
+	if expected, got := http.StatusAccepted, w.Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+	if mms.lastWriteRequest.Done == nil || !mms.lastWriteRequest.Unchecked {
+		t.Errorf("Wanted an unchecked write request with Done channel, got %v.", mms.lastWriteRequest)
+	}
+
+	mms.err = errors.New("failed to log write request")
+	req, err = http.NewRequest("POST", "http://example.org/", bytes.NewBufferString("some_metric 3.14\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	handler(w, req.WithContext(ctxWithParams(params, req)))
+	if expected, got := http.StatusBadRequest, w.Code; expected != got {
+		t.Errorf("Wanted status code %v for failed push, got %v.", expected, got)
+	}
+
+	req, err = http.NewRequest("DELETE", "http://example.org/", &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	Delete(&mms, false, nil, logger)(w, req.WithContext(ctxWithParams(params, req)))
+	if expected, got := http.StatusInternalServerError, w.Code; expected != got {
+		t.Errorf("Wanted status code %v for failed delete, got %v.", expected, got)
+	}
+	if mms.lastWriteRequest.Done == nil {
+		t.Errorf("Wanted a delete request with Done channel, got %v.", mms.lastWriteRequest)
+	}
+}
+
+func TestPushProblems(t *testing.T) {
+	mms := MockMetricStore{err: storage.Problems{
+		{
+			Kind:         storage.ProblemTimestamp,
+			MetricFamily: "some_metric",
+			Labels:       map[string]string{"job": "testjob", "x": "y"},
+			Message:      "pushed metrics must not have timestamps: some series",
+		},
+		{
+			Kind:    storage.ProblemLimit,
+			Message: "limit exceeded",
+		},
+	}}
+	mmsWithErr := MockMetricStore{err: errors.New("testerror")}
+	params := map[string]string{
+		"job": "testjob",
+	}
+
+	scenarios := []struct {
+		name                string
+		ms                  *MockMetricStore
+		accept              string
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			name:                "text",
+			ms:                  &mms,
+			expectedContentType: "text/plain; charset=utf-8",
+			expectedBody: "pushed metrics are invalid or inconsistent with existing metrics: pushed metrics must not have timestamps: some series\n" +
+				"pushed metrics are invalid or inconsistent with existing metrics: limit exceeded\n",
+		},
+		{
+			name:                "json",
+			ms:                  &mms,
+			accept:              "text/html, application/json;q=0.9",
+			expectedContentType: "application/json",
+			expectedBody: `{"error":"pushed metrics are invalid or inconsistent with existing metrics","problems":[` +
+				`{"kind":"timestamp","metric_family":"some_metric","labels":{"job":"testjob","x":"y"},"message":"pushed metrics must not have timestamps: some series"},` +
+				`{"kind":"limit","message":"limit exceeded"}]}` + "\n",
+		},
+		{
+			name:                "other error as json",
+			ms:                  &mmsWithErr,
+			accept:              "application/json",
+			expectedContentType: "application/json",
+			expectedBody:        `{"error":"pushed metrics are invalid or inconsistent with existing metrics","problems":[{"kind":"other","message":"testerror"}]}` + "\n",
+		},
+	}
+	for _, s := range scenarios {
+		handler := Push(s.ms, false, true, false, nil, logger)
+		req, err := http.NewRequest("POST", "http://example.org/", bytes.NewBufferString("some_metric 3.14\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.accept != "" {
+			req.Header.Set("Accept", s.accept)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req.WithContext(ctxWithParams(params, req)))
+		if expected, got := http.StatusBadRequest, w.Code; expected != got {
+			t.Errorf("%s: Wanted status code %v, got %v.", s.name, expected, got)
+		}
+		if expected, got := s.expectedContentType, w.Header().Get("Content-Type"); expected != got {
+			t.Errorf("%s: Wanted content type %q, got %q.", s.name, expected, got)
+		}
+		if expected, got := s.expectedBody, w.Body.String(); expected != got {
+			t.Errorf("%s: Wanted body %q, got %q.", s.name, expected, got)
+		}
+	}
+}
+
+func TestPushIncrement(t *testing.T) {
+	mms := MockMetricStore{}
+	postHandler := Push(&mms, false, true, false, nil, logger)
+	putHandler := Push(&mms, true, true, false, nil, logger)
+
+	scenarios := []struct {
+		handler           func(http.ResponseWriter, *http.Request)
+		labels            string
+		expectedCode      int
+		expectedIncrement bool
+		expectedLabels    map[string]string
+	}{
+		{
+			handler:           postHandler,
+			labels:            "/increment",
+			expectedCode:      http.StatusOK,
+			expectedIncrement: true,
+			expectedLabels:    map[string]string{"job": "testjob"},
+		},
+		{
+			handler:           postHandler,
+			labels:            "/instance/testinstance/increment",
+			expectedCode:      http.StatusOK,
+			expectedIncrement: true,
+			expectedLabels:    map[string]string{"job": "testjob", "instance": "testinstance"},
+		},
+		{
+			// Here, "increment" is a label value.
+			handler:           postHandler,
+			labels:            "/instance/increment",
+			expectedCode:      http.StatusOK,
+			expectedIncrement: false,
+			expectedLabels:    map[string]string{"job": "testjob", "instance": "increment"},
+		},
+		{
+			handler:      putHandler,
+			labels:       "/increment",
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for i, s := range scenarios {
+		mms.lastWriteRequest = storage.WriteRequest{}
+		req, err := http.NewRequest("POST", "http://example.org/", bytes.NewBufferString("# TYPE some_metric counter\nsome_metric 3\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		params := map[string]string{
+			"job":    "testjob",
+			"labels": s.labels,
+		}
+		s.handler(w, req.WithContext(ctxWithParams(params, req)))
+		if expected, got := s.expectedCode, w.Code; expected != got {
+			t.Errorf("%d. Wanted status code %v, got %v.", i, expected, got)
+		}
+		if s.expectedCode != http.StatusOK {
+			if !mms.lastWriteRequest.Timestamp.IsZero() {
+				t.Errorf("%d. Write request timestamp unexpectedly set: %#v", i, mms.lastWriteRequest)
+			}
+			continue
+		}
+		if expected, got := s.expectedIncrement, mms.lastWriteRequest.Increment; expected != got {
+			t.Errorf("%d. Wanted increment %v, got %v.", i, expected, got)
+		}
+		if expected, got := len(s.expectedLabels), len(mms.lastWriteRequest.Labels); expected != got {
+			t.Errorf("%d. Wanted %d labels, got %d.", i, expected, got)
+		}
+		for ln, lv := range s.expectedLabels {
+			if got := mms.lastWriteRequest.Labels[ln]; got != lv {
+				t.Errorf("%d. Wanted label %s=%q, got %q.", i, ln, lv, got)
+			}
+		}
+	}
+}
+
+func TestPushOpenMetrics(t *testing.T) {
+	mms := MockMetricStore{}
+	handler := Push(&mms, false, true, false, nil, logger)
+	params := map[string]string{
+		"job": "testjob",
+	}
+	post := func(body, contentType string) *httptest.ResponseRecorder {
+		t.Helper()
+		mms.lastWriteRequest = storage.WriteRequest{}
+		req, err := http.NewRequest("POST", "http://example.org/", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		handler(w, req.WithContext(ctxWithParams(params, req)))
+		return w
+	}
+
+	w := post(`# TYPE request_duration_seconds histogram
+# UNIT request_duration_seconds seconds
+# HELP request_duration_seconds Duration of requests.
+request_duration_seconds_bucket{le="1.0"} 2 # {trace_id="abc"} 0.5 1520879607.789
+request_duration_seconds_bucket{le="+Inf"} 3
+request_duration_seconds_sum 4.5
+request_duration_seconds_count 3
+# TYPE requests counter
+# HELP requests Number of requests.
+requests_total{code="200"} 7 # {trace_id="def"} 1
+# TYPE build info
+build_info{version="1.2.3"} 1
+# EOF
+`, "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	if expected, got := http.StatusOK, w.Code; expected != got {
+		t.Fatalf("Wanted status code %v, got %v: %s", expected, got, w.Body.String())
+	}
+	mfs := mms.lastWriteRequest.MetricFamilies
+	if expected, got := 3, len(mfs); expected != got {
+		t.Errorf("Wanted %d metric families, got %d.", expected, got)
+	}
+	verifyMetricFamily(t, `
+name: "request_duration_seconds"
+help: "Duration of requests."
+type: HISTOGRAM
+unit: "seconds"
+metric: <
+  histogram: <
+    sample_count: 3
+    sample_sum: 4.5
+    bucket: <
+      cumulative_count: 2
+      upper_bound: 1
+      exemplar: <
+        label: <
+          name: "trace_id"
+          value: "abc"
+        >
+        value: 0.5
+        timestamp: <
+          seconds: 1520879607
+          nanos: 789000000
+        >
+      >
+    >
+  >
+>
+`, mfs["request_duration_seconds"])
+	verifyMetricFamily(t, `
+name: "requests_total"
+help: "Number of requests."
+type: COUNTER
+metric: <
+  label: <
+    name: "code"
+    value: "200"
+  >
+  counter: <
+    value: 7
+    exemplar: <
+      label: <
+        name: "trace_id"
+        value: "def"
+      >
+      value: 1
+    >
+  >
+>
+`, mfs["requests_total"])
+	verifyMetricFamily(t, `
+name: "build_info"
+type: GAUGE
+metric: <
+  label: <
+    name: "version"
+    value: "1.2.3"
+  >
+  gauge: <
+    value: 1
+  >
+>
+`, mfs["build_info"])
+
+	// Sample timestamps are passed on to the store, which rejects them.
+	w = post("# TYPE some_metric gauge\nsome_metric 3.14 1520879607.789\n# EOF\n", "application/openmetrics-text")
+	if expected, got := http.StatusOK, w.Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+	if expected, got := int64(1520879607789), mms.lastWriteRequest.MetricFamilies["some_metric"].GetMetric()[0].GetTimestampMs(); expected != got {
+		t.Errorf("Wanted timestamp %d, got %d.", expected, got)
+	}
+
+	// Created timestamps are rejected right away.
+	w = post("# TYPE requests counter\nrequests_total 7\nrequests_created 1520879607.789\n# EOF\n", "application/openmetrics-text")
+	if expected, got := http.StatusBadRequest, w.Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+	if expected, got := storage.ErrTimestamp.Error()+"\n", w.Body.String(); expected != got {
+		t.Errorf("Wanted body %q, got %q.", expected, got)
+	}
+	if !mms.lastWriteRequest.Timestamp.IsZero() {
+		t.Errorf("Write request timestamp unexpectedly set: %#v", mms.lastWriteRequest)
+	}
+
+	// OpenMetrics requires the EOF marker.
+	w = post("some_metric 3.14\n", "application/openmetrics-text")
+	if expected, got := http.StatusBadRequest, w.Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+
+	// Without an OpenMetrics content type, the text format is used.
+	w = post("some_metric 3.14\n", "text/plain; version=0.0.4")
+	if expected, got := http.StatusOK, w.Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+}
+
 func TestDelete(t *testing.T) {
 	mms := MockMetricStore{}
-	handler := Delete(&mms, false, logger)
-	handlerBase64 := Delete(&mms, true, logger)
-	req := &http.Request{}
+	handler := Delete(&mms, false, nil, logger)
+	handlerBase64 := Delete(&mms, true, nil, logger)
+	req := httptest.NewRequest("DELETE", "http://example.org/", nil)
 	var params map[string]string
 
 	// No job name.
@@ -719,9 +1325,9 @@ func TestDeleteUTF8(t *testing.T) {
 	ValidationScheme = model.UTF8Validation
 	EscapingScheme = model.ValueEncodingEscaping
 	mms := MockMetricStore{}
-	handler := Delete(&mms, false, logger)
-	handlerBase64 := Delete(&mms, true, logger)
-	req := &http.Request{}
+	handler := Delete(&mms, false, nil, logger)
+	handlerBase64 := Delete(&mms, true, nil, logger)
+	req := httptest.NewRequest("DELETE", "http://example.org/", nil)
 	var params map[string]string
 
 	// With job name, instance name and UTF-8 escaped label name.
@@ -921,8 +1527,8 @@ func TestSplitLabelsUTF8(t *testing.T) {
 
 func TestWipeMetricStore(t *testing.T) {
 	// Create MockMetricStore with a few GroupingKeyToMetricGroup metrics
-	// so they can be returned by GetMetricFamiliesMap() to later send write
-	// requests for each of them.
+	// so they can be returned by GetGroupSummaries() to later delete each
+	// of them by its key.
 	metricCount := 5
 	mgs := storage.GroupingKeyToMetricGroup{}
 	for i := range metricCount {
@@ -931,7 +1537,7 @@ func TestWipeMetricStore(t *testing.T) {
 	mms := MockMetricStore{metricGroups: mgs}
 
 	// Wipe handler should return 202 and delete all metrics.
-	wipeHandler := WipeMetricStore(&mms, logger)
+	wipeHandler := WipeMetricStore(&mms, nil, logger)
 	w := httptest.NewRecorder()
 	// Then handler is routed to the handler based on verb and path in main.go
 	// therefore (and for now) we use the request to only record the returned status code.
@@ -945,15 +1551,84 @@ func TestWipeMetricStore(t *testing.T) {
 		t.Errorf("status code should be %d", http.StatusAccepted)
 	}
 
-	if len(mms.writeRequests) != metricCount {
-		t.Errorf("there should be %d write requests, got %d instead", metricCount, len(mms.writeRequests))
+	if len(mms.deletedKeys) != metricCount {
+		t.Errorf("there should be %d deleted groups, got %d instead", metricCount, len(mms.deletedKeys))
+	}
+	if len(mms.writeRequests) != 0 {
+		t.Errorf("there should be no write requests, got %d instead", len(mms.writeRequests))
 	}
+}
 
-	// Were all the writeRequest deletes?.
-	for i, wr := range mms.writeRequests {
-		if wr.MetricFamilies != nil {
-			t.Errorf("writeRequest at index %d was not a delete request", i)
+func TestDeleteStoredGroupWithRelabeling(t *testing.T) {
+	// A rule that isn't idempotent: Relabeling the stored grouping labels
+	// again would target another group.
+	relabelConfigs := []*relabel.Config{{
+		SourceLabels:         model.LabelNames{"job"},
+		Regex:                relabel.MustNewRegexp("(.*)"),
+		TargetLabel:          "job",
+		Replacement:          "${1}-x",
+		Action:               relabel.Replace,
+		NameValidationScheme: model.UTF8Validation,
+	}}
+	ms := storage.NewDiskMetricStore("", 100*time.Millisecond, nil, logger, storage.WithRelabeling(relabelConfigs, nil))
+	defer ms.Shutdown()
+	push := func(job string) {
+		errCh := make(chan error, 1)
+		ms.SubmitWriteRequest(storage.WriteRequest{
+			Labels:         map[string]string{"job": job},
+			Timestamp:      time.Now(),
+			MetricFamilies: map[string]*dto.MetricFamily{},
+			Done:           errCh,
+		})
+		for err := range errCh {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+	groups := func() []string {
+		var jobs []string
+		for _, summary := range ms.GetGroupSummaries() {
+			jobs = append(jobs, summary.Labels["job"])
 		}
+		sort.Strings(jobs)
+		return jobs
+	}
+
+	// Push to groups stored as job="a-x" and job="a-x-x".
+	push("a")
+	push("a-x")
+	if expected, got := "[a-x a-x-x]", fmt.Sprint(groups()); expected != got {
+		t.Fatalf("Wanted groups %s, got %s.", expected, got)
+	}
+
+	// Deleting job="a-x" as stored must not delete job="a-x-x".
+	req := httptest.NewRequest("DELETE", "http://example.org/metrics/job/a-x?relabel=false", nil)
+	w := httptest.NewRecorder()
+	Delete(ms, false, nil, logger)(w, req.WithContext(ctxWithParams(map[string]string{"job": "a-x"}, req)))
+	if expected, got := http.StatusAccepted, w.Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+	if expected, got := "[a-x-x]", fmt.Sprint(groups()); expected != got {
+		t.Errorf("Wanted groups %s, got %s.", expected, got)
+	}
+
+	// A wipe deletes all groups rather than the relabeled ones.
+	push("b")
+	req = httptest.NewRequest("PUT", "http://example.org/api/v1/admin/wipe", nil)
+	w = httptest.NewRecorder()
+	WipeMetricStore(ms, nil, logger).ServeHTTP(w, req)
+	if expected, got := http.StatusAccepted, w.Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+	if got := groups(); len(got) != 0 {
+		t.Errorf("Wanted no groups after wipe, got %v.", got)
+	}
+
+	// An invalid relabel parameter is rejected.
+	req = httptest.NewRequest("DELETE", "http://example.org/metrics/job/a?relabel=maybe", nil)
+	w = httptest.NewRecorder()
+	Delete(ms, false, nil, logger)(w, req.WithContext(ctxWithParams(map[string]string{"job": "a"}, req)))
+	if expected, got := http.StatusBadRequest, w.Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
 	}
 }
 
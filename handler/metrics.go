@@ -45,6 +45,39 @@ var (
 		},
 		[]string{"method"},
 	)
+	httpAuthzRejections = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pushgateway_http_authorization_rejections_total",
+			Help: "Total push and delete requests to the Pushgateway rejected by the authorization rules.",
+		},
+		[]string{"method", "reason"},
+	)
+	remoteWriteDroppedSamples = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "pushgateway_remote_write_dropped_samples_total",
+			Help: "Total remote-written samples not stored because a later sample of the same series was in the same request or because they were staleness markers.",
+		},
+	)
+	remoteWriteDroppedTimestamps = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "pushgateway_remote_write_dropped_timestamps_total",
+			Help: "Total remote-written samples stored without their timestamp.",
+		},
+	)
+	clusterForwards = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pushgateway_cluster_forwarded_requests_total",
+			Help: "Total push and delete requests forwarded to the cluster member owning their metric group.",
+		},
+		[]string{"member"},
+	)
+	clusterForwardFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pushgateway_cluster_forward_failures_total",
+			Help: "Total push and delete requests that could not be forwarded to the cluster member owning their metric group.",
+		},
+		[]string{"member"},
+	)
 )
 
 func InstrumentWithCounter(handlerName string, handler http.Handler) http.HandlerFunc {
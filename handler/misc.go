@@ -14,14 +14,26 @@
 package handler
 
 import (
+	"errors"
 	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/prometheus/common/server"
 
 	"github.com/prometheus/pushgateway/storage"
 )
 
+// RestoreRetryAfter is the delay suggested to clients in the Retry-After
+// header of requests rejected by RejectDuringRestore.
+const RestoreRetryAfter = 5 * time.Second
+
+// WriteQueueRetryAfter is the delay suggested to clients in the Retry-After
+// header of requests rejected because the write queue is full.
+const WriteQueueRetryAfter = time.Second
+
 // Healthy is used to report the health of the Pushgateway. It currently only
 // uses the Healthy method of the MetricScore to detect healthy state.
 //
@@ -42,7 +54,8 @@ func Healthy(ms storage.MetricStore) http.Handler {
 
 // Ready is used to report if the Pushgateway is ready to process requests. It
 // currently only uses the Ready method of the MetricScore to detect ready
-// state.
+// state. While the persisted metrics are restored, it responds with
+// http.StatusServiceUnavailable and the progress of the restore.
 //
 // The returned handler is already instrumented for Prometheus.
 func Ready(ms storage.MetricStore) http.Handler {
@@ -50,15 +63,70 @@ func Ready(ms storage.MetricStore) http.Handler {
 		"ready",
 		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 			err := ms.Ready()
-			if err == nil {
+			switch {
+			case err == nil:
 				io.WriteString(w, "OK")
-			} else {
+			case errors.Is(err, storage.ErrRestoring):
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			default:
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 			}
 		}),
 	)
 }
 
+// RejectDuringRestore returns a handler that rejects requests with
+// http.StatusServiceUnavailable and a Retry-After header while the MetricStore
+// restores the persisted metrics. Otherwise, requests are passed on to next.
+// It is meant to be put in front of handlers changing the MetricStore, which
+// would otherwise have to wait for the restore to complete.
+func RejectDuringRestore(
+	ms storage.MetricStore,
+	next func(http.ResponseWriter, *http.Request),
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := ms.Ready(); errors.Is(err, storage.ErrRestoring) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(RestoreRetryAfter.Seconds())))
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// submitWriteRequest submits wr to ms without blocking. If the write queue is
+// full, the request is rejected with http.StatusTooManyRequests and a
+// Retry-After header. If the client has gone away in the meantime, nothing is
+// submitted. In both cases, false is returned, and the caller must not write
+// a response anymore.
+func submitWriteRequest(
+	w http.ResponseWriter, r *http.Request,
+	ms storage.MetricStore, wr storage.WriteRequest, logger *slog.Logger,
+) bool {
+	err := ms.TrySubmitWriteRequest(r.Context(), wr)
+	if err == nil {
+		return true
+	}
+	rejectWriteRequest(w, r, err, logger)
+	return false
+}
+
+// rejectWriteRequest handles the provided error returned when submitting a
+// write request to the MetricStore without blocking, i.e. by
+// TrySubmitWriteRequest, DeleteGroups, or DeleteGroupsByKey. If the write
+// queue is full, the request is rejected with http.StatusTooManyRequests and a
+// Retry-After header. Otherwise, the client has gone away, and no response is
+// written.
+func rejectWriteRequest(w http.ResponseWriter, r *http.Request, err error, logger *slog.Logger) {
+	if errors.Is(err, storage.ErrWriteQueueFull) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(WriteQueueRetryAfter.Seconds())))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		logger.Warn("write request rejected", "source", r.RemoteAddr, "err", err.Error())
+		return
+	}
+	logger.Debug("write request not submitted", "source", r.RemoteAddr, "err", err.Error())
+}
+
 // Static serves the static files from the provided http.FileSystem.
 //
 // The returned handler is already instrumented for Prometheus.
@@ -0,0 +1,108 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/metadata"
+	"github.com/prometheus/prometheus/model/textparse"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus/pushgateway/storage"
+)
+
+// openMetricsMediaType is the media type of the OpenMetrics text format.
+const openMetricsMediaType = "application/openmetrics-text"
+
+// parseOpenMetrics parses the provided OpenMetrics text format and converts it
+// into MetricFamilies. The UNIT and HELP metadata and the exemplars are
+// retained. Sample timestamps are kept in the MetricFamilies so that the
+// MetricStore rejects them like timestamps pushed in any other format. The
+// created timestamps exposed as _created series can't be represented that way
+// and are therefore rejected right away with storage.ErrTimestamp.
+func parseOpenMetrics(b []byte) (map[string]*dto.MetricFamily, error) {
+	var (
+		p              = textparse.NewOpenMetricsParser(b, labels.NewSymbolTable())
+		metadataByName = map[string]metadata.Metadata{}
+		g              = newSeriesGroup(nil)
+	)
+	for {
+		entry, err := p.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch entry {
+		case textparse.EntryType:
+			name, typ := p.Type()
+			md := metadataByName[string(name)]
+			md.Type = typ
+			metadataByName[string(name)] = md
+		case textparse.EntryHelp:
+			name, help := p.Help()
+			md := metadataByName[string(name)]
+			md.Help = string(help)
+			metadataByName[string(name)] = md
+		case textparse.EntryUnit:
+			name, unit := p.Unit()
+			md := metadataByName[string(name)]
+			md.Unit = string(unit)
+			metadataByName[string(name)] = md
+		case textparse.EntrySeries:
+			s := seriesSample{samples: 1}
+			_, s.timestamp, s.value = p.Series()
+			p.Labels(&s.labels)
+			if err := validateSeriesLabels(s.labels); err != nil {
+				return nil, err
+			}
+			name := s.labels.Get(model.MetricNameLabel)
+			if isCreatedSeries(metadataByName, name) {
+				return nil, storage.ErrTimestamp
+			}
+			s.metadata, _ = lookupMetadata(metadataByName, name)
+			var e exemplar.Exemplar
+			if p.Exemplar(&e) {
+				s.exemplar, s.exemplars = &e, 1
+			}
+			if err := g.add(s); err != nil {
+				return nil, err
+			}
+		}
+	}
+	g.finish()
+	return g.metricFamilies, nil
+}
+
+// isCreatedSeries returns whether the series with the provided name exposes
+// the created timestamp of a counter, summary, or histogram.
+func isCreatedSeries(metadataByName map[string]metadata.Metadata, name string) bool {
+	family, ok := strings.CutSuffix(name, "_created")
+	if !ok {
+		return false
+	}
+	switch metadataByName[family].Type {
+	case model.MetricTypeCounter, model.MetricTypeSummary, model.MetricTypeHistogram, model.MetricTypeGaugeHistogram:
+		return true
+	}
+	return false
+}
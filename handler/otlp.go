@@ -0,0 +1,637 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/otlptranslator"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus/pushgateway/storage"
+)
+
+const (
+	// Resource attributes mapped to grouping labels.
+	otlpServiceName       = "service.name"
+	otlpServiceInstanceID = "service.instance.id"
+
+	// Highest schema of native histograms. Exponential histograms with a
+	// higher scale are downscaled.
+	maxNativeHistogramSchema = 8
+	minNativeHistogramSchema = -4
+)
+
+// OTLP returns an http.Handler which accepts OTLP/HTTP metrics export requests
+// (binary protobuf or JSON encoded) and stores the contained metrics in the
+// MetricStore.
+//
+// The resource attribute service.name is used as the job label, and the
+// resource attribute service.instance.id, if present, as the instance label.
+// Other resource attributes are ignored. Per job and instance, the metric
+// families in the request replace the stored ones of the same name, as it is
+// the case for a push with the POST method.
+//
+// Sums with cumulative temporality become counters (if monotonic) or gauges
+// (otherwise), gauges become gauges, histograms with cumulative temporality
+// become classic histograms, exponential histograms with cumulative temporality
+// become native histograms, and summaries become summaries. Any other
+// temporality or metric type results in http.StatusBadRequest. The
+// Pushgateway doesn't store timestamps, so only the most recent data point per
+// attribute set is used, and its timestamp is dropped.
+//
+// If check is true, the resulting metrics are checked for consistency as for
+// regular pushes, and an inconsistent request is rejected with
+// http.StatusBadRequest. Either way, the request is only answered once all
+// metric groups have been processed.
+//
+// If the write queue for one of the metric groups is full, the request is
+// rejected with http.StatusTooManyRequests and a Retry-After header rather than
+// waiting for the queue to drain. The metric groups submitted before are still
+// changed, which is harmless as the sender retries the request as a whole.
+//
+// If a is not nil, the request has to be authorized to change each of the
+// metric groups it writes to (see AuthorizeGroups). Otherwise, it is rejected
+// as a whole with http.StatusForbidden.
+//
+// The returned handler is already instrumented for Prometheus.
+func OTLP(
+	ms storage.MetricStore,
+	check bool,
+	a Authorizer,
+	logger *slog.Logger,
+) func(http.ResponseWriter, *http.Request) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctMediatype, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || (ctMediatype != "application/x-protobuf" && ctMediatype != "application/json") {
+			http.Error(w, fmt.Sprintf("unsupported content type %q", r.Header.Get("Content-Type")), http.StatusUnsupportedMediaType)
+			logger.Debug("unsupported content type for OTLP", "content_type", r.Header.Get("Content-Type"))
+			return
+		}
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			logger.Debug("failed to read OTLP request", "source", r.RemoteAddr, "err", err.Error())
+			return
+		}
+		// An ExportMetricsServiceRequest has the same wire format and
+		// JSON representation as MetricsData. Using the latter avoids
+		// depending on the gRPC service definitions.
+		var req metricspb.MetricsData
+		if ctMediatype == "application/json" {
+			if b, err = otlpJSONToProtoJSON(b); err == nil {
+				err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(b, &req)
+			}
+		} else {
+			err = proto.Unmarshal(b, &req)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			logger.Debug("failed to decode OTLP request", "source", r.RemoteAddr, "err", err.Error())
+			return
+		}
+		groups, err := otlpGroups(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			logger.Debug("failed to convert OTLP request", "source", r.RemoteAddr, "err", err.Error())
+			return
+		}
+		authzLabels := make([]map[string]string, len(groups))
+		for i, g := range groups {
+			authzLabels[i] = g.labels
+		}
+		if _, err := AuthorizeGroups(a, ms, r, authzLabels); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			logger.Debug("OTLP request not authorized", "source", r.RemoteAddr, "err", err.Error())
+			return
+		}
+
+		now := time.Now()
+		var errChs []chan error
+		for _, g := range groups {
+			wr := storage.WriteRequest{
+				Labels:         g.labels,
+				Timestamp:      now,
+				MetricFamilies: g.metricFamilies,
+				Done:           make(chan error, 1),
+				Unchecked:      !check,
+			}
+			errChs = append(errChs, wr.Done)
+			if !submitWriteRequest(w, r, ms, wr, logger) {
+				return
+			}
+		}
+		var errs []error
+		for _, errCh := range errChs {
+			for err := range errCh {
+				logger.Error(
+					"OTLP metrics are invalid or inconsistent with existing metrics",
+					"source", r.RemoteAddr,
+					"err", err.Error(),
+				)
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) > 0 {
+			http.Error(
+				w,
+				fmt.Sprintf("OTLP metrics are invalid or inconsistent with existing metrics: %v", errors.Join(errs...)),
+				http.StatusBadRequest,
+			)
+			return
+		}
+		// Respond with an empty ExportMetricsServiceResponse.
+		w.Header().Set("Content-Type", ctMediatype)
+		w.WriteHeader(http.StatusOK)
+		if ctMediatype == "application/json" {
+			w.Write([]byte("{}"))
+		}
+	})
+
+	instrumentedHandler := promhttp.InstrumentHandlerRequestSize(
+		httpPushSize, promhttp.InstrumentHandlerDuration(
+			httpPushDuration, InstrumentWithCounter("otlp", handler),
+		))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		instrumentedHandler.ServeHTTP(w, r)
+	}
+}
+
+// otlpJSONToProtoJSON converts the JSON encoding of OTLP into the canonical
+// protobuf JSON encoding. The two only differ in the encoding of trace and
+// span IDs, which OTLP encodes as hex strings rather than base64.
+func otlpJSONToProtoJSON(b []byte) ([]byte, error) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	var convert func(v any) error
+	convert = func(v any) error {
+		switch v := v.(type) {
+		case map[string]any:
+			for key, value := range v {
+				s, ok := value.(string)
+				switch {
+				case ok && (key == "traceId" || key == "spanId" || key == "trace_id" || key == "span_id"):
+					id, err := hex.DecodeString(s)
+					if err != nil {
+						return fmt.Errorf("invalid %s %q: %w", key, s, err)
+					}
+					v[key] = base64.StdEncoding.EncodeToString(id)
+				default:
+					if err := convert(value); err != nil {
+						return err
+					}
+				}
+			}
+		case []any:
+			for _, value := range v {
+				if err := convert(value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := convert(v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// otlpGroup is a metric group assembled from OTLP metrics.
+type otlpGroup struct {
+	labels         map[string]string
+	metricFamilies map[string]*dto.MetricFamily
+	// Metrics keyed by family name and label set, with the timestamp of
+	// the data point they have been created from.
+	metrics map[string]*dto.Metric
+	times   map[string]uint64
+}
+
+// otlpConverter converts OTLP metrics into MetricFamilies.
+type otlpConverter struct {
+	metricNamer otlptranslator.MetricNamer
+	labelNamer  otlptranslator.LabelNamer
+}
+
+// otlpGroups converts the provided OTLP metrics into metric groups.
+func otlpGroups(req *metricspb.MetricsData) ([]*otlpGroup, error) {
+	utf8Allowed := ValidationScheme == model.UTF8Validation
+	c := otlpConverter{
+		metricNamer: otlptranslator.MetricNamer{WithMetricSuffixes: true, UTF8Allowed: utf8Allowed},
+		labelNamer:  otlptranslator.LabelNamer{UTF8Allowed: utf8Allowed},
+	}
+	groupsByKey := map[string]*otlpGroup{}
+	var groups []*otlpGroup
+	for _, rm := range req.GetResourceMetrics() {
+		groupLabels := map[string]string{}
+		for _, kv := range rm.GetResource().GetAttributes() {
+			switch kv.GetKey() {
+			case otlpServiceName:
+				groupLabels["job"] = anyValueString(kv.GetValue())
+			case otlpServiceInstanceID:
+				groupLabels["instance"] = anyValueString(kv.GetValue())
+			}
+		}
+		if groupLabels["job"] == "" {
+			return nil, fmt.Errorf("resource attribute %s is required", otlpServiceName)
+		}
+		key := groupLabels["job"] + "\xff" + groupLabels["instance"]
+		g, ok := groupsByKey[key]
+		if !ok {
+			g = &otlpGroup{
+				labels:         groupLabels,
+				metricFamilies: map[string]*dto.MetricFamily{},
+				metrics:        map[string]*dto.Metric{},
+				times:          map[string]uint64{},
+			}
+			groupsByKey[key] = g
+			groups = append(groups, g)
+		}
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				if err := c.addMetric(g, m); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return groups, nil
+}
+
+// otlpDataPoint is implemented by all OTLP data point types.
+type otlpDataPoint interface {
+	GetAttributes() []*commonpb.KeyValue
+	GetTimeUnixNano() uint64
+	GetFlags() uint32
+}
+
+// addMetric converts the provided OTLP metric and adds it to the group.
+func (c otlpConverter) addMetric(g *otlpGroup, m *metricspb.Metric) error {
+	var (
+		typ        dto.MetricType
+		namerType  otlptranslator.MetricType
+		dataPoints []otlpDataPoint
+		set        func(dst *dto.Metric, i int) error
+	)
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		typ, namerType = dto.MetricType_GAUGE, otlptranslator.MetricTypeGauge
+		for _, dp := range data.Gauge.GetDataPoints() {
+			dataPoints = append(dataPoints, dp)
+		}
+		set = func(dst *dto.Metric, i int) error {
+			dst.Gauge = &dto.Gauge{Value: proto.Float64(numberValue(data.Gauge.GetDataPoints()[i]))}
+			return nil
+		}
+	case *metricspb.Metric_Sum:
+		if t := data.Sum.GetAggregationTemporality(); t != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			return fmt.Errorf("sum %q has unsupported aggregation temporality %s", m.GetName(), t)
+		}
+		typ, namerType = dto.MetricType_GAUGE, otlptranslator.MetricTypeNonMonotonicCounter
+		if data.Sum.GetIsMonotonic() {
+			typ, namerType = dto.MetricType_COUNTER, otlptranslator.MetricTypeMonotonicCounter
+		}
+		for _, dp := range data.Sum.GetDataPoints() {
+			dataPoints = append(dataPoints, dp)
+		}
+		set = func(dst *dto.Metric, i int) error {
+			dp := data.Sum.GetDataPoints()[i]
+			if typ == dto.MetricType_GAUGE {
+				dst.Gauge = &dto.Gauge{Value: proto.Float64(numberValue(dp))}
+				return nil
+			}
+			dst.Counter = &dto.Counter{Value: proto.Float64(numberValue(dp))}
+			if exemplars := dp.GetExemplars(); len(exemplars) > 0 {
+				dst.Counter.Exemplar = c.exemplar(exemplars[len(exemplars)-1])
+			}
+			return nil
+		}
+	case *metricspb.Metric_Histogram:
+		if t := data.Histogram.GetAggregationTemporality(); t != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			return fmt.Errorf("histogram %q has unsupported aggregation temporality %s", m.GetName(), t)
+		}
+		typ, namerType = dto.MetricType_HISTOGRAM, otlptranslator.MetricTypeHistogram
+		for _, dp := range data.Histogram.GetDataPoints() {
+			dataPoints = append(dataPoints, dp)
+		}
+		set = func(dst *dto.Metric, i int) error {
+			h, err := c.classicHistogram(data.Histogram.GetDataPoints()[i])
+			if err != nil {
+				return fmt.Errorf("histogram %q: %w", m.GetName(), err)
+			}
+			dst.Histogram = h
+			return nil
+		}
+	case *metricspb.Metric_ExponentialHistogram:
+		if t := data.ExponentialHistogram.GetAggregationTemporality(); t != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			return fmt.Errorf("exponential histogram %q has unsupported aggregation temporality %s", m.GetName(), t)
+		}
+		typ, namerType = dto.MetricType_HISTOGRAM, otlptranslator.MetricTypeExponentialHistogram
+		for _, dp := range data.ExponentialHistogram.GetDataPoints() {
+			dataPoints = append(dataPoints, dp)
+		}
+		set = func(dst *dto.Metric, i int) error {
+			h, err := c.nativeHistogram(data.ExponentialHistogram.GetDataPoints()[i])
+			if err != nil {
+				return fmt.Errorf("exponential histogram %q: %w", m.GetName(), err)
+			}
+			dst.Histogram = h
+			return nil
+		}
+	case *metricspb.Metric_Summary:
+		typ, namerType = dto.MetricType_SUMMARY, otlptranslator.MetricTypeSummary
+		for _, dp := range data.Summary.GetDataPoints() {
+			dataPoints = append(dataPoints, dp)
+		}
+		set = func(dst *dto.Metric, i int) error {
+			dp := data.Summary.GetDataPoints()[i]
+			dst.Summary = &dto.Summary{
+				SampleCount: proto.Uint64(dp.GetCount()),
+				SampleSum:   proto.Float64(dp.GetSum()),
+			}
+			for _, q := range dp.GetQuantileValues() {
+				dst.Summary.Quantile = append(dst.Summary.Quantile, &dto.Quantile{
+					Quantile: proto.Float64(q.GetQuantile()),
+					Value:    proto.Float64(q.GetValue()),
+				})
+			}
+			sort.Slice(dst.Summary.Quantile, func(i, j int) bool {
+				return dst.Summary.Quantile[i].GetQuantile() < dst.Summary.Quantile[j].GetQuantile()
+			})
+			return nil
+		}
+	default:
+		return fmt.Errorf("metric %q has an unsupported type", m.GetName())
+	}
+
+	name, err := c.metricNamer.Build(otlptranslator.Metric{Name: m.GetName(), Unit: m.GetUnit(), Type: namerType})
+	if err != nil {
+		return err
+	}
+	mf, ok := g.metricFamilies[name]
+	if !ok {
+		mf = &dto.MetricFamily{Name: proto.String(name), Type: typ.Enum()}
+		if m.GetDescription() != "" {
+			mf.Help = proto.String(m.GetDescription())
+		}
+		g.metricFamilies[name] = mf
+	} else if mf.GetType() != typ {
+		return fmt.Errorf("metric %q is of type %s but has been converted to type %s before", name, typ, mf.GetType())
+	}
+
+	for i, dp := range dataPoints {
+		if dp.GetFlags()&uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) != 0 {
+			continue
+		}
+		labelPairs, err := c.labelPairs(dp.GetAttributes())
+		if err != nil {
+			return fmt.Errorf("metric %q: %w", name, err)
+		}
+		key := name
+		for _, lp := range labelPairs {
+			key += "\xff" + lp.GetName() + "\xff" + lp.GetValue()
+		}
+		metric, ok := g.metrics[key]
+		if !ok {
+			metric = &dto.Metric{Label: labelPairs}
+			g.metrics[key] = metric
+			mf.Metric = append(mf.Metric, metric)
+		} else if dp.GetTimeUnixNano() < g.times[key] {
+			continue // Only keep the most recent data point.
+		}
+		g.times[key] = dp.GetTimeUnixNano()
+		if err := set(metric, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// labelPairs converts the provided attributes into label pairs, sorted by
+// label name.
+func (c otlpConverter) labelPairs(attrs []*commonpb.KeyValue) ([]*dto.LabelPair, error) {
+	values := make(map[string]string, len(attrs))
+	for _, kv := range attrs {
+		name, err := c.labelNamer.Build(kv.GetKey())
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := values[name]; ok {
+			// Several attributes map to the same label name.
+			values[name] = v + ";" + anyValueString(kv.GetValue())
+			continue
+		}
+		values[name] = anyValueString(kv.GetValue())
+	}
+	labelPairs := make([]*dto.LabelPair, 0, len(values))
+	for name, value := range values {
+		labelPairs = append(labelPairs, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	}
+	sort.Slice(labelPairs, func(i, j int) bool { return labelPairs[i].GetName() < labelPairs[j].GetName() })
+	return labelPairs, nil
+}
+
+// classicHistogram converts an OTLP histogram data point into a classic
+// histogram.
+func (c otlpConverter) classicHistogram(dp *metricspb.HistogramDataPoint) (*dto.Histogram, error) {
+	bounds, counts := dp.GetExplicitBounds(), dp.GetBucketCounts()
+	if len(counts) > 0 && len(counts) != len(bounds)+1 {
+		return nil, fmt.Errorf("%d bucket counts do not match %d explicit bounds", len(counts), len(bounds))
+	}
+	h := &dto.Histogram{
+		SampleCount: proto.Uint64(dp.GetCount()),
+		SampleSum:   proto.Float64(dp.GetSum()),
+	}
+	var cumulative uint64
+	for i, bound := range bounds {
+		if len(counts) > 0 {
+			cumulative += counts[i]
+		}
+		h.Bucket = append(h.Bucket, &dto.Bucket{
+			UpperBound:      proto.Float64(bound),
+			CumulativeCount: proto.Uint64(cumulative),
+		})
+	}
+	for _, e := range dp.GetExemplars() {
+		v := exemplarValue(e)
+		for _, b := range h.Bucket {
+			if v <= b.GetUpperBound() {
+				b.Exemplar = c.exemplar(e)
+				break
+			}
+		}
+	}
+	return h, nil
+}
+
+// nativeHistogram converts an OTLP exponential histogram data point into a
+// native histogram.
+func (c otlpConverter) nativeHistogram(dp *metricspb.ExponentialHistogramDataPoint) (*dto.Histogram, error) {
+	scale := dp.GetScale()
+	if scale < minNativeHistogramSchema {
+		return nil, fmt.Errorf("scale %d is lower than the lowest supported scale %d", scale, minNativeHistogramSchema)
+	}
+	var downscale int32
+	if scale > maxNativeHistogramSchema {
+		downscale = scale - maxNativeHistogramSchema
+		scale = maxNativeHistogramSchema
+	}
+	h := &dto.Histogram{
+		SampleCount:   proto.Uint64(dp.GetCount()),
+		SampleSum:     proto.Float64(dp.GetSum()),
+		Schema:        proto.Int32(scale),
+		ZeroThreshold: proto.Float64(dp.GetZeroThreshold()),
+		ZeroCount:     proto.Uint64(dp.GetZeroCount()),
+	}
+	h.PositiveSpan, h.PositiveDelta = exponentialBuckets(dp.GetPositive(), downscale)
+	h.NegativeSpan, h.NegativeDelta = exponentialBuckets(dp.GetNegative(), downscale)
+	if len(h.PositiveSpan) == 0 && len(h.NegativeSpan) == 0 && dp.GetZeroCount() == 0 {
+		// An empty span marks an empty native histogram as such.
+		h.PositiveSpan = []*dto.BucketSpan{{Offset: proto.Int32(0), Length: proto.Uint32(0)}}
+	}
+	for _, e := range dp.GetExemplars() {
+		h.Exemplars = append(h.Exemplars, c.exemplar(e))
+	}
+	return h, nil
+}
+
+// exponentialBuckets converts OTLP exponential buckets into a single span and
+// the delta-encoded counts of a native histogram, reducing the resolution by
+// the provided number of scales. (OTLP bucket index i covers the range
+// (base^i, base^(i+1)], while native histogram bucket index i covers
+// (base^(i-1), base^i].)
+func exponentialBuckets(b *metricspb.ExponentialHistogramDataPoint_Buckets, downscale int32) ([]*dto.BucketSpan, []int64) {
+	counts := b.GetBucketCounts()
+	if len(counts) == 0 {
+		return nil, nil
+	}
+	first := b.GetOffset() >> downscale
+	var merged []uint64
+	for i, count := range counts {
+		idx := int((b.GetOffset()+int32(i))>>downscale - first)
+		for len(merged) <= idx {
+			merged = append(merged, 0)
+		}
+		merged[idx] += count
+	}
+	// Trim empty buckets at both ends.
+	for len(merged) > 0 && merged[0] == 0 {
+		merged = merged[1:]
+		first++
+	}
+	for len(merged) > 0 && merged[len(merged)-1] == 0 {
+		merged = merged[:len(merged)-1]
+	}
+	if len(merged) == 0 {
+		return nil, nil
+	}
+	deltas := make([]int64, len(merged))
+	var prev int64
+	for i, count := range merged {
+		deltas[i] = int64(count) - prev
+		prev = int64(count)
+	}
+	return []*dto.BucketSpan{{Offset: proto.Int32(first + 1), Length: proto.Uint32(uint32(len(merged)))}}, deltas
+}
+
+// exemplar converts an OTLP exemplar. The trace and span IDs, if present,
+// become the trace_id and span_id labels.
+func (c otlpConverter) exemplar(e *metricspb.Exemplar) *dto.Exemplar {
+	result := &dto.Exemplar{Value: proto.Float64(exemplarValue(e))}
+	if e.GetTimeUnixNano() != 0 {
+		result.Timestamp = timestamppb.New(time.Unix(0, int64(e.GetTimeUnixNano())))
+	}
+	labelPairs, err := c.labelPairs(e.GetFilteredAttributes())
+	if err == nil {
+		result.Label = labelPairs
+	}
+	if len(e.GetTraceId()) > 0 {
+		result.Label = append(result.Label, &dto.LabelPair{Name: proto.String("trace_id"), Value: proto.String(hex.EncodeToString(e.GetTraceId()))})
+	}
+	if len(e.GetSpanId()) > 0 {
+		result.Label = append(result.Label, &dto.LabelPair{Name: proto.String("span_id"), Value: proto.String(hex.EncodeToString(e.GetSpanId()))})
+	}
+	return result
+}
+
+func numberValue(dp *metricspb.NumberDataPoint) float64 {
+	if v, ok := dp.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+		return float64(v.AsInt)
+	}
+	return dp.GetAsDouble()
+}
+
+func exemplarValue(e *metricspb.Exemplar) float64 {
+	if v, ok := e.GetValue().(*metricspb.Exemplar_AsInt); ok {
+		return float64(v.AsInt)
+	}
+	return e.GetAsDouble()
+}
+
+// anyValueString returns the string representation of an attribute value.
+// Arrays and maps are represented as JSON.
+func anyValueString(v *commonpb.AnyValue) string {
+	switch v := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(v.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(v.DoubleValue, 'g', -1, 64)
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		values := make([]any, 0, len(v.ArrayValue.GetValues()))
+		for _, av := range v.ArrayValue.GetValues() {
+			values = append(values, anyValueString(av))
+		}
+		b, _ := json.Marshal(values)
+		return string(b)
+	case *commonpb.AnyValue_KvlistValue:
+		values := make(map[string]string, len(v.KvlistValue.GetValues()))
+		for _, kv := range v.KvlistValue.GetValues() {
+			values[kv.GetKey()] = anyValueString(kv.GetValue())
+		}
+		b, _ := json.Marshal(values)
+		return string(b)
+	}
+	return ""
+}
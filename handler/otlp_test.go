@@ -0,0 +1,289 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func postOTLP(t *testing.T, handler func(http.ResponseWriter, *http.Request), contentType string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req, err := http.NewRequest("POST", "http://example.org/otlp/v1/metrics", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func stringAttr(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func otlpRequest(resourceAttrs []*commonpb.KeyValue, metrics ...*metricspb.Metric) *metricspb.MetricsData {
+	return &metricspb.MetricsData{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource:     &resourcepb.Resource{Attributes: resourceAttrs},
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+	}}}
+}
+
+func TestOTLPProtobuf(t *testing.T) {
+	mms := MockMetricStore{}
+	handler := OTLP(&mms, true, nil, logger)
+
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	req := otlpRequest(
+		[]*commonpb.KeyValue{stringAttr("service.name", "batch"), stringAttr("service.instance.id", "host1"), stringAttr("host.arch", "amd64")},
+		&metricspb.Metric{
+			Name:        "jobs.processed",
+			Description: "Jobs processed.",
+			Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				AggregationTemporality: cumulative,
+				IsMonotonic:            true,
+				DataPoints: []*metricspb.NumberDataPoint{
+					{Attributes: []*commonpb.KeyValue{stringAttr("job.kind", "nightly")}, TimeUnixNano: 2000, Value: &metricspb.NumberDataPoint_AsInt{AsInt: 42}},
+					// Older data point for the same attributes, ignored.
+					{Attributes: []*commonpb.KeyValue{stringAttr("job.kind", "nightly")}, TimeUnixNano: 1000, Value: &metricspb.NumberDataPoint_AsInt{AsInt: 41}},
+				},
+			}},
+		},
+		&metricspb.Metric{
+			Name: "queue.size",
+			Unit: "By",
+			Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+				DataPoints: []*metricspb.NumberDataPoint{{TimeUnixNano: 1000, Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 1024}}},
+			}},
+		},
+		&metricspb.Metric{
+			Name: "job.duration",
+			Unit: "s",
+			Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+				AggregationTemporality: cumulative,
+				DataPoints: []*metricspb.HistogramDataPoint{{
+					TimeUnixNano:   1000,
+					Count:          6,
+					Sum:            proto.Float64(20),
+					ExplicitBounds: []float64{1, 5},
+					BucketCounts:   []uint64{1, 3, 2},
+				}},
+			}},
+		},
+		&metricspb.Metric{
+			Name: "job.size",
+			Unit: "By",
+			Data: &metricspb.Metric_ExponentialHistogram{ExponentialHistogram: &metricspb.ExponentialHistogram{
+				AggregationTemporality: cumulative,
+				DataPoints: []*metricspb.ExponentialHistogramDataPoint{{
+					TimeUnixNano: 1000,
+					Count:        7,
+					Sum:          proto.Float64(100),
+					Scale:        10, // Will be downscaled to 8.
+					ZeroCount:    1,
+					Positive: &metricspb.ExponentialHistogramDataPoint_Buckets{
+						Offset:       -1,
+						BucketCounts: []uint64{1, 1, 2, 2},
+					},
+				}},
+			}},
+		},
+		&metricspb.Metric{
+			Name: "rpc.latency",
+			Unit: "ms",
+			Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{
+				DataPoints: []*metricspb.SummaryDataPoint{{
+					TimeUnixNano: 1000,
+					Count:        3,
+					Sum:          30,
+					QuantileValues: []*metricspb.SummaryDataPoint_ValueAtQuantile{
+						{Quantile: 0.99, Value: 20},
+						{Quantile: 0.5, Value: 8},
+					},
+				}},
+			}},
+		},
+	)
+	b, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := postOTLP(t, handler, "application/x-protobuf", b)
+	if expected, got := http.StatusOK, w.Code; expected != got {
+		t.Fatalf("Wanted status code %v, got %v: %s", expected, got, w.Body.String())
+	}
+	if expected, got := "application/x-protobuf", w.Header().Get("Content-Type"); expected != got {
+		t.Errorf("Wanted content type %q, got %q.", expected, got)
+	}
+	wr := mms.lastWriteRequest
+	if expected, got := 2, len(wr.Labels); expected != got {
+		t.Errorf("Wanted %d grouping labels, got %v.", expected, wr.Labels)
+	}
+	if expected, got := "batch", wr.Labels["job"]; expected != got {
+		t.Errorf("Wanted job %q, got %q.", expected, got)
+	}
+	if expected, got := "host1", wr.Labels["instance"]; expected != got {
+		t.Errorf("Wanted instance %q, got %q.", expected, got)
+	}
+	if wr.Replace || wr.Merge || wr.Increment {
+		t.Errorf("Wanted a plain POST write request, got %#v.", wr)
+	}
+	if expected, got := 5, len(wr.MetricFamilies); expected != got {
+		t.Errorf("Wanted %d metric families, got %d.", expected, got)
+	}
+	verifyMetricFamily(t, `name:"jobs_processed_total" help:"Jobs processed." type:COUNTER metric:{
+		label:{name:"job_kind" value:"nightly"} counter:{value:42}}`,
+		wr.MetricFamilies["jobs_processed_total"])
+	verifyMetricFamily(t, `name:"queue_size_bytes" type:GAUGE metric:{gauge:{value:1024}}`,
+		wr.MetricFamilies["queue_size_bytes"])
+	verifyMetricFamily(t, `name:"job_duration_seconds" type:HISTOGRAM metric:{histogram:{
+		sample_count:6 sample_sum:20
+		bucket:{cumulative_count:1 upper_bound:1} bucket:{cumulative_count:4 upper_bound:5}}}`,
+		wr.MetricFamilies["job_duration_seconds"])
+	// OTLP buckets -1..2 at scale 10 are buckets -1..0 at scale 8, i.e.
+	// native histogram buckets 0..1.
+	verifyMetricFamily(t, `name:"job_size_bytes" type:HISTOGRAM metric:{histogram:{
+		sample_count:7 sample_sum:100 schema:8 zero_threshold:0 zero_count:1
+		positive_span:{offset:0 length:2} positive_delta:1 positive_delta:4}}`,
+		wr.MetricFamilies["job_size_bytes"])
+	verifyMetricFamily(t, `name:"rpc_latency_milliseconds" type:SUMMARY metric:{summary:{
+		sample_count:3 sample_sum:30 quantile:{quantile:0.5 value:8} quantile:{quantile:0.99 value:20}}}`,
+		wr.MetricFamilies["rpc_latency_milliseconds"])
+}
+
+func TestOTLPJSON(t *testing.T) {
+	mms := MockMetricStore{}
+	handler := OTLP(&mms, true, nil, logger)
+
+	body := `{"resourceMetrics":[{
+		"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"batch"}}]},
+		"scopeMetrics":[{"metrics":[{
+			"name":"jobs.processed",
+			"sum":{
+				"aggregationTemporality":2,
+				"isMonotonic":true,
+				"dataPoints":[{
+					"timeUnixNano":"1000",
+					"asInt":"3",
+					"exemplars":[{
+						"timeUnixNano":"1000000000",
+						"asInt":"1",
+						"traceId":"5b8efff798038103d269b633813fc60c",
+						"spanId":"eee19b7ec3c1b174"
+					}]
+				}]
+			}
+		}]}]
+	}]}`
+	w := postOTLP(t, handler, "application/json", []byte(body))
+	if expected, got := http.StatusOK, w.Code; expected != got {
+		t.Fatalf("Wanted status code %v, got %v: %s", expected, got, w.Body.String())
+	}
+	if expected, got := "{}", w.Body.String(); expected != got {
+		t.Errorf("Wanted body %q, got %q.", expected, got)
+	}
+	if expected, got := map[string]string{"job": "batch"}, mms.lastWriteRequest.Labels; len(got) != 1 || got["job"] != expected["job"] {
+		t.Errorf("Wanted grouping labels %v, got %v.", expected, got)
+	}
+	verifyMetricFamily(t, `name:"jobs_processed_total" type:COUNTER metric:{counter:{value:3 exemplar:{
+		label:{name:"trace_id" value:"5b8efff798038103d269b633813fc60c"} label:{name:"span_id" value:"eee19b7ec3c1b174"}
+		value:1 timestamp:{seconds:1}}}}`,
+		mms.lastWriteRequest.MetricFamilies["jobs_processed_total"])
+}
+
+func TestOTLPErrors(t *testing.T) {
+	serviceAttrs := []*commonpb.KeyValue{stringAttr("service.name", "batch")}
+	sum := func(temporality metricspb.AggregationTemporality) *metricspb.Metric {
+		return &metricspb.Metric{
+			Name: "jobs.processed",
+			Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				AggregationTemporality: temporality,
+				IsMonotonic:            true,
+				DataPoints:             []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsInt{AsInt: 1}}},
+			}},
+		}
+	}
+
+	scenarios := []struct {
+		name         string
+		contentType  string
+		req          *metricspb.MetricsData
+		expectedCode int
+	}{
+		{
+			name:         "valid",
+			contentType:  "application/x-protobuf",
+			req:          otlpRequest(serviceAttrs, sum(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE)),
+			expectedCode: http.StatusOK,
+		},
+		{
+			name:         "delta temporality",
+			contentType:  "application/x-protobuf",
+			req:          otlpRequest(serviceAttrs, sum(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA)),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:        "delta histogram",
+			contentType: "application/x-protobuf",
+			req: otlpRequest(serviceAttrs, &metricspb.Metric{
+				Name: "job.duration",
+				Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+					AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+				}},
+			}),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "unsupported type",
+			contentType:  "application/x-protobuf",
+			req:          otlpRequest(serviceAttrs, &metricspb.Metric{Name: "empty"}),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "no service name",
+			contentType:  "application/x-protobuf",
+			req:          otlpRequest(nil, sum(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE)),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "wrong content type",
+			contentType:  "text/plain",
+			req:          otlpRequest(serviceAttrs, sum(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE)),
+			expectedCode: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, s := range scenarios {
+		mms := MockMetricStore{}
+		b, err := proto.Marshal(s.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := postOTLP(t, OTLP(&mms, true, nil, logger), s.contentType, b)
+		if expected, got := s.expectedCode, w.Code; expected != got {
+			t.Errorf("%s: Wanted status code %v, got %v.", s.name, expected, got)
+		}
+		if s.expectedCode != http.StatusOK && len(mms.writeRequests) != 0 {
+			t.Errorf("%s: Unexpected write requests: %v", s.name, mms.writeRequests)
+		}
+	}
+}
@@ -16,11 +16,14 @@ package handler
 import (
 	"bufio"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"mime"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -32,6 +35,7 @@ import (
 
 	dto "github.com/prometheus/client_model/go"
 
+	"github.com/prometheus/pushgateway/audit"
 	"github.com/prometheus/pushgateway/storage"
 )
 
@@ -39,6 +43,13 @@ const (
 	// Base64Suffix is appended to a label name in the request URL path to
 	// mark the following label value as base64 encoded.
 	Base64Suffix = "@base64"
+	// TTLHeader is the name of the request header that sets the TTL of the
+	// pushed metric group, overriding the default TTL. Its value is a
+	// duration in the format used by Prometheus, e.g. "90s" or "1h".
+	TTLHeader = "Pushgateway-TTL"
+	// IncrementSuffix is appended to the request URL path of a POST request
+	// to request incrementing stored values rather than replacing them.
+	IncrementSuffix = "/increment"
 )
 
 var (
@@ -53,15 +64,36 @@ var (
 // given by the request are deleted before new ones are stored. If check is
 // true, the pushed metrics are immediately checked for consistency (with
 // existing metrics and themselves), and an inconsistent push is rejected with
-// http.StatusBadRequest.
+// http.StatusBadRequest. Otherwise, a push is answered with
+// http.StatusAccepted, but still only once it has been processed so that an
+// acknowledged push is recorded in the write-ahead log of ms (if any).
+//
+// The format of the pushed metrics is selected by the Content-Type header of
+// the request: the delimited protobuf format, the OpenMetrics text format
+// (media type application/openmetrics-text), or, as a fallback for any other
+// content type, the Prometheus text format version 0.0.4.
+//
+// If the request URL path ends with IncrementSuffix (which is only allowed if
+// replace is false), the pushed values are added to the values already stored
+// (see storage.WriteRequest for details).
+//
+// If the write queue of ms is full, the push is rejected with
+// http.StatusTooManyRequests and a Retry-After header rather than waiting for
+// the queue to drain.
+//
+// Each request is recorded in auditLog, which may be nil.
 //
 // The returned handler is already instrumented for Prometheus.
 func Push(
 	ms storage.MetricStore,
 	replace, check, jobBase64Encoded bool,
+	auditLog *audit.Log,
 	logger *slog.Logger,
 ) func(http.ResponseWriter, *http.Request) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry, w, finish := StartAudit(auditLog, audit.OperationPush, w, r)
+		defer finish()
+
 		job := route.Param(r.Context(), "job")
 		if jobBase64Encoded {
 			var err error
@@ -71,7 +103,12 @@ func Push(
 				return
 			}
 		}
-		labelsString := route.Param(r.Context(), "labels")
+		labelsString, increment := trimIncrementSuffix(route.Param(r.Context(), "labels"))
+		if increment && replace {
+			http.Error(w, "increment is only supported with POST", http.StatusMethodNotAllowed)
+			logger.Debug("increment requested with PUT")
+			return
+		}
 		labels, err := splitLabels(labelsString)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
@@ -84,78 +121,61 @@ func Push(
 			return
 		}
 		labels["job"] = job
+		entry.Labels = labels
 
-		var metricFamilies map[string]*dto.MetricFamily
-		ctMediatype, ctParams, ctErr := mime.ParseMediaType(r.Header.Get("Content-Type"))
-		if ctErr == nil && ctMediatype == "application/vnd.google.protobuf" &&
-			ctParams["encoding"] == "delimited" &&
-			ctParams["proto"] == "io.prometheus.client.MetricFamily" {
-			metricFamilies = map[string]*dto.MetricFamily{}
-			unmarshaler := protodelim.UnmarshalOptions{
-				MaxSize: -1,
-			}
-			in := bufio.NewReader(r.Body)
-			for {
-				mf := &dto.MetricFamily{}
-				if err = unmarshaler.UnmarshalFrom(in, mf); err != nil {
-					if err == io.EOF {
-						err = nil
-					}
-					break
-				}
-				metricFamilies[mf.GetName()] = mf
+		var ttl time.Duration
+		if ttlString := r.Header.Get(TTLHeader); ttlString != "" {
+			d, err := model.ParseDuration(ttlString)
+			if err != nil || d <= 0 {
+				http.Error(w, fmt.Sprintf("invalid %s header %q, must be a positive duration", TTLHeader, ttlString), http.StatusBadRequest)
+				logger.Debug("invalid TTL header", "ttl", ttlString)
+				return
 			}
-		} else {
-			// We could do further content-type checks here, but the
-			// fallback for now will anyway be the text format
-			// version 0.0.4, so just go for it and see if it works.
-			parser := expfmt.NewTextParser(ValidationScheme)
-			metricFamilies, err = parser.TextToMetricFamilies(r.Body)
+			ttl = time.Duration(d)
 		}
+
+		metricFamilies, err := ParseMetricFamilies(r)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			logger.Debug("failed to parse text", "source", r.RemoteAddr, "err", err.Error())
 			return
 		}
-		now := time.Now()
-		if !check {
-			ms.SubmitWriteRequest(storage.WriteRequest{
-				Labels:         labels,
-				Timestamp:      now,
-				MetricFamilies: metricFamilies,
-				Replace:        replace,
-			})
-			w.WriteHeader(http.StatusAccepted)
-			return
+		if auditLog != nil {
+			entry.MetricFamilies = slices.Sorted(maps.Keys(metricFamilies))
+			for _, mf := range metricFamilies {
+				entry.Series += len(mf.GetMetric())
+			}
 		}
-		errCh := make(chan error, 1)
-		errReceived := false
-		ms.SubmitWriteRequest(storage.WriteRequest{
+		now := time.Now()
+		wr := storage.WriteRequest{
 			Labels:         labels,
 			Timestamp:      now,
 			MetricFamilies: metricFamilies,
 			Replace:        replace,
-			Done:           errCh,
-		})
+			Increment:      increment,
+			TTL:            ttl,
+			Unchecked:      !check,
+		}
+		// Even without the consistency check, only respond once the
+		// push has been processed and logged to the write-ahead log.
+		errCh := make(chan error, 1)
+		wr.Done = errCh
+		if !submitWriteRequest(w, r, ms, wr, logger) {
+			return
+		}
+		failed := false
 		for err := range errCh {
-			// Send only first error via HTTP, but log all of them.
-			// TODO(beorn): Consider sending all errors once we
-			// have a use case. (Currently, at most one error is
-			// produced.)
-			if !errReceived {
-				http.Error(
-					w,
-					fmt.Sprintf("pushed metrics are invalid or inconsistent with existing metrics: %v", err),
-					http.StatusBadRequest,
-				)
-			}
+			failed = true
 			logger.Error(
-				"pushed metrics are invalid or inconsistent with existing metrics",
+				invalidPushMessage,
 				"method", r.Method,
 				"source", r.RemoteAddr,
 				"err", err.Error(),
 			)
-			errReceived = true
+			writeProblems(w, r, storage.AsProblems(err), logger)
+		}
+		if !failed && !check {
+			w.WriteHeader(http.StatusAccepted)
 		}
 	})
 
@@ -169,6 +189,108 @@ func Push(
 	}
 }
 
+// invalidPushMessage precedes each problem of a push rejected by the
+// consistency check.
+const invalidPushMessage = "pushed metrics are invalid or inconsistent with existing metrics"
+
+type pushProblem struct {
+	Kind         storage.ProblemKind `json:"kind"`
+	MetricFamily string              `json:"metric_family,omitempty"`
+	Labels       map[string]string   `json:"labels,omitempty"`
+	Message      string              `json:"message"`
+}
+
+type pushProblems struct {
+	Error    string        `json:"error"`
+	Problems []pushProblem `json:"problems"`
+}
+
+// writeProblems responds to a push rejected because of the provided Problems
+// with http.StatusBadRequest. If the request accepts application/json, the
+// response body is a JSON object listing the Problems with the metric family,
+// the series labels, and the message of each. Otherwise, it is plain text with
+// one line per Problem.
+func writeProblems(w http.ResponseWriter, r *http.Request, problems storage.Problems, logger *slog.Logger) {
+	if !acceptsJSON(r) {
+		lines := make([]string, len(problems))
+		for i, p := range problems {
+			lines[i] = fmt.Sprintf("%s: %s", invalidPushMessage, p)
+		}
+		http.Error(w, strings.Join(lines, "\n"), http.StatusBadRequest)
+		return
+	}
+	res := pushProblems{Error: invalidPushMessage, Problems: make([]pushProblem, len(problems))}
+	for i, p := range problems {
+		res.Problems[i] = pushProblem{
+			Kind:         p.Kind,
+			MetricFamily: p.MetricFamily,
+			Labels:       p.Labels,
+			Message:      p.Message,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusBadRequest)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger.Error("failed to write data to connection", "err", err)
+	}
+}
+
+// acceptsJSON returns whether application/json is one of the media ranges in
+// the Accept header of the provided request.
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			if mediatype, _, err := mime.ParseMediaType(mediaRange); err == nil && mediatype == "application/json" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseMetricFamilies parses the body of the provided push request in the
+// format selected by its Content-Type header, as described for Push.
+func ParseMetricFamilies(r *http.Request) (map[string]*dto.MetricFamily, error) {
+	var (
+		metricFamilies map[string]*dto.MetricFamily
+		err            error
+	)
+	ctMediatype, ctParams, ctErr := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case ctErr == nil && ctMediatype == "application/vnd.google.protobuf" &&
+		ctParams["encoding"] == "delimited" &&
+		ctParams["proto"] == "io.prometheus.client.MetricFamily":
+		metricFamilies = map[string]*dto.MetricFamily{}
+		unmarshaler := protodelim.UnmarshalOptions{
+			MaxSize: -1,
+		}
+		in := bufio.NewReader(r.Body)
+		for {
+			mf := &dto.MetricFamily{}
+			if err = unmarshaler.UnmarshalFrom(in, mf); err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				break
+			}
+			metricFamilies[mf.GetName()] = mf
+		}
+	case ctErr == nil && ctMediatype == openMetricsMediaType:
+		var body []byte
+		if body, err = io.ReadAll(r.Body); err == nil {
+			metricFamilies, err = parseOpenMetrics(body)
+		}
+	default:
+		// We could do further content-type checks here, but the
+		// fallback for now will anyway be the text format
+		// version 0.0.4, so just go for it and see if it works.
+		parser := expfmt.NewTextParser(ValidationScheme)
+		metricFamilies, err = parser.TextToMetricFamilies(r.Body)
+	}
+	return metricFamilies, err
+}
+
 // decodeBase64 decodes the provided string using the “Base 64 Encoding with URL
 // and Filename Safe Alphabet” (RFC 4648). Padding characters (i.e. trailing
 // '=') are ignored.
@@ -177,6 +299,19 @@ func decodeBase64(s string) (string, error) {
 	return string(b), err
 }
 
+// trimIncrementSuffix removes IncrementSuffix from the provided labels string
+// if it is present as an additional path component after the label pairs. The
+// returned bool reports whether the suffix was removed. (If the number of path
+// components is even, a trailing "increment" is a label value rather than the
+// suffix.)
+func trimIncrementSuffix(labels string) (string, bool) {
+	trimmed, found := strings.CutSuffix(labels, IncrementSuffix)
+	if !found || strings.Count(trimmed, "/")%2 != 0 {
+		return labels, false
+	}
+	return trimmed, true
+}
+
 // splitLabels splits a labels string into a label map mapping names to values.
 func splitLabels(labels string) (map[string]string, error) {
 	result := map[string]string{}
@@ -0,0 +1,379 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/metadata"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+
+	"github.com/prometheus/pushgateway/storage"
+)
+
+const (
+	remoteWriteProtoV1 = "prometheus.WriteRequest"
+	remoteWriteProtoV2 = "io.prometheus.write.v2.Request"
+
+	// Response headers of remote-write 2.0.
+	remoteWriteSamplesWrittenHeader    = "X-Prometheus-Remote-Write-Samples-Written"
+	remoteWriteHistogramsWrittenHeader = "X-Prometheus-Remote-Write-Histograms-Written"
+	remoteWriteExemplarsWrittenHeader  = "X-Prometheus-Remote-Write-Exemplars-Written"
+
+	// Response headers warning about what has not been stored, see
+	// countDropped.
+	remoteWriteSamplesDroppedHeader    = "X-Pushgateway-Remote-Write-Samples-Dropped"
+	remoteWriteTimestampsDroppedHeader = "X-Pushgateway-Remote-Write-Timestamps-Dropped"
+)
+
+// RemoteWrite returns an http.Handler which accepts Prometheus remote-write
+// requests (protocol version 1.0 and 2.0) and stores the contained series in
+// the MetricStore.
+//
+// Each series is assigned to the metric group given by the values of its
+// labels listed in groupingLabels. The job label is always part of the
+// grouping key and required for each series. Within a metric group, a series
+// replaces only the stored metric with the same name and label set (see the
+// Merge field of storage.WriteRequest) because remote-write senders split the
+// series of a metric family across requests arbitrarily.
+//
+// Unlike pushes via Push, samples with timestamps are not rejected, as every
+// remote-written sample has one. Instead, as the Pushgateway doesn't store
+// timestamps, only the most recent sample of each series is used, and its
+// timestamp is dropped. A series whose most recent sample is a staleness marker
+// is ignored. The dropped samples and timestamps of successful requests are
+// counted in the pushgateway_remote_write_dropped_samples_total and
+// pushgateway_remote_write_dropped_timestamps_total metrics and reported to the
+// sender in the X-Pushgateway-Remote-Write-Samples-Dropped and
+// X-Pushgateway-Remote-Write-Timestamps-Dropped response headers.
+//
+// If check is true, the resulting metrics are checked for consistency as for
+// regular pushes, and an inconsistent request is rejected with
+// http.StatusBadRequest. Either way, the request is only answered once all
+// metric groups have been processed.
+//
+// If the write queue for one of the metric groups is full, the request is
+// rejected with http.StatusTooManyRequests and a Retry-After header rather than
+// waiting for the queue to drain. The metric groups submitted before are still
+// changed, which is harmless as the sender retries the request as a whole.
+//
+// If a is not nil, the request has to be authorized to change each of the
+// metric groups it writes to (see AuthorizeGroups). Otherwise, it is rejected
+// as a whole with http.StatusForbidden.
+//
+// The returned handler is already instrumented for Prometheus.
+func RemoteWrite(
+	ms storage.MetricStore,
+	check bool,
+	groupingLabels []string,
+	a Authorizer,
+	logger *slog.Logger,
+) func(http.ResponseWriter, *http.Request) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctMediatype, ctParams, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || ctMediatype != "application/x-protobuf" {
+			http.Error(w, fmt.Sprintf("unsupported content type %q", r.Header.Get("Content-Type")), http.StatusUnsupportedMediaType)
+			logger.Debug("unsupported content type for remote write", "content_type", r.Header.Get("Content-Type"))
+			return
+		}
+		protoMsg := ctParams["proto"]
+		if protoMsg == "" {
+			protoMsg = remoteWriteProtoV1
+		}
+		if protoMsg != remoteWriteProtoV1 && protoMsg != remoteWriteProtoV2 {
+			http.Error(w, fmt.Sprintf("unsupported remote-write protobuf message %q", protoMsg), http.StatusUnsupportedMediaType)
+			logger.Debug("unsupported remote-write protobuf message", "proto", protoMsg)
+			return
+		}
+		if enc := r.Header.Get("Content-Encoding"); enc != "" && !strings.EqualFold(enc, "snappy") {
+			http.Error(w, fmt.Sprintf("unsupported content encoding %q", enc), http.StatusUnsupportedMediaType)
+			logger.Debug("unsupported content encoding for remote write", "encoding", enc)
+			return
+		}
+
+		compressed, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			logger.Debug("failed to read remote-write request", "source", r.RemoteAddr, "err", err.Error())
+			return
+		}
+		b, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			logger.Debug("failed to decompress remote-write request", "source", r.RemoteAddr, "err", err.Error())
+			return
+		}
+		var series []seriesSample
+		if protoMsg == remoteWriteProtoV2 {
+			var req writev2.Request
+			if err = req.Unmarshal(b); err == nil {
+				series, err = remoteWriteSeriesV2(&req)
+			}
+		} else {
+			var req prompb.WriteRequest
+			if err = req.Unmarshal(b); err == nil {
+				series = remoteWriteSeriesV1(&req)
+			}
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			logger.Debug("failed to decode remote-write request", "source", r.RemoteAddr, "err", err.Error())
+			return
+		}
+		groups, err := remoteWriteGroups(series, groupingLabels)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			logger.Debug("failed to convert remote-write request", "source", r.RemoteAddr, "err", err.Error())
+			return
+		}
+		authzLabels := make([]map[string]string, len(groups))
+		for i, g := range groups {
+			authzLabels[i] = g.labels
+		}
+		if _, err := AuthorizeGroups(a, ms, r, authzLabels); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			logger.Debug("remote-write request not authorized", "source", r.RemoteAddr, "err", err.Error())
+			return
+		}
+
+		now := time.Now()
+		var errChs []chan error
+		for _, g := range groups {
+			wr := storage.WriteRequest{
+				Labels:         g.labels,
+				Timestamp:      now,
+				MetricFamilies: g.metricFamilies,
+				Merge:          true,
+				Done:           make(chan error, 1),
+				Unchecked:      !check,
+			}
+			errChs = append(errChs, wr.Done)
+			if !submitWriteRequest(w, r, ms, wr, logger) {
+				return
+			}
+		}
+		var errs []error
+		for _, errCh := range errChs {
+			for err := range errCh {
+				logger.Error(
+					"remote-written metrics are invalid or inconsistent with existing metrics",
+					"source", r.RemoteAddr,
+					"err", err.Error(),
+				)
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) > 0 {
+			http.Error(
+				w,
+				fmt.Sprintf("remote-written metrics are invalid or inconsistent with existing metrics: %v", errors.Join(errs...)),
+				http.StatusBadRequest,
+			)
+			return
+		}
+		droppedSamples, droppedTimestamps := countDropped(series)
+		w.Header().Set(remoteWriteSamplesDroppedHeader, strconv.Itoa(droppedSamples))
+		w.Header().Set(remoteWriteTimestampsDroppedHeader, strconv.Itoa(droppedTimestamps))
+		if droppedSamples > 0 || droppedTimestamps > 0 {
+			logger.Debug(
+				"remote-written samples or timestamps dropped",
+				"source", r.RemoteAddr,
+				"samples", droppedSamples,
+				"timestamps", droppedTimestamps,
+			)
+		}
+		if protoMsg == remoteWriteProtoV2 {
+			var samples, histograms, exemplars int
+			for _, s := range series {
+				samples += s.samples
+				histograms += s.histograms
+				exemplars += s.exemplars
+			}
+			w.Header().Set(remoteWriteSamplesWrittenHeader, strconv.Itoa(samples))
+			w.Header().Set(remoteWriteHistogramsWrittenHeader, strconv.Itoa(histograms))
+			w.Header().Set(remoteWriteExemplarsWrittenHeader, strconv.Itoa(exemplars))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	instrumentedHandler := promhttp.InstrumentHandlerRequestSize(
+		httpPushSize, promhttp.InstrumentHandlerDuration(
+			httpPushDuration, InstrumentWithCounter("remote_write", handler),
+		))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		instrumentedHandler.ServeHTTP(w, r)
+	}
+}
+
+// remoteWriteSeriesV1 converts a remote-write 1.0 request. The metadata of a
+// series is looked up by its metric name and, for the series of histograms,
+// summaries, and counters, by the name of their metric family.
+func remoteWriteSeriesV1(req *prompb.WriteRequest) []seriesSample {
+	metadataByName := make(map[string]metadata.Metadata, len(req.Metadata))
+	for _, md := range req.Metadata {
+		metadataByName[md.MetricFamilyName] = metadata.Metadata{
+			Type: model.MetricType(strings.ToLower(md.Type.String())),
+			Help: md.Help,
+			Unit: md.Unit,
+		}
+	}
+	b := labels.NewScratchBuilder(0)
+	result := make([]seriesSample, 0, len(req.Timeseries))
+	for _, ts := range req.Timeseries {
+		s := seriesSample{labels: ts.ToLabels(&b, nil)}
+		s.metadata, _ = lookupMetadata(metadataByName, s.labels.Get(model.MetricNameLabel))
+		s.setLatest(
+			len(ts.Samples), func(i int) (float64, int64) {
+				return ts.Samples[i].Value, ts.Samples[i].Timestamp
+			},
+			len(ts.Histograms), func(i int) (*histogram.Histogram, *histogram.FloatHistogram, bool, int64) {
+				h := ts.Histograms[i]
+				gauge := h.ResetHint == prompb.Histogram_GAUGE
+				if h.IsFloatHistogram() {
+					return nil, h.ToFloatHistogram(), gauge, h.Timestamp
+				}
+				return h.ToIntHistogram(), nil, gauge, h.Timestamp
+			},
+		)
+		s.exemplars = len(ts.Exemplars)
+		for _, e := range ts.Exemplars {
+			if ex := e.ToExemplar(&b, nil); s.exemplar == nil || ex.Ts >= s.exemplar.Ts {
+				s.exemplar = &ex
+			}
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+// remoteWriteSeriesV2 converts a remote-write 2.0 request.
+func remoteWriteSeriesV2(req *writev2.Request) ([]seriesSample, error) {
+	b := labels.NewScratchBuilder(0)
+	result := make([]seriesSample, 0, len(req.Timeseries))
+	for _, ts := range req.Timeseries {
+		var (
+			s   seriesSample
+			err error
+		)
+		if s.labels, err = ts.ToLabels(&b, req.Symbols); err != nil {
+			return nil, err
+		}
+		if s.metadata, err = ts.ToMetadata(req.Symbols); err != nil {
+			return nil, err
+		}
+		s.setLatest(
+			len(ts.Samples), func(i int) (float64, int64) {
+				return ts.Samples[i].Value, ts.Samples[i].Timestamp
+			},
+			len(ts.Histograms), func(i int) (*histogram.Histogram, *histogram.FloatHistogram, bool, int64) {
+				h := ts.Histograms[i]
+				gauge := h.ResetHint == writev2.Histogram_RESET_HINT_GAUGE
+				if h.IsFloatHistogram() {
+					return nil, h.ToFloatHistogram(), gauge, h.Timestamp
+				}
+				return h.ToIntHistogram(), nil, gauge, h.Timestamp
+			},
+		)
+		s.exemplars = len(ts.Exemplars)
+		for _, e := range ts.Exemplars {
+			ex, err := e.ToExemplar(&b, req.Symbols)
+			if err != nil {
+				return nil, err
+			}
+			if s.exemplar == nil || ex.Ts >= s.exemplar.Ts {
+				s.exemplar = &ex
+			}
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// countDropped counts the samples and timestamps of the provided series that
+// are not stored, see RemoteWrite, and returns their numbers.
+func countDropped(series []seriesSample) (samples, timestamps int) {
+	for _, s := range series {
+		received := s.samples + s.histograms
+		if received == 0 {
+			continue
+		}
+		if s.h == nil && s.fh == nil && value.IsStaleNaN(s.value) {
+			samples += received
+			continue
+		}
+		samples += received - 1
+		timestamps++
+	}
+	remoteWriteDroppedSamples.Add(float64(samples))
+	remoteWriteDroppedTimestamps.Add(float64(timestamps))
+	return samples, timestamps
+}
+
+// remoteWriteGroups assigns the provided series to metric groups and converts
+// them into MetricFamilies.
+func remoteWriteGroups(series []seriesSample, groupingLabels []string) ([]*seriesGroup, error) {
+	groupsByKey := map[string]*seriesGroup{}
+	var groups []*seriesGroup
+	for _, s := range series {
+		if s.h == nil && s.fh == nil && value.IsStaleNaN(s.value) {
+			continue
+		}
+		if s.samples == 0 && s.histograms == 0 {
+			continue
+		}
+		if s.labels.Get("job") == "" {
+			return nil, fmt.Errorf("series %s has no job label", s.labels)
+		}
+		if err := validateSeriesLabels(s.labels); err != nil {
+			return nil, err
+		}
+
+		groupLabels := map[string]string{"job": s.labels.Get("job")}
+		for _, name := range groupingLabels {
+			if v := s.labels.Get(name); v != "" {
+				groupLabels[name] = v
+			}
+		}
+		key := labels.FromMap(groupLabels).String()
+		g, ok := groupsByKey[key]
+		if !ok {
+			g = newSeriesGroup(groupLabels)
+			groupsByKey[key] = g
+			groups = append(groups, g)
+		}
+		if err := g.add(s); err != nil {
+			return nil, err
+		}
+	}
+	for _, g := range groups {
+		g.finish()
+	}
+	return groups, nil
+}
@@ -0,0 +1,340 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+	writev2 "github.com/prometheus/prometheus/prompb/io/prometheus/write/v2"
+
+	"github.com/prometheus/pushgateway/storage"
+)
+
+func postRemoteWrite(
+	t *testing.T,
+	handler func(http.ResponseWriter, *http.Request),
+	contentType string,
+	msg interface{ Marshal() ([]byte, error) },
+) *httptest.ResponseRecorder {
+	t.Helper()
+	b, err := msg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "http://example.org/api/v1/write", bytes.NewReader(snappy.Encode(nil, b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Content-Encoding", "snappy")
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+// writeRequestFor returns the WriteRequest in mms with the provided grouping
+// labels.
+func writeRequestFor(t *testing.T, mms *MockMetricStore, labels map[string]string) storage.WriteRequest {
+	t.Helper()
+	for _, wr := range mms.writeRequests {
+		if len(wr.Labels) != len(labels) {
+			continue
+		}
+		match := true
+		for ln, lv := range labels {
+			if wr.Labels[ln] != lv {
+				match = false
+			}
+		}
+		if match {
+			return wr
+		}
+	}
+	t.Fatalf("No write request for grouping labels %v in %v.", labels, mms.writeRequests)
+	return storage.WriteRequest{}
+}
+
+func TestRemoteWriteV1(t *testing.T) {
+	mms := MockMetricStore{}
+	handler := RemoteWrite(&mms, true, []string{"job", "instance"}, nil, logger)
+
+	series := func(value float64, ls ...string) prompb.TimeSeries {
+		ts := prompb.TimeSeries{Samples: []prompb.Sample{{Value: value, Timestamp: 1000}}}
+		for i := 0; i < len(ls); i += 2 {
+			ts.Labels = append(ts.Labels, prompb.Label{Name: ls[i], Value: ls[i+1]})
+		}
+		return ts
+	}
+	req := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			series(1, "__name__", "requests_total", "instance", "a", "job", "api", "path", "/"),
+			series(5, "__name__", "latency_seconds_bucket", "instance", "a", "job", "api", "le", "0.1"),
+			series(7, "__name__", "latency_seconds_bucket", "instance", "a", "job", "api", "le", "+Inf"),
+			series(1.5, "__name__", "latency_seconds_sum", "instance", "a", "job", "api"),
+			series(7, "__name__", "latency_seconds_count", "instance", "a", "job", "api"),
+			series(2, "__name__", "requests_total", "instance", "b", "job", "api", "path", "/"),
+			series(math.Float64frombits(value.StaleNaN), "__name__", "requests_total", "instance", "b", "job", "api", "path", "/gone"),
+			series(3, "__name__", "temperature", "job", "sensor"),
+		},
+		Metadata: []prompb.MetricMetadata{
+			{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "requests_total", Help: "Requests."},
+			{Type: prompb.MetricMetadata_HISTOGRAM, MetricFamilyName: "latency_seconds", Help: "Latency."},
+		},
+	}
+	// Only the latest sample is used.
+	req.Timeseries[0].Samples = append(req.Timeseries[0].Samples, prompb.Sample{Value: 4, Timestamp: 2000})
+
+	droppedSamplesBefore := testutil.ToFloat64(remoteWriteDroppedSamples)
+	droppedTimestampsBefore := testutil.ToFloat64(remoteWriteDroppedTimestamps)
+	w := postRemoteWrite(t, handler, "application/x-protobuf", req)
+	if expected, got := http.StatusNoContent, w.Code; expected != got {
+		t.Fatalf("Wanted status code %v, got %v: %s", expected, got, w.Body.String())
+	}
+	// The superseded sample and the staleness marker are dropped, and the
+	// timestamps of the 7 stored samples.
+	if expected, got := 2.0, testutil.ToFloat64(remoteWriteDroppedSamples)-droppedSamplesBefore; expected != got {
+		t.Errorf("Wanted %v dropped samples, got %v.", expected, got)
+	}
+	if expected, got := 7.0, testutil.ToFloat64(remoteWriteDroppedTimestamps)-droppedTimestampsBefore; expected != got {
+		t.Errorf("Wanted %v dropped timestamps, got %v.", expected, got)
+	}
+	// The sender is warned about them.
+	for header, expected := range map[string]string{
+		remoteWriteSamplesDroppedHeader:    "2",
+		remoteWriteTimestampsDroppedHeader: "7",
+	} {
+		if got := w.Header().Get(header); expected != got {
+			t.Errorf("Wanted header %s=%q, got %q.", header, expected, got)
+		}
+	}
+	if expected, got := 3, len(mms.writeRequests); expected != got {
+		t.Fatalf("Wanted %d write requests, got %d.", expected, got)
+	}
+	for _, wr := range mms.writeRequests {
+		if !wr.Merge {
+			t.Errorf("Wanted merge write request, got %#v.", wr)
+		}
+		if wr.Timestamp.IsZero() {
+			t.Errorf("Write request timestamp not set: %#v", wr)
+		}
+	}
+
+	wr := writeRequestFor(t, &mms, map[string]string{"job": "api", "instance": "a"})
+	if expected, got := 2, len(wr.MetricFamilies); expected != got {
+		t.Errorf("Wanted %d metric families, got %d.", expected, got)
+	}
+	verifyMetricFamily(t, `name:"requests_total" help:"Requests." type:COUNTER metric:{
+		label:{name:"instance" value:"a"} label:{name:"job" value:"api"} label:{name:"path" value:"/"} counter:{value:4}}`,
+		wr.MetricFamilies["requests_total"])
+	verifyMetricFamily(t, `name:"latency_seconds" help:"Latency." type:HISTOGRAM metric:{
+		label:{name:"instance" value:"a"} label:{name:"job" value:"api"}
+		histogram:{sample_count:7 sample_sum:1.5 bucket:{cumulative_count:5 upper_bound:0.1}}}`,
+		wr.MetricFamilies["latency_seconds"])
+
+	wr = writeRequestFor(t, &mms, map[string]string{"job": "api", "instance": "b"})
+	verifyMetricFamily(t, `name:"requests_total" help:"Requests." type:COUNTER metric:{
+		label:{name:"instance" value:"b"} label:{name:"job" value:"api"} label:{name:"path" value:"/"} counter:{value:2}}`,
+		wr.MetricFamilies["requests_total"])
+
+	wr = writeRequestFor(t, &mms, map[string]string{"job": "sensor"})
+	verifyMetricFamily(t, `name:"temperature" type:UNTYPED metric:{label:{name:"job" value:"sensor"} untyped:{value:3}}`,
+		wr.MetricFamilies["temperature"])
+}
+
+func TestRemoteWriteV2(t *testing.T) {
+	mms := MockMetricStore{}
+	handler := RemoteWrite(&mms, true, []string{"job", "instance"}, nil, logger)
+
+	st := writev2.NewSymbolTable()
+	ref := func(ls ...string) []uint32 {
+		var refs []uint32
+		for _, s := range ls {
+			refs = append(refs, st.Symbolize(s))
+		}
+		return refs
+	}
+	h := &histogram.Histogram{
+		Count:           5,
+		Sum:             12,
+		Schema:          0,
+		ZeroThreshold:   0.001,
+		ZeroCount:       1,
+		PositiveSpans:   []histogram.Span{{Offset: 0, Length: 2}},
+		PositiveBuckets: []int64{1, 2},
+	}
+	timeseries := []writev2.TimeSeries{
+		{
+			LabelsRefs: ref("__name__", "jobs_processed_total", "job", "batch"),
+			Samples:    []writev2.Sample{{Value: 3, Timestamp: 1000}},
+			Exemplars:  []writev2.Exemplar{{LabelsRefs: ref("trace_id", "abc"), Value: 1, Timestamp: 900}},
+			Metadata:   writev2.Metadata{Type: writev2.Metadata_METRIC_TYPE_COUNTER, HelpRef: st.Symbolize("Jobs.")},
+		},
+		{
+			LabelsRefs: ref("__name__", "job_duration_seconds", "job", "batch"),
+			Histograms: []writev2.Histogram{writev2.FromIntHistogram(1000, h)},
+			Metadata:   writev2.Metadata{Type: writev2.Metadata_METRIC_TYPE_HISTOGRAM},
+		},
+		{
+			LabelsRefs: ref("__name__", "job_size_bytes", "job", "batch", "quantile", "0.9"),
+			Samples:    []writev2.Sample{{Value: 100, Timestamp: 1000}},
+			Metadata:   writev2.Metadata{Type: writev2.Metadata_METRIC_TYPE_SUMMARY},
+		},
+		{
+			LabelsRefs: ref("__name__", "job_size_bytes_count", "job", "batch"),
+			Samples:    []writev2.Sample{{Value: 10, Timestamp: 1000}},
+			Metadata:   writev2.Metadata{Type: writev2.Metadata_METRIC_TYPE_SUMMARY},
+		},
+		{
+			LabelsRefs: ref("__name__", "job_size_bytes_sum", "job", "batch"),
+			Samples:    []writev2.Sample{{Value: 800, Timestamp: 1000}},
+			Metadata:   writev2.Metadata{Type: writev2.Metadata_METRIC_TYPE_SUMMARY},
+		},
+	}
+	req := &writev2.Request{Symbols: st.Symbols(), Timeseries: timeseries}
+
+	w := postRemoteWrite(t, handler, "application/x-protobuf;proto=io.prometheus.write.v2.Request", req)
+	if expected, got := http.StatusNoContent, w.Code; expected != got {
+		t.Fatalf("Wanted status code %v, got %v: %s", expected, got, w.Body.String())
+	}
+	for header, expected := range map[string]string{
+		remoteWriteSamplesWrittenHeader:    "4",
+		remoteWriteHistogramsWrittenHeader: "1",
+		remoteWriteExemplarsWrittenHeader:  "1",
+	} {
+		if got := w.Header().Get(header); expected != got {
+			t.Errorf("Wanted header %s=%q, got %q.", header, expected, got)
+		}
+	}
+
+	wr := writeRequestFor(t, &mms, map[string]string{"job": "batch"})
+	verifyMetricFamily(t, `name:"jobs_processed_total" help:"Jobs." type:COUNTER metric:{
+		label:{name:"job" value:"batch"}
+		counter:{value:3 exemplar:{label:{name:"trace_id" value:"abc"} value:1 timestamp:{nanos:900000000}}}}`,
+		wr.MetricFamilies["jobs_processed_total"])
+	verifyMetricFamily(t, `name:"job_duration_seconds" type:HISTOGRAM metric:{
+		label:{name:"job" value:"batch"}
+		histogram:{sample_count:5 sample_sum:12 schema:0 zero_threshold:0.001 zero_count:1
+			positive_span:{offset:0 length:2} positive_delta:1 positive_delta:2}}`,
+		wr.MetricFamilies["job_duration_seconds"])
+	verifyMetricFamily(t, `name:"job_size_bytes" type:SUMMARY metric:{
+		label:{name:"job" value:"batch"}
+		summary:{sample_count:10 sample_sum:800 quantile:{quantile:0.9 value:100}}}`,
+		wr.MetricFamilies["job_size_bytes"])
+}
+
+func TestRemoteWriteErrors(t *testing.T) {
+	validRequest := &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+		Labels:  []prompb.Label{{Name: "__name__", Value: "some_metric"}, {Name: "job", Value: "testjob"}},
+		Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+	}}}
+
+	scenarios := []struct {
+		name         string
+		contentType  string
+		req          interface{ Marshal() ([]byte, error) }
+		storeErr     error
+		expectedCode int
+	}{
+		{
+			name:         "valid",
+			contentType:  "application/x-protobuf",
+			req:          validRequest,
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:         "inconsistent",
+			contentType:  "application/x-protobuf",
+			req:          validRequest,
+			storeErr:     errors.New("inconsistent"),
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "wrong content type",
+			contentType:  "text/plain",
+			req:          validRequest,
+			expectedCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:         "unknown proto message",
+			contentType:  "application/x-protobuf;proto=io.prometheus.write.v3.Request",
+			req:          validRequest,
+			expectedCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "no job label",
+			contentType: "application/x-protobuf",
+			req: &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "some_metric"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			}}},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:        "invalid metric name",
+			contentType: "application/x-protobuf",
+			req: &prompb.WriteRequest{Timeseries: []prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "some.metric"}, {Name: "job", Value: "testjob"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1000}},
+			}}},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:        "histogram series without suffix",
+			contentType: "application/x-protobuf",
+			req: &prompb.WriteRequest{
+				Timeseries: []prompb.TimeSeries{{
+					Labels:  []prompb.Label{{Name: "__name__", Value: "some_histogram"}, {Name: "job", Value: "testjob"}},
+					Samples: []prompb.Sample{{Value: math.Pi, Timestamp: 1000}},
+				}},
+				Metadata: []prompb.MetricMetadata{{Type: prompb.MetricMetadata_HISTOGRAM, MetricFamilyName: "some_histogram"}},
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, s := range scenarios {
+		mms := MockMetricStore{err: s.storeErr}
+		handler := RemoteWrite(&mms, true, []string{"job", "instance"}, nil, logger)
+		w := postRemoteWrite(t, handler, s.contentType, s.req)
+		if expected, got := s.expectedCode, w.Code; expected != got {
+			t.Errorf("%s: Wanted status code %v, got %v.", s.name, expected, got)
+		}
+	}
+
+	// Not snappy-compressed at all.
+	mms := MockMetricStore{}
+	req, err := http.NewRequest("POST", "http://example.org/api/v1/write", bytes.NewBufferString("some_metric 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	w := httptest.NewRecorder()
+	RemoteWrite(&mms, true, []string{"job"}, nil, logger)(w, req)
+	if expected, got := http.StatusBadRequest, w.Code; expected != got {
+		t.Errorf("Wanted status code %v, got %v.", expected, got)
+	}
+	if len(mms.writeRequests) != 0 {
+		t.Errorf("Unexpected write requests: %v", mms.writeRequests)
+	}
+}
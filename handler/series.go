@@ -0,0 +1,390 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// seriesSample is the protocol-independent representation of a series
+// received by one of the series-based ingestion paths (remote write and the
+// OpenMetrics text format), reduced to a single sample (which is either a
+// float sample or a native histogram sample) and a single exemplar.
+type seriesSample struct {
+	labels   labels.Labels
+	metadata metadata.Metadata // Type is empty or "unknown" if unknown.
+
+	value          float64
+	h              *histogram.Histogram
+	fh             *histogram.FloatHistogram
+	gaugeHistogram bool   // Reset hint of the histogram sample.
+	timestamp      *int64 // Only set if it is to be stored with the sample.
+	exemplar       *exemplar.Exemplar
+
+	// Number of samples, histogram samples, and exemplars received.
+	samples, histograms, exemplars int
+}
+
+// setLatest sets the latest float sample or histogram sample of the series.
+func (s *seriesSample) setLatest(
+	samples int, sampleAt func(int) (float64, int64),
+	histograms int, histogramAt func(int) (*histogram.Histogram, *histogram.FloatHistogram, bool, int64),
+) {
+	s.samples, s.histograms = samples, histograms
+	latest := int64(math.MinInt64)
+	for i := range samples {
+		if v, ts := sampleAt(i); ts >= latest {
+			s.value, latest = v, ts
+		}
+	}
+	for i := range histograms {
+		if h, fh, gauge, ts := histogramAt(i); ts >= latest {
+			s.h, s.fh, s.gaugeHistogram, latest = h, fh, gauge, ts
+		}
+	}
+}
+
+// validateSeriesLabels checks the metric name and the label names of a series
+// against the ValidationScheme. Label names with the reserved prefix "__" are
+// rejected.
+func validateSeriesLabels(ls labels.Labels) error {
+	return ls.Validate(func(l labels.Label) error {
+		if l.Name == model.MetricNameLabel {
+			if !ValidationScheme.IsValidMetricName(l.Value) {
+				return fmt.Errorf("invalid metric name %q", l.Value)
+			}
+			return nil
+		}
+		if !ValidationScheme.IsValidLabelName(l.Name) || strings.HasPrefix(l.Name, model.ReservedLabelPrefix) {
+			return fmt.Errorf("improper label name %q", l.Name)
+		}
+		return nil
+	})
+}
+
+// familySuffixes maps the suffixes of series names to the metric types whose
+// metric families contain series with that suffix.
+var familySuffixes = map[string]map[model.MetricType]bool{
+	"_bucket": {model.MetricTypeHistogram: true, model.MetricTypeGaugeHistogram: true},
+	"_sum":    {model.MetricTypeHistogram: true, model.MetricTypeSummary: true},
+	"_count":  {model.MetricTypeHistogram: true, model.MetricTypeSummary: true},
+	"_gsum":   {model.MetricTypeGaugeHistogram: true},
+	"_gcount": {model.MetricTypeGaugeHistogram: true},
+	"_total":  {model.MetricTypeCounter: true},
+	"_info":   {model.MetricTypeInfo: true},
+}
+
+// histogramRoles maps the suffixes of the series names of classic histograms
+// to the part of the histogram they provide.
+var histogramRoles = map[string]string{
+	"_bucket": "bucket",
+	"_sum":    "sum",
+	"_count":  "count",
+	"_gsum":   "sum",
+	"_gcount": "count",
+}
+
+// lookupMetadata returns the metadata of the series with the provided name. The
+// metadata is looked up by the series name and, for the series of histograms,
+// summaries, and counters, by the name of their metric family.
+func lookupMetadata(metadataByName map[string]metadata.Metadata, name string) (metadata.Metadata, bool) {
+	if md, ok := metadataByName[name]; ok {
+		return md, true
+	}
+	for suffix, types := range familySuffixes {
+		md, ok := metadataByName[strings.TrimSuffix(name, suffix)]
+		if ok && strings.HasSuffix(name, suffix) && types[md.Type] {
+			return md, true
+		}
+	}
+	return metadata.Metadata{}, false
+}
+
+// seriesGroup is a metric group assembled from series.
+type seriesGroup struct {
+	labels         map[string]string
+	metricFamilies map[string]*dto.MetricFamily
+	metrics        map[string]*dto.Metric // Keyed by family name and label set.
+}
+
+func newSeriesGroup(groupLabels map[string]string) *seriesGroup {
+	return &seriesGroup{
+		labels:         groupLabels,
+		metricFamilies: map[string]*dto.MetricFamily{},
+		metrics:        map[string]*dto.Metric{},
+	}
+}
+
+// finish sorts the buckets of classic histograms and the quantiles of
+// summaries, which might have been added in any order.
+func (g *seriesGroup) finish() {
+	for _, mf := range g.metricFamilies {
+		for _, m := range mf.Metric {
+			if h := m.GetHistogram(); h != nil {
+				sort.Slice(h.Bucket, func(i, j int) bool {
+					return h.Bucket[i].GetUpperBound() < h.Bucket[j].GetUpperBound()
+				})
+			}
+			if s := m.GetSummary(); s != nil {
+				sort.Slice(s.Quantile, func(i, j int) bool {
+					return s.Quantile[i].GetQuantile() < s.Quantile[j].GetQuantile()
+				})
+			}
+		}
+	}
+}
+
+// add converts the provided series and adds it to the MetricFamilies of the
+// group. The series of classic histograms and summaries are combined into a
+// single Metric per label set.
+func (g *seriesGroup) add(s seriesSample) error {
+	name := s.labels.Get(model.MetricNameLabel)
+	family, typ, role := name, dto.MetricType_UNTYPED, ""
+	switch {
+	case s.h != nil || s.fh != nil:
+		typ = dto.MetricType_HISTOGRAM
+		if s.gaugeHistogram || s.metadata.Type == model.MetricTypeGaugeHistogram {
+			typ = dto.MetricType_GAUGE_HISTOGRAM
+		}
+		role = "native"
+	case s.metadata.Type == model.MetricTypeHistogram || s.metadata.Type == model.MetricTypeGaugeHistogram:
+		typ = dto.MetricType_HISTOGRAM
+		if s.metadata.Type == model.MetricTypeGaugeHistogram {
+			typ = dto.MetricType_GAUGE_HISTOGRAM
+		}
+		for _, suffix := range []string{"_bucket", "_sum", "_count", "_gsum", "_gcount"} {
+			if trimmed, ok := strings.CutSuffix(name, suffix); ok && familySuffixes[suffix][s.metadata.Type] {
+				family, role = trimmed, histogramRoles[suffix]
+				break
+			}
+		}
+		if role == "" {
+			return fmt.Errorf("unexpected series %s for metric of type %s", s.labels, s.metadata.Type)
+		}
+	case s.metadata.Type == model.MetricTypeSummary:
+		typ = dto.MetricType_SUMMARY
+		for _, suffix := range []string{"_sum", "_count"} {
+			if trimmed, ok := strings.CutSuffix(name, suffix); ok {
+				family, role = trimmed, suffix[1:]
+				break
+			}
+		}
+		if role == "" {
+			if !s.labels.Has(model.QuantileLabel) {
+				return fmt.Errorf("unexpected series %s for metric of type %s", s.labels, s.metadata.Type)
+			}
+			role = "quantile"
+		}
+	case s.metadata.Type == model.MetricTypeCounter:
+		typ = dto.MetricType_COUNTER
+	case s.metadata.Type == model.MetricTypeGauge, s.metadata.Type == model.MetricTypeInfo, s.metadata.Type == model.MetricTypeStateset:
+		typ = dto.MetricType_GAUGE
+	}
+
+	mf, ok := g.metricFamilies[family]
+	if !ok {
+		mf = &dto.MetricFamily{Name: proto.String(family), Type: typ.Enum()}
+		if s.metadata.Help != "" {
+			mf.Help = proto.String(s.metadata.Help)
+		}
+		if s.metadata.Unit != "" {
+			mf.Unit = proto.String(s.metadata.Unit)
+		}
+		g.metricFamilies[family] = mf
+	} else if mf.GetType() != typ {
+		return fmt.Errorf("series %s is of type %s but its metric family %q is of type %s", s.labels, typ, family, mf.GetType())
+	}
+
+	var labelPairs []*dto.LabelPair
+	s.labels.Range(func(l labels.Label) {
+		switch {
+		case l.Name == model.MetricNameLabel:
+		case l.Name == model.BucketLabel && role == "bucket":
+		case l.Name == model.QuantileLabel && role == "quantile":
+		default:
+			labelPairs = append(labelPairs, &dto.LabelPair{Name: proto.String(l.Name), Value: proto.String(l.Value)})
+		}
+	})
+	key := family
+	for _, lp := range labelPairs {
+		key += "\xff" + lp.GetName() + "\xff" + lp.GetValue()
+	}
+	m, ok := g.metrics[key]
+	if !ok {
+		m = &dto.Metric{Label: labelPairs}
+		g.metrics[key] = m
+		mf.Metric = append(mf.Metric, m)
+	}
+	if s.timestamp != nil {
+		m.TimestampMs = proto.Int64(*s.timestamp)
+	}
+
+	switch typ {
+	case dto.MetricType_COUNTER:
+		m.Counter = &dto.Counter{Value: proto.Float64(s.value), Exemplar: exemplarToDTO(s.exemplar)}
+	case dto.MetricType_GAUGE:
+		m.Gauge = &dto.Gauge{Value: proto.Float64(s.value)}
+	case dto.MetricType_UNTYPED:
+		m.Untyped = &dto.Untyped{Value: proto.Float64(s.value)}
+	case dto.MetricType_SUMMARY:
+		if m.Summary == nil {
+			m.Summary = &dto.Summary{}
+		}
+		switch role {
+		case "sum":
+			m.Summary.SampleSum = proto.Float64(s.value)
+		case "count":
+			m.Summary.SampleCount = proto.Uint64(uint64(s.value))
+		case "quantile":
+			q, err := strconv.ParseFloat(s.labels.Get(model.QuantileLabel), 64)
+			if err != nil {
+				return fmt.Errorf("invalid quantile in series %s: %w", s.labels, err)
+			}
+			m.Summary.Quantile = append(m.Summary.Quantile, &dto.Quantile{Quantile: proto.Float64(q), Value: proto.Float64(s.value)})
+		}
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		if m.Histogram == nil {
+			m.Histogram = &dto.Histogram{}
+		}
+		return addToHistogram(m.Histogram, s, role)
+	}
+	return nil
+}
+
+// addToHistogram sets the parts of the histogram h provided by the series s,
+// which plays the provided role in the histogram.
+func addToHistogram(h *dto.Histogram, s seriesSample, role string) error {
+	switch role {
+	case "sum":
+		h.SampleSum = proto.Float64(s.value)
+	case "count":
+		setHistogramCount(&h.SampleCount, &h.SampleCountFloat, s.value)
+	case "bucket":
+		le := s.labels.Get(model.BucketLabel)
+		upperBound, err := strconv.ParseFloat(le, 64)
+		if err != nil {
+			return fmt.Errorf("invalid bucket boundary in series %s: %w", s.labels, err)
+		}
+		if math.IsInf(upperBound, +1) {
+			// The +Inf bucket is implied by the count.
+			if h.SampleCount == nil && h.SampleCountFloat == nil {
+				setHistogramCount(&h.SampleCount, &h.SampleCountFloat, s.value)
+			}
+			return nil
+		}
+		b := &dto.Bucket{UpperBound: proto.Float64(upperBound), Exemplar: exemplarToDTO(s.exemplar)}
+		setHistogramCount(&b.CumulativeCount, &b.CumulativeCountFloat, s.value)
+		h.Bucket = append(h.Bucket, b)
+	case "native":
+		fh := s.fh
+		if fh == nil {
+			fh = s.h.ToFloat(nil)
+		}
+		h.SampleSum = proto.Float64(fh.Sum)
+		if s.h != nil {
+			h.SampleCount = proto.Uint64(s.h.Count)
+		} else {
+			h.SampleCountFloat = proto.Float64(fh.Count)
+		}
+		if e := exemplarToDTO(s.exemplar); e != nil {
+			h.Exemplars = append(h.Exemplars, e)
+		}
+		if fh.UsesCustomBuckets() {
+			// Native histograms with custom buckets are exposed as
+			// classic histograms.
+			var cumulative float64
+			it := fh.PositiveBucketIterator()
+			for it.Next() {
+				bucket := it.At()
+				cumulative += bucket.Count
+				if math.IsInf(bucket.Upper, +1) {
+					continue
+				}
+				b := &dto.Bucket{UpperBound: proto.Float64(bucket.Upper)}
+				if s.h != nil {
+					b.CumulativeCount = proto.Uint64(uint64(cumulative))
+				} else {
+					b.CumulativeCountFloat = proto.Float64(cumulative)
+				}
+				h.Bucket = append(h.Bucket, b)
+			}
+			return nil
+		}
+		h.Schema = proto.Int32(fh.Schema)
+		h.ZeroThreshold = proto.Float64(fh.ZeroThreshold)
+		h.PositiveSpan = spansToDTO(fh.PositiveSpans)
+		h.NegativeSpan = spansToDTO(fh.NegativeSpans)
+		if s.h != nil {
+			h.ZeroCount = proto.Uint64(s.h.ZeroCount)
+			h.PositiveDelta = s.h.PositiveBuckets
+			h.NegativeDelta = s.h.NegativeBuckets
+		} else {
+			h.ZeroCountFloat = proto.Float64(fh.ZeroCount)
+			h.PositiveCount = fh.PositiveBuckets
+			h.NegativeCount = fh.NegativeBuckets
+		}
+		if len(h.PositiveSpan) == 0 && len(h.NegativeSpan) == 0 && h.GetZeroCount() == 0 && h.GetZeroCountFloat() == 0 {
+			// An empty span marks an empty native histogram as such.
+			h.PositiveSpan = []*dto.BucketSpan{{Offset: proto.Int32(0), Length: proto.Uint32(0)}}
+		}
+	}
+	return nil
+}
+
+// setHistogramCount sets the integer count if v is a non-negative integer and
+// the float count otherwise.
+func setHistogramCount(count **uint64, countFloat **float64, v float64) {
+	if v >= 0 && v == math.Trunc(v) && v < math.MaxUint64 {
+		*count, *countFloat = proto.Uint64(uint64(v)), nil
+		return
+	}
+	*count, *countFloat = nil, proto.Float64(v)
+}
+
+func spansToDTO(spans []histogram.Span) []*dto.BucketSpan {
+	result := make([]*dto.BucketSpan, len(spans))
+	for i, s := range spans {
+		result[i] = &dto.BucketSpan{Offset: proto.Int32(s.Offset), Length: proto.Uint32(s.Length)}
+	}
+	return result
+}
+
+func exemplarToDTO(e *exemplar.Exemplar) *dto.Exemplar {
+	if e == nil {
+		return nil
+	}
+	result := &dto.Exemplar{Value: proto.Float64(e.Value)}
+	e.Labels.Range(func(l labels.Label) {
+		result.Label = append(result.Label, &dto.LabelPair{Name: proto.String(l.Name), Value: proto.String(l.Value)})
+	})
+	if e.HasTs {
+		result.Timestamp = timestamppb.New(time.UnixMilli(e.Ts))
+	}
+	return result
+}
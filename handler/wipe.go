@@ -16,30 +16,43 @@ package handler
 import (
 	"log/slog"
 	"net/http"
-	"time"
 
+	"github.com/prometheus/pushgateway/audit"
 	"github.com/prometheus/pushgateway/storage"
 )
 
-// WipeMetricStore deletes all the metrics in MetricStore.
+// WipeMetricStore deletes all the metrics in MetricStore. If the write queue is
+// full, the request is rejected with http.StatusTooManyRequests. Each request
+// is recorded in auditLog, which may be nil.
 //
 // The returned handler is already instrumented for Prometheus.
 func WipeMetricStore(
 	ms storage.MetricStore,
+	auditLog *audit.Log,
 	logger *slog.Logger) http.Handler {
 
 	return InstrumentWithCounter(
 		"wipe",
-		http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
-			w.WriteHeader(http.StatusAccepted)
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			entry, w, finish := StartAudit(auditLog, audit.OperationWipe, w, r)
+			defer finish()
+
 			logger.Debug("start wiping metric store")
-			// Delete all metric groups by sending write requests with MetricFamilies equal to nil.
-			for _, group := range ms.GetMetricFamiliesMap() {
-				ms.SubmitWriteRequest(storage.WriteRequest{
-					Labels:    group.Labels,
-					Timestamp: time.Now(),
-				})
+			// Delete all metric groups by their grouping keys so that
+			// their (already relabeled) grouping labels are not
+			// relabeled again.
+			summaries := ms.GetGroupSummaries()
+			keys := make([]string, len(summaries))
+			for i, summary := range summaries {
+				keys[i] = summary.Key
 			}
+			deleted, err := ms.DeleteGroupsByKey(keys)
+			if err != nil {
+				rejectWriteRequest(w, r, err, logger)
+				return
+			}
+			entry.Groups = len(deleted)
+			w.WriteHeader(http.StatusAccepted)
 
 		}))
 }
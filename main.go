@@ -26,13 +26,14 @@ import (
 	"os/signal"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/golang/snappy"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/promslog"
 	"github.com/prometheus/common/route"
@@ -40,11 +41,14 @@ import (
 	"github.com/prometheus/exporter-toolkit/web"
 
 	versioncollector "github.com/prometheus/client_golang/prometheus/collectors/version"
-	dto "github.com/prometheus/client_model/go"
 	promslogflag "github.com/prometheus/common/promslog/flag"
 	webflag "github.com/prometheus/exporter-toolkit/web/kingpinflag"
 
 	"github.com/prometheus/pushgateway/asset"
+	"github.com/prometheus/pushgateway/audit"
+	"github.com/prometheus/pushgateway/authz"
+	"github.com/prometheus/pushgateway/cluster"
+	"github.com/prometheus/pushgateway/config"
 	"github.com/prometheus/pushgateway/handler"
 	"github.com/prometheus/pushgateway/storage"
 
@@ -62,14 +66,43 @@ func main() {
 		metricsPath         = app.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
 		externalURL         = app.Flag("web.external-url", "The URL under which the Pushgateway is externally reachable.").Default("").URL()
 		routePrefix         = app.Flag("web.route-prefix", "Prefix for the internal routes of web endpoints. Defaults to the path of --web.external-url.").Default("").String()
-		enableLifeCycle     = app.Flag("web.enable-lifecycle", "Enable shutdown via HTTP request.").Default("false").Bool()
+		enableLifeCycle     = app.Flag("web.enable-lifecycle", "Enable shutdown and configuration reload via HTTP requests.").Default("false").Bool()
 		enableAdminAPI      = app.Flag("web.enable-admin-api", "Enable API endpoints for admin control actions.").Default("false").Bool()
 		persistenceFile     = app.Flag("persistence.file", "File to persist metrics. If empty, metrics are only kept in memory.").Default("").String()
+		persistenceSyncWAL  = app.Flag("persistence.sync-wal", "Sync the write-ahead log to disk after each processed push or deletion, so that acknowledged changes also survive a crash of the operating system or a power loss. Slows down pushes considerably.").Default("false").Bool()
 		persistenceInterval = app.Flag("persistence.interval", "The minimum interval at which to write out the persistence file.").Default("5m").Duration()
+		onCorruption        = app.Flag("persistence.on-corruption", "What to do if the persistence file is corrupted: fail to start, start empty, or salvage all intact metric groups. With start-empty and salvage, the corrupted file is kept with the suffix .corrupted.").Default(string(storage.CorruptionStartEmpty)).Enum(string(storage.CorruptionFail), string(storage.CorruptionStartEmpty), string(storage.CorruptionSalvage))
+		pushDefaultTTL      = app.Flag("push.default-ttl", "Time after the last push to a group after which the group is deleted. Can be overridden per push with the "+handler.TTLHeader+" header. 0 means no expiry.").Default("0s").Duration()
+		pushIncrementGauges = app.Flag("push.increment-allow-gauges", "Allow gauges and untyped metrics in pushes that increment stored values.").Default("false").Bool()
+		pushHistorySize     = app.Flag("push.history-size", "Number of versions kept per group, each recorded upon a successful push, to be inspected via the API. 0 disables the history.").Default("0").Int()
+		pushHistoryMaxAge   = app.Flag("push.history-max-age", "Time after which a version of a group is removed from its history. 0 means no age limit.").Default("0s").Duration()
 		pushUnchecked       = app.Flag("push.disable-consistency-check", "Do not check consistency of pushed metrics. DANGEROUS.").Default("false").Bool()
 		pushUTF8Names       = app.Flag("push.enable-utf8-names", "Allow UTF-8 characters in metric and label names.").Default("false").Bool()
+		nameEscapingScheme  = app.Flag("web.name-escaping-scheme", "Escaping scheme for UTF-8 metric and label names exposed to scrapers that don't negotiate UTF-8 support. Only relevant with --push.enable-utf8-names.").Default(model.EscapeUnderscores).Enum(model.EscapeUnderscores, model.EscapeDots, model.EscapeValues)
+		enableOpenMetrics   = app.Flag("web.enable-openmetrics", "Offer the OpenMetrics text format (which transmits exemplars and units) to scrapers.").Default("false").Bool()
+		createdSamples      = app.Flag("web.enable-openmetrics-created-samples", "Expose created timestamps as _created series in the OpenMetrics text format.").Default("false").Bool()
+		enableRemoteWrite   = app.Flag("web.enable-remote-write-receiver", "Enable the API endpoint accepting Prometheus remote-write requests.").Default("false").Bool()
+		enableOTLP          = app.Flag("web.enable-otlp-receiver", "Enable the endpoint accepting OTLP/HTTP metrics export requests.").Default("false").Bool()
+		authorizationFile   = app.Flag("push.authorization-file", "YAML file with rules mapping credentials to the groups they may push to and delete. If empty, no authorization is performed.").Default("").String()
+		configFile          = app.Flag("config.file", "YAML file with settings overriding the corresponding flags. Reloaded upon SIGHUP or a request to /-/reload.").Default("").String()
+		pushWriteShards     = app.Flag("push.write-shards", "Number of groups whose pushes and deletions are processed concurrently. Pushes to the same group are always processed in order.").Default("1").Int()
+		pushQueueCapacity   = app.Flag("push.write-queue-capacity", "Number of pushes and deletions that can be queued per write shard. Pushes and deletions via the push API are rejected with status code 429 while the queue of their shard is full.").Default(strconv.Itoa(storage.DefaultWriteQueueCapacity)).Int()
+		clusterMembers      = app.Flag("cluster.member", "URL of a member of the cluster, including this Pushgateway. Repeat for multiple members. Each metric group is owned by one member, to which pushes and deletions received by other members are forwarded. If not set, clustering is disabled.").Strings()
+		clusterSelf         = app.Flag("cluster.self", "URL of this Pushgateway, as given by one of the --cluster.member flags.").Default("").String()
+		clusterTLSConfig    = app.Flag("cluster.tls-config-file", "YAML file with the TLS settings, in the format of the tls_config of Prometheus, used to forward requests to other cluster members. Members only trust forwarded requests authenticated by a client certificate configured here. If empty, the default TLS settings are used.").Default("").String()
+		auditFile           = app.Flag("audit.file", "File to write an audit log of pushes, deletions, and wipes to, as JSON lines. If empty, no audit log is written.").Default("").String()
+		auditMaxSize        = app.Flag("audit.max-size", "Size at which the audit log file is rotated. 0 disables rotation.").Default("100MiB").Bytes()
+		auditMaxFiles       = app.Flag("audit.max-files", "Number of rotated audit log files to keep.").Default("5").Int()
+		remoteWriteGrouping = app.Flag("push.remote-write-grouping-label", "Label of remote-written series used for grouping. Repeat for multiple labels. The job label is always used.").Default("job", "instance").Strings()
 		promlogConfig       = promslog.Config{Style: promslog.GoKitStyle}
 	)
+	var limits storage.Limits
+	app.Flag("push.max-series-per-push", "Maximum number of series in a single push. Histograms and summaries count as one series. 0 means no limit.").Default("0").IntVar(&limits.SeriesPerPush)
+	app.Flag("push.max-series-per-group", "Maximum number of series in a group. 0 means no limit.").Default("0").IntVar(&limits.SeriesPerGroup)
+	app.Flag("push.max-series-per-metric-family", "Maximum number of series in a pushed metric family. 0 means no limit.").Default("0").IntVar(&limits.SeriesPerMetricFamily)
+	app.Flag("push.max-series-total", "Maximum number of series in the whole Pushgateway. 0 means no limit.").Default("0").IntVar(&limits.SeriesTotal)
+	app.Flag("push.max-labels-per-series", "Maximum number of labels of a pushed series, including the grouping labels. 0 means no limit.").Default("0").IntVar(&limits.LabelsPerSeries)
+	app.Flag("push.max-label-value-length", "Maximum length in bytes of a label value of a pushed series. 0 means no limit.").Default("0").IntVar(&limits.LabelValueLength)
 	promslogflag.AddFlags(app, &promlogConfig)
 	app.Version(version.Print("pushgateway"))
 	app.HelpFlag.Short('h')
@@ -95,42 +128,158 @@ func main() {
 		}
 	}
 
-	ms := storage.NewDiskMetricStore(*persistenceFile, *persistenceInterval, prometheus.DefaultGatherer, logger)
+	rl := &reloader{
+		configFile:        *configFile,
+		authorizationFile: *authorizationFile,
+		flagDefaults: config.Config{
+			Push: config.PushConfig{
+				DefaultTTL:           model.Duration(*pushDefaultTTL),
+				IncrementAllowGauges: *pushIncrementGauges,
+				Limits:               limits,
+				History: config.HistoryConfig{
+					Size:   *pushHistorySize,
+					MaxAge: model.Duration(*pushHistoryMaxAge),
+				},
+				NameValidationScheme: model.LegacyValidation,
+			},
+			Persistence: config.PersistenceConfig{Interval: model.Duration(*persistenceInterval)},
+		},
+		authorizer: &authz.Dynamic{},
+		logger:     logger,
+	}
+	if *pushUTF8Names {
+		rl.flagDefaults.Push.NameValidationScheme = model.UTF8Validation
+	}
+	cfg, err := rl.load()
+	if err != nil {
+		logger.Error("could not load configuration", "err", err)
+		os.Exit(1)
+	}
+
+	storageOpts := append(
+		cfg.StorageOptions(),
+		storage.WithWriteShards(*pushWriteShards),
+		storage.WithWriteQueueCapacity(*pushQueueCapacity),
+		storage.WithCorruptionPolicy(storage.CorruptionPolicy(*onCorruption)),
+		storage.WithWALSync(*persistenceSyncWAL),
+	)
+	var (
+		ring             *cluster.Ring
+		clusterTransport http.RoundTripper
+	)
+	if len(*clusterMembers) > 0 {
+		if ring, err = cluster.NewRing(*clusterMembers, *clusterSelf); err != nil {
+			logger.Error("invalid cluster configuration", "err", err)
+			os.Exit(1)
+		}
+		if *clusterTLSConfig != "" {
+			tlsConfig, err := cluster.LoadTLSConfig(*clusterTLSConfig)
+			if err != nil {
+				logger.Error("could not load cluster TLS configuration", "err", err)
+				os.Exit(1)
+			}
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = tlsConfig
+			clusterTransport = transport
+		}
+		// Remote-write and OTLP requests might contain groups owned
+		// by different members, which are not forwarded.
+		if *enableRemoteWrite || *enableOTLP {
+			logger.Error("the remote-write and OTLP receivers are not supported with clustering")
+			os.Exit(1)
+		}
+		// Only expose the groups owned by this member so that each
+		// group is scraped from exactly one member.
+		storageOpts = append(storageOpts, storage.WithExposedGroups(ring.Owns))
+		logger.Info("clustering enabled", "self", ring.Self(), "members", ring.Members())
+	}
+	ms := storage.NewDiskMetricStore(
+		*persistenceFile, time.Duration(cfg.Persistence.Interval), prometheus.DefaultGatherer, logger,
+		storageOpts...,
+	)
+	go func() {
+		if err := ms.WaitRestored(); err != nil {
+			logger.Error("could not restore persisted metrics", "err", err)
+			os.Exit(1)
+		}
+	}()
+	rl.start(ms, cfg)
+	go rl.reloadOnSIGHUP()
+
+	var auditLog *audit.Log
+	if *auditFile != "" {
+		if auditLog, err = audit.New(*auditFile, int64(*auditMaxSize), *auditMaxFiles, logger); err != nil {
+			logger.Error("could not open audit log", "err", err)
+			os.Exit(1)
+		}
+	}
 
 	if *pushUTF8Names {
 		handler.EscapingScheme = model.ValueEncodingEscaping
 		handler.ValidationScheme = model.UTF8Validation
+		escapingScheme, err := model.ToEscapingScheme(*nameEscapingScheme)
+		if err != nil {
+			logger.Error("invalid name escaping scheme", "err", err)
+			os.Exit(1)
+		}
+		model.NameEscapingScheme = escapingScheme
 	} else {
 		handler.EscapingScheme = model.NoEscaping
 		handler.ValidationScheme = model.LegacyValidation
 	}
 
-	// Create a Gatherer combining the DefaultGatherer and the metrics from the metric store.
-	g := prometheus.Gatherers{
-		prometheus.DefaultGatherer,
-		prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return ms.GetMetricFamilies(), nil }),
-	}
-
 	r := route.New()
 	r.Get(*routePrefix+"/-/healthy", handler.Healthy(ms).ServeHTTP)
 	r.Get(*routePrefix+"/-/ready", handler.Ready(ms).ServeHTTP)
 	r.Get(
 		path.Join(*routePrefix, *metricsPath),
-		promhttp.HandlerFor(g, promhttp.HandlerOpts{
-			ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError),
-		}).ServeHTTP,
+		// Expose the DefaultGatherer combined with the metrics from the
+		// metric store, whose encoding is cached between changes.
+		handler.ExposeCached(prometheus.DefaultGatherer, ms, handler.ExpositionOptions{
+			EnableOpenMetrics:               *enableOpenMetrics,
+			EnableOpenMetricsCreatedSamples: *createdSamples,
+		}, logger).ServeHTTP,
 	)
 
+	// With a configuration file, authorization rules might be added upon
+	// reload, so the authorizer is needed even without rules for now.
+	var authorizer handler.Authorizer
+	if *authorizationFile != "" || *configFile != "" {
+		authorizer = rl.authorizer
+	}
+	// guard puts the authorization (if configured) and the rejection of
+	// requests during the restore of persisted metrics in front of push and
+	// delete handlers. With clustering, authorized requests for groups owned
+	// by other members are forwarded to them, and requests forwarded by other
+	// members skip authorization and forwarding.
+	guard := func(h func(http.ResponseWriter, *http.Request), jobBase64Encoded bool) func(http.ResponseWriter, *http.Request) {
+		h = handler.RejectDuringRestore(ms, h)
+		local := h
+		if ring != nil {
+			h = handler.Forward(ring, ms, jobBase64Encoded, clusterTransport, h, logger)
+		}
+		if authorizer != nil {
+			h = handler.Authorize(authorizer, ms, jobBase64Encoded, h, auditLog, logger)
+		}
+		if ring != nil {
+			h = handler.AcceptForwarded(ring, local, h, logger)
+		}
+		return h
+	}
+
 	// Handlers for pushing and deleting metrics.
 	pushAPIPath := *routePrefix + "/metrics"
 	for _, suffix := range []string{"", handler.Base64Suffix} {
 		jobBase64Encoded := suffix == handler.Base64Suffix
-		r.Put(pushAPIPath+"/job"+suffix+"/:job/*labels", handler.Push(ms, true, !*pushUnchecked, jobBase64Encoded, logger))
-		r.Post(pushAPIPath+"/job"+suffix+"/:job/*labels", handler.Push(ms, false, !*pushUnchecked, jobBase64Encoded, logger))
-		r.Del(pushAPIPath+"/job"+suffix+"/:job/*labels", handler.Delete(ms, jobBase64Encoded, logger))
-		r.Put(pushAPIPath+"/job"+suffix+"/:job", handler.Push(ms, true, !*pushUnchecked, jobBase64Encoded, logger))
-		r.Post(pushAPIPath+"/job"+suffix+"/:job", handler.Push(ms, false, !*pushUnchecked, jobBase64Encoded, logger))
-		r.Del(pushAPIPath+"/job"+suffix+"/:job", handler.Delete(ms, jobBase64Encoded, logger))
+		r.Put(pushAPIPath+"/job"+suffix+"/:job/*labels", guard(handler.Push(ms, true, !*pushUnchecked, jobBase64Encoded, auditLog, logger), jobBase64Encoded))
+		r.Post(pushAPIPath+"/job"+suffix+"/:job/*labels", guard(handler.Push(ms, false, !*pushUnchecked, jobBase64Encoded, auditLog, logger), jobBase64Encoded))
+		r.Del(pushAPIPath+"/job"+suffix+"/:job/*labels", guard(handler.Delete(ms, jobBase64Encoded, auditLog, logger), jobBase64Encoded))
+		r.Put(pushAPIPath+"/job"+suffix+"/:job", guard(handler.Push(ms, true, !*pushUnchecked, jobBase64Encoded, auditLog, logger), jobBase64Encoded))
+		r.Post(pushAPIPath+"/job"+suffix+"/:job", guard(handler.Push(ms, false, !*pushUnchecked, jobBase64Encoded, auditLog, logger), jobBase64Encoded))
+		r.Del(pushAPIPath+"/job"+suffix+"/:job", guard(handler.Delete(ms, jobBase64Encoded, auditLog, logger), jobBase64Encoded))
+	}
+	if *enableOTLP {
+		r.Post(*routePrefix+"/otlp/v1/metrics", handler.RejectDuringRestore(ms, handler.OTLP(ms, !*pushUnchecked, authorizer, logger)))
 	}
 	r.Get(*routePrefix+"/static/*filepath", handler.Static(asset.Assets, *routePrefix).ServeHTTP)
 
@@ -155,15 +304,21 @@ func main() {
 	if *enableLifeCycle {
 		r.Put(*routePrefix+"/-/quit", quitHandler)
 		r.Post(*routePrefix+"/-/quit", quitHandler)
+		r.Put(*routePrefix+"/-/reload", rl.ServeHTTP)
+		r.Post(*routePrefix+"/-/reload", rl.ServeHTTP)
 	} else {
 		r.Put(*routePrefix+"/-/quit", forbiddenAPINotEnabled)
 		r.Post(*routePrefix+"/-/quit", forbiddenAPINotEnabled)
+		r.Put(*routePrefix+"/-/reload", forbiddenAPINotEnabled)
+		r.Post(*routePrefix+"/-/reload", forbiddenAPINotEnabled)
 	}
 
-	r.Get("/-/quit", func(w http.ResponseWriter, _ *http.Request) {
+	methodNotAllowed := func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		w.Write([]byte("Only POST or PUT requests allowed."))
-	})
+	}
+	r.Get("/-/quit", methodNotAllowed)
+	r.Get("/-/reload", methodNotAllowed)
 
 	mux := http.NewServeMux()
 	mux.Handle("/", decodeRequest(r))
@@ -178,6 +333,9 @@ func main() {
 	}
 
 	apiv1 := api_v1.New(logger, ms, flags, buildInfo)
+	apiv1.Cluster = ring
+	apiv1.Authorizer = authorizer
+	apiv1.AuditLog = auditLog
 
 	apiPath := "/api"
 	if *routePrefix != "/" {
@@ -187,15 +345,21 @@ func main() {
 	av1 := route.New()
 	apiv1.Register(av1)
 	if *enableAdminAPI {
-		av1.Put("/admin/wipe", handler.WipeMetricStore(ms, logger).ServeHTTP)
+		apiv1.RegisterAdmin(av1)
+		av1.Put("/admin/wipe", handler.RejectDuringRestore(ms, handler.WipeMetricStore(ms, auditLog, logger).ServeHTTP))
+	}
+	if *enableRemoteWrite {
+		av1.Post("/write", handler.RejectDuringRestore(ms, handler.RemoteWrite(ms, !*pushUnchecked, *remoteWriteGrouping, authorizer, logger)))
 	}
 
 	mux.Handle(apiPath+"/v1/", http.StripPrefix(apiPath+"/v1", av1))
 
 	server := &http.Server{Handler: mux}
+	// Watch streams would otherwise keep the server from shutting down.
+	server.RegisterOnShutdown(apiv1.CloseWatches)
 
 	go shutdownServerOnQuit(server, quitCh, logger)
-	err := web.ListenAndServe(server, webConfig, logger)
+	err = web.ListenAndServe(server, webConfig, logger)
 
 	// In the case of a graceful shutdown, do not log the error.
 	if err == http.ErrServerClosed {
@@ -207,6 +371,9 @@ func main() {
 	if err := ms.Shutdown(); err != nil {
 		logger.Error("problem shutting down metric storage", "err", err)
 	}
+	if err := auditLog.Close(); err != nil {
+		logger.Error("problem closing audit log", "err", err)
+	}
 }
 
 func decodeRequest(h http.Handler) http.Handler {
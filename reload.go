@@ -0,0 +1,132 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/prometheus/pushgateway/authz"
+	"github.com/prometheus/pushgateway/config"
+	"github.com/prometheus/pushgateway/handler"
+	"github.com/prometheus/pushgateway/storage"
+)
+
+var (
+	configSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "pushgateway_config_last_reload_successful",
+		Help: "Whether the last configuration reload attempt was successful.",
+	})
+	configSuccessTime = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "pushgateway_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload.",
+	})
+)
+
+// reloader loads the configuration and applies it to the running Pushgateway.
+type reloader struct {
+	mtx sync.Mutex // Serializes reloads.
+
+	configFile        string
+	authorizationFile string
+	flagDefaults      config.Config // Without Authorization, which is read from authorizationFile.
+
+	ms         *storage.DiskMetricStore // Nil until set by start.
+	authorizer *authz.Dynamic
+	logger     *slog.Logger
+}
+
+// load reads the authorization file and the configuration file (if set) and
+// returns the resulting Config.
+func (rl *reloader) load() (*config.Config, error) {
+	defaults := rl.flagDefaults
+	if rl.authorizationFile != "" {
+		var err error
+		if defaults.Authorization, err = authz.LoadFile(rl.authorizationFile); err != nil {
+			return nil, err
+		}
+	}
+	if rl.configFile == "" {
+		return &defaults, nil
+	}
+	return config.LoadFile(rl.configFile, defaults)
+}
+
+// start sets the DiskMetricStore the configuration is applied to upon reload
+// and the initial Config, which the DiskMetricStore has to be created with.
+func (rl *reloader) start(ms *storage.DiskMetricStore, cfg *config.Config) {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+
+	rl.ms = ms
+	rl.authorizer.SetConfig(cfg.Authorization)
+	configSuccess.Set(1)
+	configSuccessTime.SetToCurrentTime()
+}
+
+// reload loads the configuration and applies it. If loading fails, the
+// previous configuration stays in effect.
+func (rl *reloader) reload() error {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+
+	cfg, err := rl.load()
+	if err == nil {
+		err = rl.ms.ApplyOptions(cfg.StorageOptions()...)
+	}
+	if err != nil {
+		configSuccess.Set(0)
+		rl.logger.Error("error reloading configuration", "err", err)
+		return err
+	}
+	rl.authorizer.SetConfig(cfg.Authorization)
+	configSuccess.Set(1)
+	configSuccessTime.SetToCurrentTime()
+	rl.logger.Info("configuration reloaded", "file", rl.configFile)
+	return nil
+}
+
+// reloadOnSIGHUP reloads the configuration upon every SIGHUP.
+func (rl *reloader) reloadOnSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		rl.logger.Info("received SIGHUP; reloading configuration...")
+		rl.reload()
+	}
+}
+
+// ServeHTTP handles reload requests via the web service.
+// While the persisted metrics are restored, the configuration cannot be
+// applied, and the request is rejected with http.StatusServiceUnavailable.
+func (rl *reloader) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	err := rl.reload()
+	switch {
+	case errors.Is(err, storage.ErrRestoring):
+		w.Header().Set("Retry-After", strconv.Itoa(int(handler.RestoreRetryAfter.Seconds())))
+		http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusServiceUnavailable)
+	case err != nil:
+		http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+	}
+}
@@ -59,7 +59,9 @@ pushgateway.deleteGroup = function(){
     
     $.ajax({
 	type: 'DELETE',
-	url: 'metrics/job@base64/' + encodeURIComponent(pushgateway.labels['job']) + groupPath,
+	// The labels are those of the stored group, which must not be
+	// relabeled again.
+	url: 'metrics/job@base64/' + encodeURIComponent(pushgateway.labels['job']) + groupPath + '?relabel=false',
 	success: function(data, textStatus, jqXHR) {
 	    pushgateway.panel.remove();
         pushgateway.decreaseDelAllCounter();
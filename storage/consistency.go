@@ -0,0 +1,497 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// consistencyIndex indexes the metric families of all metric groups by name so
+// that checkConsistency only has to look at the metric families affected by a
+// WriteRequest instead of gathering the whole DiskMetricStore. It also keeps
+// track of the total number of series as counted by the Limits. It is
+// protected by the lock of the DiskMetricStore, like the metric groups
+// themselves.
+type consistencyIndex struct {
+	families map[string]*familyIndex // By metric family name.
+	series   int                     // Total series as counted by countSeries.
+}
+
+// familyIndex indexes the metric families of one name across all metric
+// groups.
+type familyIndex struct {
+	types  map[dto.MetricType]int // Number of groups per type.
+	helps  map[string]int         // Number of groups per help string.
+	series map[uint64]int         // Number of series per hash of name and labels.
+	groups map[string]groupEntry  // What each group contributes, by grouping key.
+}
+
+// groupEntry is what the metric family of one metric group contributes to a
+// familyIndex.
+type groupEntry struct {
+	typ    dto.MetricType
+	help   string
+	hashes []uint64
+}
+
+// set indexes the provided MetricFamily as the one of the provided name in the
+// metric group with the provided grouping key, replacing the previously indexed
+// one (if any).
+func (ci *consistencyIndex) set(key, name string, mf *dto.MetricFamily) {
+	ci.remove(key, name)
+	if mf == nil {
+		return
+	}
+	if ci.families == nil {
+		ci.families = map[string]*familyIndex{}
+	}
+	fi, ok := ci.families[name]
+	if !ok {
+		fi = &familyIndex{
+			types:  map[dto.MetricType]int{},
+			helps:  map[string]int{},
+			series: map[uint64]int{},
+			groups: map[string]groupEntry{},
+		}
+		ci.families[name] = fi
+	}
+	e := groupEntry{
+		typ:    mf.GetType(),
+		help:   mf.GetHelp(),
+		hashes: make([]uint64, len(mf.GetMetric())),
+	}
+	for i, m := range mf.GetMetric() {
+		e.hashes[i] = hashSeries(name, m)
+		fi.series[e.hashes[i]]++
+	}
+	fi.types[e.typ]++
+	fi.helps[e.help]++
+	fi.groups[key] = e
+	if name != pushMetricName && name != pushFailedMetricName {
+		ci.series += len(e.hashes)
+	}
+}
+
+// remove removes the metric family of the provided name in the metric group
+// with the provided grouping key from the index.
+func (ci *consistencyIndex) remove(key, name string) {
+	fi, ok := ci.families[name]
+	if !ok {
+		return
+	}
+	e, ok := fi.groups[key]
+	if !ok {
+		return
+	}
+	for _, h := range e.hashes {
+		decrementOrDelete(fi.series, h)
+	}
+	decrementOrDelete(fi.types, e.typ)
+	decrementOrDelete(fi.helps, e.help)
+	delete(fi.groups, key)
+	if name != pushMetricName && name != pushFailedMetricName {
+		ci.series -= len(e.hashes)
+	}
+	if len(fi.groups) == 0 {
+		delete(ci.families, name)
+	}
+}
+
+// removeGroup removes all metric families of the provided metric group from
+// the index.
+func (ci *consistencyIndex) removeGroup(key string, group MetricGroup) {
+	for name := range group.Metrics {
+		ci.remove(key, name)
+	}
+}
+
+// rebuild replaces the content of the index by the metric families of the
+// provided metric groups.
+func (ci *consistencyIndex) rebuild(groups GroupingKeyToMetricGroup) {
+	ci.families, ci.series = nil, 0
+	for key, group := range groups {
+		for name, tmf := range group.Metrics {
+			ci.set(key, name, tmf.GetMetricFamily())
+		}
+	}
+}
+
+// others returns the count of k in the provided counts of a familyIndex,
+// excluding the changed group, which contributes ownK if hasOwn is true.
+func others[K comparable](counts map[K]int, k, ownK K, hasOwn bool) int {
+	n := counts[k]
+	if hasOwn && ownK == k {
+		n--
+	}
+	return n
+}
+
+func decrementOrDelete[K comparable](m map[K]int, k K) {
+	if m[k] <= 1 {
+		delete(m, k)
+		return
+	}
+	m[k]--
+}
+
+// checkConsistency returns the Problems found if applying the provided
+// WriteRequest (which must already be sanitized and resolved) would lead to an
+// inconsistent state of metrics, i.e. if gathering the metrics of the
+// DiskMetricStore together with those of the default registry would fail
+// afterwards. Rather than actually gathering everything, only the metric
+// families affected by the WriteRequest are checked (against the
+// consistencyIndex and the default registry), with the same checks and error
+// messages as prometheus.Gatherers, but without stopping at the first problem:
+//
+//   - The type of a metric family is the same in all groups and in the default
+//     registry.
+//   - The help string of a metric family is the same as in the default registry
+//     (after replacing it by the predefined help string, if any).
+//   - The name of a metric family doesn't collide with the names of the series
+//     of a histogram or summary (like "foo_count" for a summary "foo").
+//   - Each metric has a value of the type of its metric family, valid and
+//     unique label names, label values that are valid UTF-8, and no explicit
+//     quantile label if it is a summary.
+//   - No series has the same name and labels as any other series.
+//
+// Inconsistencies that already exist in metric families not affected by the
+// WriteRequest are not detected.
+//
+// The provided defaults have to be gathered with gatherDefaults. The caller
+// must hold the (read) lock.
+func (dms *DiskMetricStore) checkConsistency(wr WriteRequest, defaults map[string]*dto.MetricFamily) Problems {
+	key := groupingKeyFor(wr.Labels)
+	stored := dms.metricGroups[key].Metrics
+
+	// The metric families of the group after applying the WriteRequest,
+	// and the names of those that change.
+	group := make(map[string]*dto.MetricFamily, len(stored)+len(wr.MetricFamilies)+2)
+	for name, tmf := range stored {
+		if !wr.Replace || name == pushMetricName || name == pushFailedMetricName {
+			group[name] = tmf.GetMetricFamily()
+		}
+	}
+	affected := make([]string, 0, len(wr.MetricFamilies)+2)
+	for name, mf := range wr.MetricFamilies {
+		group[name] = mf
+		if name != pushMetricName && name != pushFailedMetricName {
+			affected = append(affected, name)
+		}
+	}
+	// Mirror processWriteRequest, which overwrites pushed timestamps.
+	group[pushMetricName] = newPushTimestampGauge(wr.Labels, wr.Timestamp)
+	affected = append(affected, pushMetricName)
+	_, pushedFailed := wr.MetricFamilies[pushFailedMetricName]
+	_, storedFailed := stored[pushFailedMetricName]
+	if !storedFailed {
+		group[pushFailedMetricName] = newPushFailedTimestampGauge(wr.Labels, time.Time{})
+	}
+	if pushedFailed || !storedFailed {
+		affected = append(affected, pushFailedMetricName)
+	}
+	sort.Strings(affected)
+
+	c := consistencyCheck{dms: dms, key: key, group: group, defaults: defaults}
+	var problems Problems
+	for _, name := range affected {
+		problems = append(problems, c.checkFamily(name, group[name])...)
+	}
+	return problems
+}
+
+// gatherDefaults gathers the metric families of the default registry by name
+// for checkConsistency. As gathering might take a while, it is done before
+// taking the lock.
+func gatherDefaults() (map[string]*dto.MetricFamily, error) {
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return nil, fmt.Errorf("[from Gatherer #1] %w", err)
+	}
+	defaults := make(map[string]*dto.MetricFamily, len(mfs))
+	for _, mf := range mfs {
+		defaults[mf.GetName()] = mf
+	}
+	return defaults, nil
+}
+
+// consistencyCheck holds the state of one checkConsistency call.
+type consistencyCheck struct {
+	dms      *DiskMetricStore
+	key      string                       // Grouping key of the changed group.
+	group    map[string]*dto.MetricFamily // The changed group after the change.
+	defaults map[string]*dto.MetricFamily // Gathered from the default registry.
+}
+
+// typeOf returns the type of the metric family of the provided name, looking at
+// the changed group, the other groups, and the default registry, in that order.
+// False is returned if there is no such metric family.
+func (c consistencyCheck) typeOf(name string) (dto.MetricType, bool) {
+	if mf, ok := c.group[name]; ok {
+		return mf.GetType(), true
+	}
+	if fi, ok := c.dms.index.families[name]; ok {
+		own, hasOwn := fi.groups[c.key]
+		for t := range fi.types {
+			if others(fi.types, t, own.typ, hasOwn) > 0 {
+				return t, true
+			}
+		}
+	}
+	if mf, ok := c.defaults[name]; ok {
+		return mf.GetType(), true
+	}
+	return 0, false
+}
+
+// checkFamily checks the provided MetricFamily of the provided name, which
+// replaces the one in the changed group, as described for checkConsistency.
+func (c consistencyCheck) checkFamily(name string, mf *dto.MetricFamily) Problems {
+	typ := mf.GetType()
+	//nolint:staticcheck // Validate like client_golang does.
+	if !model.NameValidationScheme.IsValidMetricName(name) {
+		return Problems{newProblem(ProblemInvalidName, name, "invalid metric name %q", name)}
+	}
+	fi := c.dms.index.families[name]
+	var (
+		own    groupEntry
+		hasOwn bool
+	)
+	if fi != nil {
+		own, hasOwn = fi.groups[c.key]
+		// The metric families of all groups are merged into one, so
+		// the metrics of a type different from the merged one show up
+		// as metrics of the wrong type.
+		for t := range fi.types {
+			if t != typ && others(fi.types, t, own.typ, hasOwn) > 0 {
+				problems := make(Problems, len(mf.GetMetric()))
+				for i, m := range mf.GetMetric() {
+					problems[i] = newSeriesProblem(ProblemTypeConflict, name, m, "collected metric %q { %s} is not a %s", name, m, t)
+				}
+				return problems
+			}
+		}
+	}
+
+	var problems Problems
+	dmf, inDefaults := c.defaults[name]
+	if inDefaults {
+		help, predefined := c.dms.predefinedHelp[name]
+		if !predefined {
+			help = mf.GetHelp()
+		}
+		if help != dmf.GetHelp() {
+			problems = append(problems, newProblem(
+				ProblemHelpConflict, name,
+				"gathered metric family %s has help %q but should have %q",
+				name, help, dmf.GetHelp(),
+			))
+		} else if fi != nil && !predefined {
+			for h := range fi.helps {
+				if h != dmf.GetHelp() && others(fi.helps, h, own.help, hasOwn) > 0 {
+					problems = append(problems, newProblem(
+						ProblemHelpConflict, name,
+						"gathered metric family %s has help %q but should have %q",
+						name, h, dmf.GetHelp(),
+					))
+					break
+				}
+			}
+		}
+		if typ != dmf.GetType() {
+			problems = append(problems, newProblem(
+				ProblemTypeConflict, name,
+				"gathered metric family %s has type %s but should have %s",
+				name, typ, dmf.GetType(),
+			))
+		}
+	}
+
+	if p := c.checkSuffixCollisions(name, typ); p != nil {
+		problems = append(problems, p)
+	}
+
+	// Hashes of the series that will be gone, i.e. those of the replaced
+	// metric family in the changed group.
+	var replaced map[uint64]int
+	if hasOwn {
+		replaced = make(map[uint64]int, len(own.hashes))
+		for _, h := range own.hashes {
+			replaced[h]++
+		}
+	}
+	var defaultHashes map[uint64]struct{}
+	if inDefaults {
+		defaultHashes = make(map[uint64]struct{}, len(dmf.GetMetric()))
+		for _, m := range dmf.GetMetric() {
+			defaultHashes[hashSeries(name, m)] = struct{}{}
+		}
+	}
+	hashes := make(map[uint64]struct{}, len(mf.GetMetric()))
+	for _, m := range mf.GetMetric() {
+		if p := checkMetric(name, typ, m); p != nil {
+			problems = append(problems, p)
+			continue
+		}
+		h := hashSeries(name, m)
+		_, inPush := hashes[h]
+		_, inDefault := defaultHashes[h]
+		inOthers := fi != nil && fi.series[h]-replaced[h] > 0
+		if inPush || inDefault || inOthers {
+			problems = append(problems, newSeriesProblem(
+				ProblemDuplicateSeries, name, m,
+				"collected metric %q { %s} was collected before with the same name and label values",
+				name, m,
+			))
+			continue
+		}
+		hashes[h] = struct{}{}
+	}
+	return problems
+}
+
+// checkSuffixCollisions checks for collisions with the “magic” suffixes the
+// Prometheus text format and the internal metric representation of the
+// Prometheus server add while flattening Summaries and Histograms, like
+// prometheus.Gatherers does.
+func (c consistencyCheck) checkSuffixCollisions(name string, typ dto.MetricType) *Problem {
+	for _, suffix := range []string{"_count", "_sum", "_bucket"} {
+		base, ok := strings.CutSuffix(name, suffix)
+		if !ok {
+			continue
+		}
+		switch baseType, _ := c.typeOf(base); baseType {
+		case dto.MetricType_SUMMARY:
+			if suffix != "_bucket" {
+				return newProblem(
+					ProblemNameCollision, name,
+					"collected metric named %q collides with previously collected summary named %q",
+					name, base,
+				)
+			}
+		case dto.MetricType_HISTOGRAM:
+			return newProblem(
+				ProblemNameCollision, name,
+				"collected metric named %q collides with previously collected histogram named %q",
+				name, base,
+			)
+		}
+	}
+	if typ == dto.MetricType_SUMMARY || typ == dto.MetricType_HISTOGRAM {
+		for _, suffix := range []string{"_count", "_sum"} {
+			if _, ok := c.typeOf(name + suffix); ok {
+				return newProblem(
+					ProblemNameCollision, name,
+					"collected histogram or summary named %q collides with previously collected metric named %q",
+					name, name+suffix,
+				)
+			}
+		}
+	}
+	if typ == dto.MetricType_HISTOGRAM {
+		if _, ok := c.typeOf(name + "_bucket"); ok {
+			return newProblem(
+				ProblemNameCollision, name,
+				"collected histogram named %q collides with previously collected metric named %q",
+				name, name+"_bucket",
+			)
+		}
+	}
+	return nil
+}
+
+// checkMetric checks the provided Metric of a metric family with the provided
+// name and type like prometheus.Gatherers does, except for uniqueness.
+func checkMetric(name string, typ dto.MetricType, m *dto.Metric) *Problem {
+	if typ == dto.MetricType_GAUGE && m.Gauge == nil ||
+		typ == dto.MetricType_COUNTER && m.Counter == nil ||
+		typ == dto.MetricType_SUMMARY && m.Summary == nil ||
+		typ == dto.MetricType_HISTOGRAM && m.Histogram == nil ||
+		typ == dto.MetricType_GAUGE_HISTOGRAM && m.Histogram == nil ||
+		typ == dto.MetricType_UNTYPED && m.Untyped == nil {
+		return newSeriesProblem(ProblemInvalidSeries, name, m, "collected metric %q { %s} is not a %s", name, m, typ)
+	}
+	previousLabelName := ""
+	for _, lp := range m.GetLabel() {
+		ln := lp.GetName()
+		if ln == previousLabelName {
+			return newSeriesProblem(
+				ProblemInvalidSeries, name, m,
+				"collected metric %q { %s} has two or more labels with the same name: %s",
+				name, m, ln,
+			)
+		}
+		//nolint:staticcheck // Validate like client_golang does.
+		if !model.NameValidationScheme.IsValidLabelName(ln) || strings.HasPrefix(ln, model.ReservedLabelPrefix) {
+			return newSeriesProblem(
+				ProblemInvalidName, name, m,
+				"collected metric %q { %s} has a label with an invalid name: %s",
+				name, m, ln,
+			)
+		}
+		if m.Summary != nil && ln == model.QuantileLabel {
+			return newSeriesProblem(
+				ProblemInvalidSeries, name, m,
+				"collected metric %q { %s} must not have an explicit %q label",
+				name, m, model.QuantileLabel,
+			)
+		}
+		if !utf8.ValidString(lp.GetValue()) {
+			return newSeriesProblem(
+				ProblemInvalidSeries, name, m,
+				"collected metric %q { %s} has a label named %q whose value is not utf8: %#v",
+				name, m, ln, lp.GetValue(),
+			)
+		}
+		previousLabelName = ln
+	}
+	return nil
+}
+
+var separatorByteSlice = []byte{model.SeparatorByte}
+
+// hashSeries returns the hash of the provided metric name and the labels (and
+// the timestamp, if any) of the provided Metric, as used by prometheus.Gatherers
+// to detect duplicate series.
+func hashSeries(name string, m *dto.Metric) uint64 {
+	lps := m.GetLabel()
+	if !sort.IsSorted(labelPairs(lps)) {
+		lps = append(labelPairs(nil), lps...)
+		sort.Sort(labelPairs(lps))
+	}
+	h := xxhash.New()
+	h.WriteString(name)
+	h.Write(separatorByteSlice)
+	for _, lp := range lps {
+		h.WriteString(lp.GetName())
+		h.Write(separatorByteSlice)
+		h.WriteString(lp.GetValue())
+		h.Write(separatorByteSlice)
+	}
+	if m.TimestampMs != nil {
+		h.WriteString(strconv.FormatInt(m.GetTimestampMs(), 10))
+		h.Write(separatorByteSlice)
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,356 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/promslog"
+	"google.golang.org/protobuf/encoding/prototext"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// checkConsistencyFull is the reference for checkConsistency: It applies the
+// provided WriteRequest to a copy of the metrics of the provided dms and then
+// gathers everything together with the default registry.
+func checkConsistencyFull(dms *DiskMetricStore, wr WriteRequest) error {
+	tdms := &DiskMetricStore{
+		metricGroups:   dms.GetMetricFamiliesMap(),
+		predefinedHelp: dms.predefinedHelp,
+		logger:         promslog.NewNopLogger(),
+	}
+	wr.MetricFamilies = maps.Clone(wr.MetricFamilies)
+	tdms.processWriteRequest(wr)
+	tg := prometheus.Gatherers{
+		prometheus.DefaultGatherer,
+		prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			return tdms.GetMetricFamilies(), nil
+		}),
+	}
+	_, err := tg.Gather()
+	return err
+}
+
+// checkConsistency calls dms.checkConsistency like handleWriteRequest does.
+func checkConsistency(t testing.TB, dms *DiskMetricStore, wr WriteRequest) error {
+	defaults, err := gatherDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dms.lock.RLock()
+	defer dms.lock.RUnlock()
+	return dms.checkConsistency(wr, defaults).orNil()
+}
+
+func parseMFs(t testing.TB, texts ...string) map[string]*dto.MetricFamily {
+	t.Helper()
+	mfs := map[string]*dto.MetricFamily{}
+	for _, text := range texts {
+		mf := &dto.MetricFamily{}
+		if err := prototext.Unmarshal([]byte(text), mf); err != nil {
+			t.Fatal(err)
+		}
+		mfs[mf.GetName()] = mf
+	}
+	return mfs
+}
+
+func TestCheckConsistency(t *testing.T) {
+	const (
+		gauge     = `name: "mf" type: GAUGE metric { gauge { value: 1 } }`
+		counter   = `name: "mf" type: COUNTER metric { counter { value: 1 } }`
+		summary   = `name: "s" type: SUMMARY metric { summary { sample_count: 1 sample_sum: 1 } }`
+		sCount    = `name: "s_count" type: GAUGE metric { gauge { value: 1 } }`
+		sBucket   = `name: "s_bucket" type: GAUGE metric { gauge { value: 1 } }`
+		histogram = `name: "h" type: HISTOGRAM metric { histogram { sample_count: 1 sample_sum: 1 } }`
+		hBucket   = `name: "h_bucket" type: GAUGE metric { gauge { value: 1 } }`
+	)
+	type push struct {
+		job     string
+		replace bool
+		mfs     []string
+	}
+	scenarios := []struct {
+		name        string
+		stored      []push
+		push        push
+		expectedErr bool
+		stricter    bool // The reference doesn't detect the error.
+	}{
+		{
+			name:   "same type in another group",
+			stored: []push{{job: "a", mfs: []string{gauge}}},
+			push:   push{job: "b", mfs: []string{gauge}},
+		},
+		{
+			name:        "other type in another group",
+			stored:      []push{{job: "a", mfs: []string{gauge}}},
+			push:        push{job: "b", mfs: []string{counter}},
+			expectedErr: true,
+		},
+		{
+			name:   "type changed within group",
+			stored: []push{{job: "a", mfs: []string{gauge}}},
+			push:   push{job: "a", mfs: []string{counter}},
+		},
+		{
+			name:        "type changed within group but not in another",
+			stored:      []push{{job: "a", mfs: []string{gauge}}, {job: "b", mfs: []string{gauge}}},
+			push:        push{job: "a", mfs: []string{counter}},
+			expectedErr: true,
+		},
+		{
+			name:        "summary collides with count",
+			stored:      []push{{job: "a", mfs: []string{sCount}}},
+			push:        push{job: "a", mfs: []string{summary}},
+			expectedErr: true,
+		},
+		{
+			name:   "replace removes collision",
+			stored: []push{{job: "a", mfs: []string{sCount}}},
+			push:   push{job: "a", replace: true, mfs: []string{summary}},
+		},
+		{
+			name:        "count collides with summary in another group",
+			stored:      []push{{job: "a", mfs: []string{summary}}},
+			push:        push{job: "b", mfs: []string{sCount}},
+			expectedErr: true,
+		},
+		{
+			name:   "bucket does not collide with summary",
+			stored: []push{{job: "a", mfs: []string{summary}}},
+			push:   push{job: "b", mfs: []string{sBucket}},
+		},
+		{
+			name:        "bucket collides with histogram",
+			stored:      []push{{job: "a", mfs: []string{histogram}}},
+			push:        push{job: "b", mfs: []string{hBucket}},
+			expectedErr: true,
+		},
+		{
+			name:        "histogram collides with bucket",
+			stored:      []push{{job: "a", mfs: []string{hBucket}}},
+			push:        push{job: "b", mfs: []string{histogram}},
+			expectedErr: true,
+		},
+		{
+			name:        "duplicate series",
+			push:        push{job: "a", mfs: []string{`name: "mf" type: GAUGE metric { gauge { value: 1 } } metric { gauge { value: 2 } }`}},
+			expectedErr: true,
+		},
+		{
+			name:        "explicit quantile label",
+			push:        push{job: "a", mfs: []string{`name: "s" type: SUMMARY metric { label { name: "quantile" value: "0.5" } summary { sample_count: 1 } }`}},
+			expectedErr: true,
+		},
+		{
+			name:        "reserved label name",
+			push:        push{job: "a", mfs: []string{`name: "mf" type: GAUGE metric { label { name: "__x" value: "x" } gauge { value: 1 } }`}},
+			expectedErr: true,
+		},
+		{
+			name:        "value of wrong type",
+			push:        push{job: "a", mfs: []string{`name: "mf" type: GAUGE metric { counter { value: 1 } }`}},
+			expectedErr: true,
+		},
+		{
+			name:        "gauge histogram without histogram",
+			push:        push{job: "a", mfs: []string{`name: "gh" type: GAUGE_HISTOGRAM metric { gauge { value: 1 } }`}},
+			expectedErr: true,
+			stricter:    true,
+		},
+		{
+			name: "gauge histogram",
+			push: push{job: "a", mfs: []string{`name: "gh" type: GAUGE_HISTOGRAM metric { histogram { sample_count: 1 sample_sum: 1 } }`}},
+		},
+		{
+			name:        "type conflict with default registry",
+			push:        push{job: "a", mfs: []string{`name: "go_goroutines" type: COUNTER metric { counter { value: 1 } }`}},
+			expectedErr: true,
+		},
+		{
+			name: "help fixed for default registry",
+			push: push{job: "a", mfs: []string{`name: "go_goroutines" help: "wrong" type: GAUGE metric { gauge { value: 1 } }`}},
+		},
+		{
+			name:   "pushed push timestamp is overwritten",
+			stored: []push{{job: "a", mfs: []string{gauge}}},
+			push:   push{job: "b", mfs: []string{`name: "push_time_seconds" type: COUNTER metric { counter { value: 1 } }`}},
+		},
+		{
+			name: "new group's push failure timestamp is overwritten",
+			push: push{job: "a", mfs: []string{`name: "push_failure_time_seconds" type: COUNTER metric { counter { value: 1 } }`}},
+		},
+		{
+			name:        "push failure timestamp of wrong type",
+			stored:      []push{{job: "a", mfs: []string{gauge}}, {job: "b", mfs: []string{gauge}}},
+			push:        push{job: "b", mfs: []string{`name: "push_failure_time_seconds" type: COUNTER metric { counter { value: 1 } }`}},
+			expectedErr: true,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			dms := NewDiskMetricStore("", time.Hour, prometheus.DefaultGatherer, logger)
+			defer dms.Shutdown()
+			for _, p := range s.stored {
+				err := submitAndWait(dms, WriteRequest{
+					Labels:         map[string]string{"job": p.job},
+					Timestamp:      time.Now(),
+					MetricFamilies: parseMFs(t, p.mfs...),
+					Replace:        p.replace,
+				})
+				if err != nil {
+					t.Fatal("Unexpected error:", err)
+				}
+			}
+
+			wr := WriteRequest{
+				Labels:         map[string]string{"job": s.push.job},
+				Timestamp:      time.Now(),
+				MetricFamilies: parseMFs(t, s.push.mfs...),
+				Replace:        s.push.replace,
+			}
+			for _, mf := range wr.MetricFamilies {
+				sanitizeLabels(mf, wr.Labels)
+			}
+			expectedErr := checkConsistencyFull(dms, wr)
+			if (expectedErr != nil) != (s.expectedErr && !s.stricter) {
+				t.Fatalf("Expected error %t from reference, got %v.", s.expectedErr && !s.stricter, expectedErr)
+			}
+			err := checkConsistency(t, dms, wr)
+			if (err != nil) != s.expectedErr {
+				t.Errorf("Expected error %t, got %v.", s.expectedErr, err)
+			}
+			if err != nil && expectedErr != nil && err.Error() != expectedErr.Error() {
+				t.Logf("Error differs from reference: %q vs. %q.", err, expectedErr)
+			}
+		})
+	}
+}
+
+// checkIndex checks that the consistencyIndex of the provided dms matches an
+// index freshly built from its metric groups.
+func checkIndex(t *testing.T, dms *DiskMetricStore, step string) {
+	t.Helper()
+	dms.lock.RLock()
+	defer dms.lock.RUnlock()
+	var expected consistencyIndex
+	expected.rebuild(dms.metricGroups)
+	if len(expected.families) == 0 && len(dms.index.families) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(expected.families, dms.index.families) {
+		t.Errorf("After %s: Expected index %v, got %v.", step, expected.families, dms.index.families)
+	}
+}
+
+func TestConsistencyIndex(t *testing.T) {
+	dms := NewDiskMetricStore("", time.Hour, nil, logger, WithDefaultTTL(time.Minute))
+	defer dms.Shutdown()
+	groupingA := map[string]string{"job": "a"}
+	groupingB := map[string]string{"job": "b"}
+	ts := time.Now()
+
+	steps := []struct {
+		name string
+		wr   WriteRequest
+	}{
+		{"push to a", WriteRequest{Labels: groupingA, MetricFamilies: map[string]*dto.MetricFamily{
+			"mf1": gaugeFamily("mf1", "1", "2"), "mf2": gaugeFamily("mf2", "1"),
+		}}},
+		{"push to b", WriteRequest{Labels: groupingB, MetricFamilies: map[string]*dto.MetricFamily{
+			"mf1": gaugeFamily("mf1", "1"),
+		}}},
+		{"update of a", WriteRequest{Labels: groupingA, MetricFamilies: map[string]*dto.MetricFamily{
+			"mf1": gaugeFamily("mf1", "3"),
+		}}},
+		{"replace of a", WriteRequest{Labels: groupingA, Replace: true, MetricFamilies: map[string]*dto.MetricFamily{
+			"mf3": gaugeFamily("mf3", "1"),
+		}}},
+		{"failed push to a", WriteRequest{Labels: groupingA, MetricFamilies: parseMFs(t,
+			`name: "mf1" type: COUNTER metric { counter { value: 1 } }`,
+		)}},
+		{"failed push to c", WriteRequest{Labels: map[string]string{"job": "c"}, MetricFamilies: parseMFs(t,
+			`name: "mf1" type: COUNTER metric { counter { value: 1 } }`,
+		)}},
+		{"delete of b", WriteRequest{Labels: groupingB}},
+	}
+	for _, s := range steps {
+		s.wr.Timestamp = ts
+		submitAndWait(dms, s.wr)
+		checkIndex(t, dms, s.name)
+	}
+
+	if expected, got := 2, dms.removeExpiredGroups(ts.Add(time.Hour)); expected != got {
+		t.Errorf("Expected %d expired groups, got %d.", expected, got)
+	}
+	checkIndex(t, dms, "expiry")
+	if got := len(dms.index.families); got != 0 {
+		t.Errorf("Expected empty index, got %d metric families.", got)
+	}
+}
+
+func BenchmarkCheckConsistency(b *testing.B) {
+	for _, groups := range []int{100, 1000, 10000} {
+		dms := NewDiskMetricStore("", time.Hour, prometheus.DefaultGatherer, logger)
+		for i := range groups {
+			wr := WriteRequest{
+				Labels:    map[string]string{"job": "job", "instance": fmt.Sprint(i)},
+				Timestamp: time.Now(),
+				MetricFamilies: map[string]*dto.MetricFamily{
+					"mf1": gaugeFamily("mf1", "1", "2", "3"),
+					"mf2": gaugeFamily("mf2", "1", "2", "3"),
+					"mf3": gaugeFamily("mf3", "1", "2", "3"),
+				},
+			}
+			for _, mf := range wr.MetricFamilies {
+				sanitizeLabels(mf, wr.Labels)
+			}
+			dms.processWriteRequest(wr)
+		}
+		wr := WriteRequest{
+			Labels:    map[string]string{"job": "job", "instance": "0"},
+			Timestamp: time.Now(),
+			MetricFamilies: map[string]*dto.MetricFamily{
+				"mf1": gaugeFamily("mf1", "1", "2", "3"),
+			},
+		}
+		for _, mf := range wr.MetricFamilies {
+			sanitizeLabels(mf, wr.Labels)
+		}
+
+		b.Run(fmt.Sprintf("groups=%d/full", groups), func(b *testing.B) {
+			for b.Loop() {
+				if err := checkConsistencyFull(dms, wr); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("groups=%d/indexed", groups), func(b *testing.B) {
+			for b.Loop() {
+				if err := checkConsistency(b, dms, wr); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		dms.Shutdown()
+	}
+}
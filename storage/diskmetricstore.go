@@ -14,21 +14,25 @@
 package storage
 
 import (
-	"encoding/gob"
+	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"maps"
 	"os"
 	"path"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
-	"github.com/prometheus/common/promslog"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
 	"google.golang.org/protobuf/proto"
 
 	dto "github.com/prometheus/client_model/go"
@@ -39,22 +43,113 @@ const (
 	pushMetricHelp       = "Last Unix time when changing this group in the Pushgateway succeeded."
 	pushFailedMetricName = "push_failure_time_seconds"
 	pushFailedMetricHelp = "Last Unix time when changing this group in the Pushgateway failed."
-	writeQueueCapacity   = 1000
+	expiryCheckInterval  = 10 * time.Second
+
+	// DefaultWriteQueueCapacity is the default capacity of each write
+	// queue, see WithWriteQueueCapacity.
+	DefaultWriteQueueCapacity = 1000
 )
 
-var errTimestamp = errors.New("pushed metrics must not have timestamps")
+// ErrTimestamp is the error returned for pushed metrics with timestamps.
+var ErrTimestamp = errors.New("pushed metrics must not have timestamps")
+
+// ErrWriteQueueFull is the error returned by TrySubmitWriteRequest if the
+// WriteRequest cannot be submitted because the write queue is full.
+var ErrWriteQueueFull = errors.New("write queue is full")
 
 // DiskMetricStore is an implementation of MetricStore that persists metrics to
 // disk.
 type DiskMetricStore struct {
-	lock            sync.RWMutex // Protects metricFamilies.
-	writeQueue      chan WriteRequest
+	lock            sync.RWMutex // Protects metricGroups and index.
+	shards          []*writeShard
+	writeShards     int            // Number of shards to create.
+	queueCapacity   int            // Capacity of each shard queue.
+	shardsRunning   sync.WaitGroup // Until all shards are drained.
+	submitAllLock   sync.Mutex     // Serializes submitToAllShards.
+	written         chan struct{}  // Notifies the loop about processed writes.
+	reconfigure     chan reconfiguration
 	drain           chan struct{}
 	done            chan error
 	metricGroups    GroupingKeyToMetricGroup
+	index           consistencyIndex // Indexes metricGroups, protected by lock.
+	generation      atomic.Uint64    // Incremented upon each change of metricGroups.
+	familiesLock    sync.Mutex       // Protects families.
+	families        familiesCache    // Result of GetMetricFamilies.
 	persistenceFile string
+	wal             *wal // Only set if persistenceFile is set.
 	predefinedHelp  map[string]string
-	logger          *slog.Logger
+	restored        chan struct{} // Closed once initialize is complete.
+	restoreErr      error         // Only set if the restore failed with CorruptionFail.
+	restoreRead     atomic.Int64  // Bytes restored so far.
+	restoreTotal    atomic.Int64  // Bytes to restore in total.
+	onCorruption    CorruptionPolicy
+	syncWAL         bool
+	exposed         func(groupingKey string) bool // Nil if all groups are exposed.
+	// The following fields are only changed by the loop goroutine once
+	// it has started, while holding optionsLock, so that they can be
+	// changed by ApplyOptions. The shards hold optionsLock for reading
+	// while processing WriteRequests.
+	optionsLock          sync.RWMutex
+	persistenceInterval  time.Duration
+	defaultTTL           time.Duration
+	incrementGauges      bool
+	limits               Limits
+	relabelConfigs       []*relabel.Config
+	metricRelabelConfigs []*relabel.Config
+	historySize          int
+	historyMaxAge        time.Duration
+
+	watchersLock sync.Mutex // Protects watchers.
+	watchers     map[*watcher]struct{}
+	logger       *slog.Logger
+}
+
+// Option configures optional behavior of a DiskMetricStore upon creation or,
+// with ApplyOptions, while it is running.
+type Option func(*DiskMetricStore)
+
+// WithGaugeIncrements allows gauges and untyped metrics in WriteRequests with
+// Increment set. By default, they are rejected because adding up gauges rarely
+// makes sense.
+func WithGaugeIncrements(allow bool) Option {
+	return func(dms *DiskMetricStore) {
+		dms.incrementGauges = allow
+	}
+}
+
+// WithDefaultTTL sets the time after the last change of a metric group after
+// which the group is deleted automatically. It applies to all groups that were
+// not pushed with an explicit TTL (see WriteRequest). A ttl of zero (the
+// default) means that groups without an explicit TTL never expire.
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(dms *DiskMetricStore) {
+		dms.defaultTTL = ttl
+	}
+}
+
+// WithPersistenceInterval overrides the persistenceInterval passed to
+// NewDiskMetricStore.
+func WithPersistenceInterval(interval time.Duration) Option {
+	return func(dms *DiskMetricStore) {
+		dms.persistenceInterval = interval
+	}
+}
+
+// WithExposedGroups restricts the metric families returned by GetMetricFamilies
+// to those of the groups for which exposed returns true when called with their
+// grouping key. All groups are still taken into account for consistency
+// checks and returned by GetMetricFamiliesMap. exposed must not change its
+// result for a grouping key while the DiskMetricStore is running.
+func WithExposedGroups(exposed func(groupingKey string) bool) Option {
+	return func(dms *DiskMetricStore) {
+		dms.exposed = exposed
+	}
+}
+
+// reconfiguration is a request to apply Options to a running DiskMetricStore.
+type reconfiguration struct {
+	opts    []Option
+	applied chan struct{} // Closed once the Options are applied.
 }
 
 type mfStat struct {
@@ -68,45 +163,118 @@ type mfStat struct {
 // If persistenceFile is the empty string, no persisting to disk will
 // happen. Otherwise, a file of that name is used for persisting metrics to
 // disk. If the file already exists, metrics are read from it as part of the
-// start-up. Persisting is happening upon shutdown and after every write action,
-// but the latter will only happen persistenceDuration after the previous
-// persisting.
+// start-up, which happens asynchronously: NewDiskMetricStore returns at once,
+// but the DiskMetricStore is not Ready, and the read methods do not return the
+// persisted metrics, until the restore is complete. WriteRequests submitted in
+// the meantime are queued and processed after the restore. Persisting is
+// happening upon shutdown and after every write action, but the latter will
+// only happen persistenceDuration after the previous persisting. In between,
+// every processed write request is appended to a write-ahead log (in files
+// named like the persistence file with a ".wal.NNN" suffix), which is replayed
+// during start-up and truncated after each successful persisting.
 //
 // If a non-nil Gatherer is provided, the help strings of metrics gathered by it
 // will be used as standard. Pushed metrics with deviating help strings will be
 // adjusted to avoid inconsistent expositions.
+//
+// Further optional behavior can be configured with the provided Options.
 func NewDiskMetricStore(
 	persistenceFile string,
 	persistenceInterval time.Duration,
 	gatherPredefinedHelpFrom prometheus.Gatherer,
 	logger *slog.Logger,
+	opts ...Option,
 ) *DiskMetricStore {
-	// TODO: Do that outside of the constructor to allow the HTTP server to
-	//  serve /-/healthy and /-/ready earlier.
 	dms := &DiskMetricStore{
-		writeQueue:      make(chan WriteRequest, writeQueueCapacity),
-		drain:           make(chan struct{}),
-		done:            make(chan error),
-		metricGroups:    GroupingKeyToMetricGroup{},
-		persistenceFile: persistenceFile,
-		logger:          logger,
+		writeShards:         1,
+		onCorruption:        CorruptionStartEmpty,
+		queueCapacity:       DefaultWriteQueueCapacity,
+		written:             make(chan struct{}, 1),
+		reconfigure:         make(chan reconfiguration),
+		drain:               make(chan struct{}),
+		done:                make(chan error),
+		metricGroups:        GroupingKeyToMetricGroup{},
+		persistenceFile:     persistenceFile,
+		persistenceInterval: persistenceInterval,
+		restored:            make(chan struct{}),
+		watchers:            map[*watcher]struct{}{},
+		logger:              logger,
+	}
+	for _, opt := range opts {
+		opt(dms)
 	}
-	if err := dms.restore(); err != nil {
-		logger.Error("could not load persisted metrics", "err", err)
+	dms.shards = make([]*writeShard, dms.writeShards)
+	for i := range dms.shards {
+		dms.shards[i] = newWriteShard(i, dms.queueCapacity)
 	}
+	writeQueueCapacity.Set(float64(dms.queueCapacity))
 	if helpStrings, err := extractPredefinedHelpStrings(gatherPredefinedHelpFrom); err == nil {
 		dms.predefinedHelp = helpStrings
 	} else {
 		logger.Error("could not gather metrics for predefined help strings", "err", err)
 	}
 
-	go dms.loop(persistenceInterval)
+	if persistenceFile == "" {
+		// Nothing to restore, so be ready right away.
+		dms.initialize()
+		dms.startShards()
+		go dms.loop()
+		return dms
+	}
+	go func() {
+		dms.initialize()
+		dms.startShards()
+		dms.loop()
+	}()
 	return dms
 }
 
+// ApplyOptions applies the provided Options to the running DiskMetricStore.
+// They take effect for all WriteRequests processed after ApplyOptions has
+// returned. While the persisted metrics are restored, nothing is applied, and
+// an error wrapping ErrRestoring is returned (as by Ready). An error is also
+// returned if the restore has failed or the DiskMetricStore has been shut
+// down.
+func (dms *DiskMetricStore) ApplyOptions(opts ...Option) error {
+	// The loop only receives reconfigurations once the restore has
+	// finished, which may take long.
+	if err := dms.checkRestored(); err != nil {
+		return err
+	}
+	r := reconfiguration{opts: opts, applied: make(chan struct{})}
+	select {
+	case dms.reconfigure <- r:
+		<-r.applied
+		return nil
+	case <-dms.drain:
+		return errors.New("metric store has been shut down")
+	}
+}
+
 // SubmitWriteRequest implements the MetricStore interface.
 func (dms *DiskMetricStore) SubmitWriteRequest(req WriteRequest) {
-	dms.writeQueue <- req
+	s := dms.shardFor(groupingKeyFor(req.Labels))
+	s.length.Inc()
+	s.queue <- req
+}
+
+// TrySubmitWriteRequest implements the MetricStore interface.
+func (dms *DiskMetricStore) TrySubmitWriteRequest(ctx context.Context, req WriteRequest) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if req.batchDelete != nil {
+		return dms.submitToAllShards(req)
+	}
+	s := dms.shardFor(groupingKeyFor(req.Labels))
+	s.length.Inc()
+	select {
+	case s.queue <- req:
+		return nil
+	default:
+		s.length.Dec()
+		return ErrWriteQueueFull
+	}
 }
 
 // Shutdown implements the MetricStore interface.
@@ -123,29 +291,71 @@ func (dms *DiskMetricStore) Healthy() error {
 
 	// A pushgateway that cannot be written to should not be
 	// considered as healthy.
-	if len(dms.writeQueue) == cap(dms.writeQueue) {
-		err := fmt.Errorf("write queue is full")
-		dms.logger.Warn(err.Error())
-		return err
+	for _, s := range dms.shards {
+		if len(s.queue) == cap(s.queue) {
+			dms.logger.Warn(ErrWriteQueueFull.Error())
+			return ErrWriteQueueFull
+		}
 	}
 
 	return nil
 }
 
-// Ready implements the MetricStore interface.
+// Ready implements the MetricStore interface. While the persisted metrics are
+// restored, the returned error wraps ErrRestoring and reports the progress.
 func (dms *DiskMetricStore) Ready() error {
+	if err := dms.checkRestored(); err != nil {
+		return err
+	}
 	return dms.Healthy()
 }
 
-// GetMetricFamilies implements the MetricStore interface.
+// familiesCache is the result of GetMetricFamilies for a generation of the
+// DiskMetricStore.
+type familiesCache struct {
+	generation uint64
+	mfs        []*dto.MetricFamily // Nil if nothing is cached.
+}
+
+// Generation returns a number that changes whenever the stored metrics change.
+// The result of GetMetricFamilies is cached until then. Callers can use the
+// generation to cache things derived from it (like encoded expositions).
+func (dms *DiskMetricStore) Generation() uint64 {
+	return dms.generation.Load()
+}
+
+// GetMetricFamilies implements the MetricStore interface. The result is cached
+// as long as the Generation doesn't change. The returned slice is shared
+// between callers and must therefore not be modified either.
 func (dms *DiskMetricStore) GetMetricFamilies() []*dto.MetricFamily {
 	dms.lock.RLock()
 	defer dms.lock.RUnlock()
 
+	generation := dms.generation.Load()
+	dms.familiesLock.Lock()
+	cache := dms.families
+	dms.familiesLock.Unlock()
+	if cache.mfs != nil && cache.generation == generation {
+		return cache.mfs
+	}
+
+	result := dms.mergeMetricFamilies()
+	dms.familiesLock.Lock()
+	dms.families = familiesCache{generation: generation, mfs: result}
+	dms.familiesLock.Unlock()
+	return result
+}
+
+// mergeMetricFamilies merges the metric families of all groups as described
+// for GetMetricFamilies. The caller must hold the read lock.
+func (dms *DiskMetricStore) mergeMetricFamilies() []*dto.MetricFamily {
 	result := []*dto.MetricFamily{}
 	mfStatByName := map[string]mfStat{}
 
-	for _, group := range dms.metricGroups {
+	for key, group := range dms.metricGroups {
+		if dms.exposed != nil && !dms.exposed(key) {
+			continue
+		}
 		for name, tmf := range group.Metrics {
 			mf := tmf.GetMetricFamily()
 			if mf == nil {
@@ -195,23 +405,70 @@ func (dms *DiskMetricStore) GetMetricFamiliesMap() GroupingKeyToMetricGroup {
 	groupsCopy := make(GroupingKeyToMetricGroup, len(dms.metricGroups))
 	for k, g := range dms.metricGroups {
 		metricsCopy := make(NameToTimestampedMetricFamilyMap, len(g.Metrics))
-		groupsCopy[k] = MetricGroup{Labels: g.Labels, Metrics: metricsCopy}
+		groupsCopy[k] = MetricGroup{Labels: g.Labels, Metrics: metricsCopy, TTL: g.TTL, History: g.History}
 		maps.Copy(metricsCopy, g.Metrics)
 	}
 	return groupsCopy
 }
 
-func (dms *DiskMetricStore) loop(persistenceInterval time.Duration) {
+// GetGroupSummaries implements the MetricStore interface.
+func (dms *DiskMetricStore) GetGroupSummaries() []GroupSummary {
+	dms.lock.RLock()
+	defer dms.lock.RUnlock()
+	summaries := make([]GroupSummary, 0, len(dms.metricGroups))
+	for k, g := range dms.metricGroups {
+		summaries = append(summaries, g.Summary(k))
+	}
+	return summaries
+}
+
+// GetMetricGroup implements the MetricStore interface.
+func (dms *DiskMetricStore) GetMetricGroup(key string) (MetricGroup, bool) {
+	dms.lock.RLock()
+	defer dms.lock.RUnlock()
+	g, ok := dms.metricGroups[key]
+	if !ok {
+		return MetricGroup{}, false
+	}
+	return MetricGroup{Labels: g.Labels, Metrics: maps.Clone(g.Metrics), TTL: g.TTL, History: g.History}, true
+}
+
+// DeleteGroups implements the MetricStore interface.
+func (dms *DiskMetricStore) DeleteGroups(selectors [][]*labels.Matcher, dryRun bool) ([]map[string]string, error) {
+	return dms.deleteBatch(&batchDelete{
+		selectors: selectors,
+		dryRun:    dryRun,
+	})
+}
+
+// DeleteGroupsByKey implements the MetricStore interface.
+func (dms *DiskMetricStore) DeleteGroupsByKey(keys []string) ([]map[string]string, error) {
+	return dms.deleteBatch(&batchDelete{keys: keys})
+}
+
+// deleteBatch submits the provided batchDelete without blocking and waits for
+// it to be processed.
+func (dms *DiskMetricStore) deleteBatch(bd *batchDelete) ([]map[string]string, error) {
+	bd.matched = make(chan []map[string]string, 1)
+	if err := dms.TrySubmitWriteRequest(context.Background(), WriteRequest{Timestamp: time.Now(), batchDelete: bd}); err != nil {
+		return nil, err
+	}
+	return <-bd.matched, nil
+}
+
+func (dms *DiskMetricStore) loop() {
 	lastPersist := time.Now()
 	persistScheduled := false
 	lastWrite := time.Time{}
 	persistDone := make(chan time.Time)
 	var persistTimer *time.Timer
+	expiryTicker := time.NewTicker(expiryCheckInterval)
+	defer expiryTicker.Stop()
 
 	checkPersist := func() {
 		if dms.persistenceFile != "" && !persistScheduled && lastWrite.After(lastPersist) {
 			persistTimer = time.AfterFunc(
-				persistenceInterval-lastWrite.Sub(lastPersist),
+				dms.persistenceInterval-lastWrite.Sub(lastPersist),
 				func() {
 					persistStarted := time.Now()
 					if err := dms.persist(); err != nil {
@@ -228,17 +485,22 @@ func (dms *DiskMetricStore) loop(persistenceInterval time.Duration) {
 
 	for {
 		select {
-		case wr := <-dms.writeQueue:
+		case <-dms.written:
 			lastWrite = time.Now()
-			if dms.checkWriteRequest(wr) {
-				dms.processWriteRequest(wr)
-			} else {
-				dms.setPushFailedTimestamp(wr)
+			checkPersist()
+		case r := <-dms.reconfigure:
+			dms.optionsLock.Lock()
+			for _, opt := range r.opts {
+				opt(dms)
 			}
-			if wr.Done != nil {
-				close(wr.Done)
+			dms.optionsLock.Unlock()
+			close(r.applied)
+		case now := <-expiryTicker.C:
+			if dms.removeExpiredGroups(now) > 0 {
+				lastWrite = now
+				checkPersist()
 			}
-			checkPersist()
+			dms.pruneHistories(now)
 		case lastPersist = <-persistDone:
 			persistScheduled = false
 			checkPersist() // In case something has been written in the meantime.
@@ -247,33 +509,105 @@ func (dms *DiskMetricStore) loop(persistenceInterval time.Duration) {
 			if persistTimer != nil {
 				persistTimer.Stop()
 			}
-			// Now draining...
-			for {
-				select {
-				case wr := <-dms.writeQueue:
-					if dms.checkWriteRequest(wr) {
-						dms.processWriteRequest(wr)
-					} else {
-						dms.setPushFailedTimestamp(wr)
-					}
-				default:
-					dms.done <- dms.persist()
-					return
+			// Now the shards are draining...
+			dms.shardsRunning.Wait()
+			dms.closeWatchers()
+			err := dms.persist()
+			if dms.wal != nil {
+				if walErr := dms.wal.close(); err == nil {
+					err = walErr
 				}
 			}
+			dms.done <- err
+			return
+		}
+	}
+}
+
+// handleWriteRequest relabels, checks, and processes the provided WriteRequest
+// (or only sets the push-failed timestamp if relabeling or the check fails),
+// reports the result to the Done channel of the WriteRequest (if any) and to
+// the watchers, and finally closes the Done channel. A WriteRequest dropped by
+// relabeling is not processed and not reported to the watchers.
+//
+// After relabeling, the lock of the writeShard responsible for the group is
+// held. The checks that depend on other groups (see checkWriteRequest) and the
+// processing happen atomically while holding the write lock. Everything else
+// (including the write to the write-ahead log) happens concurrently with other
+// shards.
+func (dms *DiskMetricStore) handleWriteRequest(wr WriteRequest) {
+	if wr.batchDelete != nil {
+		dms.processWriteRequest(wr)
+		dms.notify(writeRequestEvents(wr, nil)...)
+		return
+	}
+	wr, keep, err := dms.relabel(wr, true)
+	if !keep {
+		if wr.Done != nil {
+			close(wr.Done)
 		}
+		return
+	}
+	s := dms.shardFor(groupingKeyFor(wr.Labels))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var (
+		defaults map[string]*dto.MetricFamily
+		problems Problems
+	)
+	if err == nil {
+		defaults, problems, err = dms.prepareWriteRequest(wr)
+	}
+	var rec walRecord
+	dms.lock.Lock()
+	if err == nil {
+		err = dms.checkWriteRequest(wr, defaults, problems)
+	}
+	if err == nil {
+		dms.applyWriteRequest(wr)
+		rec = newWALRecord(wr)
+	} else {
+		dms.applyPushFailedTimestamp(wr)
+		rec = walRecord{Labels: wr.Labels, Timestamp: wr.Timestamp, TTL: wr.TTL, Failed: true}
+	}
+	dms.lock.Unlock()
+	dms.logToWAL(rec)
+
+	if err != nil && wr.Done != nil {
+		wr.Done <- err
+	}
+	dms.notify(writeRequestEvents(wr, err)...)
+	if wr.Done != nil {
+		close(wr.Done)
 	}
 }
 
+// processWriteRequest processes the provided WriteRequest without any checks
+// and logs it to the write-ahead log.
 func (dms *DiskMetricStore) processWriteRequest(wr WriteRequest) {
 	dms.lock.Lock()
 	defer dms.lock.Unlock()
 
+	if wr.batchDelete != nil {
+		dms.processBatchDelete(wr)
+		return
+	}
+	dms.applyWriteRequest(wr)
+	dms.logToWAL(newWALRecord(wr))
+}
+
+// applyWriteRequest changes the metric groups as requested by the provided
+// WriteRequest, which must not be a batch delete. The caller must hold the write
+// lock.
+func (dms *DiskMetricStore) applyWriteRequest(wr WriteRequest) {
 	key := groupingKeyFor(wr.Labels)
+	dms.generation.Add(1)
 
 	if wr.MetricFamilies == nil {
 		// No MetricFamilies means delete request. Delete the whole
 		// metric group, and we are done here.
+		dms.index.removeGroup(key, dms.metricGroups[key])
 		delete(dms.metricGroups, key)
 		return
 	}
@@ -284,13 +618,13 @@ func (dms *DiskMetricStore) processWriteRequest(wr WriteRequest) {
 			Labels:  wr.Labels,
 			Metrics: NameToTimestampedMetricFamilyMap{},
 		}
-		dms.metricGroups[key] = group
 	} else if wr.Replace {
 		// For replace, we have to delete all metric families in the
 		// group except pre-existing push timestamps.
 		for name := range group.Metrics {
 			if name != pushMetricName && name != pushFailedMetricName {
 				delete(group.Metrics, name)
+				dms.index.remove(key, name)
 			}
 		}
 	}
@@ -305,20 +639,133 @@ func (dms *DiskMetricStore) processWriteRequest(wr WriteRequest) {
 			Timestamp:            wr.Timestamp,
 			GobbableMetricFamily: (*GobbableMetricFamily)(mf),
 		}
+		dms.index.set(key, name, mf)
+	}
+	// The TTL of the latest successful push is the one that counts.
+	group.TTL = wr.TTL
+	group.History = dms.recordVersion(group.History, wr.Timestamp, group.Metrics)
+	dms.metricGroups[key] = group
+}
+
+// processBatchDelete deletes all metric groups matching the batchDelete of the
+// provided WriteRequest (unless it is a dry run) and sends their grouping
+// labels to the batchDelete's matched channel. The caller must hold the write
+// lock. The whole batch is logged as a single record to the write-ahead log so
+// that it is also replayed atomically.
+func (dms *DiskMetricStore) processBatchDelete(wr WriteRequest) {
+	var keys []string
+	if wr.batchDelete.keys != nil {
+		for _, key := range wr.batchDelete.keys {
+			if _, ok := dms.metricGroups[key]; ok {
+				keys = append(keys, key)
+			}
+		}
+	} else {
+		for key, group := range dms.metricGroups {
+			if group.Matches(wr.batchDelete.selectors) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	keys = slices.Compact(keys)
+	matched := make([]map[string]string, len(keys))
+	for i, key := range keys {
+		matched[i] = dms.metricGroups[key].Labels
+		if !wr.batchDelete.dryRun {
+			dms.index.removeGroup(key, dms.metricGroups[key])
+			delete(dms.metricGroups, key)
+		}
+	}
+	if !wr.batchDelete.dryRun && len(matched) > 0 {
+		dms.generation.Add(1)
+		dms.logToWAL(walRecord{Timestamp: wr.Timestamp, DeletedGroups: matched})
+		wr.batchDelete.deleted = matched
+	}
+	wr.batchDelete.matched <- matched
+}
+
+// logToWAL appends the provided record to the write-ahead log, if there is
+// one. The caller must hold the write lock or the lock of the writeShard
+// responsible for the changed group so that the order of the records for each
+// group matches the order of the changes. (Records for different groups can be
+// replayed in any order.)
+func (dms *DiskMetricStore) logToWAL(rec walRecord) {
+	if dms.wal == nil {
+		return
+	}
+	if err := dms.wal.log(rec); err != nil {
+		dms.logger.Error("error writing to write-ahead log", "err", err)
+	}
+}
+
+// removeExpiredGroups deletes all metric groups whose TTL has passed at the
+// provided time. The age of a group is measured from the timestamp of its
+// push_time_seconds metric family, i.e. from the last successful push (or from
+// the creation of the group if there has never been a successful push). The
+// number of deleted groups is returned, and the deletions are reported to the
+// watchers.
+func (dms *DiskMetricStore) removeExpiredGroups(now time.Time) int {
+	// Hold the locks of all shards so that no record for an expired
+	// group can be logged to the WAL after its deletion.
+	for _, s := range dms.shards {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+	}
+	dms.lock.Lock()
+	defer dms.lock.Unlock()
+
+	var expired []Event
+	for key, group := range dms.metricGroups {
+		ttl := group.TTL
+		if ttl == 0 {
+			ttl = dms.defaultTTL
+		}
+		if ttl <= 0 {
+			continue
+		}
+		tmf, ok := group.Metrics[pushMetricName]
+		if !ok {
+			continue
+		}
+		if now.Sub(tmf.Timestamp) >= ttl {
+			dms.index.removeGroup(key, group)
+			delete(dms.metricGroups, key)
+			dms.logToWAL(walRecord{Labels: group.Labels, Timestamp: now, Delete: true})
+			expired = append(expired, Event{Labels: group.Labels, Operation: OperationExpire, Timestamp: now})
+			dms.logger.Debug("metric group expired", "labels", group.Labels, "ttl", ttl)
+		}
+	}
+	if len(expired) > 0 {
+		dms.generation.Add(1)
 	}
+	dms.notify(expired...)
+	return len(expired)
 }
 
+// setPushFailedTimestamp sets the push-failed timestamp of the group of the
+// provided WriteRequest and logs that to the write-ahead log.
 func (dms *DiskMetricStore) setPushFailedTimestamp(wr WriteRequest) {
 	dms.lock.Lock()
 	defer dms.lock.Unlock()
 
+	dms.applyPushFailedTimestamp(wr)
+	dms.logToWAL(walRecord{Labels: wr.Labels, Timestamp: wr.Timestamp, TTL: wr.TTL, Failed: true})
+}
+
+// applyPushFailedTimestamp sets the push-failed timestamp of the group of the
+// provided WriteRequest to its timestamp, creating the group if needed. The
+// caller must hold the write lock.
+func (dms *DiskMetricStore) applyPushFailedTimestamp(wr WriteRequest) {
 	key := groupingKeyFor(wr.Labels)
+	dms.generation.Add(1)
 
 	group, ok := dms.metricGroups[key]
 	if !ok {
 		group = MetricGroup{
 			Labels:  wr.Labels,
 			Metrics: NameToTimestampedMetricFamilyMap{},
+			TTL:     wr.TTL,
 		}
 		dms.metricGroups[key] = group
 	}
@@ -327,6 +774,7 @@ func (dms *DiskMetricStore) setPushFailedTimestamp(wr WriteRequest) {
 		Timestamp:            wr.Timestamp,
 		GobbableMetricFamily: (*GobbableMetricFamily)(newPushFailedTimestampGauge(wr.Labels, wr.Timestamp)),
 	}
+	dms.index.set(key, pushFailedMetricName, group.Metrics[pushFailedMetricName].GetMetricFamily())
 	// Only add a zero push metric if none is there yet, so that a
 	// previously added push timestamp is retained.
 	if _, ok := group.Metrics[pushMetricName]; !ok {
@@ -334,65 +782,134 @@ func (dms *DiskMetricStore) setPushFailedTimestamp(wr WriteRequest) {
 			Timestamp:            wr.Timestamp,
 			GobbableMetricFamily: (*GobbableMetricFamily)(newPushTimestampGauge(wr.Labels, time.Time{})),
 		}
+		dms.index.set(key, pushMetricName, group.Metrics[pushMetricName].GetMetricFamily())
 	}
 }
 
-// checkWriteRequest return if applying the provided WriteRequest will result in
-// a consistent state of metrics. The dms is not modified by the check. However,
-// the WriteRequest _will_ be sanitized: the MetricFamilies are ensured to
-// contain the grouping Labels after the check. If false is returned, the
-// causing error is written to the Done channel of the WriteRequest.
+// prepareWriteRequest prepares the provided WriteRequest for checkWriteRequest
+// and returns the first error preventing that. The dms is not modified.
+// However, the WriteRequest _will_ be sanitized: the MetricFamilies are ensured
+// to contain the grouping Labels afterwards. If the WriteRequest is an
+// increment, the MetricFamilies are replaced by the sum of the stored and the
+// pushed values so that the WriteRequest can be processed like a normal,
+// non-replacing update afterwards. Similarly, if the WriteRequest is a merge,
+// the MetricFamilies are replaced by the result of merging them into the
+// stored MetricFamilies. Finally, the metric families of the default registry
+// are gathered and returned if the consistency check is needed.
+//
+// Pushed timestamps are removed (after sanitizing) and returned as Problems of
+// kind ProblemTimestamp so that checkWriteRequest can report them together with
+// all other Problems. So is an exceeded limit on the pushed series, which is
+// checked before resolving increments and merges (see checkPushLimits). Any
+// other failure to prepare the WriteRequest is returned as an error (which then
+// also contains those Problems), and the WriteRequest must not be checked any
+// further.
 //
-// Special case: If the WriteRequest has no Done channel set, the (expensive)
-// consistency check is skipped. The WriteRequest is still sanitized, and the
-// presence of timestamps still results in returning false.
-func (dms *DiskMetricStore) checkWriteRequest(wr WriteRequest) bool {
+// As the stored MetricFamilies of the group are used, the caller must hold the
+// lock of the writeShard responsible for the group until the WriteRequest has
+// been processed.
+func (dms *DiskMetricStore) prepareWriteRequest(wr WriteRequest) (map[string]*dto.MetricFamily, Problems, error) {
 	if wr.MetricFamilies == nil {
 		// Delete request cannot create inconsistencies, and nothing has
 		// to be sanitized.
-		return true
+		return nil, nil, nil
 	}
 
-	var err error
-	defer func() {
-		if err != nil && wr.Done != nil {
-			wr.Done <- err
+	for _, mf := range wr.MetricFamilies {
+		sanitizeLabels(mf, wr.Labels)
+	}
+	problems := stripTimestamps(wr.MetricFamilies)
+	if err := dms.checkPushLimits(wr); err != nil {
+		problems = append(problems, problemFor(err))
+	}
+	if wr.Increment {
+		if err := dms.resolveIncrement(wr); err != nil {
+			return nil, nil, problems.and(err)
 		}
-	}()
+	}
+	if wr.Merge {
+		if err := dms.resolveMerge(wr); err != nil {
+			return nil, nil, problems.and(err)
+		}
+	}
 
-	if timestampsPresent(wr.MetricFamilies) {
-		err = errTimestamp
-		return false
+	// Without Done channel, don't do the consistency check.
+	if wr.Done == nil || wr.Unchecked {
+		return nil, problems, nil
 	}
-	for _, mf := range wr.MetricFamilies {
-		sanitizeLabels(mf, wr.Labels)
+	defaults, err := gatherDefaults()
+	if err != nil {
+		return nil, nil, problems.and(err)
 	}
+	return defaults, problems, nil
+}
 
-	// Without Done channel, don't do the expensive consistency check.
-	if wr.Done == nil {
-		return true
+// checkWriteRequest returns nil if applying the provided WriteRequest, as
+// prepared by prepareWriteRequest, will result in a consistent state of metrics
+// within the Limits of the dms and if there are no Problems found by
+// prepareWriteRequest (provided as problems). Otherwise, it returns Problems
+// listing the provided problems followed by all problems found by the checks.
+// The consistency check is only performed if the metric families of the
+// default registry have been returned by prepareWriteRequest.
+//
+// Special case: If the WriteRequest has no Done channel set (or is Unchecked),
+// prepareWriteRequest doesn't gather the default registry, and the consistency
+// check is skipped. Pushed timestamps and exceeded Limits still result in an
+// error.
+//
+// As other groups are taken into account, the caller must hold the write lock
+// until the WriteRequest has been processed.
+func (dms *DiskMetricStore) checkWriteRequest(wr WriteRequest, defaults map[string]*dto.MetricFamily, problems Problems) error {
+	if wr.MetricFamilies == nil {
+		return nil
 	}
+	if err := dms.checkStoreLimits(wr); err != nil {
+		problems = append(problems, problemFor(err))
+	}
+	if defaults != nil {
+		problems = append(problems, dms.checkConsistency(wr, defaults)...)
+	}
+	return problems.orNil()
+}
+
+// resolveIncrement replaces the MetricFamilies in the provided WriteRequest by
+// the sum of them and the stored MetricFamilies of the same name in the same
+// group. The MetricFamilies in the WriteRequest must already be sanitized. If
+// an error is returned, the WriteRequest might have been partially resolved.
+func (dms *DiskMetricStore) resolveIncrement(wr WriteRequest) error {
+	if wr.Replace {
+		return errors.New("increment and replace are mutually exclusive")
+	}
+	dms.lock.RLock()
+	defer dms.lock.RUnlock()
 
-	// Construct a test dms, acting on a copy of the metrics, to test the
-	// WriteRequest with.
-	tdms := &DiskMetricStore{
-		metricGroups:   dms.GetMetricFamiliesMap(),
-		predefinedHelp: dms.predefinedHelp,
-		logger:         promslog.NewNopLogger(),
+	stored := dms.metricGroups[groupingKeyFor(wr.Labels)].Metrics
+	for name, mf := range wr.MetricFamilies {
+		sum, err := incrementMetricFamily(stored[name].GetMetricFamily(), mf, dms.incrementGauges)
+		if err != nil {
+			return err
+		}
+		wr.MetricFamilies[name] = sum
 	}
-	tdms.processWriteRequest(wr)
+	return nil
+}
 
-	// Construct a test Gatherer to check if consistent gathering is possible.
-	tg := prometheus.Gatherers{
-		prometheus.DefaultGatherer,
-		prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
-			return tdms.GetMetricFamilies(), nil
-		}),
+// resolveMerge replaces the MetricFamilies in the provided WriteRequest by the
+// result of merging them into the stored MetricFamilies of the same name in
+// the same group. The MetricFamilies in the WriteRequest must already be
+// sanitized.
+func (dms *DiskMetricStore) resolveMerge(wr WriteRequest) error {
+	if wr.Replace || wr.Increment {
+		return errors.New("merge cannot be combined with replace or increment")
 	}
-	if _, err = tg.Gather(); err != nil {
-		return false
+	dms.lock.RLock()
+	defer dms.lock.RUnlock()
+
+	stored := dms.metricGroups[groupingKeyFor(wr.Labels)].Metrics
+	for name, mf := range wr.MetricFamilies {
+		wr.MetricFamilies[name] = mergeMetricFamily(stored[name].GetMetricFamily(), mf)
 	}
-	return true
+	return nil
 }
 
 func (dms *DiskMetricStore) persist() error {
@@ -401,6 +918,11 @@ func (dms *DiskMetricStore) persist() error {
 	if dms.persistenceFile == "" {
 		return nil
 	}
+	if dms.restoreErr != nil {
+		// Never overwrite a persistence file that could not be
+		// restored.
+		return fmt.Errorf("not persisting metrics: %w", dms.restoreErr)
+	}
 	f, err := os.CreateTemp(
 		path.Dir(dms.persistenceFile),
 		path.Base(dms.persistenceFile)+".in_progress.",
@@ -409,10 +931,16 @@ func (dms *DiskMetricStore) persist() error {
 		return err
 	}
 	inProgressFileName := f.Name()
-	e := gob.NewEncoder(f)
 
 	dms.lock.RLock()
-	err = e.Encode(dms.metricGroups)
+	err = writeSnapshot(f, dms.metricGroups)
+	// While we still hold the lock, no changes can happen. Thus, all
+	// changes logged to the WAL so far are contained in the snapshot, and
+	// we can start a new WAL segment for all changes to come.
+	var walSeq uint64
+	if err == nil && dms.wal != nil {
+		walSeq, err = dms.wal.cut()
+	}
 	dms.lock.RUnlock()
 	if err != nil {
 		f.Close()
@@ -423,23 +951,115 @@ func (dms *DiskMetricStore) persist() error {
 		os.Remove(inProgressFileName)
 		return err
 	}
-	return os.Rename(inProgressFileName, dms.persistenceFile)
+	if err := os.Rename(inProgressFileName, dms.persistenceFile); err != nil {
+		return err
+	}
+	if dms.wal != nil {
+		return dms.wal.removeBefore(walSeq)
+	}
+	return nil
 }
 
-func (dms *DiskMetricStore) restore() error {
+// restore loads the persisted metrics from the persistence file. A corrupted
+// persistence file is dealt with according to dms.onCorruption. The
+// returned bool is true if the persistence file has to be rewritten because it
+// is in the legacy format or was corrupted.
+func (dms *DiskMetricStore) restore() (bool, error) {
 	if dms.persistenceFile == "" {
-		return nil
+		return false, nil
 	}
 	f, err := os.Open(dms.persistenceFile)
 	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return false, err
+	}
+	// Decode into a separate map so that reads during the restore don't
+	// race with it.
+	metricGroups, legacy, err := readPersistenceFile(f, fi.Size(), dms.onCorruption == CorruptionSalvage, dms.addRestoreProgress)
+	f.Close()
+	if pathErr := (*fs.PathError)(nil); errors.As(err, &pathErr) {
+		// Reading the file failed, which says nothing about its content.
+		return false, err
+	}
+	rewrite := legacy
+	if err != nil {
+		if dms.onCorruption == CorruptionFail {
+			return false, fmt.Errorf("corrupted persistence file %s: %w", dms.persistenceFile, err)
+		}
+		kept := dms.persistenceFile + corruptedFileSuffix
+		if renameErr := os.Rename(dms.persistenceFile, kept); renameErr != nil {
+			dms.logger.Error("could not keep corrupted persistence file", "err", renameErr)
+			kept = ""
+		}
+		if metricGroups == nil {
+			metricGroups = GroupingKeyToMetricGroup{}
+		}
+		dms.logger.Warn(
+			"corrupted persistence file",
+			"file", dms.persistenceFile, "kept_as", kept, "policy", dms.onCorruption,
+			"restored_groups", len(metricGroups), "err", err,
+		)
+		rewrite = true
+	} else if legacy {
+		dms.logger.Info("migrating persistence file from legacy format", "file", dms.persistenceFile)
+	}
+	dms.lock.Lock()
+	dms.metricGroups = metricGroups
+	dms.index.rebuild(metricGroups)
+	dms.generation.Add(1)
+	dms.lock.Unlock()
+	return rewrite, nil
+}
+
+// startWAL replays all existing WAL segments on top of the restored metrics
+// and then starts a new segment. It must be called after restore and before
+// any write request is processed.
+func (dms *DiskMetricStore) startWAL() error {
+	if dms.persistenceFile == "" {
 		return nil
 	}
+	prefix := dms.persistenceFile + walSegmentInfix
+	seqs, err := walSegments(prefix)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	d := gob.NewDecoder(f)
-	if err := d.Decode(&dms.metricGroups); err != nil {
+	var nextSeq uint64
+	for _, seq := range seqs {
+		fileName := walSegmentName(prefix, seq)
+		replayed := 0
+		err := readWALSegment(fileName, func(rec walRecord) {
+			switch {
+			case rec.DeletedGroups != nil:
+				for _, groupingLabels := range rec.DeletedGroups {
+					dms.processWriteRequest(WriteRequest{Labels: groupingLabels, Timestamp: rec.Timestamp})
+				}
+			case rec.Failed:
+				dms.setPushFailedTimestamp(rec.writeRequest())
+			default:
+				dms.processWriteRequest(rec.writeRequest())
+			}
+			replayed++
+		})
+		if err != nil {
+			// Most likely the last record was only partially
+			// written during a crash. Continue with what we have.
+			dms.logger.Warn("incomplete write-ahead log segment", "file", fileName, "replayed_records", replayed, "err", err)
+		} else {
+			dms.logger.Debug("write-ahead log segment replayed", "file", fileName, "replayed_records", replayed)
+		}
+		if fi, err := os.Stat(fileName); err == nil {
+			dms.addRestoreProgress(fi.Size())
+		}
+		nextSeq = seq + 1
+	}
+	if dms.wal, err = openWAL(dms.persistenceFile, nextSeq, dms.syncWAL); err != nil {
 		return err
 	}
 	return nil
@@ -450,10 +1070,18 @@ func copyMetricFamily(mf *dto.MetricFamily) *dto.MetricFamily {
 		Name:   mf.Name,
 		Help:   mf.Help,
 		Type:   mf.Type,
+		Unit:   mf.Unit,
 		Metric: append([]*dto.Metric{}, mf.Metric...),
 	}
 }
 
+// GroupingKeyFor returns the grouping key of the metric group with the provided
+// grouping labels as stored, i.e. without relabeling (unlike
+// DiskMetricStore.GroupingKey).
+func GroupingKeyFor(labels map[string]string) string {
+	return groupingKeyFor(labels)
+}
+
 // groupingKeyFor creates a grouping key from the provided map of grouping
 // labels. The grouping key is created by joining all label names and values
 // together with model.SeparatorByte as a separator. The label names are sorted
@@ -576,16 +1204,22 @@ metric:
 	}
 }
 
-// Checks if any timestamps have been specified.
-func timestampsPresent(metricFamilies map[string]*dto.MetricFamily) bool {
-	for _, mf := range metricFamilies {
-		for _, m := range mf.GetMetric() {
-			if m.TimestampMs != nil {
-				return true
+// stripTimestamps removes the timestamps from the provided metric families and
+// returns a Problem for each series that had one, sorted by metric family name.
+func stripTimestamps(metricFamilies map[string]*dto.MetricFamily) Problems {
+	var problems Problems
+	for _, name := range slices.Sorted(maps.Keys(metricFamilies)) {
+		for _, m := range metricFamilies[name].GetMetric() {
+			if m.TimestampMs == nil {
+				continue
 			}
+			p := newSeriesProblem(ProblemTimestamp, name, m, "%s: collected metric %q { %s}", ErrTimestamp, name, m)
+			p.err = ErrTimestamp
+			problems = append(problems, p)
+			m.TimestampMs = nil
 		}
 	}
-	return false
+	return problems
 }
 
 // labelPairs implements sort.Interface. It provides a sortable version of a
@@ -14,10 +14,12 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"os"
 	"path"
+	"reflect"
 	"sort"
 	"testing"
 	"time"
@@ -27,6 +29,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/promslog"
+	"github.com/prometheus/prometheus/model/labels"
 
 	dto "github.com/prometheus/client_model/go"
 
@@ -717,6 +720,7 @@ func TestAddDeletePersistRestore(t *testing.T) {
 	defer os.RemoveAll(tempDir)
 	fileName := path.Join(tempDir, "persistence")
 	dms := NewDiskMetricStore(fileName, 100*time.Millisecond, nil, logger)
+	<-dms.restored
 
 	// Submit a single simple metric family.
 	ts1 := time.Now()
@@ -850,6 +854,7 @@ func TestAddDeletePersistRestore(t *testing.T) {
 
 	// Load it again.
 	dms = NewDiskMetricStore(fileName, 100*time.Millisecond, nil, logger)
+	<-dms.restored
 	if err := checkMetricFamilies(
 		dms, mf1a, mf2, mf3, mf5, mfHist,
 		pushTimestamp, pushFailedTimestamp,
@@ -1074,8 +1079,8 @@ func TestRejectTimestamps(t *testing.T) {
 	})
 	var err error
 	for err = range errCh {
-		if err != errTimestamp {
-			t.Errorf("Expected error %q, got %q.", errTimestamp, err)
+		if !errors.Is(err, ErrTimestamp) {
+			t.Errorf("Expected error %q, got %q.", ErrTimestamp, err)
 		}
 	}
 	if err == nil {
@@ -1277,8 +1282,8 @@ func TestReplace(t *testing.T) {
 	})
 	var err error
 	for err = range errCh {
-		if err != errTimestamp {
-			t.Errorf("Expected error %q, got %q.", errTimestamp, err)
+		if !errors.Is(err, ErrTimestamp) {
+			t.Errorf("Expected error %q, got %q.", ErrTimestamp, err)
 		}
 	}
 	if err == nil {
@@ -1349,8 +1354,8 @@ func TestReplace(t *testing.T) {
 	})
 	err = nil
 	for err = range errCh {
-		if err != errTimestamp {
-			t.Errorf("Expected error %q, got %q.", errTimestamp, err)
+		if !errors.Is(err, ErrTimestamp) {
+			t.Errorf("Expected error %q, got %q.", ErrTimestamp, err)
 		}
 	}
 	if err == nil {
@@ -1400,6 +1405,7 @@ func TestGetMetricFamiliesMap(t *testing.T) {
 	fileName := path.Join(tempDir, "persistence")
 
 	dms := NewDiskMetricStore(fileName, 100*time.Millisecond, nil, logger)
+	<-dms.restored
 
 	labels1 := map[string]string{
 		"job":      "job1",
@@ -1468,6 +1474,29 @@ func TestGetMetricFamiliesMap(t *testing.T) {
 	if err := checkMetricFamilyGroups(dms, expectedMFMap); err != nil {
 		t.Error(err)
 	}
+
+	// The summaries and single groups are consistent with the map.
+	groups := dms.GetMetricFamiliesMap()
+	summaries := dms.GetGroupSummaries()
+	if expected, got := len(groups), len(summaries); expected != got {
+		t.Errorf("Expected %d group summaries, got %d.", expected, got)
+	}
+	for _, summary := range summaries {
+		group := groups[summary.Key]
+		if expected := group.Summary(summary.Key); !reflect.DeepEqual(expected, summary) {
+			t.Errorf("Expected summary %v, got %v.", expected, summary)
+		}
+		got, ok := dms.GetMetricGroup(summary.Key)
+		if !ok || !reflect.DeepEqual(group, got) {
+			t.Errorf("Expected group %v, got %v.", group, got)
+		}
+	}
+	if summary := groups[gk2].Summary(gk2); summary.LastPushTime.Unix() != ts2.Unix() || !summary.LastPushSuccess || summary.PushedMetricFamilies != 2 {
+		t.Errorf("Unexpected summary %v.", summary)
+	}
+	if _, ok := dms.GetMetricGroup("missing"); ok {
+		t.Error("Expected no group for a missing key.")
+	}
 }
 
 func TestHelpStringFix(t *testing.T) {
@@ -1535,6 +1564,339 @@ func TestHelpStringFix(t *testing.T) {
 
 }
 
+func TestExpiry(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "diskmetricstore.TestExpiry.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	fileName := path.Join(tempDir, "persistence")
+	dms := NewDiskMetricStore(fileName, 100*time.Millisecond, nil, logger, WithDefaultTTL(time.Minute))
+	<-dms.restored
+
+	ts1 := time.Now()
+	grouping1 := map[string]string{
+		"job":      "job1",
+		"instance": "instance1",
+	}
+	grouping2 := map[string]string{
+		"job":      "job1",
+		"instance": "instance2",
+	}
+	grouping3 := map[string]string{
+		"job": "job3",
+	}
+	// grouping1 uses the default TTL.
+	errCh := make(chan error, 1)
+	dms.SubmitWriteRequest(WriteRequest{
+		Labels:         grouping1,
+		Timestamp:      ts1,
+		MetricFamilies: testutil.MetricFamiliesMap(mf3),
+		Done:           errCh,
+	})
+	for err := range errCh {
+		t.Fatal("Unexpected error:", err)
+	}
+	// grouping2 overrides the default TTL with a longer one.
+	errCh = make(chan error, 1)
+	dms.SubmitWriteRequest(WriteRequest{
+		Labels:         grouping2,
+		Timestamp:      ts1,
+		MetricFamilies: testutil.MetricFamiliesMap(mf1b),
+		TTL:            time.Hour,
+		Done:           errCh,
+	})
+	for err := range errCh {
+		t.Fatal("Unexpected error:", err)
+	}
+	// grouping3 only sees a failed push.
+	errCh = make(chan error, 1)
+	dms.SubmitWriteRequest(WriteRequest{
+		Labels:         grouping3,
+		Timestamp:      ts1,
+		MetricFamilies: testutil.MetricFamiliesMap(mf1ts),
+		Done:           errCh,
+	})
+	for range errCh {
+	}
+
+	// Restart to check that the TTLs survive persistence.
+	if err := dms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	dms = NewDiskMetricStore(fileName, 100*time.Millisecond, nil, logger, WithDefaultTTL(time.Minute))
+	<-dms.restored
+	if expected, got := time.Hour, dms.GetMetricFamiliesMap()[groupingKeyFor(grouping2)].TTL; expected != got {
+		t.Errorf("Expected TTL %v after restore, got %v.", expected, got)
+	}
+
+	if expected, got := 0, dms.removeExpiredGroups(ts1.Add(59*time.Second)); expected != got {
+		t.Errorf("Expected %d expired groups, got %d.", expected, got)
+	}
+	if expected, got := 2, dms.removeExpiredGroups(ts1.Add(time.Minute)); expected != got {
+		t.Errorf("Expected %d expired groups, got %d.", expected, got)
+	}
+	pushTimestamp := newPushTimestampGauge(grouping2, ts1)
+	pushFailedTimestamp := newPushFailedTimestampGauge(grouping2, time.Time{})
+	if err := checkMetricFamilies(
+		dms, mf1b,
+		pushTimestamp, pushFailedTimestamp,
+	); err != nil {
+		t.Error(err)
+	}
+
+	// A new push without TTL resets grouping2 to the default TTL.
+	ts2 := ts1.Add(2 * time.Minute)
+	errCh = make(chan error, 1)
+	dms.SubmitWriteRequest(WriteRequest{
+		Labels:         grouping2,
+		Timestamp:      ts2,
+		MetricFamilies: testutil.MetricFamiliesMap(mf1a),
+		Done:           errCh,
+	})
+	for err := range errCh {
+		t.Fatal("Unexpected error:", err)
+	}
+	if expected, got := 0, dms.removeExpiredGroups(ts2.Add(59*time.Second)); expected != got {
+		t.Errorf("Expected %d expired groups, got %d.", expected, got)
+	}
+	if expected, got := 1, dms.removeExpiredGroups(ts2.Add(time.Minute)); expected != got {
+		t.Errorf("Expected %d expired groups, got %d.", expected, got)
+	}
+	if err := checkMetricFamilies(dms); err != nil {
+		t.Error(err)
+	}
+
+	if err := dms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNoExpiryWithoutTTL(t *testing.T) {
+	dms := NewDiskMetricStore("", 100*time.Millisecond, nil, logger)
+
+	ts1 := time.Now()
+	grouping1 := map[string]string{
+		"job": "job1",
+	}
+	errCh := make(chan error, 1)
+	dms.SubmitWriteRequest(WriteRequest{
+		Labels:         grouping1,
+		Timestamp:      ts1,
+		MetricFamilies: testutil.MetricFamiliesMap(mf3),
+		Done:           errCh,
+	})
+	for err := range errCh {
+		t.Fatal("Unexpected error:", err)
+	}
+	if expected, got := 0, dms.removeExpiredGroups(ts1.Add(10000*time.Hour)); expected != got {
+		t.Errorf("Expected %d expired groups, got %d.", expected, got)
+	}
+
+	if err := dms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyOptions(t *testing.T) {
+	dms := NewDiskMetricStore("", 100*time.Millisecond, nil, logger, WithLimits(Limits{SeriesPerPush: 1}))
+	grouping := map[string]string{"job": "job1"}
+	push := func() error {
+		errCh := make(chan error, 1)
+		dms.SubmitWriteRequest(WriteRequest{
+			Labels:         grouping,
+			Timestamp:      time.Now(),
+			MetricFamilies: map[string]*dto.MetricFamily{"mf": gaugeFamily("mf", "1", "2")},
+			Done:           errCh,
+		})
+		return <-errCh
+	}
+
+	if err := push(); err == nil {
+		t.Error("Expected push exceeding the limit to fail.")
+	}
+	if err := dms.ApplyOptions(WithLimits(Limits{SeriesPerPush: 2})); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if err := push(); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+
+	if err := dms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dms.ApplyOptions(WithLimits(Limits{})); err == nil {
+		t.Error("Expected error applying options after shutdown.")
+	}
+}
+
+func TestGetMetricFamiliesCache(t *testing.T) {
+	dms := NewDiskMetricStore("", 100*time.Millisecond, nil, logger)
+	defer dms.Shutdown()
+	grouping := map[string]string{"job": "job1"}
+
+	generation := dms.Generation()
+	if err := submitAndWait(dms, WriteRequest{
+		Labels:         grouping,
+		Timestamp:      time.Now(),
+		MetricFamilies: map[string]*dto.MetricFamily{"mf": gaugeFamily("mf", "1")},
+	}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if dms.Generation() == generation {
+		t.Error("Expected generation to change upon push.")
+	}
+	mfs1, mfs2 := dms.GetMetricFamilies(), dms.GetMetricFamilies()
+	if len(mfs1) != 3 || &mfs1[0] != &mfs2[0] {
+		t.Errorf("Expected the same cached result, got %v and %v.", mfs1, mfs2)
+	}
+
+	generation = dms.Generation()
+	if err := submitAndWait(dms, WriteRequest{Labels: grouping, Timestamp: time.Now()}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if dms.Generation() == generation {
+		t.Error("Expected generation to change upon delete.")
+	}
+	if got := dms.GetMetricFamilies(); len(got) != 0 {
+		t.Errorf("Expected no metric families after delete, got %v.", got)
+	}
+}
+
+func TestExposedGroups(t *testing.T) {
+	exposedKey := groupingKeyFor(map[string]string{"job": "job1"})
+	dms := NewDiskMetricStore("", 100*time.Millisecond, nil, logger, WithExposedGroups(func(key string) bool {
+		return key == exposedKey
+	}))
+	defer dms.Shutdown()
+
+	for _, job := range []string{"job1", "job2"} {
+		if err := submitAndWait(dms, WriteRequest{
+			Labels:         map[string]string{"job": job},
+			Timestamp:      time.Now(),
+			MetricFamilies: map[string]*dto.MetricFamily{"mf": gaugeFamily("mf", "1")},
+		}); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	for _, mf := range dms.GetMetricFamilies() {
+		for _, m := range mf.GetMetric() {
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() == "job" && lp.GetValue() != "job1" {
+					t.Errorf("Expected only series of job1 in metric family %s, got %v.", mf.GetName(), m)
+				}
+			}
+		}
+	}
+	if expected, got := 2, len(dms.GetMetricFamiliesMap()); expected != got {
+		t.Errorf("Expected %d groups, got %d.", expected, got)
+	}
+}
+
+func TestDeleteGroups(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "diskmetricstore.TestDeleteGroups.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	fileName := path.Join(tempDir, "persistence")
+	dms := NewDiskMetricStore(fileName, time.Hour, nil, logger)
+	<-dms.restored
+
+	groupings := []map[string]string{
+		{"job": "nightly-etl", "env": "staging-1"},
+		{"job": "nightly-etl", "env": "prod"},
+		{"job": "nightly-etl", "env": "staging-2", "instance": "host1"},
+		{"job": "other", "env": "staging-1"},
+		{"job": "nightly-etl"},
+	}
+	for _, grouping := range groupings {
+		if err := submitAndWait(dms, WriteRequest{
+			Labels:         grouping,
+			Timestamp:      time.Now(),
+			MetricFamilies: testutil.MetricFamiliesMap(mf3),
+		}); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	deleteGroups := func(selectors [][]*labels.Matcher, dryRun bool) []map[string]string {
+		deleted, err := dms.DeleteGroups(selectors, dryRun)
+		if err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+		return deleted
+	}
+
+	selectors := [][]*labels.Matcher{{
+		labels.MustNewMatcher(labels.MatchEqual, "job", "nightly-etl"),
+		labels.MustNewMatcher(labels.MatchRegexp, "env", "staging.*"),
+	}}
+	expected := []map[string]string{groupings[0], groupings[2]}
+
+	// A dry run deletes nothing.
+	if got := deleteGroups(selectors, true); !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected matching groups %v, got %v.", expected, got)
+	}
+	if expected, got := len(groupings), len(dms.GetMetricFamiliesMap()); expected != got {
+		t.Errorf("Expected %d groups after dry run, got %d.", expected, got)
+	}
+
+	if got := deleteGroups(selectors, false); !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected deleted groups %v, got %v.", expected, got)
+	}
+	groups := dms.GetMetricFamiliesMap()
+	if expected, got := len(groupings)-2, len(groups); expected != got {
+		t.Errorf("Expected %d groups after delete, got %d.", expected, got)
+	}
+	for _, grouping := range expected {
+		if _, ok := groups[groupingKeyFor(grouping)]; ok {
+			t.Errorf("Group %v not deleted.", grouping)
+		}
+	}
+
+	// A label missing from the grouping labels matches the empty string.
+	// Multiple selectors are ORed.
+	selectors = [][]*labels.Matcher{
+		{labels.MustNewMatcher(labels.MatchEqual, "env", "")},
+		{labels.MustNewMatcher(labels.MatchEqual, "job", "other")},
+	}
+	expected = []map[string]string{groupings[3], groupings[4]}
+	if got := deleteGroups(selectors, false); !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected deleted groups %v, got %v.", expected, got)
+	}
+	if got := deleteGroups(selectors, false); len(got) != 0 {
+		t.Errorf("Expected no deleted groups, got %v.", got)
+	}
+
+	// Groups are deleted by key, ignoring missing and duplicate keys.
+	var remaining MetricGroup
+	for _, group := range dms.GetMetricFamiliesMap() {
+		remaining = group
+	}
+	key := groupingKeyFor(remaining.Labels)
+	expected = []map[string]string{remaining.Labels}
+	got, err := dms.DeleteGroupsByKey([]string{key, "missing", key})
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected deleted groups %v, got %v.", expected, got)
+	}
+
+	// The batch deletes are replayed from the WAL after a crash.
+	recovered := NewDiskMetricStore(fileName, time.Hour, nil, logger)
+	<-recovered.restored
+	checkSameState(t, dms, recovered)
+	if expected, got := 0, len(recovered.GetMetricFamiliesMap()); expected != got {
+		t.Errorf("Expected %d groups after recovery, got %d.", expected, got)
+	}
+	if err := recovered.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestGroupingKeyForLabels(t *testing.T) {
 	sep := string([]byte{model.SeparatorByte})
 	scenarios := []struct {
@@ -0,0 +1,107 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"maps"
+	"slices"
+	"time"
+)
+
+// GroupVersion is the state of a metric group after a successful push to it,
+// as recorded in the History of the MetricGroup.
+type GroupVersion struct {
+	// Version numbers the versions of a group consecutively, starting at 1.
+	// It starts again at 1 once the whole History has been pruned.
+	Version   uint64
+	Timestamp time.Time // Of the push.
+	Metrics   NameToTimestampedMetricFamilyMap
+}
+
+// WithHistory makes the DiskMetricStore keep up to size versions of each metric
+// group (see MetricGroup.History), none of them older than maxAge. A maxAge of
+// zero means no age limit. A size of zero (the default) disables the history.
+// Reducing the limits prunes the history of all groups within the expiry
+// check interval.
+func WithHistory(size int, maxAge time.Duration) Option {
+	return func(dms *DiskMetricStore) {
+		dms.historySize = size
+		dms.historyMaxAge = maxAge
+	}
+}
+
+// recordVersion returns the provided history with the provided metrics (which
+// are copied) appended as the latest version and pruned to the limits set by
+// WithHistory. If the latest version has the same timestamp, it is replaced
+// instead so that replaying a WAL record already contained in the restored
+// history doesn't record the version twice. The provided history is not
+// modified so that it can still be read by others. The caller must hold
+// optionsLock for reading (or be the loop goroutine).
+func (dms *DiskMetricStore) recordVersion(history []GroupVersion, ts time.Time, metrics NameToTimestampedMetricFamilyMap) []GroupVersion {
+	if dms.historySize <= 0 {
+		return nil
+	}
+	v := GroupVersion{Version: 1, Timestamp: ts, Metrics: maps.Clone(metrics)}
+	if n := len(history); n > 0 {
+		v.Version = history[n-1].Version + 1
+		if history[n-1].Timestamp.Equal(ts) {
+			v.Version = history[n-1].Version
+			history = history[:n-1]
+		}
+	}
+	// Clip to never append to a backing array shared with readers.
+	return dms.pruneHistory(append(slices.Clip(history), v), ts)
+}
+
+// pruneHistory returns the provided history without the oldest versions
+// exceeding the size limit and without the versions older than the maximum age
+// at the provided time. The provided history is not modified. If versions are
+// pruned, the remaining ones are copied so that the pruned ones can be garbage
+// collected.
+func (dms *DiskMetricStore) pruneHistory(history []GroupVersion, now time.Time) []GroupVersion {
+	if dms.historySize <= 0 {
+		return nil
+	}
+	start := max(len(history)-dms.historySize, 0)
+	if dms.historyMaxAge > 0 {
+		for start < len(history) && now.Sub(history[start].Timestamp) > dms.historyMaxAge {
+			start++
+		}
+	}
+	switch start {
+	case 0:
+		return history
+	case len(history):
+		return nil
+	default:
+		return slices.Clone(history[start:])
+	}
+}
+
+// pruneHistories prunes the history of all metric groups as described for
+// pruneHistory. It must only be called by the loop goroutine.
+func (dms *DiskMetricStore) pruneHistories(now time.Time) {
+	dms.lock.Lock()
+	defer dms.lock.Unlock()
+
+	for key, group := range dms.metricGroups {
+		if len(group.History) == 0 {
+			continue
+		}
+		if pruned := dms.pruneHistory(group.History, now); len(pruned) != len(group.History) {
+			group.History = pruned
+			dms.metricGroups[key] = group
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"path"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// historyValues returns the version numbers and the values of the sequence
+// metric family in the history of the group of job "history".
+func historyValues(dms *DiskMetricStore) ([]uint64, []string) {
+	var (
+		versions []uint64
+		values   []string
+	)
+	group := dms.GetMetricFamiliesMap()[groupingKeyFor(map[string]string{"job": "history"})]
+	for _, v := range group.History {
+		versions = append(versions, v.Version)
+		values = append(values, fmt.Sprint(v.Metrics["sequence"].GetMetricFamily().GetMetric()[0].GetGauge().GetValue()))
+	}
+	return versions, values
+}
+
+func TestHistory(t *testing.T) {
+	fileName := path.Join(t.TempDir(), "persistence")
+	dms := NewDiskMetricStore(fileName, time.Hour, nil, logger, WithHistory(3, time.Hour))
+	grouping := map[string]string{"job": "history"}
+	start := time.Now()
+
+	push := func(dms *DiskMetricStore, value float64, ts time.Time) {
+		t.Helper()
+		if err := submitAndWait(dms, WriteRequest{
+			Labels:         grouping,
+			Timestamp:      ts,
+			MetricFamilies: newSequenceFamily(dto.MetricType_GAUGE, value),
+		}); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+	for i := range 4 {
+		push(dms, float64(i), start.Add(time.Duration(i)*time.Second))
+	}
+	// A failed push doesn't record a version.
+	withTimestamp := newSequenceFamily(dto.MetricType_GAUGE, 5)
+	withTimestamp["sequence"].Metric[0].TimestampMs = proto.Int64(1)
+	if err := submitAndWait(dms, WriteRequest{
+		Labels:         grouping,
+		Timestamp:      start.Add(5 * time.Second),
+		MetricFamilies: withTimestamp,
+	}); err == nil {
+		t.Fatal("Expected error for push with timestamp.")
+	}
+	versions, values := historyValues(dms)
+	if fmt.Sprint(versions, values) != "[2 3 4] [1 2 3]" {
+		t.Errorf("Expected versions [2 3 4] with values [1 2 3], got %v with %v.", versions, values)
+	}
+
+	// The history is persisted, including the versions only contained in
+	// the WAL.
+	if err := dms.persist(); err != nil {
+		t.Fatal(err)
+	}
+	push(dms, 4, start.Add(6*time.Second))
+	// Simulate a crash by starting a new DiskMetricStore on the same files.
+	dms = NewDiskMetricStore(fileName, time.Hour, nil, logger, WithHistory(3, time.Hour))
+	if err := dms.WaitRestored(); err != nil {
+		t.Fatal(err)
+	}
+	versions, values = historyValues(dms)
+	if fmt.Sprint(versions, values) != "[3 4 5] [2 3 4]" {
+		t.Errorf("Expected restored versions [3 4 5] with values [2 3 4], got %v with %v.", versions, values)
+	}
+
+	// Versions older than the maximum age are pruned.
+	dms.pruneHistories(start.Add(time.Hour + 4*time.Second))
+	versions, _ = historyValues(dms)
+	if fmt.Sprint(versions) != "[5]" {
+		t.Errorf("Expected versions [5] after pruning, got %v.", versions)
+	}
+
+	// The history is deleted with the group.
+	if err := submitAndWait(dms, WriteRequest{Labels: grouping, Timestamp: time.Now()}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	push(dms, 5, time.Now())
+	versions, _ = historyValues(dms)
+	if fmt.Sprint(versions) != "[1]" {
+		t.Errorf("Expected versions [1] after deletion, got %v.", versions)
+	}
+	if err := dms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHistoryDisabled(t *testing.T) {
+	dms := NewDiskMetricStore("", time.Hour, nil, logger)
+	defer dms.Shutdown()
+	if err := submitAndWait(dms, WriteRequest{
+		Labels:         map[string]string{"job": "history"},
+		Timestamp:      time.Now(),
+		MetricFamilies: map[string]*dto.MetricFamily{"mf": gaugeFamily("mf", "1")},
+	}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if versions, _ := historyValues(dms); len(versions) != 0 {
+		t.Errorf("Expected no history, got versions %v.", versions)
+	}
+}
+
+func TestRecordVersion(t *testing.T) {
+	dms := &DiskMetricStore{historySize: 2}
+	ts := time.Now()
+	metrics := NameToTimestampedMetricFamilyMap{"mf": {Timestamp: ts}}
+
+	h1 := dms.recordVersion(nil, ts, metrics)
+	h2 := dms.recordVersion(h1, ts.Add(time.Second), metrics)
+	// Same timestamp, e.g. upon WAL replay, replaces the latest version.
+	h3 := dms.recordVersion(h2, ts.Add(time.Second), metrics)
+	h4 := dms.recordVersion(h3, ts.Add(2*time.Second), metrics)
+
+	for _, s := range []struct {
+		history  []GroupVersion
+		expected string
+	}{
+		{h1, "[1]"}, {h2, "[1 2]"}, {h3, "[1 2]"}, {h4, "[2 3]"},
+	} {
+		var versions []uint64
+		for _, v := range s.history {
+			versions = append(versions, v.Version)
+		}
+		if got := fmt.Sprint(versions); got != s.expected {
+			t.Errorf("Expected versions %s, got %s.", s.expected, got)
+		}
+	}
+	// Versions are copies.
+	metrics["mf2"] = TimestampedMetricFamily{}
+	if len(h1[0].Metrics) != 1 {
+		t.Error("Expected recorded version not to change with the group.")
+	}
+}
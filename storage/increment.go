@@ -0,0 +1,367 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// incrementMetricFamily returns a new MetricFamily where the values of the
+// Metrics in inc are added to the values of the Metrics with the same label set
+// in stored. Metrics only present in one of the two are taken over as they
+// are. Neither stored nor inc are modified. stored may be nil, in which case inc
+// is only validated and returned.
+//
+// Both MetricFamilies must already be sanitized, i.e. their label pairs must be
+// sorted. Gauges and untyped metrics are only accepted if allowGauges is
+// true. Summaries must not contain quantiles.
+func incrementMetricFamily(stored, inc *dto.MetricFamily, allowGauges bool) (*dto.MetricFamily, error) {
+	switch inc.GetType() {
+	case dto.MetricType_COUNTER, dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		// Always OK.
+	case dto.MetricType_SUMMARY:
+		for _, m := range inc.GetMetric() {
+			if len(m.GetSummary().GetQuantile()) > 0 {
+				return nil, fmt.Errorf("summary %q has quantiles, which cannot be incremented", inc.GetName())
+			}
+		}
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+		if !allowGauges {
+			return nil, fmt.Errorf("metric %q is of type %s, which cannot be incremented", inc.GetName(), strings.ToLower(inc.GetType().String()))
+		}
+	default:
+		return nil, fmt.Errorf("metric %q has unsupported type %s", inc.GetName(), inc.GetType())
+	}
+	if stored == nil {
+		return inc, nil
+	}
+	if stored.GetType() != inc.GetType() {
+		return nil, fmt.Errorf(
+			"metric %q is of type %s but has been stored as %s before",
+			inc.GetName(), strings.ToLower(inc.GetType().String()), strings.ToLower(stored.GetType().String()),
+		)
+	}
+
+	return combineMetricFamily(stored, inc, func(m, im *dto.Metric) (*dto.Metric, error) {
+		sum := proto.Clone(m).(*dto.Metric)
+		if err := addMetric(sum, im, inc.GetType()); err != nil {
+			return nil, fmt.Errorf("cannot increment metric %q: %w", inc.GetName(), err)
+		}
+		return sum, nil
+	})
+}
+
+// mergeMetricFamily returns a new MetricFamily where the Metrics in upd replace
+// the Metrics with the same label set in stored. Metrics only present in one
+// of the two are taken over as they are. If stored is nil or of a different
+// type than upd, upd is returned as is. Neither stored nor upd are modified.
+//
+// Both MetricFamilies must already be sanitized, i.e. their label pairs must be
+// sorted.
+func mergeMetricFamily(stored, upd *dto.MetricFamily) *dto.MetricFamily {
+	if stored == nil || stored.GetType() != upd.GetType() {
+		return upd
+	}
+	result, _ := combineMetricFamily(stored, upd, func(_, um *dto.Metric) (*dto.Metric, error) {
+		return um, nil
+	})
+	return result
+}
+
+// combineMetricFamily returns a new MetricFamily with the name, help, type, and
+// unit of upd. It contains all Metrics of stored, in their original order,
+// followed by the Metrics only present in upd. Metrics present in both are
+// replaced by the result of calling combine with the stored and the updated
+// Metric.
+func combineMetricFamily(
+	stored, upd *dto.MetricFamily,
+	combine func(stored, upd *dto.Metric) (*dto.Metric, error),
+) (*dto.MetricFamily, error) {
+	updByLabels := make(map[string]*dto.Metric, len(upd.GetMetric()))
+	for _, m := range upd.GetMetric() {
+		updByLabels[labelPairsKey(m.GetLabel())] = m
+	}
+	result := &dto.MetricFamily{
+		Name:   upd.Name,
+		Help:   upd.Help,
+		Type:   upd.Type,
+		Unit:   upd.Unit,
+		Metric: make([]*dto.Metric, 0, len(stored.GetMetric())+len(upd.GetMetric())),
+	}
+	for _, m := range stored.GetMetric() {
+		key := labelPairsKey(m.GetLabel())
+		um, ok := updByLabels[key]
+		if !ok {
+			// The stored Metric is not modified and can be reused.
+			result.Metric = append(result.Metric, m)
+			continue
+		}
+		delete(updByLabels, key)
+		combined, err := combine(m, um)
+		if err != nil {
+			return nil, err
+		}
+		result.Metric = append(result.Metric, combined)
+	}
+	// Append new Metrics in their original order.
+	for _, m := range upd.GetMetric() {
+		if _, ok := updByLabels[labelPairsKey(m.GetLabel())]; ok {
+			result.Metric = append(result.Metric, m)
+		}
+	}
+	return result, nil
+}
+
+// addMetric adds the value(s) of inc to dst, which has to be of the same type.
+func addMetric(dst, inc *dto.Metric, typ dto.MetricType) error {
+	switch typ {
+	case dto.MetricType_COUNTER:
+		if dst.Counter == nil {
+			dst.Counter = &dto.Counter{}
+		}
+		dst.Counter.Value = proto.Float64(dst.GetCounter().GetValue() + inc.GetCounter().GetValue())
+		if inc.GetCounter().GetExemplar() != nil {
+			dst.Counter.Exemplar = inc.GetCounter().GetExemplar()
+		}
+	case dto.MetricType_GAUGE:
+		if dst.Gauge == nil {
+			dst.Gauge = &dto.Gauge{}
+		}
+		dst.Gauge.Value = proto.Float64(dst.GetGauge().GetValue() + inc.GetGauge().GetValue())
+	case dto.MetricType_UNTYPED:
+		if dst.Untyped == nil {
+			dst.Untyped = &dto.Untyped{}
+		}
+		dst.Untyped.Value = proto.Float64(dst.GetUntyped().GetValue() + inc.GetUntyped().GetValue())
+	case dto.MetricType_SUMMARY:
+		if dst.Summary == nil {
+			dst.Summary = &dto.Summary{}
+		}
+		dst.Summary.SampleCount = proto.Uint64(dst.GetSummary().GetSampleCount() + inc.GetSummary().GetSampleCount())
+		dst.Summary.SampleSum = proto.Float64(dst.GetSummary().GetSampleSum() + inc.GetSummary().GetSampleSum())
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		if dst.Histogram == nil {
+			dst.Histogram = &dto.Histogram{}
+		}
+		return addHistogram(dst.Histogram, inc.GetHistogram())
+	}
+	return nil
+}
+
+// addHistogram adds the histogram inc to the histogram dst. The classic
+// buckets of both have to have the same upper bounds. The native buckets, if
+// any, have to have the same schema and zero threshold. If either of the
+// histograms is a float histogram, the result is a float histogram, too.
+func addHistogram(dst, inc *dto.Histogram) error {
+	if (dst.Schema == nil) != (inc.Schema == nil) {
+		return fmt.Errorf("cannot add a native histogram to a classic-only histogram")
+	}
+	if len(dst.GetBucket()) != len(inc.GetBucket()) {
+		return fmt.Errorf("classic bucket layouts differ")
+	}
+	for i, b := range dst.GetBucket() {
+		if b.GetUpperBound() != inc.GetBucket()[i].GetUpperBound() {
+			return fmt.Errorf("classic bucket layouts differ")
+		}
+	}
+	if dst.Schema != nil {
+		if dst.GetSchema() != inc.GetSchema() {
+			return fmt.Errorf("native histogram schemas differ (%d vs. %d)", dst.GetSchema(), inc.GetSchema())
+		}
+		if dst.GetZeroThreshold() != inc.GetZeroThreshold() {
+			return fmt.Errorf("native histogram zero thresholds differ (%g vs. %g)", dst.GetZeroThreshold(), inc.GetZeroThreshold())
+		}
+	}
+
+	float := isFloatHistogram(dst) || isFloatHistogram(inc)
+	dst.SampleSum = proto.Float64(dst.GetSampleSum() + inc.GetSampleSum())
+	if float {
+		dst.SampleCountFloat = proto.Float64(histogramCount(dst) + histogramCount(inc))
+		dst.SampleCount = nil
+	} else {
+		dst.SampleCount = proto.Uint64(dst.GetSampleCount() + inc.GetSampleCount())
+	}
+
+	for i, b := range dst.GetBucket() {
+		ib := inc.GetBucket()[i]
+		if float {
+			b.CumulativeCountFloat = proto.Float64(bucketCount(b) + bucketCount(ib))
+			b.CumulativeCount = nil
+		} else {
+			b.CumulativeCount = proto.Uint64(b.GetCumulativeCount() + ib.GetCumulativeCount())
+		}
+		if ib.GetExemplar() != nil {
+			b.Exemplar = ib.GetExemplar()
+		}
+	}
+
+	if dst.Schema == nil {
+		return nil
+	}
+	if float {
+		dst.ZeroCountFloat = proto.Float64(zeroCount(dst) + zeroCount(inc))
+		dst.ZeroCount = nil
+	} else {
+		dst.ZeroCount = proto.Uint64(dst.GetZeroCount() + inc.GetZeroCount())
+	}
+	pos := nativeBuckets(dst.GetPositiveSpan(), dst.GetPositiveDelta(), dst.GetPositiveCount())
+	addNativeBuckets(pos, nativeBuckets(inc.GetPositiveSpan(), inc.GetPositiveDelta(), inc.GetPositiveCount()))
+	neg := nativeBuckets(dst.GetNegativeSpan(), dst.GetNegativeDelta(), dst.GetNegativeCount())
+	addNativeBuckets(neg, nativeBuckets(inc.GetNegativeSpan(), inc.GetNegativeDelta(), inc.GetNegativeCount()))
+	if float {
+		dst.PositiveSpan, dst.PositiveCount = encodeFloatBuckets(pos)
+		dst.NegativeSpan, dst.NegativeCount = encodeFloatBuckets(neg)
+		dst.PositiveDelta, dst.NegativeDelta = nil, nil
+	} else {
+		dst.PositiveSpan, dst.PositiveDelta = encodeIntBuckets(pos)
+		dst.NegativeSpan, dst.NegativeDelta = encodeIntBuckets(neg)
+		dst.PositiveCount, dst.NegativeCount = nil, nil
+	}
+	if len(inc.GetExemplars()) > 0 {
+		dst.Exemplars = inc.GetExemplars()
+	}
+	return nil
+}
+
+func isFloatHistogram(h *dto.Histogram) bool {
+	return h.GetSampleCountFloat() > 0 || h.GetZeroCountFloat() > 0 ||
+		len(h.GetPositiveCount()) > 0 || len(h.GetNegativeCount()) > 0 ||
+		(len(h.GetBucket()) > 0 && h.GetBucket()[0].CumulativeCountFloat != nil)
+}
+
+func histogramCount(h *dto.Histogram) float64 {
+	if h.SampleCountFloat != nil {
+		return h.GetSampleCountFloat()
+	}
+	return float64(h.GetSampleCount())
+}
+
+func bucketCount(b *dto.Bucket) float64 {
+	if b.CumulativeCountFloat != nil {
+		return b.GetCumulativeCountFloat()
+	}
+	return float64(b.GetCumulativeCount())
+}
+
+func zeroCount(h *dto.Histogram) float64 {
+	if h.ZeroCountFloat != nil {
+		return h.GetZeroCountFloat()
+	}
+	return float64(h.GetZeroCount())
+}
+
+// nativeBuckets decodes the spans and either the deltas (integer histogram) or
+// the absolute counts (float histogram) into a map from bucket index to count.
+func nativeBuckets(spans []*dto.BucketSpan, deltas []int64, counts []float64) map[int32]float64 {
+	result := map[int32]float64{}
+	var (
+		idx   int32
+		i     int
+		count int64
+	)
+	for _, s := range spans {
+		idx += s.GetOffset()
+		for j := uint32(0); j < s.GetLength(); j++ {
+			switch {
+			case i < len(deltas):
+				count += deltas[i]
+				result[idx] = float64(count)
+			case i < len(counts):
+				result[idx] = counts[i]
+			}
+			idx++
+			i++
+		}
+	}
+	return result
+}
+
+func addNativeBuckets(dst, inc map[int32]float64) {
+	for idx, c := range inc {
+		dst[idx] += c
+	}
+}
+
+// encodeFloatBuckets encodes the provided buckets as spans and absolute counts.
+func encodeFloatBuckets(buckets map[int32]float64) ([]*dto.BucketSpan, []float64) {
+	spans, indices := encodeSpans(buckets)
+	counts := make([]float64, len(indices))
+	for i, idx := range indices {
+		counts[i] = buckets[idx]
+	}
+	return spans, counts
+}
+
+// encodeIntBuckets encodes the provided buckets as spans and deltas.
+func encodeIntBuckets(buckets map[int32]float64) ([]*dto.BucketSpan, []int64) {
+	spans, indices := encodeSpans(buckets)
+	deltas := make([]int64, len(indices))
+	var prev int64
+	for i, idx := range indices {
+		c := int64(buckets[idx])
+		deltas[i] = c - prev
+		prev = c
+	}
+	return spans, deltas
+}
+
+// encodeSpans returns the spans for the provided buckets together with the
+// sorted bucket indices.
+func encodeSpans(buckets map[int32]float64) ([]*dto.BucketSpan, []int32) {
+	if len(buckets) == 0 {
+		return nil, nil
+	}
+	indices := make([]int32, 0, len(buckets))
+	for idx := range buckets {
+		indices = append(indices, idx)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+
+	var (
+		spans   []*dto.BucketSpan
+		nextIdx int32 // Index following the last bucket of the last span.
+	)
+	for i, idx := range indices {
+		if i > 0 && idx == nextIdx {
+			spans[len(spans)-1].Length = proto.Uint32(spans[len(spans)-1].GetLength() + 1)
+		} else {
+			spans = append(spans, &dto.BucketSpan{
+				Offset: proto.Int32(idx - nextIdx),
+				Length: proto.Uint32(1),
+			})
+		}
+		nextIdx = idx + 1
+	}
+	return spans, indices
+}
+
+// labelPairsKey returns a string uniquely identifying the provided label pairs,
+// which must be sorted by name.
+func labelPairsKey(lps []*dto.LabelPair) string {
+	sb := strings.Builder{}
+	for _, lp := range lps {
+		sb.WriteString(lp.GetName())
+		sb.WriteByte(model.SeparatorByte)
+		sb.WriteString(lp.GetValue())
+		sb.WriteByte(model.SeparatorByte)
+	}
+	return sb.String()
+}
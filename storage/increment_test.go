@@ -0,0 +1,324 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/prometheus/pushgateway/testutil"
+)
+
+func mustParseMF(t *testing.T, text string) *dto.MetricFamily {
+	t.Helper()
+	mf := &dto.MetricFamily{}
+	if err := prototext.Unmarshal([]byte(text), mf); err != nil {
+		t.Fatal(err)
+	}
+	return mf
+}
+
+func TestIncrementMetricFamily(t *testing.T) {
+	scenarios := map[string]struct {
+		stored, inc string // Empty stored means nil.
+		allowGauges bool
+		expected    string // Empty means error expected.
+	}{
+		"counter without stored": {
+			inc:      `name:"c" type:COUNTER metric:{label:{name:"job" value:"j"} counter:{value:3}}`,
+			expected: `name:"c" type:COUNTER metric:{label:{name:"job" value:"j"} counter:{value:3}}`,
+		},
+		"counter": {
+			stored: `name:"c" help:"old" type:COUNTER metric:{label:{name:"a" value:"1"} counter:{value:3}} metric:{label:{name:"a" value:"2"} counter:{value:5}}`,
+			inc:    `name:"c" help:"new" type:COUNTER metric:{label:{name:"a" value:"3"} counter:{value:1}} metric:{label:{name:"a" value:"1"} counter:{value:2}}`,
+			expected: `name:"c" help:"new" type:COUNTER
+				metric:{label:{name:"a" value:"1"} counter:{value:5}}
+				metric:{label:{name:"a" value:"2"} counter:{value:5}}
+				metric:{label:{name:"a" value:"3"} counter:{value:1}}`,
+		},
+		"type mismatch": {
+			stored: `name:"c" type:COUNTER metric:{counter:{value:3}}`,
+			inc:    `name:"c" type:SUMMARY metric:{summary:{sample_count:1 sample_sum:2}}`,
+		},
+		"gauge not allowed": {
+			inc: `name:"g" type:GAUGE metric:{gauge:{value:3}}`,
+		},
+		"untyped not allowed": {
+			inc: `name:"u" type:UNTYPED metric:{untyped:{value:3}}`,
+		},
+		"gauge allowed": {
+			stored:      `name:"g" type:GAUGE metric:{gauge:{value:3}}`,
+			inc:         `name:"g" type:GAUGE metric:{gauge:{value:-1}}`,
+			allowGauges: true,
+			expected:    `name:"g" type:GAUGE metric:{gauge:{value:2}}`,
+		},
+		"summary": {
+			stored:   `name:"s" type:SUMMARY metric:{summary:{sample_count:1 sample_sum:2}}`,
+			inc:      `name:"s" type:SUMMARY metric:{summary:{sample_count:2 sample_sum:3.5}}`,
+			expected: `name:"s" type:SUMMARY metric:{summary:{sample_count:3 sample_sum:5.5}}`,
+		},
+		"summary with quantiles": {
+			inc: `name:"s" type:SUMMARY metric:{summary:{sample_count:1 sample_sum:2 quantile:{quantile:0.5 value:2}}}`,
+		},
+		"classic histogram": {
+			stored:   `name:"h" type:HISTOGRAM metric:{histogram:{sample_count:3 sample_sum:5 bucket:{upper_bound:1 cumulative_count:1} bucket:{upper_bound:2 cumulative_count:3}}}`,
+			inc:      `name:"h" type:HISTOGRAM metric:{histogram:{sample_count:2 sample_sum:1 bucket:{upper_bound:1 cumulative_count:2} bucket:{upper_bound:2 cumulative_count:2}}}`,
+			expected: `name:"h" type:HISTOGRAM metric:{histogram:{sample_count:5 sample_sum:6 bucket:{upper_bound:1 cumulative_count:3} bucket:{upper_bound:2 cumulative_count:5}}}`,
+		},
+		"classic float histogram": {
+			stored:   `name:"h" type:HISTOGRAM metric:{histogram:{sample_count:3 sample_sum:5 bucket:{upper_bound:1 cumulative_count:1} bucket:{upper_bound:2 cumulative_count:3}}}`,
+			inc:      `name:"h" type:HISTOGRAM metric:{histogram:{sample_count_float:1.5 sample_sum:1 bucket:{upper_bound:1 cumulative_count_float:0.5} bucket:{upper_bound:2 cumulative_count_float:1.5}}}`,
+			expected: `name:"h" type:HISTOGRAM metric:{histogram:{sample_count_float:4.5 sample_sum:6 bucket:{upper_bound:1 cumulative_count_float:1.5} bucket:{upper_bound:2 cumulative_count_float:4.5}}}`,
+		},
+		"classic histogram with different buckets": {
+			stored: `name:"h" type:HISTOGRAM metric:{histogram:{sample_count:3 sample_sum:5 bucket:{upper_bound:1 cumulative_count:1} bucket:{upper_bound:2 cumulative_count:3}}}`,
+			inc:    `name:"h" type:HISTOGRAM metric:{histogram:{sample_count:2 sample_sum:1 bucket:{upper_bound:1 cumulative_count:2} bucket:{upper_bound:5 cumulative_count:2}}}`,
+		},
+		"native histogram": {
+			stored: `name:"h" type:HISTOGRAM metric:{histogram:{sample_count:4 sample_sum:5 schema:0 zero_threshold:1e-128 zero_count:1
+				positive_span:{offset:0 length:2} positive_delta:1 positive_delta:1}}`,
+			inc: `name:"h" type:HISTOGRAM metric:{histogram:{sample_count:5 sample_sum:1 schema:0 zero_threshold:1e-128 zero_count:0
+				positive_span:{offset:1 length:1} positive_span:{offset:1 length:1} positive_delta:3 positive_delta:-1
+				negative_span:{offset:-2 length:1} negative_delta:1}}`,
+			expected: `name:"h" type:HISTOGRAM metric:{histogram:{sample_count:9 sample_sum:6 schema:0 zero_threshold:1e-128 zero_count:1
+				negative_span:{offset:-2 length:1} negative_delta:1
+				positive_span:{offset:0 length:2} positive_span:{offset:1 length:1} positive_delta:1 positive_delta:4 positive_delta:-3}}`,
+		},
+		"native float histogram": {
+			stored: `name:"h" type:HISTOGRAM metric:{histogram:{sample_count:4 sample_sum:5 schema:0 zero_threshold:1e-128 zero_count:1
+				positive_span:{offset:0 length:2} positive_delta:1 positive_delta:1}}`,
+			inc: `name:"h" type:HISTOGRAM metric:{histogram:{sample_count_float:5 sample_sum:1 schema:0 zero_threshold:1e-128 zero_count_float:0.5
+				positive_span:{offset:1 length:1} positive_span:{offset:1 length:1} positive_count:3 positive_count:2}}`,
+			expected: `name:"h" type:HISTOGRAM metric:{histogram:{sample_count_float:9 sample_sum:6 schema:0 zero_threshold:1e-128 zero_count_float:1.5
+				positive_span:{offset:0 length:2} positive_span:{offset:1 length:1} positive_count:1 positive_count:5 positive_count:2}}`,
+		},
+		"native histogram with different schema": {
+			stored: `name:"h" type:HISTOGRAM metric:{histogram:{sample_count:1 sample_sum:5 schema:0 zero_threshold:1e-128 positive_span:{offset:0 length:1} positive_delta:1}}`,
+			inc:    `name:"h" type:HISTOGRAM metric:{histogram:{sample_count:1 sample_sum:5 schema:1 zero_threshold:1e-128 positive_span:{offset:0 length:1} positive_delta:1}}`,
+		},
+		"native and classic histogram": {
+			stored: `name:"h" type:HISTOGRAM metric:{histogram:{sample_count:1 sample_sum:5 schema:0 zero_threshold:1e-128 positive_span:{offset:0 length:1} positive_delta:1}}`,
+			inc:    `name:"h" type:HISTOGRAM metric:{histogram:{sample_count:1 sample_sum:5 bucket:{upper_bound:1 cumulative_count:1}}}`,
+		},
+	}
+
+	for name, s := range scenarios {
+		t.Run(name, func(t *testing.T) {
+			var stored *dto.MetricFamily
+			if s.stored != "" {
+				stored = mustParseMF(t, s.stored)
+			}
+			inc := mustParseMF(t, s.inc)
+			storedCopy := proto.Clone(stored)
+			incCopy := proto.Clone(inc)
+
+			got, err := incrementMetricFamily(stored, inc, s.allowGauges)
+			if s.expected == "" {
+				if err == nil {
+					t.Fatalf("Expected error, got %v.", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			if expected := mustParseMF(t, s.expected); !proto.Equal(expected, got) {
+				t.Errorf("Expected %v, got %v.", expected, got)
+			}
+			if !proto.Equal(storedCopy, stored) {
+				t.Errorf("Stored metric family modified: %v", stored)
+			}
+			if !proto.Equal(incCopy, inc) {
+				t.Errorf("Incoming metric family modified: %v", inc)
+			}
+		})
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	dms := NewDiskMetricStore("", 100*time.Millisecond, nil, logger)
+
+	grouping := map[string]string{
+		"job": "job1",
+	}
+	counter := func(v float64) *dto.MetricFamily {
+		return mustParseMF(t, fmt.Sprintf(`name:"items_processed_total" help:"Items." type:COUNTER metric:{counter:{value:%g}}`, v))
+	}
+	expectedCounter := func(v float64) *dto.MetricFamily {
+		return mustParseMF(t, fmt.Sprintf(`name:"items_processed_total" help:"Items." type:COUNTER metric:{
+			label:{name:"instance" value:""} label:{name:"job" value:"job1"} counter:{value:%g}}`, v))
+	}
+
+	// The first increment simply creates the counter. Then shards add to
+	// it.
+	ts := time.Now()
+	for i := range 5 {
+		if err := submitAndWait(dms, WriteRequest{
+			Labels:         grouping,
+			Timestamp:      ts.Add(time.Duration(i) * time.Second),
+			MetricFamilies: testutil.MetricFamiliesMap(counter(float64(i + 1))),
+			Increment:      true,
+		}); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+	ts = ts.Add(4 * time.Second)
+	if err := checkMetricFamilies(
+		dms, expectedCounter(15),
+		newPushTimestampGauge(grouping, ts), newPushFailedTimestampGauge(grouping, time.Time{}),
+	); err != nil {
+		t.Error(err)
+	}
+
+	// A gauge is rejected and sets the push-failed timestamp.
+	failTS := ts.Add(time.Second)
+	if err := submitAndWait(dms, WriteRequest{
+		Labels:    grouping,
+		Timestamp: failTS,
+		MetricFamilies: testutil.MetricFamiliesMap(mustParseMF(t,
+			`name:"temperature" type:GAUGE metric:{gauge:{value:3}}`)),
+		Increment: true,
+	}); err == nil {
+		t.Error("Expected error when incrementing a gauge.")
+	}
+	if err := checkMetricFamilies(
+		dms, expectedCounter(15),
+		newPushTimestampGauge(grouping, ts), newPushFailedTimestampGauge(grouping, failTS),
+	); err != nil {
+		t.Error(err)
+	}
+
+	// A normal POST overwrites the counter.
+	ts = ts.Add(2 * time.Second)
+	if err := submitAndWait(dms, WriteRequest{
+		Labels:         grouping,
+		Timestamp:      ts,
+		MetricFamilies: testutil.MetricFamiliesMap(counter(1)),
+	}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if err := checkMetricFamilies(
+		dms, expectedCounter(1),
+		newPushTimestampGauge(grouping, ts), newPushFailedTimestampGauge(grouping, failTS),
+	); err != nil {
+		t.Error(err)
+	}
+
+	if err := dms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// With gauge increments allowed.
+	dms = NewDiskMetricStore("", 100*time.Millisecond, nil, logger, WithGaugeIncrements(true))
+	for range 2 {
+		if err := submitAndWait(dms, WriteRequest{
+			Labels:    grouping,
+			Timestamp: ts,
+			MetricFamilies: testutil.MetricFamiliesMap(mustParseMF(t,
+				`name:"temperature" type:GAUGE metric:{gauge:{value:3}}`)),
+			Increment: true,
+		}); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+	if err := checkMetricFamilies(
+		dms,
+		mustParseMF(t, `name:"temperature" type:GAUGE metric:{label:{name:"instance" value:""} label:{name:"job" value:"job1"} gauge:{value:6}}`),
+		newPushTimestampGauge(grouping, ts), newPushFailedTimestampGauge(grouping, time.Time{}),
+	); err != nil {
+		t.Error(err)
+	}
+	if err := dms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dms := NewDiskMetricStore("", 100*time.Millisecond, nil, logger)
+
+	grouping := map[string]string{
+		"job": "job1",
+	}
+	requests := func(path string, v float64) *dto.MetricFamily {
+		return mustParseMF(t, fmt.Sprintf(`name:"requests_total" type:COUNTER metric:{
+			label:{name:"path" value:%q} counter:{value:%g}}`, path, v))
+	}
+
+	ts := time.Now()
+	for i, mf := range []*dto.MetricFamily{
+		requests("/a", 1),
+		requests("/b", 2),
+		requests("/a", 3),
+	} {
+		if err := submitAndWait(dms, WriteRequest{
+			Labels:         grouping,
+			Timestamp:      ts.Add(time.Duration(i) * time.Second),
+			MetricFamilies: testutil.MetricFamiliesMap(mf),
+			Merge:          true,
+		}); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+	ts = ts.Add(2 * time.Second)
+	if err := checkMetricFamilies(
+		dms,
+		mustParseMF(t, `name:"requests_total" type:COUNTER
+			metric:{label:{name:"instance" value:""} label:{name:"job" value:"job1"} label:{name:"path" value:"/a"} counter:{value:3}}
+			metric:{label:{name:"instance" value:""} label:{name:"job" value:"job1"} label:{name:"path" value:"/b"} counter:{value:2}}`),
+		newPushTimestampGauge(grouping, ts), newPushFailedTimestampGauge(grouping, time.Time{}),
+	); err != nil {
+		t.Error(err)
+	}
+
+	// A metric family of a different type replaces the stored one as a
+	// whole.
+	ts = ts.Add(time.Second)
+	if err := submitAndWait(dms, WriteRequest{
+		Labels:    grouping,
+		Timestamp: ts,
+		MetricFamilies: testutil.MetricFamiliesMap(mustParseMF(t,
+			`name:"requests_total" type:GAUGE metric:{label:{name:"path" value:"/c"} gauge:{value:7}}`)),
+		Merge: true,
+	}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if err := checkMetricFamilies(
+		dms,
+		mustParseMF(t, `name:"requests_total" type:GAUGE
+			metric:{label:{name:"instance" value:""} label:{name:"job" value:"job1"} label:{name:"path" value:"/c"} gauge:{value:7}}`),
+		newPushTimestampGauge(grouping, ts), newPushFailedTimestampGauge(grouping, time.Time{}),
+	); err != nil {
+		t.Error(err)
+	}
+
+	// Merge and replace are mutually exclusive.
+	if err := submitAndWait(dms, WriteRequest{
+		Labels:         grouping,
+		Timestamp:      ts.Add(time.Second),
+		MetricFamilies: testutil.MetricFamiliesMap(requests("/a", 1)),
+		Merge:          true,
+		Replace:        true,
+	}); err == nil {
+		t.Error("Expected error when combining merge and replace.")
+	}
+	if err := dms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -14,9 +14,13 @@
 package storage
 
 import (
+	"context"
+	"math"
 	"sort"
+	"sync"
 	"time"
 
+	"github.com/prometheus/prometheus/model/labels"
 	"google.golang.org/protobuf/proto"
 
 	dto "github.com/prometheus/client_model/go"
@@ -27,17 +31,25 @@ import (
 type MetricStore interface {
 	// SubmitWriteRequest submits a WriteRequest for processing. There is no
 	// guarantee when a request will be processed, but it is guaranteed that
-	// the requests are processed in the order of submission.
+	// requests with the same grouping labels are processed in the order of
+	// submission. Requests with different grouping labels might be
+	// processed in a different order or concurrently.
 	SubmitWriteRequest(req WriteRequest)
+	// TrySubmitWriteRequest submits a WriteRequest like SubmitWriteRequest
+	// but never blocks. If the write queue is full, the WriteRequest is not
+	// submitted, and ErrWriteQueueFull is returned. If ctx is already done,
+	// the WriteRequest is not submitted either, and ctx.Err() is returned.
+	TrySubmitWriteRequest(ctx context.Context, req WriteRequest) error
 	// GetMetricFamilies returns all the currently saved MetricFamilies. The
 	// returned MetricFamilies are guaranteed to not be modified by the
 	// MetricStore anymore. However, they may still be read somewhere else,
-	// so the caller is not allowed to modify the returned MetricFamilies.
-	// If different groups have saved MetricFamilies of the same name, they
-	// are all merged into one MetricFamily by concatenating the contained
-	// Metrics. Inconsistent help strings are logged, and one of the
-	// versions will "win". Inconsistent types and inconsistent or duplicate
-	// label sets will go undetected.
+	// so the caller is not allowed to modify the returned MetricFamilies
+	// (or the returned slice). If different groups have saved
+	// MetricFamilies of the same name, they are all merged into one
+	// MetricFamily by concatenating the contained Metrics. Inconsistent
+	// help strings are logged, and one of the versions will "win".
+	// Inconsistent types and inconsistent or duplicate label sets will go
+	// undetected.
 	GetMetricFamilies() []*dto.MetricFamily
 	// GetMetricFamiliesMap returns a map grouping-key -> MetricGroup. The
 	// MetricFamily pointed to by the Metrics map in each MetricGroup is
@@ -47,6 +59,51 @@ type MetricStore interface {
 	// deep copy of the internal state of the MetricStore and completely
 	// owned by the caller.
 	GetMetricFamiliesMap() GroupingKeyToMetricGroup
+	// GetGroupSummaries returns a GroupSummary for each metric group, in no
+	// particular order. Unlike GetMetricFamiliesMap, it doesn't copy the
+	// metrics of the groups.
+	GetGroupSummaries() []GroupSummary
+	// GetMetricGroup returns the MetricGroup with the provided grouping key
+	// (as in the GroupingKeyToMetricGroup returned by
+	// GetMetricFamiliesMap), with the same guarantees. False is returned if
+	// there is no such group.
+	GetMetricGroup(key string) (MetricGroup, bool)
+	// DeleteGroups deletes all metric groups matching at least one of the
+	// provided selectors (see MetricGroup.Matches). The deletion is
+	// processed as one atomic batch, in order with the submitted
+	// WriteRequests. If dryRun is true, the matching groups are not
+	// deleted. In any case, the grouping labels of the matching groups are
+	// returned, sorted by grouping key. Like TrySubmitWriteRequest,
+	// DeleteGroups doesn't block on a full write queue but returns
+	// ErrWriteQueueFull without deleting anything.
+	DeleteGroups(selectors [][]*labels.Matcher, dryRun bool) ([]map[string]string, error)
+	// DeleteGroupsByKey deletes the metric groups with the provided
+	// grouping keys (as in the GroupingKeyToMetricGroup returned by
+	// GetMetricFamiliesMap) like DeleteGroups. The keys are those of the
+	// stored groups, so unlike for WriteRequests, no relabeling is applied.
+	// The grouping labels of the deleted groups are returned, sorted by
+	// grouping key. Keys of groups that don't exist are ignored.
+	DeleteGroupsByKey(keys []string) ([]map[string]string, error)
+	// RelabelGroupingLabels returns the grouping labels of the metric
+	// group that a WriteRequest with the provided grouping labels applies
+	// to after relabeling, or false if the WriteRequest is dropped by
+	// relabeling. If relabeling fails, the provided grouping labels are
+	// returned unchanged.
+	RelabelGroupingLabels(groupingLabels map[string]string) (map[string]string, bool)
+	// Validate returns all Problems that would currently prevent the
+	// provided WriteRequest from being processed with the consistency
+	// check, or nil if there are none. The MetricStore is not changed.
+	Validate(req WriteRequest) []*Problem
+	// Watch subscribes to the Events of all changes to metric groups
+	// matching at least one of the provided selectors (or of all groups if
+	// selectors is nil), including failed attempts to change them. The
+	// Events are sent to the returned channel in the order the changes are
+	// processed, buffered up to bufferSize Events. Sending never blocks
+	// the processing of changes. Instead, the subscription is dropped and
+	// the channel closed once the buffer is full. The channel is also
+	// closed if the returned function is called to end the subscription,
+	// or if the MetricStore is shut down.
+	Watch(selectors [][]*labels.Matcher, bufferSize int) (<-chan Event, func())
 	// Shutdown must only be called after the caller has made sure that
 	// SubmitWriteRequests is not called anymore. (If it is called later,
 	// the request might get submitted, but not processed anymore.) The
@@ -78,6 +135,22 @@ type MetricStore interface {
 // with the same grouping key. Otherwise, only those MetricFamilies with the
 // same name as new MetricFamilies will be replaced.
 //
+// If Increment is true (which must not be combined with Replace), the values of
+// the MetricFamilies are added to the values of the metrics with the same name
+// and label set already stored with the same grouping key. Metrics that are
+// stored but not contained in the MetricFamilies are retained. Only counters,
+// histograms, and summaries without quantiles can be incremented by default.
+// While processing the WriteRequest, the MetricStore replaces the incremented
+// MetricFamilies with the resulting sums.
+//
+// If Merge is true (which must not be combined with Replace or Increment), each
+// Metric in the MetricFamilies replaces only the metric with the same name and
+// label set already stored with the same grouping key. Other stored metrics of
+// the same metric family are retained (unless the stored metric family has a
+// different type, in which case it is replaced as a whole). While processing
+// the WriteRequest, the MetricStore replaces the merged MetricFamilies with
+// the result of the merge.
+//
 // The key in MetricFamilies is the name of the mapped metric family.
 //
 // When the WriteRequest is processed, the metrics in MetricFamilies will be
@@ -91,15 +164,54 @@ type MetricStore interface {
 // message. In fact, WriteRequests containing any Metrics with a TimestampMs set
 // are invalid and will be rejected.
 //
+// TTL is the time after which the metric group is deleted automatically if it
+// has not been pushed to again in the meantime. The TTL of the last successful
+// push applies to the whole group. A TTL of zero means the MetricStore's
+// default applies (which might be no expiry at all). The TTL is ignored for
+// delete requests.
+//
 // The Done channel may be nil. If it is not nil, it will be closed once the
-// write request is processed. Any errors occurring during processing are sent to
-// the channel before closing it.
+// write request is processed. If processing fails, exactly one error is sent to
+// the channel before closing it, so that a buffer size of one suffices to never
+// block processing. If the WriteRequest is rejected by the checks performed
+// during processing (pushed timestamps, Limits, and, only with a Done channel
+// and without Unchecked, consistency with the existing metrics), the error is of
+// type Problems and
+// lists every problem found, not just the first one (use AsProblems to retrieve
+// them). Errors wrapped by the individual Problems, like ErrTimestamp or a
+// *LimitError, can be detected with errors.Is and errors.As.
 type WriteRequest struct {
 	Labels         map[string]string
 	Timestamp      time.Time
 	MetricFamilies map[string]*dto.MetricFamily
 	Replace        bool
+	Increment      bool
+	Merge          bool
+	TTL            time.Duration
 	Done           chan error
+	// Unchecked skips the consistency check even with a Done channel, so
+	// that the sender can still wait for the WriteRequest to be processed.
+	Unchecked bool
+
+	// batchDelete is only set for the WriteRequests created by the
+	// DeleteGroups method of the DiskMetricStore.
+	batchDelete *batchDelete
+}
+
+// batchDelete describes the deletion of all metric groups matching any of the
+// selectors or, if keys is not nil, of the metric groups with those grouping
+// keys.
+type batchDelete struct {
+	selectors [][]*labels.Matcher
+	keys      []string
+	dryRun    bool
+	matched   chan []map[string]string // Receives the grouping labels of the matching groups.
+	deleted   []map[string]string      // Set to the deleted groups during processing.
+
+	mtx       sync.Mutex    // Protects pending and cancelled.
+	pending   int           // Number of shards that have not received the batch delete yet.
+	cancelled bool          // Set if the batch delete could not be submitted to all shards.
+	released  chan struct{} // Closed once the batch delete has been processed or cancelled.
 }
 
 // GroupingKeyToMetricGroup is the first level of the metric store, keyed by
@@ -107,9 +219,17 @@ type WriteRequest struct {
 type GroupingKeyToMetricGroup map[string]MetricGroup
 
 // MetricGroup adds the grouping labels to a NameToTimestampedMetricFamilyMap.
+// TTL is the expiry time set by the last push to the group (zero if none was
+// set, see WriteRequest). History contains the versions of the group after the
+// latest successful pushes, oldest first, if the MetricStore keeps a history
+// (see WithHistory). Apart from the push-failed timestamp, the latest version
+// equals Metrics (unless it has been pruned for its age). The History is
+// deleted together with the group.
 type MetricGroup struct {
 	Labels  map[string]string
 	Metrics NameToTimestampedMetricFamilyMap
+	TTL     time.Duration
+	History []GroupVersion
 }
 
 // SortedLabels returns the label names of the grouping labels sorted
@@ -144,6 +264,105 @@ func (mg MetricGroup) LastPushSuccess() bool {
 	return (*dto.MetricFamily)(fail).GetMetric()[0].GetGauge().GetValue() <= (*dto.MetricFamily)(success).GetMetric()[0].GetGauge().GetValue()
 }
 
+// LastPushTime returns the time of the last successful push to the group as
+// recorded by the automatically added push_time_seconds metric. The zero time
+// is returned if there has never been a successful push (or if the metric is
+// missing for some reason).
+func (mg MetricGroup) LastPushTime() time.Time {
+	return mg.timestampFrom(pushMetricName)
+}
+
+// LastPushFailureTime returns the time of the last failed push to the group as
+// recorded by the automatically added push_failure_time_seconds metric. The
+// zero time is returned if there has never been a failed push (or if the
+// metric is missing for some reason).
+func (mg MetricGroup) LastPushFailureTime() time.Time {
+	return mg.timestampFrom(pushFailedMetricName)
+}
+
+func (mg MetricGroup) timestampFrom(metricName string) time.Time {
+	mf := mg.Metrics[metricName].GetMetricFamily()
+	if len(mf.GetMetric()) == 0 {
+		return time.Time{}
+	}
+	v := mf.GetMetric()[0].GetGauge().GetValue()
+	if v == 0 {
+		return time.Time{}
+	}
+	sec, frac := math.Modf(v)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
+// PushedMetricFamilies returns the number of pushed MetricFamilies in the group,
+// i.e. not counting the automatically added push_time_seconds and
+// push_failure_time_seconds metrics.
+func (mg MetricGroup) PushedMetricFamilies() int {
+	n := len(mg.Metrics)
+	for _, name := range []string{pushMetricName, pushFailedMetricName} {
+		if _, ok := mg.Metrics[name]; ok {
+			n--
+		}
+	}
+	return n
+}
+
+// IsPushTimestamp returns whether the metric family with the provided name is
+// one of the push_time_seconds and push_failure_time_seconds metric families
+// added to each group automatically.
+func IsPushTimestamp(name string) bool {
+	return name == pushMetricName || name == pushFailedMetricName
+}
+
+// Matches returns whether the grouping labels of the group match at least one
+// of the provided selectors. A selector is a list of label matchers that all
+// have to match. A label missing from the grouping labels matches like a label
+// with an empty value.
+func (mg MetricGroup) Matches(selectors [][]*labels.Matcher) bool {
+	for _, selector := range selectors {
+		matches := true
+		for _, m := range selector {
+			if !m.Matches(mg.Labels[m.Name]) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// GroupSummary describes a metric group without its metrics, see
+// MetricStore.GetGroupSummaries. The fields are the results of the methods of
+// MetricGroup with the same name.
+type GroupSummary struct {
+	Key                  string // The grouping key.
+	Labels               map[string]string
+	LastPushTime         time.Time
+	LastPushFailureTime  time.Time
+	LastPushSuccess      bool
+	PushedMetricFamilies int
+}
+
+// Summary returns the GroupSummary of the group with the provided grouping key.
+func (mg MetricGroup) Summary(key string) GroupSummary {
+	return GroupSummary{
+		Key:                  key,
+		Labels:               mg.Labels,
+		LastPushTime:         mg.LastPushTime(),
+		LastPushFailureTime:  mg.LastPushFailureTime(),
+		LastPushSuccess:      mg.LastPushSuccess(),
+		PushedMetricFamilies: mg.PushedMetricFamilies(),
+	}
+}
+
+// Matches returns whether the grouping labels of the group match at least one
+// of the provided selectors, see MetricGroup.Matches.
+func (gs GroupSummary) Matches(selectors [][]*labels.Matcher) bool {
+	return MetricGroup{Labels: gs.Labels}.Matches(selectors)
+}
+
 // NameToTimestampedMetricFamilyMap is the second level of the metric store,
 // keyed by metric name.
 type NameToTimestampedMetricFamilyMap map[string]TimestampedMetricFamily
@@ -0,0 +1,203 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Limit identifies one of the Limits.
+type Limit string
+
+// The possible Limits.
+const (
+	LimitSeriesPerPush         Limit = "series_per_push"
+	LimitSeriesPerGroup        Limit = "series_per_group"
+	LimitSeriesPerMetricFamily Limit = "series_per_metric_family"
+	LimitSeriesTotal           Limit = "series_total"
+	LimitLabelsPerSeries       Limit = "labels_per_series"
+	LimitLabelValueLength      Limit = "label_value_length"
+)
+
+var limitRejections = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "pushgateway_limit_rejections_total",
+		Help: "Total write requests rejected by the Pushgateway because they exceeded a limit.",
+	},
+	[]string{"limit"},
+)
+
+func init() {
+	for _, l := range []Limit{
+		LimitSeriesPerPush, LimitSeriesPerGroup, LimitSeriesPerMetricFamily,
+		LimitSeriesTotal, LimitLabelsPerSeries, LimitLabelValueLength,
+	} {
+		limitRejections.WithLabelValues(string(l))
+	}
+}
+
+// Limits are the limits a WriteRequest must not exceed. The limits on pushed
+// series count the series as pushed, i.e. for increments and merges without the
+// stored series they are combined with, while SeriesPerGroup and SeriesTotal
+// count the series after the WriteRequest has been applied. A series is a
+// Metric in a MetricFamily, i.e. a histogram or a summary counts as one series.
+// The push_time_seconds and push_failure_time_seconds metrics added by the
+// MetricStore are not counted. The labels of a series include the grouping
+// labels. Zero means no limit.
+type Limits struct {
+	SeriesPerPush         int `yaml:"series_per_push"`          // Series in the WriteRequest.
+	SeriesPerGroup        int `yaml:"series_per_group"`         // Series in the changed group.
+	SeriesPerMetricFamily int `yaml:"series_per_metric_family"` // Series in each MetricFamily of the WriteRequest.
+	SeriesTotal           int `yaml:"series_total"`             // Series in the whole MetricStore.
+	LabelsPerSeries       int `yaml:"labels_per_series"`        // Labels of each series in the WriteRequest.
+	LabelValueLength      int `yaml:"label_value_length"`       // Length in bytes of each label value in the WriteRequest.
+}
+
+// LimitError is the error for a WriteRequest exceeding one of the Limits.
+type LimitError struct {
+	Limit  Limit
+	Max    int    // The configured limit.
+	Actual int    // The value exceeding the limit.
+	Detail string // Where the limit was exceeded, e.g. the metric family. Might be empty.
+}
+
+func (e *LimitError) Error() string {
+	msg := fmt.Sprintf("limit %s exceeded: %d > %d", e.Limit, e.Actual, e.Max)
+	if e.Detail != "" {
+		msg += " (" + e.Detail + ")"
+	}
+	return msg
+}
+
+// WithLimits sets the Limits enforced on WriteRequests. They are enforced
+// even if the consistency check is skipped (see checkWriteRequest).
+func WithLimits(l Limits) Option {
+	return func(dms *DiskMetricStore) {
+		dms.limits = l
+	}
+}
+
+// checkPushLimits returns a *LimitError if the MetricFamilies of the provided
+// WriteRequest exceed one of the Limits on what is pushed (SeriesPerPush,
+// SeriesPerMetricFamily, LabelsPerSeries, and LabelValueLength). The
+// MetricFamilies must already be sanitized but not yet resolved (for
+// increments and merges) so that only the pushed series are counted, not the
+// stored series they are combined with.
+func (dms *DiskMetricStore) checkPushLimits(wr WriteRequest) error {
+	return countRejection(dms.findPushLimitViolation(wr))
+}
+
+// checkStoreLimits returns a *LimitError if applying the provided WriteRequest
+// would exceed SeriesPerGroup or SeriesTotal. The MetricFamilies of the
+// WriteRequest must already be sanitized and resolved (for increments and
+// merges). The caller must hold the (read) lock.
+func (dms *DiskMetricStore) checkStoreLimits(wr WriteRequest) error {
+	return countRejection(dms.findStoreLimitViolation(wr))
+}
+
+// countRejection counts the rejection for the provided *LimitError, if not nil,
+// and returns it as an error.
+func countRejection(err *LimitError) error {
+	if err == nil {
+		return nil
+	}
+	limitRejections.WithLabelValues(string(err.Limit)).Inc()
+	return err
+}
+
+func (dms *DiskMetricStore) findPushLimitViolation(wr WriteRequest) *LimitError {
+	l := dms.limits
+	if l.SeriesPerPush == 0 && l.SeriesPerMetricFamily == 0 && l.LabelsPerSeries == 0 && l.LabelValueLength == 0 {
+		return nil
+	}
+
+	pushSeries := 0
+	for name, mf := range wr.MetricFamilies {
+		series := len(mf.GetMetric())
+		pushSeries += series
+		if l.SeriesPerMetricFamily > 0 && series > l.SeriesPerMetricFamily {
+			return &LimitError{Limit: LimitSeriesPerMetricFamily, Max: l.SeriesPerMetricFamily, Actual: series, Detail: "metric family " + name}
+		}
+		if l.LabelsPerSeries == 0 && l.LabelValueLength == 0 {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if labels := len(m.GetLabel()); l.LabelsPerSeries > 0 && labels > l.LabelsPerSeries {
+				return &LimitError{Limit: LimitLabelsPerSeries, Max: l.LabelsPerSeries, Actual: labels, Detail: "metric family " + name}
+			}
+			if l.LabelValueLength == 0 {
+				continue
+			}
+			for _, lp := range m.GetLabel() {
+				if length := len(lp.GetValue()); length > l.LabelValueLength {
+					return &LimitError{Limit: LimitLabelValueLength, Max: l.LabelValueLength, Actual: length, Detail: fmt.Sprintf("label %s of metric family %s", lp.GetName(), name)}
+				}
+			}
+		}
+	}
+	if l.SeriesPerPush > 0 && pushSeries > l.SeriesPerPush {
+		return &LimitError{Limit: LimitSeriesPerPush, Max: l.SeriesPerPush, Actual: pushSeries}
+	}
+	return nil
+}
+
+func (dms *DiskMetricStore) findStoreLimitViolation(wr WriteRequest) *LimitError {
+	l := dms.limits
+	if l.SeriesPerGroup == 0 && l.SeriesTotal == 0 {
+		return nil
+	}
+
+	// The series in the group after applying the WriteRequest are the
+	// ones of the (resolved) MetricFamilies and, unless the whole group is
+	// replaced, the stored ones in metric families not contained in the
+	// WriteRequest.
+	stored := dms.metricGroups[groupingKeyFor(wr.Labels)]
+	groupSeries := 0
+	for _, mf := range wr.MetricFamilies {
+		groupSeries += len(mf.GetMetric())
+	}
+	if !wr.Replace {
+		for name, tmf := range stored.Metrics {
+			if _, ok := wr.MetricFamilies[name]; !ok {
+				groupSeries += countSeries(name, tmf)
+			}
+		}
+	}
+	if l.SeriesPerGroup > 0 && groupSeries > l.SeriesPerGroup {
+		return &LimitError{Limit: LimitSeriesPerGroup, Max: l.SeriesPerGroup, Actual: groupSeries}
+	}
+	if l.SeriesTotal == 0 {
+		return nil
+	}
+	totalSeries := dms.index.series + groupSeries
+	for name, tmf := range stored.Metrics {
+		totalSeries -= countSeries(name, tmf)
+	}
+	if totalSeries > l.SeriesTotal {
+		return &LimitError{Limit: LimitSeriesTotal, Max: l.SeriesTotal, Actual: totalSeries}
+	}
+	return nil
+}
+
+// countSeries returns the number of series in the provided
+// TimestampedMetricFamily of the provided name as counted by the Limits.
+func countSeries(name string, tmf TimestampedMetricFamily) int {
+	if name == pushMetricName || name == pushFailedMetricName {
+		return 0
+	}
+	return len(tmf.GetMetricFamily().GetMetric())
+}
@@ -0,0 +1,252 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gaugeFamily returns a gauge MetricFamily with the provided name and one
+// series for each of the provided values of the label "series".
+func gaugeFamily(name string, seriesLabelValues ...string) *dto.MetricFamily {
+	mf := &dto.MetricFamily{
+		Name: proto.String(name),
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+	for _, v := range seriesLabelValues {
+		mf.Metric = append(mf.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{{Name: proto.String("series"), Value: proto.String(v)}},
+			Gauge: &dto.Gauge{Value: proto.Float64(1)},
+		})
+	}
+	return mf
+}
+
+func TestLimits(t *testing.T) {
+	limits := Limits{
+		SeriesPerPush:         4,
+		SeriesPerGroup:        5,
+		SeriesPerMetricFamily: 3,
+		SeriesTotal:           8,
+		LabelsPerSeries:       3, // Including job, instance, and series.
+		LabelValueLength:      10,
+	}
+	groupingA := map[string]string{"job": "a"}
+	groupingB := map[string]string{"job": "b"}
+
+	scenarios := []struct {
+		name          string
+		wr            WriteRequest
+		expectedLimit Limit // Empty if the request is accepted.
+	}{
+		{
+			name: "initial push to a",
+			wr: WriteRequest{Labels: groupingA, MetricFamilies: map[string]*dto.MetricFamily{
+				"mf1": gaugeFamily("mf1", "1", "2", "3"),
+			}},
+		},
+		{
+			name: "too many series in metric family",
+			wr: WriteRequest{Labels: groupingA, MetricFamilies: map[string]*dto.MetricFamily{
+				"mf2": gaugeFamily("mf2", "1", "2", "3", "4"),
+			}},
+			expectedLimit: LimitSeriesPerMetricFamily,
+		},
+		{
+			name: "too many series in push",
+			wr: WriteRequest{Labels: groupingB, MetricFamilies: map[string]*dto.MetricFamily{
+				"mf1": gaugeFamily("mf1", "1", "2", "3"),
+				"mf2": gaugeFamily("mf2", "1", "2"),
+			}},
+			expectedLimit: LimitSeriesPerPush,
+		},
+		{
+			name: "too many series in group",
+			wr: WriteRequest{Labels: groupingA, MetricFamilies: map[string]*dto.MetricFamily{
+				"mf2": gaugeFamily("mf2", "1", "2", "3"),
+			}},
+			expectedLimit: LimitSeriesPerGroup,
+		},
+		{
+			name: "series replacing a metric family don't count twice",
+			wr: WriteRequest{Labels: groupingA, MetricFamilies: map[string]*dto.MetricFamily{
+				"mf1": gaugeFamily("mf1", "1"),
+				"mf2": gaugeFamily("mf2", "1", "2", "3"),
+			}},
+		},
+		{
+			name: "series replacing the group don't count twice",
+			wr: WriteRequest{Labels: groupingA, Replace: true, MetricFamilies: map[string]*dto.MetricFamily{
+				"mf3": gaugeFamily("mf3", "1", "2", "3"),
+				"mf4": gaugeFamily("mf4", "1"),
+			}},
+		},
+		{
+			name: "initial push to b",
+			wr: WriteRequest{Labels: groupingB, MetricFamilies: map[string]*dto.MetricFamily{
+				"mf1": gaugeFamily("mf1", "1", "2", "3"),
+			}},
+		},
+		{
+			name: "too many series in total",
+			wr: WriteRequest{Labels: groupingB, MetricFamilies: map[string]*dto.MetricFamily{
+				"mf2": gaugeFamily("mf2", "1", "2"),
+			}},
+			expectedLimit: LimitSeriesTotal,
+		},
+		{
+			name: "too many labels",
+			wr: WriteRequest{Labels: map[string]string{"job": "b", "instance": "i", "env": "prod"}, MetricFamilies: map[string]*dto.MetricFamily{
+				"mf1": gaugeFamily("mf1", "1"),
+			}},
+			expectedLimit: LimitLabelsPerSeries,
+		},
+		{
+			name: "too long label value",
+			wr: WriteRequest{Labels: groupingB, MetricFamilies: map[string]*dto.MetricFamily{
+				"mf1": gaugeFamily("mf1", "12345678901"),
+			}},
+			expectedLimit: LimitLabelValueLength,
+		},
+		{
+			name: "too long grouping label value",
+			wr: WriteRequest{Labels: map[string]string{"job": "b-123456789"}, MetricFamilies: map[string]*dto.MetricFamily{
+				"mf1": gaugeFamily("mf1", "1"),
+			}},
+			expectedLimit: LimitLabelValueLength,
+		},
+		{
+			name:          "unchecked push still limited",
+			wr:            WriteRequest{Labels: groupingB, MetricFamilies: map[string]*dto.MetricFamily{"mf2": gaugeFamily("mf2", "1", "2")}},
+			expectedLimit: LimitSeriesTotal,
+		},
+	}
+
+	dms := NewDiskMetricStore("", 100*time.Millisecond, nil, logger, WithLimits(limits))
+	defer dms.Shutdown()
+	ts := time.Unix(1700000000, 0)
+	for i, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			var rejectionsBefore float64
+			if s.expectedLimit != "" {
+				rejectionsBefore = testutil.ToFloat64(limitRejections.WithLabelValues(string(s.expectedLimit)))
+			}
+			s.wr.Timestamp = ts.Add(time.Duration(i) * time.Second)
+			unchecked := strings.HasPrefix(s.name, "unchecked")
+			var errCh chan error
+			if !unchecked {
+				errCh = make(chan error, 1)
+				s.wr.Done = errCh
+			}
+			dms.SubmitWriteRequest(s.wr)
+
+			if unchecked {
+				// Wait for the request to be processed.
+				done := make(chan error, 1)
+				dms.SubmitWriteRequest(WriteRequest{Labels: map[string]string{"job": "sync"}, Timestamp: ts, Done: done})
+				for range done {
+				}
+			} else {
+				err := <-errCh
+				if s.expectedLimit == "" {
+					if err != nil {
+						t.Fatal("Unexpected error:", err)
+					}
+					return
+				}
+				var limitErr *LimitError
+				if !errors.As(err, &limitErr) || limitErr.Limit != s.expectedLimit {
+					t.Fatalf("Expected error for limit %s, got %v.", s.expectedLimit, err)
+				}
+			}
+			if expected, got := rejectionsBefore+1, testutil.ToFloat64(limitRejections.WithLabelValues(string(s.expectedLimit))); expected != got {
+				t.Errorf("Expected %v rejections, got %v.", expected, got)
+			}
+			group, ok := dms.GetMetricFamiliesMap()[groupingKeyFor(s.wr.Labels)]
+			if !ok {
+				t.Fatal("Expected group with push failure timestamp.")
+			}
+			if expected, got := s.wr.Timestamp, group.LastPushFailureTime(); !expected.Equal(got) {
+				t.Errorf("Expected push failure time %v, got %v.", expected, got)
+			}
+		})
+	}
+}
+
+func TestLimitsCountPushedSeries(t *testing.T) {
+	dms := NewDiskMetricStore(
+		"", 100*time.Millisecond, nil, logger,
+		WithLimits(Limits{SeriesPerPush: 2, SeriesPerMetricFamily: 2}),
+		WithGaugeIncrements(true),
+	)
+	defer dms.Shutdown()
+	grouping := map[string]string{"job": "a"}
+
+	scenarios := []struct {
+		name          string
+		wr            WriteRequest
+		expectedLimit Limit // Empty if the request is accepted.
+	}{
+		{
+			name: "initial push at the limit",
+			wr:   WriteRequest{MetricFamilies: map[string]*dto.MetricFamily{"mf": gaugeFamily("mf", "1", "2")}},
+		},
+		{
+			name: "merge beyond the limit",
+			wr:   WriteRequest{Merge: true, MetricFamilies: map[string]*dto.MetricFamily{"mf": gaugeFamily("mf", "3")}},
+		},
+		{
+			name: "increment of a metric family above the limit",
+			wr:   WriteRequest{Increment: true, MetricFamilies: map[string]*dto.MetricFamily{"mf": gaugeFamily("mf", "1", "2")}},
+		},
+		{
+			name:          "increment with too many series",
+			wr:            WriteRequest{Increment: true, MetricFamilies: map[string]*dto.MetricFamily{"mf": gaugeFamily("mf", "1", "2", "3")}},
+			expectedLimit: LimitSeriesPerMetricFamily,
+		},
+	}
+	for _, s := range scenarios {
+		s.wr.Labels = grouping
+		s.wr.Timestamp = time.Now()
+		err := submitAndWait(dms, s.wr)
+		if s.expectedLimit == "" {
+			if err != nil {
+				t.Errorf("%s: Unexpected error: %v", s.name, err)
+			}
+			continue
+		}
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != s.expectedLimit {
+			t.Errorf("%s: Expected error for limit %s, got %v.", s.name, s.expectedLimit, err)
+		}
+	}
+
+	mf := dms.GetMetricFamiliesMap()[groupingKeyFor(grouping)].Metrics["mf"].GetMetricFamily()
+	values := []float64{}
+	for _, m := range mf.GetMetric() {
+		values = append(values, m.GetGauge().GetValue())
+	}
+	if expected, got := "[2 2 1]", fmt.Sprint(values); expected != got {
+		t.Errorf("Expected values %s, got %s.", expected, got)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ProblemKind classifies a Problem.
+type ProblemKind string
+
+// The kinds of Problems.
+const (
+	// ProblemTimestamp is a pushed series with a timestamp.
+	ProblemTimestamp ProblemKind = "timestamp"
+	// ProblemTypeConflict is a metric family whose type differs from the
+	// one of the same name in other groups or in the default registry.
+	ProblemTypeConflict ProblemKind = "type_conflict"
+	// ProblemHelpConflict is a metric family whose help string differs
+	// from the one of the same name in the default registry.
+	ProblemHelpConflict ProblemKind = "help_conflict"
+	// ProblemNameCollision is a metric family whose name collides with the
+	// names of the series of a histogram or summary.
+	ProblemNameCollision ProblemKind = "name_collision"
+	// ProblemDuplicateSeries is a series with the same name and labels as
+	// another one.
+	ProblemDuplicateSeries ProblemKind = "duplicate_series"
+	// ProblemInvalidName is an invalid metric or label name.
+	ProblemInvalidName ProblemKind = "invalid_name"
+	// ProblemInvalidSeries is a series that is invalid otherwise, e.g. one
+	// with a value not matching the type of its metric family.
+	ProblemInvalidSeries ProblemKind = "invalid_series"
+	// ProblemLimit is an exceeded limit, see LimitError.
+	ProblemLimit ProblemKind = "limit"
+	// ProblemOther is any other reason to reject a push, e.g. a failed
+	// increment or relabeling.
+	ProblemOther ProblemKind = "other"
+)
+
+// Problem is a reason for the rejection of a WriteRequest, as found by the
+// checks performed while processing it or by Validate.
+type Problem struct {
+	Kind         ProblemKind
+	MetricFamily string            // Empty if not specific to a metric family.
+	Labels       map[string]string // Of the series, nil if not specific to a series.
+	Message      string
+
+	err error // The underlying error, if any, e.g. ErrTimestamp or a *LimitError.
+}
+
+func newProblem(kind ProblemKind, metricFamily, format string, args ...any) *Problem {
+	return &Problem{Kind: kind, MetricFamily: metricFamily, Message: fmt.Sprintf(format, args...)}
+}
+
+// newSeriesProblem is like newProblem for a Problem specific to the provided
+// Metric. Labels with an empty value are equivalent to missing labels and are
+// therefore not included in the Labels of the Problem.
+func newSeriesProblem(kind ProblemKind, metricFamily string, m *dto.Metric, format string, args ...any) *Problem {
+	p := newProblem(kind, metricFamily, format, args...)
+	p.Labels = make(map[string]string, len(m.GetLabel()))
+	for _, lp := range m.GetLabel() {
+		if lp.GetValue() != "" {
+			p.Labels[lp.GetName()] = lp.GetValue()
+		}
+	}
+	return p
+}
+
+func (p *Problem) Error() string {
+	return p.Message
+}
+
+// Unwrap returns the underlying error, if any.
+func (p *Problem) Unwrap() error {
+	return p.err
+}
+
+// problemFor returns the provided error as a Problem. Errors that are no
+// Problem are of kind ProblemLimit for a LimitError and ProblemOther otherwise.
+func problemFor(err error) *Problem {
+	var (
+		p *Problem
+		l *LimitError
+	)
+	switch {
+	case errors.As(err, &p):
+		return p
+	case errors.As(err, &l):
+		return &Problem{Kind: ProblemLimit, Message: err.Error(), err: err}
+	default:
+		return &Problem{Kind: ProblemOther, Message: err.Error(), err: err}
+	}
+}
+
+// Problems is the error sent to the Done channel of a WriteRequest that has
+// been rejected by the checks performed while processing it. It lists all
+// Problems found. Use AsProblems to retrieve them from an error.
+type Problems []*Problem
+
+// Error returns the message of the only Problem or, for multiple Problems, a
+// message listing all of them in the format of prometheus.MultiError.
+func (ps Problems) Error() string {
+	if len(ps) == 1 {
+		return ps[0].Error()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d error(s) occurred:", len(ps))
+	for _, p := range ps {
+		fmt.Fprintf(&b, "\n* %s", p)
+	}
+	return b.String()
+}
+
+// Unwrap returns the Problems as errors so that errors.Is and errors.As look
+// at each of them.
+func (ps Problems) Unwrap() []error {
+	errs := make([]error, len(ps))
+	for i, p := range ps {
+		errs[i] = p
+	}
+	return errs
+}
+
+// orNil returns the Problems as an error, or nil if there are none.
+func (ps Problems) orNil() error {
+	if len(ps) == 0 {
+		return nil
+	}
+	return ps
+}
+
+// and returns the provided error (which must not be nil) appended to the
+// Problems, or just the error if there are no Problems.
+func (ps Problems) and(err error) error {
+	if len(ps) == 0 {
+		return err
+	}
+	return append(slices.Clip(ps), problemFor(err))
+}
+
+// AsProblems returns the Problems of the provided error as sent to the Done
+// channel of a WriteRequest: the listed Problems if it is (or wraps) Problems,
+// a single Problem for any other error, or nil for a nil error.
+func AsProblems(err error) Problems {
+	if err == nil {
+		return nil
+	}
+	var ps Problems
+	if errors.As(err, &ps) {
+		return ps
+	}
+	return Problems{problemFor(err)}
+}
@@ -0,0 +1,102 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestProblems(t *testing.T) {
+	dms := NewDiskMetricStore("", time.Hour, nil, logger, WithLimits(Limits{SeriesPerMetricFamily: 3}))
+	defer dms.Shutdown()
+	if err := submitAndWait(dms, WriteRequest{
+		Labels:         map[string]string{"job": "a"},
+		Timestamp:      time.Now(),
+		MetricFamilies: newSequenceFamily(dto.MetricType_GAUGE, 1),
+	}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	withTimestamps := gaugeFamily("with_ts", "x", "y")
+	for _, m := range withTimestamps.Metric {
+		m.TimestampMs = proto.Int64(1000)
+	}
+	err := submitAndWait(dms, WriteRequest{
+		Labels:    map[string]string{"job": "b"},
+		Timestamp: time.Now(),
+		MetricFamilies: map[string]*dto.MetricFamily{
+			"sequence": newSequenceFamily(dto.MetricType_COUNTER, 2)["sequence"],
+			"with_ts":  withTimestamps,
+			"dup":      gaugeFamily("dup", "x", "x", "y", "z"),
+		},
+	})
+
+	// All problems are reported, not only the first one.
+	problems := AsProblems(err)
+	var got []string
+	for _, p := range problems {
+		got = append(got, fmt.Sprintf("%s/%s %v", p.Kind, p.MetricFamily, p.Labels))
+	}
+	expected := "[" +
+		"timestamp/with_ts map[job:b series:x] " +
+		"timestamp/with_ts map[job:b series:y] " +
+		"limit/ map[] " +
+		"duplicate_series/dup map[job:b series:x] " +
+		"type_conflict/sequence map[job:b]" +
+		"]"
+	if fmt.Sprint(got) != expected {
+		t.Errorf("Expected problems %s, got %v.", expected, got)
+	}
+	if !errors.Is(err, ErrTimestamp) {
+		t.Errorf("Expected error to wrap %v, got %v.", ErrTimestamp, err)
+	}
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitSeriesPerMetricFamily {
+		t.Errorf("Expected error to wrap limit error, got %v.", err)
+	}
+	if expected, got := "5 error(s) occurred:\n* pushed metrics must not have timestamps: ", err.Error(); !strings.HasPrefix(got, expected) {
+		t.Errorf("Expected error message starting with %q, got %q.", expected, got)
+	}
+	if expected, got := strings.Count(err.Error(), "\n* "), len(problems); expected != got {
+		t.Errorf("Expected %d problems in error message, got %d.", got, expected)
+	}
+
+	// A single problem is reported with its message only.
+	err = submitAndWait(dms, WriteRequest{
+		Labels:         map[string]string{"job": "b"},
+		Timestamp:      time.Now(),
+		MetricFamilies: map[string]*dto.MetricFamily{"dup": gaugeFamily("dup", "x", "x")},
+	})
+	if expected, got := `collected metric "dup" { label:{name:"instance" value:""} label:{name:"job" value:"b"} label:{name:"series" value:"x"} gauge:{value:1}} was collected before with the same name and label values`, strings.ReplaceAll(fmt.Sprint(err), "  ", " "); expected != got {
+		t.Errorf("Expected error %q, got %q.", expected, got)
+	}
+
+	// Errors other than Problems are a single Problem of kind ProblemOther.
+	other := errors.New("something else")
+	if expected, got := (Problems{{Kind: ProblemOther, Message: "something else", err: other}}), AsProblems(other); !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected problems %v, got %v.", expected, got)
+	}
+	if got := AsProblems(nil); got != nil {
+		t.Errorf("Expected no problems, got %v.", got)
+	}
+}
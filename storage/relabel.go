@@ -0,0 +1,212 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+var relabelDrops = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "pushgateway_relabel_drops_total",
+		Help: "Total pushes (target=group) and pushed series (target=series) dropped by relabeling.",
+	},
+	[]string{"target"},
+)
+
+func init() {
+	relabelDrops.WithLabelValues("group")
+	relabelDrops.WithLabelValues("series")
+}
+
+// WithRelabeling sets the relabeling rules applied to WriteRequests before
+// they are checked: relabelConfigs to the grouping labels, and
+// metricRelabelConfigs to the labels of each pushed series (see relabel). The
+// rules must have been validated.
+func WithRelabeling(relabelConfigs, metricRelabelConfigs []*relabel.Config) Option {
+	return func(dms *DiskMetricStore) {
+		dms.relabelConfigs = relabelConfigs
+		dms.metricRelabelConfigs = metricRelabelConfigs
+	}
+}
+
+// relabel returns the provided WriteRequest after applying the relabeling rules
+// of the dms to it, or false if the WriteRequest is dropped as a whole.
+//
+// The relabelConfigs are applied to the grouping labels of pushes and of
+// deletions of a single group (but not of batch deletions), so that rewriting
+// a grouping label moves the push to another group.
+//
+// The metricRelabelConfigs are applied to each series of a push, with the
+// metric name in the __name__ label and the (relabeled) grouping labels
+// added. Changes to the grouping labels have no effect here as they are set
+// again by sanitizeLabels. Series can be dropped, and renamed series are moved
+// to the metric family of their new name.
+//
+// Dropped groups and series are only counted if countDrops is true.
+//
+// Labels starting with "__" are removed after relabeling. If an error is
+// returned, the Labels of the returned WriteRequest identify the group to
+// record the failed push for, and its MetricFamilies must not be used.
+func (dms *DiskMetricStore) relabel(wr WriteRequest, countDrops bool) (WriteRequest, bool, error) {
+	if wr.batchDelete != nil {
+		return wr, true, nil
+	}
+	groupingLabels, keep, err := dms.relabelGroupingLabels(wr.Labels)
+	if !keep {
+		if countDrops {
+			relabelDrops.WithLabelValues("group").Inc()
+		}
+		return wr, false, nil
+	}
+	if err != nil {
+		return wr, true, err
+	}
+	wr.Labels = groupingLabels
+	if len(dms.metricRelabelConfigs) == 0 || wr.MetricFamilies == nil {
+		return wr, true, nil
+	}
+	mfs, err := relabelMetricFamilies(wr.MetricFamilies, wr.Labels, dms.metricRelabelConfigs, countDrops)
+	if err != nil {
+		return wr, true, err
+	}
+	wr.MetricFamilies = mfs
+	return wr, true, nil
+}
+
+// relabelGroupingLabels applies the relabelConfigs of the dms to the provided
+// grouping labels as described for relabel. It returns false if the group is
+// dropped. If an error is returned, the provided grouping labels are returned
+// unchanged.
+func (dms *DiskMetricStore) relabelGroupingLabels(groupingLabels map[string]string) (map[string]string, bool, error) {
+	if len(dms.relabelConfigs) == 0 {
+		return groupingLabels, true, nil
+	}
+	lb := labels.NewBuilder(labels.FromMap(groupingLabels))
+	if !relabel.ProcessBuilder(lb, dms.relabelConfigs...) {
+		return groupingLabels, false, nil
+	}
+	result := map[string]string{}
+	lb.Labels().Range(func(l labels.Label) {
+		if !strings.HasPrefix(l.Name, model.ReservedLabelPrefix) {
+			result[l.Name] = l.Value
+		}
+	})
+	if result[string(model.JobLabel)] == "" {
+		return groupingLabels, true, errors.New("relabeling removed the job label")
+	}
+	return result, true, nil
+}
+
+// RelabelGroupingLabels implements the MetricStore interface.
+func (dms *DiskMetricStore) RelabelGroupingLabels(groupingLabels map[string]string) (map[string]string, bool) {
+	dms.optionsLock.RLock()
+	defer dms.optionsLock.RUnlock()
+	relabeled, keep, _ := dms.relabelGroupingLabels(groupingLabels)
+	return relabeled, keep
+}
+
+// GroupingKey returns the grouping key of the metric group that a push or
+// deletion with the provided grouping labels applies to after relabeling. If
+// the push or deletion is dropped by relabeling, false is returned.
+func (dms *DiskMetricStore) GroupingKey(groupingLabels map[string]string) (string, bool) {
+	relabeled, keep := dms.RelabelGroupingLabels(groupingLabels)
+	if !keep {
+		return "", false
+	}
+	return groupingKeyFor(relabeled), true
+}
+
+// relabelMetricFamilies applies the provided relabeling rules to each series in
+// the provided MetricFamilies as described for relabel. The Metrics are
+// modified in place, but the MetricFamilies are returned in a new map.
+func relabelMetricFamilies(
+	mfs map[string]*dto.MetricFamily,
+	groupingLabels map[string]string,
+	cfgs []*relabel.Config,
+	countDrops bool,
+) (map[string]*dto.MetricFamily, error) {
+	result := make(map[string]*dto.MetricFamily, len(mfs))
+	lb := labels.NewBuilder(labels.EmptyLabels())
+	for name, mf := range mfs {
+		if len(mf.GetMetric()) == 0 {
+			// Keep metric families without series (i.e. only
+			// metadata) unless their name is taken by renamed series.
+			if _, ok := result[name]; !ok {
+				result[name] = mf
+			}
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			lb.Reset(labels.EmptyLabels())
+			for _, lp := range m.GetLabel() {
+				lb.Set(lp.GetName(), lp.GetValue())
+			}
+			for ln, lv := range groupingLabels {
+				lb.Set(ln, lv)
+			}
+			lb.Set(model.MetricNameLabel, name)
+			if !relabel.ProcessBuilder(lb, cfgs...) {
+				if countDrops {
+					relabelDrops.WithLabelValues("series").Inc()
+				}
+				continue
+			}
+			newName := lb.Get(model.MetricNameLabel)
+			if newName == "" {
+				return nil, fmt.Errorf("relabeling removed the metric name of a series of metric family %s", name)
+			}
+
+			m.Label = m.Label[:0]
+			lb.Range(func(l labels.Label) {
+				if strings.HasPrefix(l.Name, model.ReservedLabelPrefix) {
+					return
+				}
+				if _, ok := groupingLabels[l.Name]; ok {
+					return
+				}
+				m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(l.Name), Value: proto.String(l.Value)})
+			})
+
+			target, ok := result[newName]
+			if !ok || len(target.GetMetric()) == 0 {
+				target = &dto.MetricFamily{
+					Name: proto.String(newName),
+					Help: mf.Help,
+					Type: mf.Type,
+					Unit: mf.Unit,
+				}
+				result[newName] = target
+			} else if target.GetType() != mf.GetType() {
+				return nil, fmt.Errorf(
+					"relabeling moved series of type %s from metric family %s into metric family %s of type %s",
+					mf.GetType(), name, newName, target.GetType(),
+				)
+			}
+			target.Metric = append(target.Metric, m)
+		}
+	}
+	return result, nil
+}
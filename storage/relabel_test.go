@@ -0,0 +1,225 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/relabel"
+	"go.yaml.in/yaml/v2"
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func mustLoadRelabelConfigs(t *testing.T, s string) []*relabel.Config {
+	t.Helper()
+	var cfgs []*relabel.Config
+	if err := yaml.UnmarshalStrict([]byte(s), &cfgs); err != nil {
+		t.Fatal(err)
+	}
+	for _, cfg := range cfgs {
+		if err := cfg.Validate(model.LegacyValidation); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cfgs
+}
+
+// seriesLabels returns the labels of all series of the provided MetricFamily
+// as sorted strings like `job=a,series=1`.
+func seriesLabels(mf *dto.MetricFamily) []string {
+	var result []string
+	for _, m := range mf.GetMetric() {
+		pairs := make([]string, 0, len(m.GetLabel()))
+		for _, lp := range m.GetLabel() {
+			pairs = append(pairs, lp.GetName()+"="+lp.GetValue())
+		}
+		result = append(result, strings.Join(pairs, ","))
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestRelabel(t *testing.T) {
+	relabelConfigs := mustLoadRelabelConfigs(t, `
+- source_labels: [job]
+  regex: dropped
+  action: drop
+- source_labels: [team_legacy]
+  regex: (.+)
+  target_label: team
+- regex: team_legacy
+  action: labeldrop
+- source_labels: [instance]
+  modulus: 4
+  target_label: shard
+  action: hashmod
+- source_labels: [job]
+  regex: no-job
+  target_label: job
+  replacement: ""
+`)
+	metricRelabelConfigs := mustLoadRelabelConfigs(t, `
+- source_labels: [__name__]
+  regex: noisy_.*
+  action: drop
+- source_labels: [series]
+  regex: 1|2
+  action: keep
+- regex: pod_uid
+  action: labeldrop
+- source_labels: [__name__]
+  regex: old_(.*)
+  target_label: __name__
+  replacement: new_$1
+`)
+	dms := NewDiskMetricStore("", 100*time.Millisecond, nil, logger, WithRelabeling(relabelConfigs, metricRelabelConfigs))
+	defer dms.Shutdown()
+
+	push := func(grouping map[string]string, mfs ...*dto.MetricFamily) error {
+		errCh := make(chan error, 1)
+		wr := WriteRequest{
+			Labels:         grouping,
+			Timestamp:      time.Now(),
+			MetricFamilies: map[string]*dto.MetricFamily{},
+			Done:           errCh,
+		}
+		for _, mf := range mfs {
+			wr.MetricFamilies[mf.GetName()] = mf
+		}
+		dms.SubmitWriteRequest(wr)
+		return <-errCh
+	}
+
+	// A dropped push succeeds without creating a group.
+	if err := push(map[string]string{"job": "dropped"}, gaugeFamily("mf1", "1")); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if expected, got := 0, len(dms.GetMetricFamiliesMap()); expected != got {
+		t.Fatalf("Expected %d groups, got %d.", expected, got)
+	}
+
+	// Removing the job label fails the push. The failure is recorded for
+	// the original group.
+	if err := push(map[string]string{"job": "no-job"}, gaugeFamily("mf1", "1")); err == nil {
+		t.Error("Expected error for push without job label after relabeling.")
+	}
+	if _, ok := dms.GetMetricFamiliesMap()[groupingKeyFor(map[string]string{"job": "no-job"})]; !ok {
+		t.Error("Expected group with push failure timestamp.")
+	}
+
+	// The legacy label is renamed, a shard label is added, and series are
+	// dropped, stripped, and renamed.
+	withPodUID := gaugeFamily("mf1", "1")
+	withPodUID.Metric[0].Label = append(withPodUID.Metric[0].Label, &dto.LabelPair{
+		Name: proto.String("pod_uid"), Value: proto.String("1234"),
+	})
+	err := push(
+		map[string]string{"job": "a", "instance": "i1", "team_legacy": "x"},
+		withPodUID,
+		gaugeFamily("mf2", "1", "2", "3"),
+		gaugeFamily("noisy_mf", "1"),
+		gaugeFamily("old_mf", "1"),
+	)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	var group MetricGroup
+	for _, g := range dms.GetMetricFamiliesMap() {
+		if g.Labels["job"] == "a" {
+			group = g
+		}
+	}
+	if _, ok := group.Labels["team_legacy"]; ok {
+		t.Errorf("Expected team_legacy label to be dropped, got %v.", group.Labels)
+	}
+	if expected, got := "x", group.Labels["team"]; expected != got {
+		t.Errorf("Expected team label %q, got %q.", expected, got)
+	}
+	if _, ok := group.Labels["shard"]; !ok {
+		t.Errorf("Expected shard label, got %v.", group.Labels)
+	}
+	if groupingKeyFor(group.Labels) == groupingKeyFor(map[string]string{"job": "a", "instance": "i1", "team_legacy": "x"}) {
+		t.Error("Expected push to be moved to a new grouping key.")
+	}
+	if key, ok := dms.GroupingKey(map[string]string{"job": "a", "instance": "i1", "team_legacy": "x"}); !ok || key != groupingKeyFor(group.Labels) {
+		t.Errorf("Expected grouping key %q, got %q (kept %t).", groupingKeyFor(group.Labels), key, ok)
+	}
+	if _, ok := dms.GroupingKey(map[string]string{"job": "dropped"}); ok {
+		t.Error("Expected grouping key of dropped push not to be kept.")
+	}
+
+	shard := group.Labels["shard"]
+	expectedSeries := map[string][]string{
+		"mf1":    {"instance=i1,job=a,series=1,shard=" + shard + ",team=x"},
+		"mf2":    {"instance=i1,job=a,series=1,shard=" + shard + ",team=x", "instance=i1,job=a,series=2,shard=" + shard + ",team=x"},
+		"new_mf": {"instance=i1,job=a,series=1,shard=" + shard + ",team=x"},
+	}
+	for name, expected := range expectedSeries {
+		tmf, ok := group.Metrics[name]
+		if !ok {
+			t.Errorf("Expected metric family %s.", name)
+			continue
+		}
+		got := seriesLabels(tmf.GetMetricFamily())
+		if strings.Join(expected, " ") != strings.Join(got, " ") {
+			t.Errorf("Expected series %v in metric family %s, got %v.", expected, name, got)
+		}
+	}
+	for _, name := range []string{"noisy_mf", "old_mf"} {
+		if _, ok := group.Metrics[name]; ok {
+			t.Errorf("Expected no metric family %s.", name)
+		}
+	}
+
+	// A delete is relabeled like a push.
+	errCh := make(chan error, 1)
+	dms.SubmitWriteRequest(WriteRequest{
+		Labels:    map[string]string{"job": "a", "instance": "i1", "team_legacy": "x"},
+		Timestamp: time.Now(),
+		Done:      errCh,
+	})
+	for err := range errCh {
+		t.Fatal("Unexpected error:", err)
+	}
+	if _, ok := dms.GetMetricFamiliesMap()[groupingKeyFor(group.Labels)]; ok {
+		t.Error("Expected relabeled group to be deleted.")
+	}
+}
+
+func TestRelabelMetricFamiliesTypeConflict(t *testing.T) {
+	cfgs := mustLoadRelabelConfigs(t, `
+- source_labels: [__name__]
+  regex: mf_counter
+  target_label: __name__
+  replacement: mf_gauge
+`)
+	counter := &dto.MetricFamily{
+		Name:   proto.String("mf_counter"),
+		Type:   dto.MetricType_COUNTER.Enum(),
+		Metric: []*dto.Metric{{Counter: &dto.Counter{Value: proto.Float64(1)}}},
+	}
+	mfs := map[string]*dto.MetricFamily{
+		"mf_counter": counter,
+		"mf_gauge":   gaugeFamily("mf_gauge", "1"),
+	}
+	if _, err := relabelMetricFamilies(mfs, map[string]string{"job": "a"}, cfgs, true); err == nil {
+		t.Error("Expected error for series moved into metric family of another type.")
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ErrRestoring is wrapped by the error returned by DiskMetricStore.Ready while
+// the persisted metrics are still being restored.
+var ErrRestoring = errors.New("restoring persisted metrics")
+
+var (
+	restoreDuration = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "pushgateway_restore_duration_seconds",
+		Help: "Duration of restoring the persisted metrics, including the replay of the write-ahead log, during start-up.",
+	})
+	restoreProgress = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "pushgateway_restore_progress_ratio",
+		Help: "Progress of restoring the persisted metrics during start-up, from 0 to 1, measured in bytes of the persistence file and the write-ahead log.",
+	})
+)
+
+// initialize restores the persisted metrics, replays the write-ahead log, and
+// finally marks the DiskMetricStore as ready by closing dms.restored. It must
+// be called exactly once before the loop is started. Persisting and expiry
+// only happen in the loop, so they cannot interfere with the restore.
+//
+// If the persistence file is in the legacy format or was corrupted, it is
+// rewritten right away. If it could not be restored with CorruptionFail, the
+// restore is aborted, and dms.restoreErr is set.
+func (dms *DiskMetricStore) initialize() {
+	start := time.Now()
+	dms.restoreTotal.Store(dms.restoreSize())
+	restoreProgress.Set(0)
+
+	rewrite, err := dms.restore()
+	if err != nil {
+		dms.logger.Error("could not load persisted metrics", "err", err)
+		if dms.onCorruption == CorruptionFail {
+			dms.restoreErr = err
+			close(dms.restored)
+			return
+		}
+	}
+	if err := dms.startWAL(); err != nil {
+		dms.logger.Error("could not start write-ahead log", "err", err)
+	}
+	if rewrite {
+		if err := dms.persist(); err != nil {
+			dms.logger.Error("could not rewrite persistence file", "err", err)
+		}
+	}
+
+	restoreDuration.Set(time.Since(start).Seconds())
+	restoreProgress.Set(1)
+	close(dms.restored)
+	if dms.persistenceFile != "" {
+		dms.logger.Info("persisted metrics restored", "file", dms.persistenceFile, "duration", time.Since(start))
+	}
+}
+
+// restoreSize returns the total size in bytes of the persistence file and the
+// write-ahead log segments, i.e. of everything read by restore and startWAL.
+func (dms *DiskMetricStore) restoreSize() int64 {
+	if dms.persistenceFile == "" {
+		return 0
+	}
+	var size int64
+	if fi, err := os.Stat(dms.persistenceFile); err == nil {
+		size += fi.Size()
+	}
+	prefix := dms.persistenceFile + walSegmentInfix
+	seqs, _ := walSegments(prefix)
+	for _, seq := range seqs {
+		if fi, err := os.Stat(walSegmentName(prefix, seq)); err == nil {
+			size += fi.Size()
+		}
+	}
+	return size
+}
+
+// addRestoreProgress records that n more bytes have been restored.
+func (dms *DiskMetricStore) addRestoreProgress(n int64) {
+	read := dms.restoreRead.Add(n)
+	if total := dms.restoreTotal.Load(); total > 0 {
+		restoreProgress.Set(min(float64(read)/float64(total), 1))
+	}
+}
+
+// WaitRestored blocks until the persisted metrics have been restored. It
+// returns an error if the persistence file could not be restored with
+// CorruptionFail. In that case, the DiskMetricStore will never become ready and
+// never persist anything, and the caller should give up on it.
+func (dms *DiskMetricStore) WaitRestored() error {
+	<-dms.restored
+	return dms.restoreErr
+}
+
+// checkRestored returns nil once the restore is complete and an error wrapping
+// ErrRestoring with the current progress otherwise. If the restore has failed,
+// the error of the restore is returned.
+func (dms *DiskMetricStore) checkRestored() error {
+	select {
+	case <-dms.restored:
+		return dms.restoreErr
+	default:
+	}
+	total := dms.restoreTotal.Load()
+	if total == 0 {
+		return ErrRestoring
+	}
+	read := min(dms.restoreRead.Load(), total)
+	return fmt.Errorf(
+		"%w: %d of %d bytes read (%.0f%%)",
+		ErrRestoring, read, total, 100*float64(read)/float64(total),
+	)
+}
+
+// progressReader is an io.Reader reporting every read to the restore progress
+// of a DiskMetricStore.
+type progressReader struct {
+	r        io.Reader
+	progress func(int64)
+}
+
+func (pr progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.progress(int64(n))
+	return n, err
+}
@@ -0,0 +1,113 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestAsyncRestore(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "diskmetricstore.TestAsyncRestore.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	fileName := path.Join(tempDir, "persistence")
+
+	grouping1 := map[string]string{"job": "job1"}
+	grouping2 := map[string]string{"job": "job2"}
+	push := func(dms *DiskMetricStore, grouping map[string]string) {
+		errCh := make(chan error, 1)
+		dms.SubmitWriteRequest(WriteRequest{
+			Labels:         grouping,
+			Timestamp:      time.Now(),
+			MetricFamilies: map[string]*dto.MetricFamily{"mf": gaugeFamily("mf", "1")},
+			Done:           errCh,
+		})
+		for err := range errCh {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+
+	dms := NewDiskMetricStore(fileName, time.Hour, nil, logger)
+	<-dms.restored
+	push(dms, grouping1)
+	if err := dms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A push submitted right away is processed after the restore.
+	dms = NewDiskMetricStore(fileName, time.Hour, nil, logger)
+	push(dms, grouping2)
+	if err := dms.Ready(); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+	groups := dms.GetMetricFamiliesMap()
+	for _, grouping := range []map[string]string{grouping1, grouping2} {
+		if _, ok := groups[groupingKeyFor(grouping)]; !ok {
+			t.Errorf("Group %v missing.", grouping)
+		}
+	}
+	if expected, got := 1.0, testutil.ToFloat64(restoreProgress); expected != got {
+		t.Errorf("Expected restore progress %v, got %v.", expected, got)
+	}
+	if err := dms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadyDuringRestore(t *testing.T) {
+	dms := &DiskMetricStore{
+		shards:   []*writeShard{newWriteShard(0, 1)},
+		restored: make(chan struct{}),
+		logger:   logger,
+	}
+	dms.restoreTotal.Store(400)
+	dms.addRestoreProgress(100)
+
+	err := dms.Ready()
+	if !errors.Is(err, ErrRestoring) {
+		t.Fatalf("Expected error wrapping ErrRestoring, got %v.", err)
+	}
+	if !strings.Contains(err.Error(), "100 of 400 bytes read (25%)") {
+		t.Errorf("Expected progress in error, got %q.", err)
+	}
+	if expected, got := 0.25, testutil.ToFloat64(restoreProgress); expected != got {
+		t.Errorf("Expected restore progress %v, got %v.", expected, got)
+	}
+	if err := dms.Healthy(); err != nil {
+		t.Error("Expected healthy store during restore, got", err)
+	}
+	// Options cannot be applied before the loop runs after the restore.
+	if err := dms.ApplyOptions(WithLimits(Limits{SeriesPerPush: 1})); !errors.Is(err, ErrRestoring) {
+		t.Errorf("Expected error wrapping ErrRestoring, got %v.", err)
+	}
+	if expected, got := (Limits{}), dms.limits; expected != got {
+		t.Errorf("Expected limits %+v, got %+v.", expected, got)
+	}
+
+	close(dms.restored)
+	if err := dms.Ready(); err != nil {
+		t.Error("Unexpected error:", err)
+	}
+}
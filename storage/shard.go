@@ -0,0 +1,204 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	writeQueueLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pushgateway_write_queue_length",
+		Help: "Number of pushes and deletions waiting in the write queue of a shard.",
+	}, []string{"shard"})
+	writeQueueCapacity = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "pushgateway_write_queue_capacity",
+		Help: "Capacity of the write queue of each shard. Pushes are rejected while the write queue of their shard is full.",
+	})
+)
+
+// writeShard is one of the independent write queues of a DiskMetricStore. Each
+// WriteRequest is submitted to the shard selected by the hash of its grouping
+// key and processed by the goroutine of that shard, so that WriteRequests for
+// the same group are processed in order while WriteRequests for different
+// groups are processed concurrently.
+//
+// As relabeling might change the grouping key, the lock of the shard selected
+// by the resulting grouping key is held while a WriteRequest is checked and
+// processed, so that no other shard changes the same group in the meantime.
+type writeShard struct {
+	queue  chan WriteRequest
+	length prometheus.Gauge // Of queue, including WriteRequests about to be sent.
+	lock   sync.Mutex
+}
+
+func newWriteShard(i, capacity int) *writeShard {
+	length := writeQueueLength.WithLabelValues(strconv.Itoa(i))
+	length.Set(0)
+	return &writeShard{
+		queue:  make(chan WriteRequest, capacity),
+		length: length,
+	}
+}
+
+// WithWriteShards sets the number of write shards, i.e. how many WriteRequests
+// for different groups may be processed concurrently. The default is 1. Values
+// smaller than 1 are treated as 1. This Option is only effective when passed to
+// NewDiskMetricStore, not with ApplyOptions.
+func WithWriteShards(n int) Option {
+	return func(dms *DiskMetricStore) {
+		if dms.shards == nil {
+			dms.writeShards = max(n, 1)
+		}
+	}
+}
+
+// WithWriteQueueCapacity sets the number of WriteRequests that can be queued
+// in each write shard. The default is DefaultWriteQueueCapacity. Values smaller
+// than 1 are treated as 1. While the queue of a shard is full,
+// SubmitWriteRequest blocks and TrySubmitWriteRequest returns
+// ErrWriteQueueFull. This Option is only effective when passed to
+// NewDiskMetricStore, not with ApplyOptions.
+func WithWriteQueueCapacity(n int) Option {
+	return func(dms *DiskMetricStore) {
+		if dms.shards == nil {
+			dms.queueCapacity = max(n, 1)
+		}
+	}
+}
+
+// shardFor returns the writeShard responsible for the provided grouping key.
+func (dms *DiskMetricStore) shardFor(key string) *writeShard {
+	return dms.shards[xxhash.Sum64String(key)%uint64(len(dms.shards))]
+}
+
+// startShards starts one goroutine per writeShard processing its queue until
+// the DiskMetricStore is drained.
+func (dms *DiskMetricStore) startShards() {
+	for _, s := range dms.shards {
+		dms.shardsRunning.Add(1)
+		go func() {
+			defer dms.shardsRunning.Done()
+			for {
+				select {
+				case wr := <-s.queue:
+					s.length.Dec()
+					dms.handleShardRequest(wr)
+				case <-dms.drain:
+					for {
+						select {
+						case wr := <-s.queue:
+							s.length.Dec()
+							dms.handleShardRequest(wr)
+						default:
+							return
+						}
+					}
+				}
+			}
+		}()
+	}
+}
+
+// handleShardRequest handles a WriteRequest received from a shard queue. A
+// batch delete is submitted to all shards and only processed once all of them
+// have received it (see submitToAllShards).
+func (dms *DiskMetricStore) handleShardRequest(wr WriteRequest) {
+	if wr.batchDelete != nil {
+		if !wr.batchDelete.arrive() {
+			return
+		}
+		defer wr.batchDelete.release()
+	}
+	dms.optionsLock.RLock()
+	dms.handleWriteRequest(wr)
+	dms.optionsLock.RUnlock()
+	// Notify the loop to schedule persisting, unless it is notified
+	// already.
+	select {
+	case dms.written <- struct{}{}:
+	default:
+	}
+}
+
+// submitToAllShards submits the provided WriteRequest, which must be a batch
+// delete, to all shards without blocking. It is processed by the shard that
+// receives it last, while all other shards wait for it, so that it is
+// processed after all WriteRequests submitted to any shard before and before
+// all WriteRequests submitted to any shard later. If the queue of any shard is
+// full, the batch delete is cancelled, and ErrWriteQueueFull is returned.
+func (dms *DiskMetricStore) submitToAllShards(wr WriteRequest) error {
+	wr.batchDelete.pending = len(dms.shards)
+	wr.batchDelete.released = make(chan struct{})
+	// Submit to all shards atomically so that two batch deletes cannot
+	// wait for each other in different shards.
+	dms.submitAllLock.Lock()
+	defer dms.submitAllLock.Unlock()
+	for i, s := range dms.shards {
+		s.length.Inc()
+		select {
+		case s.queue <- wr:
+		default:
+			s.length.Dec()
+			wr.batchDelete.cancel(len(dms.shards) - i)
+			return ErrWriteQueueFull
+		}
+	}
+	return nil
+}
+
+// arrive records that a shard has received the batch delete. It returns true
+// for the last shard, which then processes the batch delete and has to call
+// release afterwards. For all other shards, and if the batch delete has been
+// cancelled, arrive blocks until the batch delete is released and returns
+// false.
+func (bd *batchDelete) arrive() bool {
+	bd.mtx.Lock()
+	bd.pending--
+	last := bd.pending == 0
+	cancelled := bd.cancelled
+	bd.mtx.Unlock()
+	if !last {
+		<-bd.released
+		return false
+	}
+	if cancelled {
+		bd.release()
+		return false
+	}
+	return true
+}
+
+// cancel cancels the batch delete after it could not be submitted to the
+// provided number of shards. The shards that have received it already skip it
+// once all of them have arrived.
+func (bd *batchDelete) cancel(unsubmitted int) {
+	bd.mtx.Lock()
+	bd.cancelled = true
+	bd.pending -= unsubmitted
+	last := bd.pending == 0
+	bd.mtx.Unlock()
+	if last {
+		bd.release()
+	}
+}
+
+func (bd *batchDelete) release() {
+	close(bd.released)
+}
@@ -0,0 +1,273 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/prometheus/prometheus/model/labels"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func newSequenceFamily(typ dto.MetricType, value float64) map[string]*dto.MetricFamily {
+	m := &dto.Metric{}
+	switch typ {
+	case dto.MetricType_COUNTER:
+		m.Counter = &dto.Counter{Value: proto.Float64(value)}
+	default:
+		m.Gauge = &dto.Gauge{Value: proto.Float64(value)}
+	}
+	return map[string]*dto.MetricFamily{
+		"sequence": {
+			Name:   proto.String("sequence"),
+			Help:   proto.String("Sequence number of the push."),
+			Type:   typ.Enum(),
+			Metric: []*dto.Metric{m},
+		},
+	}
+}
+
+func TestWriteShardsOrder(t *testing.T) {
+	dms := NewDiskMetricStore("", time.Hour, nil, logger, WithWriteShards(4))
+	if expected, got := 4, len(dms.shards); expected != got {
+		t.Fatalf("Expected %d shards, got %d.", expected, got)
+	}
+	// Changing the number of shards later has no effect.
+	dms.ApplyOptions(WithWriteShards(8))
+	if expected, got := 4, len(dms.shards); expected != got {
+		t.Fatalf("Expected %d shards after ApplyOptions, got %d.", expected, got)
+	}
+
+	const groups, pushes = 20, 50
+	var wg sync.WaitGroup
+	for g := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range pushes {
+				dms.SubmitWriteRequest(WriteRequest{
+					Labels:         map[string]string{"job": "ordered", "instance": fmt.Sprint(g)},
+					Timestamp:      time.Now(),
+					MetricFamilies: newSequenceFamily(dto.MetricType_GAUGE, float64(i)),
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The batch delete is only processed after all pushes submitted
+	// before, in any shard.
+	deleted, err := dms.DeleteGroups([][]*labels.Matcher{{
+		labels.MustNewMatcher(labels.MatchRegexp, "instance", "1.*"),
+	}}, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if expected, got := 11, len(deleted); expected != got {
+		t.Errorf("Expected %d deleted groups, got %d: %v", expected, got, deleted)
+	}
+
+	stored := dms.GetMetricFamiliesMap()
+	if expected, got := groups-11, len(stored); expected != got {
+		t.Errorf("Expected %d groups, got %d.", expected, got)
+	}
+	for key, group := range stored {
+		mf := group.Metrics["sequence"].GetMetricFamily()
+		if expected, got := float64(pushes-1), mf.GetMetric()[0].GetGauge().GetValue(); expected != got {
+			t.Errorf("Expected last pushed value %v in group %q, got %v.", expected, key, got)
+		}
+	}
+
+	if err := dms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteShardsConsistency(t *testing.T) {
+	for trial := range 20 {
+		dms := NewDiskMetricStore("", time.Hour, nil, logger, WithWriteShards(4))
+
+		// Push the same metric with different types to many groups
+		// concurrently. Only pushes of the type that wins the race may
+		// succeed.
+		var (
+			wg     sync.WaitGroup
+			mtx    sync.Mutex
+			failed = map[dto.MetricType]int{}
+		)
+		for g := range 8 {
+			typ := dto.MetricType_GAUGE
+			if g%2 == 1 {
+				typ = dto.MetricType_COUNTER
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := submitAndWait(dms, WriteRequest{
+					Labels:         map[string]string{"job": "racing", "instance": fmt.Sprint(g)},
+					Timestamp:      time.Now(),
+					MetricFamilies: newSequenceFamily(typ, 1),
+				})
+				if err != nil {
+					mtx.Lock()
+					failed[typ]++
+					mtx.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if len(failed) != 1 || failed[dto.MetricType_GAUGE]+failed[dto.MetricType_COUNTER] != 4 {
+			t.Errorf("Trial %d: Expected all pushes of exactly one type to fail, got failures %v.", trial, failed)
+		}
+		types := map[dto.MetricType]struct{}{}
+		for _, group := range dms.GetMetricFamiliesMap() {
+			if tmf, ok := group.Metrics["sequence"]; ok {
+				types[tmf.GetMetricFamily().GetType()] = struct{}{}
+			}
+		}
+		if len(types) != 1 {
+			t.Errorf("Trial %d: Expected stored metrics of one type, got %v.", trial, types)
+		}
+
+		if err := dms.Shutdown(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTrySubmitWriteRequest(t *testing.T) {
+	dms := NewDiskMetricStore("", time.Hour, nil, logger, WithWriteQueueCapacity(1))
+
+	// Block processing so that the queue fills up. The shard might take
+	// one WriteRequest from the queue before blocking.
+	dms.optionsLock.Lock()
+	submitted := 0
+	var err error
+	for submitted < 3 {
+		err = dms.TrySubmitWriteRequest(context.Background(), WriteRequest{
+			Labels:         map[string]string{"job": "queued", "instance": fmt.Sprint(submitted)},
+			Timestamp:      time.Now(),
+			MetricFamilies: newSequenceFamily(dto.MetricType_GAUGE, 1),
+		})
+		if err != nil {
+			break
+		}
+		submitted++
+	}
+	if !errors.Is(err, ErrWriteQueueFull) {
+		t.Errorf("Expected error %v after %d submitted WriteRequests, got %v.", ErrWriteQueueFull, submitted, err)
+	}
+	if dms.Healthy() == nil {
+		t.Error("Expected unhealthy store with full write queue.")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := dms.TrySubmitWriteRequest(ctx, WriteRequest{
+		Labels:    map[string]string{"job": "cancelled"},
+		Timestamp: time.Now(),
+	}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected error %v, got %v.", context.Canceled, err)
+	}
+	dms.optionsLock.Unlock()
+
+	if err := dms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := submitted, len(dms.GetMetricFamiliesMap()); expected != got {
+		t.Errorf("Expected %d groups, got %d.", expected, got)
+	}
+}
+
+func TestDeleteGroupsQueueFull(t *testing.T) {
+	dms := NewDiskMetricStore("", time.Hour, nil, logger, WithWriteShards(2), WithWriteQueueCapacity(1))
+
+	// Block processing and fill the queue of the last shard so that batch
+	// deletes are submitted to the first shard but not to the last one.
+	dms.optionsLock.Lock()
+	last := dms.shards[len(dms.shards)-1]
+	var keys []string
+	var err error
+	for i := 0; err == nil; i++ {
+		grouping := map[string]string{"job": "queued", "instance": fmt.Sprint(i)}
+		if dms.shardFor(groupingKeyFor(grouping)) != last {
+			continue
+		}
+		err = dms.TrySubmitWriteRequest(context.Background(), WriteRequest{
+			Labels:         grouping,
+			Timestamp:      time.Now(),
+			MetricFamilies: newSequenceFamily(dto.MetricType_GAUGE, 1),
+		})
+		if err == nil {
+			keys = append(keys, groupingKeyFor(grouping))
+		}
+	}
+
+	selectors := [][]*labels.Matcher{{labels.MustNewMatcher(labels.MatchEqual, "job", "queued")}}
+	for _, dryRun := range []bool{true, false} {
+		if _, err := dms.DeleteGroups(selectors, dryRun); !errors.Is(err, ErrWriteQueueFull) {
+			t.Errorf("Expected error %v with dry run %t, got %v.", ErrWriteQueueFull, dryRun, err)
+		}
+	}
+	if _, err := dms.DeleteGroupsByKey(keys); !errors.Is(err, ErrWriteQueueFull) {
+		t.Errorf("Expected error %v, got %v.", ErrWriteQueueFull, err)
+	}
+	dms.optionsLock.Unlock()
+
+	// Wait for all shards to process their queues.
+	for _, s := range dms.shards {
+		for i := 0; ; i++ {
+			grouping := map[string]string{"job": "sync", "instance": fmt.Sprint(i)}
+			if dms.shardFor(groupingKeyFor(grouping)) != s {
+				continue
+			}
+			if err := submitAndWait(dms, WriteRequest{
+				Labels:         grouping,
+				Timestamp:      time.Now(),
+				MetricFamilies: newSequenceFamily(dto.MetricType_GAUGE, 1),
+			}); err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			break
+		}
+	}
+
+	// The cancelled batch deletes have deleted nothing and don't block the
+	// shards.
+	deleted, err := dms.DeleteGroups(selectors, true)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if expected, got := len(keys), len(deleted); expected != got {
+		t.Errorf("Expected %d matching groups, got %d.", expected, got)
+	}
+	if deleted, err = dms.DeleteGroupsByKey(keys); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if expected, got := len(keys), len(deleted); expected != got {
+		t.Errorf("Expected %d deleted groups, got %d.", expected, got)
+	}
+	if err := dms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"slices"
+	"sync"
+)
+
+// The persistence file starts with a header consisting of snapshotMagic and
+// the format version as big-endian uint32. It is followed by one record per
+// metric group, framed like WAL records (see frameRecord) with the gob-encoded
+// MetricGroup (including its History) as payload. The file ends with a footer,
+// which is framed like a record but has snapshotFooterMarker in place of the
+// length and the number of records as big-endian uint64 as payload.
+//
+// A persistence file in the legacy format, a plain gob stream of a
+// GroupingKeyToMetricGroup, never starts with a zero byte and can therefore be
+// told apart by the missing snapshotMagic.
+const (
+	snapshotMagic        = "\x00PGWSNAP"
+	snapshotVersion      = 1
+	snapshotHeaderSize   = len(snapshotMagic) + 4
+	snapshotFooterMarker = math.MaxUint32
+	snapshotFooterSize   = 16
+	recordHeaderSize     = 8
+)
+
+var (
+	errSnapshotVersion   = errors.New("unsupported persistence file format version")
+	errSnapshotTruncated = errors.New("truncated persistence file")
+	errSnapshotChecksum  = errors.New("persistence file record checksum mismatch")
+	errSnapshotFooter    = errors.New("persistence file footer missing or invalid")
+)
+
+// CorruptionPolicy determines how a DiskMetricStore deals with a persistence
+// file that cannot be read completely.
+type CorruptionPolicy string
+
+const (
+	// CorruptionFail makes the restore fail. The DiskMetricStore never
+	// becomes ready and doesn't persist anything (see WaitRestored).
+	CorruptionFail CorruptionPolicy = "fail"
+	// CorruptionStartEmpty discards the whole persistence file.
+	CorruptionStartEmpty CorruptionPolicy = "start-empty"
+	// CorruptionSalvage restores every intact metric group from the
+	// persistence file.
+	CorruptionSalvage CorruptionPolicy = "salvage"
+)
+
+// WithCorruptionPolicy sets how a corrupted persistence file is dealt with upon
+// start-up. The default is CorruptionStartEmpty. With CorruptionStartEmpty and
+// CorruptionSalvage, the corrupted file is kept with the suffix
+// corruptedFileSuffix.
+func WithCorruptionPolicy(p CorruptionPolicy) Option {
+	return func(dms *DiskMetricStore) {
+		dms.onCorruption = p
+	}
+}
+
+// corruptedFileSuffix is appended to the name of a corrupted persistence file
+// to keep it for inspection.
+const corruptedFileSuffix = ".corrupted"
+
+// frameRecord fills in the first recordHeaderSize bytes of b, which have to be
+// reserved for it, with the length and the CRC32 (Castagnoli) checksum of the
+// rest of b, both as big-endian uint32.
+func frameRecord(b []byte) {
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(b)-recordHeaderSize))
+	binary.BigEndian.PutUint32(b[4:8], crc32.Checksum(b[recordHeaderSize:], castagnoliTable))
+}
+
+// writeSnapshot writes the provided metric groups to w in the snapshot format.
+func writeSnapshot(w io.Writer, groups GroupingKeyToMetricGroup) error {
+	bw := bufio.NewWriter(w)
+	header := make([]byte, snapshotHeaderSize)
+	copy(header, snapshotMagic)
+	binary.BigEndian.PutUint32(header[len(snapshotMagic):], snapshotVersion)
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, group := range groups {
+		buf.Reset()
+		buf.Write(make([]byte, recordHeaderSize))
+		if err := gob.NewEncoder(&buf).Encode(group); err != nil {
+			return err
+		}
+		b := buf.Bytes()
+		frameRecord(b)
+		if _, err := bw.Write(b); err != nil {
+			return err
+		}
+	}
+	footer := make([]byte, snapshotFooterSize)
+	binary.BigEndian.PutUint64(footer[recordHeaderSize:], uint64(len(groups)))
+	frameRecord(footer)
+	binary.BigEndian.PutUint32(footer[0:4], snapshotFooterMarker)
+	if _, err := bw.Write(footer); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// readPersistenceFile decodes a persistence file of the provided size in the
+// snapshot or the legacy format. The returned bool is true for the legacy
+// format. If the content is corrupted, an error is returned. In that case, the
+// returned metric groups are nil, unless salvage is true, in which case they
+// are all the groups that could be recovered. The file is read sequentially
+// (except when salvaging), and progress is called with the number of bytes
+// processed, after each record for the snapshot format.
+func readPersistenceFile(r io.ReaderAt, size int64, salvage bool, progress func(int64)) (GroupingKeyToMetricGroup, bool, error) {
+	magic := make([]byte, len(snapshotMagic))
+	if n, _ := r.ReadAt(magic, 0); n < len(magic) || string(magic) != snapshotMagic {
+		groups, err := readLegacySnapshot(progressReader{r: io.NewSectionReader(r, 0, size), progress: progress}, salvage)
+		return groups, true, err
+	}
+	groups, err := readSnapshot(r, size, salvage, progress)
+	return groups, false, err
+}
+
+// readLegacySnapshot decodes a gob stream of a GroupingKeyToMetricGroup. As the
+// legacy format has no framing, salvaging only keeps the groups decoded before
+// the first error.
+func readLegacySnapshot(r io.Reader, salvage bool) (GroupingKeyToMetricGroup, error) {
+	groups := GroupingKeyToMetricGroup{}
+	if err := gob.NewDecoder(r).Decode(&groups); err != nil {
+		if salvage {
+			return groups, err
+		}
+		return nil, err
+	}
+	return groups, nil
+}
+
+// readSnapshot decodes a persistence file of the provided size in the snapshot
+// format, record by record. Upon salvaging, corrupted records are skipped by
+// searching for the next intact frame.
+func readSnapshot(r io.ReaderAt, size int64, salvage bool, progress func(int64)) (GroupingKeyToMetricGroup, error) {
+	header := make([]byte, snapshotHeaderSize)
+	if size < int64(snapshotHeaderSize) {
+		return nil, errSnapshotTruncated
+	}
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if v := binary.BigEndian.Uint32(header[len(snapshotMagic):]); v != snapshotVersion {
+		return nil, fmt.Errorf("%w: %d", errSnapshotVersion, v)
+	}
+	progress(int64(snapshotHeaderSize))
+
+	var (
+		groups   = GroupingKeyToMetricGroup{}
+		firstErr error
+		records  uint64
+		footer   bool
+		buf      []byte
+		// prefix is the beginning of the payload of an intact record,
+		// see nextIntactFrame.
+		prefix []byte
+	)
+	pos := int64(snapshotHeaderSize)
+	br := bufio.NewReaderSize(io.NewSectionReader(r, pos, size-pos), snapshotReadBufferSize)
+	for pos < size {
+		length, frame, err := readFrame(br, size-pos, buf)
+		buf = frame[:0]
+		if err != nil && !errors.Is(err, errSnapshotTruncated) && !errors.Is(err, errSnapshotChecksum) {
+			return nil, err // An I/O error rather than corruption.
+		}
+		switch {
+		case err == nil && length == snapshotFooterMarker:
+			footer = true
+			if n := binary.BigEndian.Uint64(frame[recordHeaderSize:]); n != records {
+				firstErr = cmp.Or(firstErr, fmt.Errorf("%w: %d records expected, %d found", errSnapshotFooter, n, records))
+			}
+			if pos+snapshotFooterSize != size {
+				firstErr = cmp.Or(firstErr, fmt.Errorf("%w: data after footer at offset %d", errSnapshotFooter, pos))
+			}
+			progress(size - pos)
+			pos = size
+			continue
+		case err == nil:
+			var group MetricGroup
+			payload := frame[recordHeaderSize:]
+			err = gob.NewDecoder(bytes.NewReader(payload)).Decode(&group)
+			if err == nil {
+				groups[groupingKeyFor(group.Labels)] = group
+				records++
+				if prefix == nil {
+					prefix = bytes.Clone(payload[:min(len(payload), recordPrefixSize)])
+				}
+				progress(int64(len(frame)))
+				pos += int64(len(frame))
+				continue
+			}
+			firstErr = cmp.Or(firstErr, fmt.Errorf("undecodable record at offset %d: %w", pos, err))
+		default:
+			firstErr = cmp.Or(firstErr, fmt.Errorf("%w at offset %d", err, pos))
+		}
+		if !salvage {
+			return nil, firstErr
+		}
+		if prefix == nil {
+			prefix = defaultRecordPrefix()
+		}
+		next, err := nextIntactFrame(r, pos+1, size, prefix)
+		if err != nil {
+			return groups, cmp.Or(firstErr, err)
+		}
+		progress(next - pos)
+		pos = next
+		br.Reset(io.NewSectionReader(r, pos, size-pos))
+	}
+	if !footer {
+		firstErr = cmp.Or(firstErr, errSnapshotFooter)
+	}
+	if firstErr != nil && !salvage {
+		return nil, firstErr
+	}
+	return groups, firstErr
+}
+
+// snapshotReadBufferSize is the size of the buffer for reading persistence
+// files and of the window searched at once for intact frames.
+const snapshotReadBufferSize = 64 * 1024
+
+// frameSize returns the size of the record or footer (including its header)
+// with the provided length from its header, and whether it fits into the
+// remaining bytes of the file.
+func frameSize(length uint32, remaining int64) (int64, bool) {
+	size := int64(recordHeaderSize) + int64(length)
+	if length == snapshotFooterMarker {
+		size = snapshotFooterSize
+	}
+	return size, size <= remaining
+}
+
+// readFrame reads the record or footer at the current position of br, with
+// remaining bytes left in the file. It returns the length from its header and
+// the whole frame, which is read into buf if it has enough capacity. An error
+// wrapping errSnapshotTruncated is returned for a frame not fitting into the
+// remaining bytes, in which case nothing is read from br, and
+// errSnapshotChecksum for a checksum mismatch. Other errors are I/O errors.
+func readFrame(br *bufio.Reader, remaining int64, buf []byte) (uint32, []byte, error) {
+	header, _ := br.Peek(recordHeaderSize)
+	if len(header) < recordHeaderSize {
+		return 0, buf, fmt.Errorf("%w: incomplete record", errSnapshotTruncated)
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	size, ok := frameSize(length, remaining)
+	if !ok {
+		return length, buf, fmt.Errorf("%w: incomplete record", errSnapshotTruncated)
+	}
+	frame := slices.Grow(buf[:0], int(size))[:size]
+	if _, err := io.ReadFull(br, frame); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = fmt.Errorf("%w: incomplete record", errSnapshotTruncated)
+		}
+		return length, frame, err
+	}
+	if crc32.Checksum(frame[recordHeaderSize:], castagnoliTable) != binary.BigEndian.Uint32(frame[4:8]) {
+		return length, frame, errSnapshotChecksum
+	}
+	return length, frame, nil
+}
+
+// recordPrefixSize is the number of bytes at the beginning of a record payload
+// that have to match the prefix passed to nextIntactFrame.
+const recordPrefixSize = 32
+
+// defaultRecordPrefix returns the beginning of the gob encoding of a
+// MetricGroup as written by writeSnapshot.
+var defaultRecordPrefix = sync.OnceValue(func() []byte {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(MetricGroup{}); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()[:min(buf.Len(), recordPrefixSize)]
+})
+
+// nextIntactFrame returns the offset of the first intact frame at or after from
+// in the file of the provided size, or size if there is none.
+//
+// Each record is encoded with a new gob.Encoder and therefore starts with the
+// same type definitions. Only records whose payload starts with the provided
+// prefix (taken from an intact record of the same file if possible) and which
+// fit into the rest of the file are checksummed, so that the search through a
+// corrupted region takes linear time.
+func nextIntactFrame(r io.ReaderAt, from, size int64, prefix []byte) (int64, error) {
+	window := make([]byte, snapshotReadBufferSize+recordHeaderSize+len(prefix))
+	for start := from; start < size; start += snapshotReadBufferSize {
+		n, err := r.ReadAt(window[:min(int64(len(window)), size-start)], start)
+		if err != nil && err != io.EOF {
+			return size, err
+		}
+		for i := 0; i < min(n, snapshotReadBufferSize); i++ {
+			candidate := window[i:n]
+			if len(candidate) < recordHeaderSize {
+				break
+			}
+			length := binary.BigEndian.Uint32(candidate[0:4])
+			frameSize, ok := frameSize(length, size-start-int64(i))
+			if !ok {
+				continue
+			}
+			if length != snapshotFooterMarker &&
+				(int(length) < len(prefix) || len(candidate) < recordHeaderSize+len(prefix) ||
+					!bytes.Equal(candidate[recordHeaderSize:recordHeaderSize+len(prefix)], prefix)) {
+				continue
+			}
+			h := crc32.New(castagnoliTable)
+			if _, err := io.Copy(h, io.NewSectionReader(r, start+int64(i)+recordHeaderSize, frameSize-recordHeaderSize)); err != nil {
+				return size, err
+			}
+			if h.Sum32() == binary.BigEndian.Uint32(candidate[4:8]) {
+				return start + int64(i), nil
+			}
+		}
+	}
+	return size, nil
+}
@@ -0,0 +1,284 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// writePersistenceFile creates a persistence file with the provided number of
+// groups and returns its content.
+func writePersistenceFile(t *testing.T, fileName string, groups int) []byte {
+	t.Helper()
+	dms := NewDiskMetricStore(fileName, time.Hour, nil, logger)
+	for i := range groups {
+		if err := submitAndWait(dms, WriteRequest{
+			Labels:         map[string]string{"job": "snapshot", "instance": fmt.Sprint(i)},
+			Timestamp:      time.Now(),
+			MetricFamilies: map[string]*dto.MetricFamily{"mf": gaugeFamily("mf", "1")},
+		}); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+	if err := dms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// recordOffsets returns the offsets of all records and the footer in the
+// provided persistence file content.
+func recordOffsets(t *testing.T, b []byte) []int {
+	t.Helper()
+	var offsets []int
+	for pos := snapshotHeaderSize; pos < len(b); {
+		length, _, err := readFrame(bufio.NewReader(bytes.NewReader(b[pos:])), int64(len(b)-pos), nil)
+		if err != nil {
+			t.Fatalf("No intact frame at offset %d: %v", pos, err)
+		}
+		offsets = append(offsets, pos)
+		if length == snapshotFooterMarker {
+			break
+		}
+		pos += recordHeaderSize + int(length)
+	}
+	return offsets
+}
+
+// readPersistenceBytes calls readPersistenceFile for the provided content.
+func readPersistenceBytes(b []byte, salvage bool) (GroupingKeyToMetricGroup, bool, error) {
+	return readPersistenceFile(bytes.NewReader(b), int64(len(b)), salvage, func(int64) {})
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	tempDir := t.TempDir()
+	fileName := path.Join(tempDir, "persistence")
+	b := writePersistenceFile(t, fileName, 3)
+	if !bytes.HasPrefix(b, []byte(snapshotMagic)) {
+		t.Fatalf("Expected persistence file to start with magic number, got %q.", b[:min(len(b), 8)])
+	}
+	if expected, got := 4, len(recordOffsets(t, b)); expected != got {
+		t.Errorf("Expected %d frames, got %d.", expected, got)
+	}
+	groups, legacy, err := readPersistenceBytes(b, false)
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if legacy {
+		t.Error("Persistence file detected as legacy format.")
+	}
+	if expected, got := 3, len(groups); expected != got {
+		t.Errorf("Expected %d groups, got %d.", expected, got)
+	}
+
+	// Empty store.
+	var buf bytes.Buffer
+	if err := writeSnapshot(&buf, GroupingKeyToMetricGroup{}); err != nil {
+		t.Fatal(err)
+	}
+	if groups, _, err := readPersistenceBytes(buf.Bytes(), false); err != nil || len(groups) != 0 {
+		t.Errorf("Expected no groups and no error, got %v and %v.", groups, err)
+	}
+
+	// Unknown version.
+	b = bytes.Clone(buf.Bytes())
+	b[len(snapshotMagic)+3] = 2
+	if _, _, err := readPersistenceBytes(b, true); !errors.Is(err, errSnapshotVersion) {
+		t.Errorf("Expected error %v, got %v.", errSnapshotVersion, err)
+	}
+}
+
+func TestLegacyMigration(t *testing.T) {
+	tempDir := t.TempDir()
+	fileName := path.Join(tempDir, "persistence")
+	b := writePersistenceFile(t, fileName, 2)
+	groups, _, err := readPersistenceBytes(b, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var legacy bytes.Buffer
+	if err := gob.NewEncoder(&legacy).Encode(groups); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fileName, legacy.Bytes(), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	dms := NewDiskMetricStore(fileName, time.Hour, nil, logger)
+	if err := dms.WaitRestored(); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if expected, got := 2, len(dms.GetMetricFamiliesMap()); expected != got {
+		t.Errorf("Expected %d groups, got %d.", expected, got)
+	}
+	// The persistence file has been migrated right away.
+	b, err = os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated, legacy, err := readPersistenceBytes(b, false); err != nil || legacy || len(migrated) != 2 {
+		t.Errorf("Expected migrated persistence file with 2 groups, got %d groups, legacy %t, error %v.", len(migrated), legacy, err)
+	}
+	if err := dms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCorruptionPolicies(t *testing.T) {
+	tempDir := t.TempDir()
+	fileName := path.Join(tempDir, "persistence")
+	intact := writePersistenceFile(t, fileName, 4)
+	offsets := recordOffsets(t, intact)
+
+	bitFlipped := bytes.Clone(intact)
+	bitFlipped[offsets[1]+recordHeaderSize+2] ^= 0x10
+	truncated := intact[:offsets[3]+5]
+	noFooter := intact[:offsets[4]]
+	firstFlipped := bytes.Clone(intact)
+	firstFlipped[offsets[0]+recordHeaderSize+2] ^= 0x10
+	// A record overwritten with garbage, starting with a huge length.
+	garbage := bytes.Clone(intact)
+	for i := offsets[1]; i < offsets[2]; i++ {
+		garbage[i] = 0xab
+	}
+	garbage[offsets[1]] = 0x7f
+
+	scenarios := []struct {
+		name     string
+		content  []byte
+		policy   CorruptionPolicy
+		expected int // Number of restored groups, -1 for failure.
+	}{
+		{"bit flip, fail", bitFlipped, CorruptionFail, -1},
+		{"bit flip, start empty", bitFlipped, CorruptionStartEmpty, 0},
+		{"bit flip, salvage", bitFlipped, CorruptionSalvage, 3},
+		{"truncated, fail", truncated, CorruptionFail, -1},
+		{"truncated, salvage", truncated, CorruptionSalvage, 3},
+		{"no footer, start empty", noFooter, CorruptionStartEmpty, 0},
+		{"no footer, salvage", noFooter, CorruptionSalvage, 4},
+		{"first record bit flip, salvage", firstFlipped, CorruptionSalvage, 3},
+		{"garbage, salvage", garbage, CorruptionSalvage, 3},
+		{"legacy truncated, fail", []byte{0x42, 0x17}, CorruptionFail, -1},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			os.Remove(fileName + corruptedFileSuffix)
+			if err := os.WriteFile(fileName, s.content, 0o666); err != nil {
+				t.Fatal(err)
+			}
+			dms := NewDiskMetricStore(fileName, time.Hour, nil, logger, WithCorruptionPolicy(s.policy))
+			err := dms.WaitRestored()
+
+			if s.expected < 0 {
+				if err == nil {
+					t.Fatal("Expected restore to fail.")
+				}
+				if dms.Ready() == nil {
+					t.Error("Expected store not to become ready.")
+				}
+				if err := dms.Shutdown(); err == nil {
+					t.Error("Expected error persisting after failed restore.")
+				}
+				// The persistence file is left alone.
+				if b, err := os.ReadFile(fileName); err != nil || !bytes.Equal(b, s.content) {
+					t.Errorf("Persistence file changed after failed restore, error %v.", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+			if expected, got := s.expected, len(dms.GetMetricFamiliesMap()); expected != got {
+				t.Errorf("Expected %d groups, got %d.", expected, got)
+			}
+			// The corrupted file is kept, and a valid one is written.
+			if b, err := os.ReadFile(fileName + corruptedFileSuffix); err != nil || !bytes.Equal(b, s.content) {
+				t.Errorf("Corrupted persistence file not kept, error %v.", err)
+			}
+			b, err := os.ReadFile(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if groups, _, err := readPersistenceBytes(b, false); err != nil || len(groups) != s.expected {
+				t.Errorf("Expected rewritten persistence file with %d groups, got %d groups and error %v.", s.expected, len(groups), err)
+			}
+			if err := dms.Shutdown(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestReadSnapshotProgress(t *testing.T) {
+	b := writePersistenceFile(t, path.Join(t.TempDir(), "persistence"), 3)
+	var reported []int64
+	groups, _, err := readPersistenceFile(bytes.NewReader(b), int64(len(b)), false, func(n int64) {
+		reported = append(reported, n)
+	})
+	if err != nil || len(groups) != 3 {
+		t.Fatalf("Expected 3 groups and no error, got %d groups and %v.", len(groups), err)
+	}
+	// Progress is reported for the header, each record, and the footer.
+	if expected, got := 5, len(reported); expected != got {
+		t.Errorf("Expected %d progress reports, got %d.", expected, got)
+	}
+	var sum int64
+	for _, n := range reported {
+		sum += n
+	}
+	if expected := int64(len(b)); sum != expected {
+		t.Errorf("Expected progress of %d bytes, got %d.", expected, sum)
+	}
+}
+
+func TestSalvageLargeCorruption(t *testing.T) {
+	intact := writePersistenceFile(t, path.Join(t.TempDir(), "persistence"), 2)
+	offsets := recordOffsets(t, intact)
+
+	// Insert a corrupted region after the first record in which every
+	// offset looks like the header of a record of 1MiB. Checksumming all
+	// of them would take ages.
+	corrupted := bytes.Clone(intact[:offsets[1]])
+	corrupted = append(corrupted, bytes.Repeat([]byte{0x00, 0x10, 0x00, 0x00}, 1<<20)...)
+	corrupted = append(corrupted, intact[offsets[1]:]...)
+
+	start := time.Now()
+	groups, _, err := readPersistenceBytes(corrupted, true)
+	if err == nil {
+		t.Error("Expected error for corrupted persistence file.")
+	}
+	if expected, got := 2, len(groups); expected != got {
+		t.Errorf("Expected %d salvaged groups, got %d.", expected, got)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Salvaging took %v.", elapsed)
+	}
+}
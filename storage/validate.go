@@ -0,0 +1,53 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+// Validate implements the MetricStore interface.
+//
+// The provided WriteRequest is prepared and checked like upon processing with a
+// Done channel set, i.e. including the consistency check, but it is not
+// applied. The returned Problems are those that processing would send to the
+// Done channel.
+func (dms *DiskMetricStore) Validate(wr WriteRequest) []*Problem {
+	if wr.MetricFamilies == nil {
+		return nil
+	}
+	dms.optionsLock.RLock()
+	defer dms.optionsLock.RUnlock()
+
+	wr.Done = nil // Only processed WriteRequests are checked with a Done channel.
+	wr, keep, err := dms.relabel(wr, false)
+	if !keep {
+		return nil
+	}
+	if err != nil {
+		return AsProblems(err)
+	}
+
+	// Like handleWriteRequest, but without applying the WriteRequest.
+	s := dms.shardFor(groupingKeyFor(wr.Labels))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, problems, err := dms.prepareWriteRequest(wr)
+	if err != nil {
+		return AsProblems(err)
+	}
+	defaults, err := gatherDefaults()
+	if err != nil {
+		return AsProblems(problems.and(err))
+	}
+	dms.lock.RLock()
+	defer dms.lock.RUnlock()
+	return AsProblems(dms.checkWriteRequest(wr, defaults, problems))
+}
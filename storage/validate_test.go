@@ -0,0 +1,74 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestValidate(t *testing.T) {
+	dms := NewDiskMetricStore("", time.Hour, nil, logger, WithLimits(Limits{SeriesPerPush: 4}))
+	defer dms.Shutdown()
+	if err := submitAndWait(dms, WriteRequest{
+		Labels:         map[string]string{"job": "a"},
+		Timestamp:      time.Now(),
+		MetricFamilies: newSequenceFamily(dto.MetricType_GAUGE, 1),
+	}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	generation := dms.Generation()
+
+	withTimestamp := gaugeFamily("with_ts", "x")
+	withTimestamp.Metric[0].TimestampMs = proto.Int64(1000)
+	problems := dms.Validate(WriteRequest{
+		Labels:    map[string]string{"job": "b"},
+		Timestamp: time.Now(),
+		MetricFamilies: map[string]*dto.MetricFamily{
+			"sequence": newSequenceFamily(dto.MetricType_COUNTER, 2)["sequence"],
+			"with_ts":  withTimestamp,
+			"dup":      gaugeFamily("dup", "x", "x"),
+			"":         gaugeFamily("", "x"),
+		},
+	})
+	var got []string
+	for _, p := range problems {
+		got = append(got, fmt.Sprintf("%s/%s", p.Kind, p.MetricFamily))
+	}
+	if expected := "[timestamp/with_ts limit/ invalid_name/ duplicate_series/dup type_conflict/sequence]"; fmt.Sprint(got) != expected {
+		t.Errorf("Expected problems %s, got %v.", expected, got)
+	}
+
+	// The DiskMetricStore is unchanged.
+	if expected, got := generation, dms.Generation(); expected != got {
+		t.Errorf("Expected generation %d, got %d.", expected, got)
+	}
+	groups := dms.GetMetricFamiliesMap()
+	if _, ok := groups[groupingKeyFor(map[string]string{"job": "b"})]; ok {
+		t.Error("Expected no group created by validation.")
+	}
+
+	if problems := dms.Validate(WriteRequest{
+		Labels:         map[string]string{"job": "b"},
+		Timestamp:      time.Now(),
+		MetricFamilies: newSequenceFamily(dto.MetricType_GAUGE, 2),
+	}); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v.", problems)
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// walSegmentInfix is inserted between the name of the persistence file and the
+// sequence number of a WAL segment to form the segment's file name.
+const walSegmentInfix = ".wal."
+
+var (
+	castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+	errWALRecordTruncated = errors.New("truncated WAL record")
+	errWALRecordChecksum  = errors.New("WAL record checksum mismatch")
+)
+
+// walRecord is the on-disk representation of a change applied to the
+// DiskMetricStore. It records the effect of a processed WriteRequest rather
+// than the request itself so that replaying a record is idempotent, i.e. a
+// record that is already contained in the persisted snapshot can be replayed
+// on top of it without harm.
+type walRecord struct {
+	Labels         map[string]string
+	Timestamp      time.Time
+	MetricFamilies map[string]*GobbableMetricFamily
+	Replace        bool
+	TTL            time.Duration
+	// Delete is true if the whole group was deleted. (An empty or nil
+	// MetricFamilies map cannot be used to mark deletion because gob
+	// doesn't distinguish between them.)
+	Delete bool
+	// Failed is true if only the push-failed timestamp was updated.
+	Failed bool
+	// DeletedGroups contains the grouping labels of all groups deleted by
+	// a batch delete. If it is set, only Timestamp is set, too.
+	DeletedGroups []map[string]string
+}
+
+// writeRequest converts the walRecord back into a WriteRequest that can be
+// processed without any consistency check.
+func (rec walRecord) writeRequest() WriteRequest {
+	wr := WriteRequest{
+		Labels:    rec.Labels,
+		Timestamp: rec.Timestamp,
+		Replace:   rec.Replace,
+		TTL:       rec.TTL,
+	}
+	if !rec.Delete {
+		wr.MetricFamilies = make(map[string]*dto.MetricFamily, len(rec.MetricFamilies))
+		for name, gmf := range rec.MetricFamilies {
+			wr.MetricFamilies[name] = (*dto.MetricFamily)(gmf)
+		}
+	}
+	return wr
+}
+
+// newWALRecord creates a walRecord for the provided WriteRequest.
+func newWALRecord(wr WriteRequest) walRecord {
+	rec := walRecord{
+		Labels:    wr.Labels,
+		Timestamp: wr.Timestamp,
+		Replace:   wr.Replace,
+		TTL:       wr.TTL,
+		Delete:    wr.MetricFamilies == nil,
+	}
+	if !rec.Delete {
+		rec.MetricFamilies = make(map[string]*GobbableMetricFamily, len(wr.MetricFamilies))
+		for name, mf := range wr.MetricFamilies {
+			rec.MetricFamilies[name] = (*GobbableMetricFamily)(mf)
+		}
+	}
+	return rec
+}
+
+// wal is an append-only write-ahead log, split into numbered segment files. A
+// new segment is started whenever the DiskMetricStore starts to persist a
+// snapshot, so that all segments older than the current one can be removed
+// once the snapshot has been written successfully.
+//
+// Each record in a segment is framed by its length and its CRC32 (Castagnoli)
+// checksum, both as big-endian uint32, followed by the gob-encoded walRecord.
+type wal struct {
+	mtx    sync.Mutex
+	prefix string // File name of a segment without the sequence number.
+	seq    uint64 // Sequence number of the current segment.
+	f      *os.File
+	size   int64 // Bytes written to the current segment.
+	sync   bool  // Whether to sync the segment after each record.
+}
+
+// WithWALSync makes the DiskMetricStore sync the write-ahead log to disk after
+// each record, before the WriteRequest is reported as processed. Without it,
+// processed WriteRequests survive a crash of the Pushgateway process, but they
+// can be lost upon a crash of the operating system or a power loss.
+func WithWALSync(sync bool) Option {
+	return func(dms *DiskMetricStore) {
+		dms.syncWAL = sync
+	}
+}
+
+// openWAL creates a new segment with the provided sequence number and returns a
+// wal writing to it, syncing each record to disk if sync is true.
+func openWAL(persistenceFile string, seq uint64, sync bool) (*wal, error) {
+	w := &wal{prefix: persistenceFile + walSegmentInfix, sync: sync}
+	if err := w.openSegment(seq); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *wal) openSegment(seq uint64) error {
+	f, err := os.OpenFile(w.segmentName(seq), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o666)
+	if err != nil {
+		return err
+	}
+	w.f, w.seq, w.size = f, seq, 0
+	return nil
+}
+
+func (w *wal) segmentName(seq uint64) string {
+	return walSegmentName(w.prefix, seq)
+}
+
+func walSegmentName(prefix string, seq uint64) string {
+	return fmt.Sprintf("%s%08d", prefix, seq)
+}
+
+// log appends the provided record to the current segment. Once log has
+// returned without error, the record survives a crash of the process and, if
+// the wal syncs, also a crash of the operating system.
+func (w *wal) log(rec walRecord) error {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, recordHeaderSize))
+	if err := gob.NewEncoder(&buf).Encode(rec); err != nil {
+		return err
+	}
+	b := buf.Bytes()
+	frameRecord(b)
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	n, err := w.f.Write(b)
+	w.size += int64(n)
+	if err == nil && w.sync {
+		err = w.f.Sync()
+	}
+	return err
+}
+
+// cut closes the current segment and starts a new one. It returns the sequence
+// number of the new segment.
+func (w *wal) cut() (uint64, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.f.Close(); err != nil {
+		return 0, err
+	}
+	if err := w.openSegment(w.seq + 1); err != nil {
+		return 0, err
+	}
+	return w.seq, nil
+}
+
+// removeBefore deletes all segments with a sequence number lower than seq.
+func (w *wal) removeBefore(seq uint64) error {
+	seqs, err := walSegments(w.prefix)
+	if err != nil {
+		return err
+	}
+	for _, s := range seqs {
+		if s >= seq {
+			break
+		}
+		if err := os.Remove(w.segmentName(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close closes the current segment. If nothing has been written to it, it is
+// removed.
+func (w *wal) close() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	if w.size == 0 {
+		return os.Remove(w.segmentName(w.seq))
+	}
+	return nil
+}
+
+// walSegments returns the sorted sequence numbers of all segments starting with
+// the provided prefix.
+func walSegments(prefix string) ([]uint64, error) {
+	fileNames, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	var seqs []uint64
+	for _, fn := range fileNames {
+		seq, err := strconv.ParseUint(strings.TrimPrefix(fn, prefix), 10, 64)
+		if err != nil {
+			continue // Not a segment.
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+// readWALSegment calls fn for each record in the segment with the provided file
+// name, in order. Reading stops at the first incomplete or corrupted record,
+// which is then reported as an error. This happens regularly if the process
+// crashed in the middle of writing a record.
+func readWALSegment(fileName string, fn func(walRecord)) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	remaining := fi.Size()
+	r := bufio.NewReader(f)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return errWALRecordTruncated
+		}
+		remaining -= int64(len(header))
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		if length > remaining {
+			// Also protects against huge allocations caused by a
+			// corrupted length.
+			return errWALRecordTruncated
+		}
+		remaining -= length
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return errWALRecordTruncated
+		}
+		if crc32.Checksum(payload, castagnoliTable) != binary.BigEndian.Uint32(header[4:8]) {
+			return errWALRecordChecksum
+		}
+		var rec walRecord
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&rec); err != nil {
+			return err
+		}
+		fn(rec)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/prometheus/pushgateway/testutil"
+)
+
+// submitAndWait submits the provided WriteRequest with a Done channel and
+// waits for it to be processed. It returns the last error received, if any.
+func submitAndWait(dms *DiskMetricStore, wr WriteRequest) error {
+	errCh := make(chan error, 1)
+	wr.Done = errCh
+	dms.SubmitWriteRequest(wr)
+	var err error
+	for e := range errCh {
+		err = e
+	}
+	return err
+}
+
+// submitMixedRequests submits a mix of pushes, failed pushes, and deletes.
+func submitMixedRequests(t *testing.T, dms *DiskMetricStore, ts time.Time) {
+	grouping1 := map[string]string{
+		"job":      "job1",
+		"instance": "instance1",
+	}
+	grouping2 := map[string]string{
+		"job":      "job1",
+		"instance": "instance2",
+	}
+	grouping3 := map[string]string{
+		"job": "job3",
+	}
+	for _, wr := range []WriteRequest{
+		{Labels: grouping1, Timestamp: ts, MetricFamilies: testutil.MetricFamiliesMap(mf3)},
+		{Labels: grouping2, Timestamp: ts.Add(1 * time.Second), MetricFamilies: testutil.MetricFamiliesMap(mf1b, mf2)},
+		{Labels: grouping2, Timestamp: ts.Add(2 * time.Second), MetricFamilies: testutil.MetricFamiliesMap(mf1a)},
+		{Labels: grouping3, Timestamp: ts.Add(3 * time.Second), MetricFamilies: testutil.MetricFamiliesMap(mf4), TTL: time.Hour},
+		{Labels: grouping1, Timestamp: ts.Add(4 * time.Second), MetricFamilies: testutil.MetricFamiliesMap(mfHist), Replace: true},
+		{Labels: grouping3, Timestamp: ts.Add(5 * time.Second)},
+		{Labels: grouping3, Timestamp: ts.Add(6 * time.Second), MetricFamilies: testutil.MetricFamiliesMap(mf5)},
+	} {
+		if err := submitAndWait(dms, wr); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+	// A failed push.
+	if err := submitAndWait(dms, WriteRequest{
+		Labels:         grouping2,
+		Timestamp:      ts.Add(7 * time.Second),
+		MetricFamilies: testutil.MetricFamiliesMap(mf1ts),
+	}); !errors.Is(err, ErrTimestamp) {
+		t.Errorf("Expected error %q, got %q.", ErrTimestamp, err)
+	}
+}
+
+// checkSameState checks that the two DiskMetricStores contain the same metric
+// groups.
+func checkSameState(t *testing.T, expected, got *DiskMetricStore) {
+	t.Helper()
+	if err := checkMetricFamilies(got, expected.GetMetricFamilies()...); err != nil {
+		t.Error(err)
+	}
+	expectedMap, gotMap := expected.GetMetricFamiliesMap(), got.GetMetricFamiliesMap()
+	for key, expectedGroup := range expectedMap {
+		gotGroup, ok := gotMap[key]
+		if !ok {
+			t.Errorf("Group %v missing.", expectedGroup.Labels)
+			continue
+		}
+		if expectedGroup.TTL != gotGroup.TTL {
+			t.Errorf("Expected TTL %v for group %v, got %v.", expectedGroup.TTL, expectedGroup.Labels, gotGroup.TTL)
+		}
+		for name, tmf := range expectedGroup.Metrics {
+			if !tmf.Timestamp.Equal(gotGroup.Metrics[name].Timestamp) {
+				t.Errorf("Expected timestamp %v for %s in group %v, got %v.", tmf.Timestamp, name, expectedGroup.Labels, gotGroup.Metrics[name].Timestamp)
+			}
+		}
+	}
+}
+
+func TestWALRecoveryAfterCrash(t *testing.T) {
+	for _, sync := range []bool{false, true} {
+		t.Run(fmt.Sprintf("sync %t", sync), func(t *testing.T) {
+			testWALRecoveryAfterCrash(t, sync)
+		})
+	}
+}
+
+func testWALRecoveryAfterCrash(t *testing.T, sync bool) {
+	fileName := path.Join(t.TempDir(), "persistence")
+
+	// A long persistence interval makes sure nothing but the WAL is written
+	// before the "crash".
+	dms := NewDiskMetricStore(fileName, time.Hour, nil, logger, WithWALSync(sync))
+	<-dms.restored
+	submitMixedRequests(t, dms, time.Now())
+	if _, err := os.Stat(fileName); !os.IsNotExist(err) {
+		t.Fatalf("Expected no persistence file yet, got err=%v.", err)
+	}
+
+	// Simulate a crash by simply not shutting down dms and starting a new
+	// DiskMetricStore on the same files.
+	recovered := NewDiskMetricStore(fileName, time.Hour, nil, logger)
+	<-recovered.restored
+	checkSameState(t, dms, recovered)
+	if err := recovered.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// After the clean shutdown, the WAL must be gone, and a restart has to
+	// result in the same state from the snapshot alone.
+	seqs, err := walSegments(fileName + walSegmentInfix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seqs) != 0 {
+		t.Errorf("Expected no WAL segments after shutdown, got %v.", seqs)
+	}
+	restarted := NewDiskMetricStore(fileName, time.Hour, nil, logger)
+	<-restarted.restored
+	checkSameState(t, dms, restarted)
+	if err := restarted.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWALTruncatedAfterPersist(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "diskmetricstore.TestWALTruncatedAfterPersist.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	fileName := path.Join(tempDir, "persistence")
+
+	dms := NewDiskMetricStore(fileName, time.Hour, nil, logger)
+	<-dms.restored
+	ts := time.Now()
+	submitMixedRequests(t, dms, ts)
+	if err := dms.persist(); err != nil {
+		t.Fatal(err)
+	}
+	seqs, err := walSegments(fileName + walSegmentInfix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seqs) != 1 {
+		t.Fatalf("Expected exactly one WAL segment after persisting, got %v.", seqs)
+	}
+	fi, err := os.Stat(walSegmentName(fileName+walSegmentInfix, seqs[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 0 {
+		t.Errorf("Expected empty WAL segment after persisting, got %d bytes.", fi.Size())
+	}
+
+	// Changes after the snapshot have to be recovered from the WAL.
+	if err := submitAndWait(dms, WriteRequest{
+		Labels:         map[string]string{"job": "job4"},
+		Timestamp:      ts.Add(time.Minute),
+		MetricFamilies: testutil.MetricFamiliesMap(mfUnlabelled),
+	}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if err := submitAndWait(dms, WriteRequest{
+		Labels:    map[string]string{"job": "job1", "instance": "instance1"},
+		Timestamp: ts.Add(time.Minute),
+	}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	recovered := NewDiskMetricStore(fileName, time.Hour, nil, logger)
+	<-recovered.restored
+	checkSameState(t, dms, recovered)
+	if err := recovered.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWALTornWrite(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "diskmetricstore.TestWALTornWrite.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	fileName := path.Join(tempDir, "persistence")
+
+	dms := NewDiskMetricStore(fileName, time.Hour, nil, logger)
+	<-dms.restored
+	ts := time.Now()
+	submitMixedRequests(t, dms, ts)
+	segmentName := walSegmentName(fileName+walSegmentInfix, dms.wal.seq)
+	completeSize := dms.wal.size
+
+	// Write another record and then cut it in half as if the process had
+	// died while writing it.
+	if err := submitAndWait(dms, WriteRequest{
+		Labels:         map[string]string{"job": "job4"},
+		Timestamp:      ts.Add(time.Minute),
+		MetricFamilies: testutil.MetricFamiliesMap(mfUnlabelled),
+	}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if err := os.Truncate(segmentName, completeSize+(dms.wal.size-completeSize)/2); err != nil {
+		t.Fatal(err)
+	}
+	// Also undo the last change in dms, which is now the reference state.
+	if err := submitAndWait(dms, WriteRequest{
+		Labels:    map[string]string{"job": "job4"},
+		Timestamp: ts.Add(time.Minute),
+	}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	recovered := NewDiskMetricStore(fileName, time.Hour, nil, logger)
+	<-recovered.restored
+	checkSameState(t, dms, recovered)
+	if err := recovered.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A flipped bit must be detected, too. Everything before the corrupted
+	// record is still recovered.
+	dms = NewDiskMetricStore(fileName, time.Hour, nil, logger)
+	<-dms.restored
+	segmentName = walSegmentName(fileName+walSegmentInfix, dms.wal.seq)
+	if err := submitAndWait(dms, WriteRequest{
+		Labels:         map[string]string{"job": "job5"},
+		Timestamp:      ts.Add(2 * time.Minute),
+		MetricFamilies: testutil.MetricFamiliesMap(mf5),
+	}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	sizeBefore := dms.wal.size
+	if err := submitAndWait(dms, WriteRequest{
+		Labels:         map[string]string{"job": "job4"},
+		Timestamp:      ts.Add(2 * time.Minute),
+		MetricFamilies: testutil.MetricFamiliesMap(mfUnlabelled),
+	}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	b, err := os.ReadFile(segmentName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[sizeBefore+20] ^= 0x01
+	if err := os.WriteFile(segmentName, b, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := submitAndWait(dms, WriteRequest{
+		Labels:    map[string]string{"job": "job4"},
+		Timestamp: ts.Add(2 * time.Minute),
+	}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	recovered = NewDiskMetricStore(fileName, time.Hour, nil, logger)
+	<-recovered.restored
+	checkSameState(t, dms, recovered)
+	if err := recovered.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// Operation is the kind of change to a metric group reported by an Event.
+type Operation string
+
+// The possible Operations.
+const (
+	OperationPush      Operation = "push"      // Update replacing metric families of the same name.
+	OperationReplace   Operation = "replace"   // Update replacing the whole group.
+	OperationIncrement Operation = "increment" // Update incrementing stored values.
+	OperationMerge     Operation = "merge"     // Update merging into stored metric families.
+	OperationDelete    Operation = "delete"    // Deletion of the group.
+	OperationExpire    Operation = "expire"    // Deletion of the group because its TTL has passed.
+)
+
+// Event reports a change of a metric group in the MetricStore, or the failed
+// attempt to change it. Labels are the grouping labels of the group. They must
+// not be modified by the receiver of the Event. Err is nil if the change
+// succeeded. Timestamp is the Timestamp of the causing WriteRequest (or the
+// time of the expiry check for OperationExpire).
+type Event struct {
+	Labels    map[string]string
+	Operation Operation
+	Err       error
+	Timestamp time.Time
+}
+
+// watcher is a subscriber to the Events of a DiskMetricStore.
+type watcher struct {
+	selectors [][]*labels.Matcher // Only Events of matching groups are sent. Nil means all Events.
+	events    chan Event
+}
+
+// Watch implements the MetricStore interface.
+func (dms *DiskMetricStore) Watch(selectors [][]*labels.Matcher, bufferSize int) (<-chan Event, func()) {
+	w := &watcher{
+		selectors: selectors,
+		events:    make(chan Event, bufferSize),
+	}
+	dms.watchersLock.Lock()
+	defer dms.watchersLock.Unlock()
+	if dms.watchers == nil {
+		// Already shut down.
+		close(w.events)
+		return w.events, func() {}
+	}
+	dms.watchers[w] = struct{}{}
+	return w.events, func() { dms.unwatch(w) }
+}
+
+// unwatch removes the provided watcher (if it hasn't been removed yet) and
+// closes its channel.
+func (dms *DiskMetricStore) unwatch(w *watcher) {
+	dms.watchersLock.Lock()
+	defer dms.watchersLock.Unlock()
+	if _, ok := dms.watchers[w]; ok {
+		delete(dms.watchers, w)
+		close(w.events)
+	}
+}
+
+// closeWatchers removes all watchers and closes their channels. Watchers
+// registered afterwards are closed right away.
+func (dms *DiskMetricStore) closeWatchers() {
+	dms.watchersLock.Lock()
+	defer dms.watchersLock.Unlock()
+	for w := range dms.watchers {
+		close(w.events)
+	}
+	dms.watchers = nil
+}
+
+// notify sends the provided Events to all watchers whose selectors match. It
+// never blocks. A watcher whose buffer is full is removed, and its channel is
+// closed.
+func (dms *DiskMetricStore) notify(events ...Event) {
+	dms.watchersLock.Lock()
+	defer dms.watchersLock.Unlock()
+watchers:
+	for w := range dms.watchers {
+		for _, e := range events {
+			if w.selectors != nil && !(MetricGroup{Labels: e.Labels}).Matches(w.selectors) {
+				continue
+			}
+			select {
+			case w.events <- e:
+			default:
+				delete(dms.watchers, w)
+				close(w.events)
+				dms.logger.Warn("dropped watcher not keeping up with events", "buffer_size", cap(w.events))
+				continue watchers
+			}
+		}
+	}
+}
+
+// writeRequestEvents returns the Events caused by processing the provided
+// WriteRequest with the provided result of checkWriteRequest.
+func writeRequestEvents(wr WriteRequest, err error) []Event {
+	if wr.batchDelete != nil {
+		events := make([]Event, len(wr.batchDelete.deleted))
+		for i, ls := range wr.batchDelete.deleted {
+			events[i] = Event{Labels: ls, Operation: OperationDelete, Timestamp: wr.Timestamp}
+		}
+		return events
+	}
+	e := Event{Labels: wr.Labels, Err: err, Timestamp: wr.Timestamp}
+	switch {
+	case wr.MetricFamilies == nil:
+		e.Operation = OperationDelete
+	case wr.Replace:
+		e.Operation = OperationReplace
+	case wr.Increment:
+		e.Operation = OperationIncrement
+	case wr.Merge:
+		e.Operation = OperationMerge
+	default:
+		e.Operation = OperationPush
+	}
+	return []Event{e}
+}
@@ -0,0 +1,164 @@
+// Copyright 2026 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+
+	"github.com/prometheus/pushgateway/testutil"
+)
+
+// receiveEvents receives from the provided channel until n Events have been
+// received or the channel is closed.
+func receiveEvents(t *testing.T, events <-chan Event, n int) []Event {
+	t.Helper()
+	var result []Event
+	for len(result) < n {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return result
+			}
+			result = append(result, e)
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for event %d of %d.", len(result)+1, n)
+		}
+	}
+	return result
+}
+
+func TestWatch(t *testing.T) {
+	dms := NewDiskMetricStore("", 100*time.Millisecond, nil, logger, WithDefaultTTL(time.Minute), WithGaugeIncrements(true))
+
+	all, cancelAll := dms.Watch(nil, 100)
+	defer cancelAll()
+	jobA, cancelJobA := dms.Watch([][]*labels.Matcher{{labels.MustNewMatcher(labels.MatchEqual, "job", "a")}}, 100)
+	defer cancelJobA()
+	slow, cancelSlow := dms.Watch(nil, 1)
+	defer cancelSlow()
+
+	groupingA := map[string]string{"job": "a"}
+	groupingB := map[string]string{"job": "b", "instance": "i"}
+	ts := time.Unix(1700000000, 0)
+	submit := func(wr WriteRequest) error {
+		errCh := make(chan error, 1)
+		wr.Done = errCh
+		dms.SubmitWriteRequest(wr)
+		return <-errCh
+	}
+	if err := submit(WriteRequest{Labels: groupingA, Timestamp: ts, MetricFamilies: testutil.MetricFamiliesMap(mf1a)}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if err := submit(WriteRequest{Labels: groupingB, Timestamp: ts, MetricFamilies: testutil.MetricFamiliesMap(mf1ts)}); !errors.Is(err, ErrTimestamp) {
+		t.Fatalf("Expected error %v, got %v.", ErrTimestamp, err)
+	}
+	if err := submit(WriteRequest{Labels: groupingB, Timestamp: ts, MetricFamilies: testutil.MetricFamiliesMap(mf2), Replace: true}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if err := submit(WriteRequest{Labels: groupingA, Timestamp: ts.Add(time.Minute), MetricFamilies: testutil.MetricFamiliesMap(mf1a), Increment: true}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if err := submit(WriteRequest{Labels: groupingB, Timestamp: ts.Add(time.Minute)}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if expected, got := 1, dms.removeExpiredGroups(ts.Add(2*time.Minute)); expected != got {
+		t.Errorf("Expected %d expired groups, got %d.", expected, got)
+	}
+
+	type summary struct {
+		labels    map[string]string
+		operation Operation
+		err       error
+	}
+	summarize := func(events []Event) []summary {
+		result := []summary{}
+		for _, e := range events {
+			err := e.Err
+			if errors.Is(err, ErrTimestamp) {
+				// Reported as Problems, one per series.
+				err = ErrTimestamp
+			}
+			result = append(result, summary{e.Labels, e.Operation, err})
+		}
+		return result
+	}
+	expected := []summary{
+		{groupingA, OperationPush, nil},
+		{groupingB, OperationPush, ErrTimestamp},
+		{groupingB, OperationReplace, nil},
+		{groupingA, OperationIncrement, nil},
+		{groupingB, OperationDelete, nil},
+		{groupingA, OperationExpire, nil},
+	}
+	events := receiveEvents(t, all, len(expected))
+	if got := summarize(events); !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected events %v, got %v.", expected, got)
+	}
+	if expected, got := ts.Add(time.Minute), events[3].Timestamp; !expected.Equal(got) {
+		t.Errorf("Expected timestamp %v, got %v.", expected, got)
+	}
+	expectedJobA := []summary{expected[0], expected[3], expected[5]}
+	if got := summarize(receiveEvents(t, jobA, len(expectedJobA))); !reflect.DeepEqual(expectedJobA, got) {
+		t.Errorf("Expected events %v, got %v.", expectedJobA, got)
+	}
+
+	// The slow watcher has been dropped after the first event.
+	if expected, got := expected[:1], summarize(receiveEvents(t, slow, len(expected))); !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected events %v, got %v.", expected, got)
+	}
+
+	// A batch delete results in one event per deleted group. Ending a
+	// subscription closes its channel.
+	cancelJobA()
+	if err := submit(WriteRequest{Labels: groupingA, Timestamp: ts, MetricFamilies: testutil.MetricFamiliesMap(mf1a)}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if err := submit(WriteRequest{Labels: groupingB, Timestamp: ts, MetricFamilies: testutil.MetricFamiliesMap(mf2)}); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	for _, dryRun := range []bool{true, false} {
+		if _, err := dms.DeleteGroups([][]*labels.Matcher{{labels.MustNewMatcher(labels.MatchRegexp, "job", ".+")}}, dryRun); err != nil {
+			t.Fatal("Unexpected error:", err)
+		}
+	}
+	expected = []summary{
+		{groupingA, OperationPush, nil},
+		{groupingB, OperationPush, nil},
+		{groupingB, OperationDelete, nil}, // Sorted by grouping key.
+		{groupingA, OperationDelete, nil},
+	}
+	if got := summarize(receiveEvents(t, all, len(expected))); !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected events %v, got %v.", expected, got)
+	}
+	if _, ok := <-jobA; ok {
+		t.Error("Expected channel of ended subscription to be closed.")
+	}
+
+	// Shutting down closes all channels.
+	if err := dms.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-all; ok {
+		t.Error("Expected channel to be closed after shutdown.")
+	}
+	late, _ := dms.Watch(nil, 1)
+	if _, ok := <-late; ok {
+		t.Error("Expected channel of watch after shutdown to be closed.")
+	}
+}